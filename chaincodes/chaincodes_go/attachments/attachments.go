@@ -0,0 +1,197 @@
+// Package attachments keeps the documents of patients, such as radiology reports, discharge
+// letters and scanned consents, off the ledger. A Service encrypts each document, stores it in
+// a content-addressed BlobStore, and anchors its hash and metadata as a DocumentReference
+// through the documents chaincode. Anyone who can read the DocumentReference can check a copy
+// of the document against the anchored hash, without the encryption key.
+package attachments
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/xDaryamo/MedChain/fhir"
+)
+
+// ErrHashMismatch is returned when a file is not the document whose hash the ledger anchors
+var ErrHashMismatch = errors.New("the file does not match the hash anchored on the ledger")
+
+// Contract submits and evaluates transactions of the documents chaincode. A Fabric Gateway
+// *client.Contract satisfies it.
+type Contract interface {
+	SubmitTransaction(name string, args ...string) ([]byte, error)
+	EvaluateTransaction(name string, args ...string) ([]byte, error)
+}
+
+// blobVersion is the first byte of an encrypted blob, naming its layout: the version,
+// the AES-GCM nonce, then the sealed document
+const blobVersion = 1
+
+// Service stores documents encrypted off the ledger and anchors them on it
+type Service struct {
+	store    BlobStore   // Where the encrypted documents are kept
+	contract Contract    // The documents chaincode
+	aead     cipher.AEAD // AES-256-GCM with the key of the service
+}
+
+// NewService returns a service keeping documents in store, anchoring them through contract,
+// and encrypting them with key, which must be 32 bytes long
+func NewService(store BlobStore, contract Contract, key []byte) (*Service, error) {
+	if len(key) != 32 {
+		return nil, errors.New("the encryption key must be 32 bytes long, not " + strconv.Itoa(len(key)))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.New("failed to create cipher: " + err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.New("failed to create cipher: " + err.Error())
+	}
+	return &Service{store: store, contract: contract, aead: aead}, nil
+}
+
+// Hash returns the hash of a file as the ledger anchors it: base64 SHA-256
+func Hash(file []byte) string {
+	digest := sha256.Sum256(file)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// Upload encrypts file, stores it in the blob store and anchors document with its hash, size
+// and content address, which it records in document. The title, content type and language of
+// the first content entry of document, if any, describe file. A blob whose anchoring fails
+// stays in the store; being encrypted and unreferenced, it discloses nothing.
+func (s *Service) Upload(document *fhir.DocumentReference, file []byte) error {
+	if document.ID == "" {
+		return errors.New("document reference ID is required")
+	}
+	if len(file) == 0 {
+		return errors.New("the file is empty")
+	}
+
+	var content fhir.DocumentReferenceContent
+	if len(document.Content) > 0 {
+		content = document.Content[0]
+	}
+	blob, err := s.encrypt(document.ID, file)
+	if err != nil {
+		return err
+	}
+	address, err := s.store.Put(blob)
+	if err != nil {
+		return err
+	}
+
+	content.Attachment.Data = ""
+	content.Attachment.Hash = Hash(file)
+	content.Attachment.Size = int64(len(file))
+	content.Attachment.IPFSHash = address
+	document.Content = []fhir.DocumentReferenceContent{content}
+
+	documentJSON, err := json.Marshal(document)
+	if err != nil {
+		return errors.New("failed to marshal document reference: " + err.Error())
+	}
+	if _, err := s.contract.SubmitTransaction("CreateDocumentReference", string(documentJSON)); err != nil {
+		return errors.New("failed to anchor document " + document.ID + ": " + err.Error())
+	}
+	return nil
+}
+
+// Download returns a document, decrypted and checked against the hash anchored on the ledger,
+// along with its DocumentReference. Callers should check its status: a superseded or
+// entered-in-error document still downloads.
+func (s *Service) Download(documentID string) ([]byte, *fhir.DocumentReference, error) {
+	document, err := s.documentReference(documentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, content := range document.Content {
+		if content.Attachment.IPFSHash == "" {
+			continue
+		}
+		blob, err := s.store.Get(content.Attachment.IPFSHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		if Address(blob) != content.Attachment.IPFSHash {
+			return nil, nil, errors.New("the blob of document " + documentID + " does not match its content address")
+		}
+		file, err := s.decrypt(documentID, blob)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := VerifyFile(document, file); err != nil {
+			return nil, nil, err
+		}
+		return file, document, nil
+	}
+	return nil, nil, errors.New("document " + documentID + " has no content in the blob store")
+}
+
+// Verify checks a copy of a document, however it was obtained, against the hash anchored on the
+// ledger, and returns the DocumentReference it matches. Callers should check its status.
+func (s *Service) Verify(documentID string, file []byte) (*fhir.DocumentReference, error) {
+	document, err := s.documentReference(documentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyFile(document, file); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// VerifyFile checks file against the hash and size anchored for any content of document, and
+// returns ErrHashMismatch when it matches none
+func VerifyFile(document *fhir.DocumentReference, file []byte) error {
+	hash := Hash(file)
+	for _, content := range document.Content {
+		if content.Attachment.Hash == hash && content.Attachment.Size == int64(len(file)) {
+			return nil
+		}
+	}
+	return ErrHashMismatch
+}
+
+// documentReference reads a DocumentReference from the ledger
+func (s *Service) documentReference(documentID string) (*fhir.DocumentReference, error) {
+	documentJSON, err := s.contract.EvaluateTransaction("GetDocumentReference", documentID)
+	if err != nil {
+		return nil, errors.New("failed to read document reference " + documentID + ": " + err.Error())
+	}
+	var document fhir.DocumentReference
+	if err := json.Unmarshal(documentJSON, &document); err != nil {
+		return nil, errors.New("failed to unmarshal document reference: " + err.Error())
+	}
+	return &document, nil
+}
+
+// encrypt seals file under a random nonce. The document ID is authenticated with it, so the
+// blob of one document cannot be passed off as another's.
+func (s *Service) encrypt(documentID string, file []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.New("failed to generate nonce: " + err.Error())
+	}
+	blob := append([]byte{blobVersion}, nonce...)
+	return s.aead.Seal(blob, nonce, file, []byte(documentID)), nil
+}
+
+func (s *Service) decrypt(documentID string, blob []byte) ([]byte, error) {
+	if len(blob) < 1+s.aead.NonceSize() || blob[0] != blobVersion {
+		return nil, errors.New("the blob of document " + documentID + " is not an encrypted document")
+	}
+	nonce := blob[1 : 1+s.aead.NonceSize()]
+	file, err := s.aead.Open(nil, nonce, blob[1+s.aead.NonceSize():], []byte(documentID))
+	if err != nil {
+		return nil, errors.New("failed to decrypt document " + documentID + ": " + err.Error())
+	}
+	return file, nil
+}
@@ -0,0 +1,298 @@
+package attachments
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/xDaryamo/MedChain/fhir"
+)
+
+// MockContract is a mock implementation of the Contract interface
+type MockContract struct {
+	mock.Mock
+}
+
+func (m *MockContract) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	callArgs := m.Called(name, args)
+	return nil, callArgs.Error(0)
+}
+
+func (m *MockContract) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	callArgs := m.Called(name, args)
+	if callArgs.Get(0) == nil {
+		return nil, callArgs.Error(1)
+	}
+	return callArgs.Get(0).([]byte), callArgs.Error(1)
+}
+
+// testKey is the AES-256 key the tests encrypt documents with
+var testKey = bytes.Repeat([]byte{7}, 32)
+
+// dischargeLetter is the content of the document the tests store
+var dischargeLetter = []byte("%PDF-1.7 discharge letter of patient1")
+
+// newTestService returns a service storing blobs in a temporary directory, and that directory
+func newTestService(t *testing.T, contract *MockContract) (*Service, string) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.NoError(t, err)
+	service, err := NewService(store, contract, testKey)
+	assert.NoError(t, err)
+	return service, dir
+}
+
+// sampleDocument returns a discharge letter of patient1 to upload
+func sampleDocument(documentID string) *fhir.DocumentReference {
+	return &fhir.DocumentReference{
+		ID:      documentID,
+		Subject: &fhir.Reference{Reference: "Patient/patient1"},
+		Content: []fhir.DocumentReferenceContent{{Attachment: fhir.Attachment{
+			ContentType: &fhir.Code{Coding: []fhir.Coding{{Code: "application/pdf"}}},
+			Title:       "Discharge letter",
+		}}},
+	}
+}
+
+// uploadAnchored uploads the discharge letter as documentID and makes the ledger return the
+// DocumentReference that was anchored
+func uploadAnchored(t *testing.T, service *Service, contract *MockContract, documentID string) *fhir.DocumentReference {
+	contract.On("SubmitTransaction", "CreateDocumentReference", mock.Anything).Return(nil).Once()
+	document := sampleDocument(documentID)
+	assert.NoError(t, service.Upload(document, dischargeLetter))
+
+	documentJSON, _ := json.Marshal(document)
+	contract.On("EvaluateTransaction", "GetDocumentReference", []string{documentID}).Return(documentJSON, nil)
+	return document
+}
+
+func TestAddress_IsTheIPFSRawBlockCID(t *testing.T) {
+	assert.Equal(t, "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e", Address([]byte("hello world")))
+}
+
+func TestNewService_RejectsShortKeys(t *testing.T) {
+	_, err := NewService(nil, nil, []byte("too short"))
+
+	assert.EqualError(t, err, "the encryption key must be 32 bytes long, not 9")
+}
+
+func TestUpload_AnchorsTheHashButNotTheFile(t *testing.T) {
+	contract := new(MockContract)
+	service, dir := newTestService(t, contract)
+
+	var anchored fhir.DocumentReference
+	contract.On("SubmitTransaction", "CreateDocumentReference", mock.Anything).Run(func(args mock.Arguments) {
+		assert.NoError(t, json.Unmarshal([]byte(args.Get(1).([]string)[0]), &anchored))
+	}).Return(nil)
+
+	err := service.Upload(sampleDocument("doc1"), dischargeLetter)
+
+	assert.NoError(t, err)
+	attachment := anchored.Content[0].Attachment
+	assert.Equal(t, Hash(dischargeLetter), attachment.Hash)
+	assert.Equal(t, int64(len(dischargeLetter)), attachment.Size)
+	assert.Equal(t, "Discharge letter", attachment.Title)
+	assert.Empty(t, attachment.Data)
+
+	blob, err := os.ReadFile(filepath.Join(dir, attachment.IPFSHash))
+	assert.NoError(t, err)
+	assert.Equal(t, attachment.IPFSHash, Address(blob))
+	assert.False(t, bytes.Contains(blob, []byte("discharge letter")), "the stored blob is encrypted")
+}
+
+func TestUpload_ReportsAnchoringFailures(t *testing.T) {
+	contract := new(MockContract)
+	service, _ := newTestService(t, contract)
+	contract.On("SubmitTransaction", "CreateDocumentReference", mock.Anything).Return(errors.New("access denied"))
+
+	err := service.Upload(sampleDocument("doc1"), dischargeLetter)
+
+	assert.EqualError(t, err, "failed to anchor document doc1: access denied")
+}
+
+func TestDownload_ReturnsTheDocument(t *testing.T) {
+	contract := new(MockContract)
+	service, _ := newTestService(t, contract)
+	uploadAnchored(t, service, contract, "doc1")
+
+	file, document, err := service.Download("doc1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, dischargeLetter, file)
+	assert.Equal(t, "doc1", document.ID)
+}
+
+func TestDownload_DetectsATamperedBlob(t *testing.T) {
+	contract := new(MockContract)
+	service, dir := newTestService(t, contract)
+	document := uploadAnchored(t, service, contract, "doc1")
+
+	blobPath := filepath.Join(dir, document.Content[0].Attachment.IPFSHash)
+	blob, _ := os.ReadFile(blobPath)
+	blob[len(blob)-1] ^= 1
+	assert.NoError(t, os.WriteFile(blobPath, blob, 0o600))
+
+	_, _, err := service.Download("doc1")
+
+	assert.EqualError(t, err, "the blob of document doc1 does not match its content address")
+}
+
+func TestDownload_RejectsTheBlobOfAnotherDocument(t *testing.T) {
+	contract := new(MockContract)
+	service, _ := newTestService(t, contract)
+	original := uploadAnchored(t, service, contract, "doc1")
+
+	// doc2 claims the content of doc1, which was encrypted for doc1 only
+	claimed := sampleDocument("doc2")
+	claimed.Content = original.Content
+	claimedJSON, _ := json.Marshal(claimed)
+	contract.On("EvaluateTransaction", "GetDocumentReference", []string{"doc2"}).Return(claimedJSON, nil)
+
+	_, _, err := service.Download("doc2")
+
+	assert.ErrorContains(t, err, "failed to decrypt document doc2")
+}
+
+func TestDownload_NeedsTheKey(t *testing.T) {
+	contract := new(MockContract)
+	service, dir := newTestService(t, contract)
+	uploadAnchored(t, service, contract, "doc1")
+
+	store, _ := NewFileStore(dir)
+	otherService, _ := NewService(store, contract, bytes.Repeat([]byte{8}, 32))
+	_, _, err := otherService.Download("doc1")
+
+	assert.ErrorContains(t, err, "failed to decrypt document doc1")
+}
+
+func TestVerify_AcceptsTheAnchoredFile(t *testing.T) {
+	contract := new(MockContract)
+	service, _ := newTestService(t, contract)
+	uploadAnchored(t, service, contract, "doc1")
+
+	document, err := service.Verify("doc1", dischargeLetter)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "doc1", document.ID)
+}
+
+func TestVerify_RejectsAModifiedFile(t *testing.T) {
+	contract := new(MockContract)
+	service, _ := newTestService(t, contract)
+	uploadAnchored(t, service, contract, "doc1")
+
+	modified := append([]byte{}, dischargeLetter...)
+	modified[0] = '#'
+	_, err := service.Verify("doc1", modified)
+
+	assert.ErrorIs(t, err, ErrHashMismatch)
+}
+
+func TestVerifyFile_WorksWithoutTheKey(t *testing.T) {
+	document := &fhir.DocumentReference{Content: []fhir.DocumentReferenceContent{{Attachment: fhir.Attachment{
+		Hash: Hash(dischargeLetter),
+		Size: int64(len(dischargeLetter)),
+	}}}}
+
+	assert.NoError(t, VerifyFile(document, dischargeLetter))
+	assert.ErrorIs(t, VerifyFile(document, dischargeLetter[1:]), ErrHashMismatch)
+}
+
+func TestFileStore_StoresEachBlobOnce(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStore(dir)
+
+	first, err := store.Put([]byte("blob"))
+	assert.NoError(t, err)
+	second, err := store.Put([]byte("blob"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+}
+
+func TestFileStore_RejectsAddressesOutsideTheStore(t *testing.T) {
+	store, _ := NewFileStore(t.TempDir())
+
+	_, err := store.Get("../../etc/passwd")
+
+	assert.EqualError(t, err, "invalid content address ../../etc/passwd")
+}
+
+func TestFileStore_MissingBlob(t *testing.T) {
+	store, _ := NewFileStore(t.TempDir())
+
+	_, err := store.Get(Address([]byte("never stored")))
+
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// fakeIPFS serves the block/put and block/get calls of the Kubo RPC API from memory
+func fakeIPFS(t *testing.T, blocks map[string][]byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		switch r.URL.Path {
+		case "/api/v0/block/put":
+			assert.Equal(t, "raw", r.URL.Query().Get("cid-codec"))
+			assert.Equal(t, "sha2-256", r.URL.Query().Get("mhtype"))
+			file, _, err := r.FormFile("file")
+			assert.NoError(t, err)
+			blob, _ := io.ReadAll(file)
+			blocks[Address(blob)] = blob
+			json.NewEncoder(w).Encode(map[string]interface{}{"Key": Address(blob), "Size": len(blob)})
+		case "/api/v0/block/get":
+			blob, found := blocks[r.URL.Query().Get("arg")]
+			if !found {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"Message": "block was not found locally (offline): ipld: could not find node"})
+				return
+			}
+			w.Write(blob)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestIPFSStore_StoresRawBlocks(t *testing.T) {
+	blocks := map[string][]byte{}
+	server := fakeIPFS(t, blocks)
+	defer server.Close()
+	store := NewIPFSStore(server.URL)
+
+	address, err := store.Put([]byte("blob"))
+	assert.NoError(t, err)
+	assert.Equal(t, Address([]byte("blob")), address)
+
+	blob, err := store.Get(address)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("blob"), blob)
+}
+
+func TestIPFSStore_RejectsABlockThatDoesNotMatchItsAddress(t *testing.T) {
+	address := Address([]byte("blob"))
+	server := fakeIPFS(t, map[string][]byte{address: []byte("another blob")})
+	defer server.Close()
+
+	_, err := NewIPFSStore(server.URL).Get(address)
+
+	assert.EqualError(t, err, "IPFS returned a blob that does not match "+address)
+}
+
+func TestIPFSStore_MissingBlock(t *testing.T) {
+	server := fakeIPFS(t, map[string][]byte{})
+	defer server.Close()
+
+	_, err := NewIPFSStore(server.URL).Get(Address([]byte("never stored")))
+
+	assert.ErrorIs(t, err, ErrNotFound)
+}
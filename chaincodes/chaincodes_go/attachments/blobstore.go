@@ -0,0 +1,209 @@
+package attachments
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by a BlobStore that holds no content at an address
+var ErrNotFound = errors.New("no content is stored at this address")
+
+// BlobStore stores encrypted documents under their content address
+type BlobStore interface {
+	Put(blob []byte) (string, error)    // Stores blob and returns its content address
+	Get(address string) ([]byte, error) // Returns the blob stored at address
+}
+
+// Prefix of a CIDv1 naming a raw IPFS block by its SHA-256 multihash
+var rawBlockPrefix = []byte{0x01, 0x55, 0x12, sha256.Size}
+
+// base32Lower is the multibase encoding of CIDv1, announced by the prefix b
+var base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Address returns the content address of blob: the CIDv1 IPFS gives it as a single raw block.
+// Both stores use it, so blobs kept on the filesystem can later be pinned to IPFS unchanged.
+func Address(blob []byte) string {
+	digest := sha256.Sum256(blob)
+	return "b" + base32Lower.EncodeToString(append(append([]byte{}, rawBlockPrefix...), digest[:]...))
+}
+
+// validAddress reports whether address is a content address Address could have returned
+func validAddress(address string) bool {
+	if !strings.HasPrefix(address, "b") {
+		return false
+	}
+	cid, err := base32Lower.DecodeString(address[1:])
+	return err == nil && len(cid) == len(rawBlockPrefix)+sha256.Size && bytes.HasPrefix(cid, rawBlockPrefix)
+}
+
+/*
+================================
+	FILESYSTEM STORE
+================================
+*/
+
+// FileStore keeps blobs as files named by their content address in a local directory
+type FileStore struct {
+	dir string // Directory holding the blobs
+}
+
+// NewFileStore returns a store keeping blobs in dir, creating it when needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.New("failed to create blob directory: " + err.Error())
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Put stores blob and returns its content address. Storing the same blob twice keeps one copy.
+func (s *FileStore) Put(blob []byte) (string, error) {
+	address := Address(blob)
+	path := filepath.Join(s.dir, address)
+	if _, err := os.Stat(path); err == nil {
+		return address, nil
+	}
+
+	// Written under a temporary name and renamed, so a blob is never seen half written
+	temp, err := os.CreateTemp(s.dir, address+".*.tmp")
+	if err != nil {
+		return "", errors.New("failed to create blob: " + err.Error())
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(blob); err != nil {
+		temp.Close()
+		return "", errors.New("failed to write blob: " + err.Error())
+	}
+	if err := temp.Close(); err != nil {
+		return "", errors.New("failed to write blob: " + err.Error())
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return "", errors.New("failed to store blob: " + err.Error())
+	}
+	return address, nil
+}
+
+// Get returns the blob stored at address
+func (s *FileStore) Get(address string) ([]byte, error) {
+	if !validAddress(address) {
+		return nil, errors.New("invalid content address " + address)
+	}
+	blob, err := os.ReadFile(filepath.Join(s.dir, address))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.New("failed to read blob: " + err.Error())
+	}
+	return blob, nil
+}
+
+/*
+================================
+	IPFS STORE
+================================
+*/
+
+// IPFSStore keeps blobs as raw blocks on an IPFS node, or on any service speaking the
+// block/put and block/get calls of the Kubo RPC API
+type IPFSStore struct {
+	api    string       // Base URL of the RPC API, e.g., http://127.0.0.1:5001
+	client *http.Client // Client the calls are made with
+}
+
+// NewIPFSStore returns a store keeping blobs on the node whose RPC API is at api
+func NewIPFSStore(api string) *IPFSStore {
+	return &IPFSStore{api: strings.TrimSuffix(api, "/"), client: &http.Client{Timeout: time.Minute}}
+}
+
+// Put stores blob as a single raw block and returns its content address
+func (s *IPFSStore) Put(blob []byte) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "blob")
+	if err != nil {
+		return "", errors.New("failed to encode blob: " + err.Error())
+	}
+	if _, err := part.Write(blob); err != nil {
+		return "", errors.New("failed to encode blob: " + err.Error())
+	}
+	if err := form.Close(); err != nil {
+		return "", errors.New("failed to encode blob: " + err.Error())
+	}
+
+	// Documents can be larger than the 1 MiB IPFS allows a block by default
+	parameters := url.Values{"cid-codec": {"raw"}, "mhtype": {"sha2-256"}, "allow-big-block": {"true"}}
+	response, err := s.call("block/put", parameters, form.FormDataContentType(), &body)
+	if err != nil {
+		return "", err
+	}
+
+	var stored struct {
+		Key string `json:"Key"` // CID of the block
+	}
+	if err := json.Unmarshal(response, &stored); err != nil {
+		return "", errors.New("failed to decode IPFS response: " + err.Error())
+	}
+	if address := Address(blob); stored.Key != address {
+		return "", errors.New("IPFS stored the blob as " + stored.Key + " instead of " + address)
+	}
+	return stored.Key, nil
+}
+
+// Get returns the blob stored at address, after checking that it matches the address
+func (s *IPFSStore) Get(address string) ([]byte, error) {
+	if !validAddress(address) {
+		return nil, errors.New("invalid content address " + address)
+	}
+	blob, err := s.call("block/get", url.Values{"arg": {address}}, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if Address(blob) != address {
+		return nil, errors.New("IPFS returned a blob that does not match " + address)
+	}
+	return blob, nil
+}
+
+// call makes a call to the RPC API, which takes every call as a POST, and returns the response body
+func (s *IPFSStore) call(command string, parameters url.Values, contentType string, body io.Reader) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodPost, s.api+"/api/v0/"+command+"?"+parameters.Encode(), body)
+	if err != nil {
+		return nil, errors.New("failed to create IPFS request: " + err.Error())
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, errors.New("failed to call IPFS: " + err.Error())
+	}
+	defer response.Body.Close()
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.New("failed to read IPFS response: " + err.Error())
+	}
+
+	if response.StatusCode != http.StatusOK {
+		var failure struct {
+			Message string `json:"Message"` // Why the call failed
+		}
+		_ = json.Unmarshal(responseBody, &failure)
+		if strings.Contains(failure.Message, "not found") {
+			return nil, ErrNotFound
+		}
+		return nil, errors.New("IPFS " + command + " failed: " + response.Status + " " + failure.Message)
+	}
+	return responseBody, nil
+}
@@ -0,0 +1,17 @@
+module github.com/xDaryamo/MedChain/attachments
+
+go 1.21
+
+require (
+	github.com/stretchr/testify v1.9.0
+	github.com/xDaryamo/MedChain/fhir v0.0.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/objx v0.5.2 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/xDaryamo/MedChain/fhir => ../fhir
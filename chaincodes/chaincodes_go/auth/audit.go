@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Access log entries are stored under the composite key AccessLog~patientID~txID and
+// are never updated or deleted
+const accessLogObjectType = "AccessLog"
+
+// AccessLogEntry records a read of a patient's data
+type AccessLogEntry struct {
+	ID           string    `json:"id"`           // ID of the transaction that read the data
+	PatientID    string    `json:"patientId"`    // Patient whose data was read
+	ResourceType string    `json:"resourceType"` // FHIR resource type of the data, e.g. Observation
+	ResourceID   string    `json:"resourceId"`   // ID of the resource that was read
+	Function     string    `json:"function"`     // Contract function used to read the data
+	UserID       string    `json:"userId"`       // userId attribute of the reader
+	IdentityID   string    `json:"identityId"`   // x509 identity of the reader's certificate
+	MSPID        string    `json:"mspId"`        // Organization of the reader
+	Role         string    `json:"role"`         // Role of the reader
+	Grant        *Grant    `json:"grant"`        // Consent or emergency access the read was allowed under
+	Timestamp    time.Time `json:"timestamp"`    // Transaction timestamp of the read
+}
+
+// Audit authorizes a read through the policy of function, like BeforeTransaction does,
+// and appends an entry describing it to the access log. Reads are only logged when the
+// transaction is submitted for ordering; evaluated transactions leave no trace.
+func (e *Enforcer) Audit(ctx contractapi.TransactionContextInterface, function string, args []string, resourceType string, resourceID string) (*AccessLogEntry, error) {
+	access, err := e.Authorize(ctx, function, args)
+	if err != nil {
+		return nil, err
+	}
+	if access.PatientID == "" {
+		return nil, errors.New("cannot audit " + function + ": it does not read patient data")
+	}
+
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+
+	entry := AccessLogEntry{
+		ID:           ctx.GetStub().GetTxID(),
+		PatientID:    access.PatientID,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Function:     function,
+		UserID:       access.Caller.UserID,
+		IdentityID:   access.Caller.ID,
+		MSPID:        access.Caller.MSPID,
+		Role:         access.Caller.Role,
+		Grant:        access.Grant,
+		Timestamp:    timestamp.AsTime(),
+	}
+
+	entryKey, err := ctx.GetStub().CreateCompositeKey(accessLogObjectType, []string{entry.PatientID, entry.ID})
+	if err != nil {
+		return nil, errors.New("failed to create access log key: " + err.Error())
+	}
+
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return nil, errors.New("failed to marshal access log entry: " + err.Error())
+	}
+
+	if err := ctx.GetStub().PutState(entryKey, entryJSON); err != nil {
+		return nil, errors.New("failed to put state: " + err.Error())
+	}
+
+	return &entry, nil
+}
+
+// GetAccessLog returns the access log entries of patientID recorded by the calling chaincode
+func GetAccessLog(ctx contractapi.TransactionContextInterface, patientID string) ([]*AccessLogEntry, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(accessLogObjectType, []string{patientID})
+	if err != nil {
+		return nil, errors.New("failed to get access log: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var entries []*AccessLogEntry
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, errors.New("failed to iterate access log: " + err.Error())
+		}
+
+		var entry AccessLogEntry
+		if err := json.Unmarshal(queryResponse.Value, &entry); err != nil {
+			return nil, errors.New("failed to unmarshal access log entry: " + err.Error())
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
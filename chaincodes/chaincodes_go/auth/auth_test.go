@@ -0,0 +1,759 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockStub is a mock implementation of the ChaincodeStubInterface
+type MockStub struct {
+	mock.Mock
+}
+
+func (m *MockStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	args := m.Called(objectType, attributes)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStub) DelPrivateData(collection string, key string) error {
+	args := m.Called(collection, key)
+	return args.Error(0)
+}
+
+func (m *MockStub) DelState(key string) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockStub) GetArgs() [][]byte {
+	args := m.Called()
+	return args.Get(0).([][]byte)
+}
+
+func (m *MockStub) GetArgsSlice() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetBinding() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetChannelID() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *MockStub) GetCreator() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetDecorations() map[string][]byte {
+	args := m.Called()
+	return args.Get(0).(map[string][]byte)
+}
+
+func (m *MockStub) GetFunctionAndParameters() (string, []string) {
+	args := m.Called()
+	return args.String(0), args.Get(1).([]string)
+}
+
+func (m *MockStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	args := m.Called(key)
+	return args.Get(0).(shim.HistoryQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	args := m.Called(collection, key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(collection, objectType, attributes)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(collection, startKey, endKey)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	args := m.Called(collection, key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(collection, query)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	args := m.Called(collection, key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(query)
+	// Check if the first argument is nil
+	if args.Get(0) == nil {
+		// Return a properly initialized MockIterator along with a nil error
+		return new(MockIterator), args.Error(1)
+	}
+	// Otherwise, return the mock iterator and the error as usual
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	args := m.Called(query, pageSize, bookmark)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Get(1).(*peer.QueryResponseMetadata), args.Error(2)
+}
+
+func (m *MockStub) GetSignedProposal() (*peer.SignedProposal, error) {
+	args := m.Called()
+	return args.Get(0).(*peer.SignedProposal), args.Error(1)
+}
+
+func (m *MockStub) GetState(key string) ([]byte, error) {
+	args := m.Called(key)
+	// Check if the first argument is nil
+	if args.Get(0) == nil {
+		// Return nil along with the error
+		return nil, args.Error(1)
+	}
+	// Otherwise, return the byte slice and the error as usual
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(objectType, attributes)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	args := m.Called(objectType, keys, pageSize, bookmark)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Get(1).(*peer.QueryResponseMetadata), args.Error(2)
+}
+
+func (m *MockStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(startKey, endKey)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	args := m.Called(startKey, endKey, pageSize, bookmark)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Get(1).(*peer.QueryResponseMetadata), args.Error(2)
+}
+
+func (m *MockStub) GetStateValidationParameter(key string) ([]byte, error) {
+	args := m.Called(key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetStringArgs() []string {
+	args := m.Called()
+	return args.Get(0).([]string)
+}
+
+func (m *MockStub) GetTransient() (map[string][]byte, error) {
+	args := m.Called()
+	return args.Get(0).(map[string][]byte), args.Error(1)
+}
+
+func (m *MockStub) GetTxID() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *MockStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	args := m.Called()
+	return args.Get(0).(*timestamp.Timestamp), args.Error(1)
+}
+
+func (m *MockStub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) peer.Response {
+	callArgs := m.Called(chaincodeName, args, channel)
+	return callArgs.Get(0).(peer.Response)
+}
+
+func (m *MockStub) PurgePrivateData(collection string, key string) error {
+	args := m.Called(collection, key)
+	return args.Error(0)
+}
+
+func (m *MockStub) PutPrivateData(collection string, key string, value []byte) error {
+	args := m.Called(collection, key, value)
+	return args.Error(0)
+}
+
+func (m *MockStub) PutState(key string, value []byte) error {
+	args := m.Called(key, value)
+	return args.Error(0)
+}
+
+func (m *MockStub) SetEvent(name string, payload []byte) error {
+	args := m.Called(name, payload)
+	return args.Error(0)
+}
+
+func (m *MockStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	args := m.Called(collection, key, ep)
+	return args.Error(0)
+}
+
+func (m *MockStub) SetStateValidationParameter(key string, ep []byte) error {
+	args := m.Called(key, ep)
+	return args.Error(0)
+}
+
+func (m *MockStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	args := m.Called(compositeKey)
+	return args.String(0), args.Get(1).([]string), args.Error(2)
+}
+
+type MockTransactionContext struct {
+	mock.Mock
+}
+
+func (m *MockTransactionContext) GetStub() shim.ChaincodeStubInterface {
+	args := m.Called()
+	return args.Get(0).(shim.ChaincodeStubInterface)
+}
+
+func (m *MockTransactionContext) GetClientIdentity() cid.ClientIdentity {
+	args := m.Called()
+	return args.Get(0).(cid.ClientIdentity)
+}
+
+// KVPair represents a key-value pair for testing purposes
+type KVPair struct {
+	Key   string
+	Value []byte
+}
+
+// MockIterator is a mock implementation of the StateQueryIteratorInterface
+type MockIterator struct {
+	Records      []KVPair // Slice to hold the records for iteration
+	CurrentIndex int      // Index to keep track of the current position
+}
+
+// HasNext returns true if the iterator has more items to iterate over
+func (m *MockIterator) HasNext() bool {
+	return m.CurrentIndex < len(m.Records)
+}
+
+// Next returns the next key and value in the iterator
+func (m *MockIterator) Next() (*queryresult.KV, error) {
+	if m.CurrentIndex >= len(m.Records) {
+		return nil, nil
+	}
+	result := m.Records[m.CurrentIndex]
+	m.CurrentIndex++
+	kv := &queryresult.KV{
+		Key:   result.Key,
+		Value: result.Value,
+	}
+	return kv, nil
+}
+
+// AddRecord adds a key-value pair to the mock iterator
+func (m *MockIterator) AddRecord(key string, value []byte) {
+	m.Records = append(m.Records, KVPair{Key: key, Value: value})
+}
+
+// Close closes the mock iterator (implements shim.StateQueryIteratorInterface)
+func (m *MockIterator) Close() error {
+	// No action needed for a mock iterator, return nil
+	return nil
+}
+
+// MockHistoryIterator is a mock implementation of the HistoryQueryIteratorInterface
+type MockHistoryIterator struct {
+	Modifications []*queryresult.KeyModification // Versions of the key, in the order they are returned
+	CurrentIndex  int                            // Index to keep track of the current position
+}
+
+// HasNext returns true if the iterator has more versions to iterate over
+func (m *MockHistoryIterator) HasNext() bool {
+	return m.CurrentIndex < len(m.Modifications)
+}
+
+// Next returns the next version of the key
+func (m *MockHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	if m.CurrentIndex >= len(m.Modifications) {
+		return nil, nil
+	}
+	modification := m.Modifications[m.CurrentIndex]
+	m.CurrentIndex++
+	return modification, nil
+}
+
+// AddModification adds a version of the key written by txID at the given time
+func (m *MockHistoryIterator) AddModification(txID string, value []byte, at time.Time, isDelete bool) {
+	m.Modifications = append(m.Modifications, &queryresult.KeyModification{
+		TxId:      txID,
+		Value:     value,
+		Timestamp: &timestamp.Timestamp{Seconds: at.Unix()},
+		IsDelete:  isDelete,
+	})
+}
+
+// Close closes the mock iterator (implements shim.HistoryQueryIteratorInterface)
+func (m *MockHistoryIterator) Close() error {
+	return nil
+}
+
+type MockClientIdentity struct {
+	mock.Mock
+}
+
+func (mci *MockClientIdentity) GetID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetMSPID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	args := mci.Called(attrName)
+	return args.String(0), args.Bool(1), args.Error(2)
+}
+
+func (mci *MockClientIdentity) HasAttribute(attrName string) (bool, error) {
+	args := mci.Called(attrName)
+	return args.Bool(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	args := mci.Called(attrName, attrValue)
+	return args.Error(0)
+}
+
+func (mci *MockClientIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	args := mci.Called()
+	return args.Get(0).(*x509.Certificate), args.Error(1)
+}
+
+// Tests
+
+func newCaller(mspID string, role string, userID string) *MockClientIdentity {
+	clientIdentity := new(MockClientIdentity)
+	clientIdentity.On("GetID").Return("x509::CN="+userID, nil)
+	clientIdentity.On("GetMSPID").Return(mspID, nil)
+	clientIdentity.On("GetAttributeValue", UserIDAttribute).Return(userID, userID != "", nil)
+	clientIdentity.On("GetAttributeValue", RoleAttribute).Return(role, role != "", nil)
+	return clientIdentity
+}
+
+func newContext(stub *MockStub, clientIdentity *MockClientIdentity) *MockTransactionContext {
+	ctx := new(MockTransactionContext)
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	return ctx
+}
+
+// enrolledPatientStub answers the patient chaincode's VerifyIdentity as for an enrolled certificate
+func enrolledPatientStub() *MockStub {
+	stub := new(MockStub)
+	stub.On("InvokeChaincode", PatientChaincode, [][]byte{[]byte("VerifyIdentity")}, PatientChannel).Return(peer.Response{Status: 200})
+	return stub
+}
+
+func consentFor(grants map[string]string) ConsentChecker {
+	return ConsentFunc(func(ctx contractapi.TransactionContextInterface, patientID string, caller *Identity, request AccessRequest) (*Grant, error) {
+		if grants[patientID] != caller.UserID || request.Action != ActionRead {
+			return nil, nil
+		}
+		return &Grant{Basis: BasisConsent, ID: ConsentID(patientID, caller.MSPID, caller.UserID)}, nil
+	})
+}
+
+var testPolicies = Policies{
+	"CreateRecord": {MSPs: HospitalMSPs, Roles: []string{RoleDoctor}},
+	"ReadRecord": {
+		Roles:        []string{RoleDoctor, RoleNurse, RolePatient},
+		Subject:      Arg(0),
+		ConsentRoles: []string{RoleDoctor, RoleNurse},
+		Resource:     "Observation",
+		Action:       ActionRead,
+	},
+	"AmendRecord": {
+		Roles:        []string{RoleDoctor},
+		Subject:      Arg(0),
+		ConsentRoles: []string{RoleDoctor},
+		Resource:     "Observation",
+		Action:       ActionWrite,
+	},
+}
+
+func TestGetIdentity(t *testing.T) {
+	ctx := newContext(new(MockStub), newCaller(OspedaleMarescaMSP, RoleDoctor, "doctor-001"))
+
+	identity, err := GetIdentity(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Identity{ID: "x509::CN=doctor-001", MSPID: OspedaleMarescaMSP, UserID: "doctor-001", Role: RoleDoctor}, identity)
+}
+
+func TestAuthorize_AllowsMatchingRoleAndMSP(t *testing.T) {
+	enforcer := &Enforcer{Policies: testPolicies}
+	ctx := newContext(new(MockStub), newCaller(OspedaleDelMareMSP, RoleDoctor, "doctor-001"))
+
+	access, err := enforcer.Authorize(ctx, "CreateRecord", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "doctor-001", access.Caller.UserID)
+	assert.Nil(t, access.Grant)
+}
+
+func TestAuthorize_RejectsWrongMSP(t *testing.T) {
+	enforcer := &Enforcer{Policies: testPolicies}
+	ctx := newContext(new(MockStub), newCaller(MedicinaGeneraleNapoliMSP, RoleDoctor, "doctor-001"))
+
+	_, err := enforcer.Authorize(ctx, "CreateRecord", nil)
+
+	assert.EqualError(t, err, "access denied: organization MedicinaGeneraleNapoliMSP may not call CreateRecord")
+}
+
+func TestAuthorize_RejectsRolesTheMSPMayNotIssue(t *testing.T) {
+	enforcer := &Enforcer{Policies: Policies{"MigrateKeys": {Roles: []string{RoleAdmin}}}}
+	ctx := newContext(new(MockStub), newCaller(FarmaciaPetroneMSP, RoleAdmin, "admin-001"))
+
+	_, err := enforcer.Authorize(ctx, "MigrateKeys", nil)
+
+	assert.EqualError(t, err, "access denied: organization FarmaciaPetroneMSP may not issue role admin")
+}
+
+func TestAuthorize_RejectsPatientsOutsideThePatientMSP(t *testing.T) {
+	enforcer := &Enforcer{Policies: testPolicies}
+	ctx := newContext(new(MockStub), newCaller(LaboratorioAnalisiCMOMSP, RolePatient, "patient-001"))
+
+	_, err := enforcer.Authorize(ctx, "ReadRecord", []string{"patient-001"})
+
+	assert.EqualError(t, err, "access denied: organization LaboratorioAnalisiCMOMSP may not issue role patient")
+}
+
+func TestAuthorize_RejectsWrongRole(t *testing.T) {
+	enforcer := &Enforcer{Policies: testPolicies}
+	ctx := newContext(new(MockStub), newCaller(OspedaleMarescaMSP, RoleNurse, "nurse-001"))
+
+	_, err := enforcer.Authorize(ctx, "CreateRecord", nil)
+
+	assert.EqualError(t, err, "access denied: role nurse may not call CreateRecord")
+}
+
+func TestAuthorize_RejectsUnknownFunction(t *testing.T) {
+	enforcer := &Enforcer{Policies: testPolicies}
+	ctx := newContext(new(MockStub), newCaller(OspedaleMarescaMSP, RoleDoctor, "doctor-001"))
+
+	_, err := enforcer.Authorize(ctx, "DeleteRecord", nil)
+
+	assert.EqualError(t, err, "access denied: no policy defined for DeleteRecord")
+}
+
+func TestAuthorize_PatientReadsOwnData(t *testing.T) {
+	enforcer := &Enforcer{Policies: testPolicies}
+	ctx := newContext(enrolledPatientStub(), newCaller(PatientMSP, RolePatient, "patient-001"))
+
+	access, err := enforcer.Authorize(ctx, "ReadRecord", []string{"Patient/patient-001"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "patient-001", access.PatientID)
+	assert.Equal(t, &Grant{Basis: BasisPatient}, access.Grant)
+}
+
+func TestAuthorize_PatientCannotReadOthersData(t *testing.T) {
+	enforcer := &Enforcer{Policies: testPolicies}
+	ctx := newContext(enrolledPatientStub(), newCaller(PatientMSP, RolePatient, "patient-002"))
+
+	_, err := enforcer.Authorize(ctx, "ReadRecord", []string{"patient-001"})
+
+	assert.EqualError(t, err, "access denied: patients may only access their own data")
+}
+
+func TestAuthorize_ClinicianNeedsConsent(t *testing.T) {
+	enforcer := &Enforcer{Policies: testPolicies, Consent: consentFor(map[string]string{"patient-001": "doctor-001"})}
+
+	ctx := newContext(new(MockStub), newCaller(OspedaleMarescaMSP, RoleDoctor, "doctor-001"))
+	access, err := enforcer.Authorize(ctx, "ReadRecord", []string{"patient-001"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Grant{Basis: BasisConsent, ID: ConsentID("patient-001", OspedaleMarescaMSP, "doctor-001")}, access.Grant)
+
+	ctx = newContext(new(MockStub), newCaller(OspedaleMarescaMSP, RoleDoctor, "doctor-002"))
+	_, err = enforcer.Authorize(ctx, "ReadRecord", []string{"patient-001"})
+	assert.EqualError(t, err, "access denied: no consent from patient patient-001")
+}
+
+func TestBeforeTransaction_StripsContractName(t *testing.T) {
+	enforcer := &Enforcer{Policies: testPolicies}
+	stub := new(MockStub)
+	stub.On("GetFunctionAndParameters").Return("RecordsContract:CreateRecord", []string{})
+	ctx := newContext(stub, newCaller(OspedaleSGiulianoMSP, RoleDoctor, "doctor-001"))
+
+	err := enforcer.BeforeTransaction(ctx)
+
+	assert.NoError(t, err)
+	stub.AssertExpectations(t)
+}
+
+func TestAuthorize_ConsentIsCheckedForTheRequestedAction(t *testing.T) {
+	enforcer := &Enforcer{Policies: testPolicies, Consent: consentFor(map[string]string{"patient-001": "doctor-001"})}
+	ctx := newContext(new(MockStub), newCaller(OspedaleMarescaMSP, RoleDoctor, "doctor-001"))
+
+	_, err := enforcer.Authorize(ctx, "AmendRecord", []string{"patient-001"})
+
+	assert.EqualError(t, err, "access denied: no consent from patient patient-001")
+}
+
+var readObservation = AccessRequest{ResourceType: "Observation", Action: ActionRead, Purpose: PurposeTreatment}
+
+func TestGetIdentity_RejectsCertificateNotEnrolled(t *testing.T) {
+	stub := new(MockStub)
+	stub.On("InvokeChaincode", PatientChaincode, [][]byte{[]byte("VerifyIdentity")}, PatientChannel).Return(peer.Response{Status: 500, Message: "client certificate is not the identity enrolled for patient-001"})
+	ctx := newContext(stub, newCaller(PatientMSP, RolePatient, "patient-001"))
+
+	caller, err := GetIdentity(ctx)
+
+	assert.EqualError(t, err, "failed to verify identity of patient-001: client certificate is not the identity enrolled for patient-001")
+	assert.Nil(t, caller)
+}
+
+func TestGetIdentity_ChecksEnrollmentOfPatientsOnly(t *testing.T) {
+	stub := new(MockStub)
+	ctx := newContext(stub, newCaller(OspedaleMarescaMSP, RoleDoctor, "doctor-001"))
+
+	caller, err := GetIdentity(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "doctor-001", caller.UserID)
+	stub.AssertNotCalled(t, "InvokeChaincode", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestChaincodeConsent_QueriesPatientChaincode(t *testing.T) {
+	stub := new(MockStub)
+	args := [][]byte{
+		[]byte("EvaluateConsent"),
+		[]byte("patient-001"),
+		[]byte(OspedaleMarescaMSP),
+		[]byte("doctor-001"),
+		[]byte("Observation"),
+		[]byte(ActionRead),
+		[]byte(PurposeTreatment),
+	}
+	stub.On("InvokeChaincode", PatientChaincode, args, PatientChannel).Return(peer.Response{Status: 200, Payload: []byte("true")})
+	ctx := newContext(stub, nil)
+
+	grant, err := PatientConsent.HasConsent(ctx, "patient-001", &Identity{MSPID: OspedaleMarescaMSP, UserID: "doctor-001"}, readObservation)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Grant{Basis: BasisConsent, ID: ConsentID("patient-001", OspedaleMarescaMSP, "doctor-001")}, grant)
+	stub.AssertExpectations(t)
+}
+
+func TestChaincodeConsent_PropagatesFailure(t *testing.T) {
+	stub := new(MockStub)
+	stub.On("InvokeChaincode", PatientChaincode, mock.Anything, PatientChannel).Return(peer.Response{Status: 500, Message: "chaincode not found"})
+	ctx := newContext(stub, nil)
+
+	grant, err := PatientConsent.HasConsent(ctx, "patient-001", &Identity{UserID: "doctor-001"}, readObservation)
+
+	assert.EqualError(t, err, "failed to check consent: chaincode not found")
+	assert.Nil(t, grant)
+}
+
+func TestConsentID_DistinguishesRequesters(t *testing.T) {
+	assert.NotEqual(t, ConsentID("a-b", OspedaleMarescaMSP, "c"), ConsentID("a", OspedaleMarescaMSP, "b-c"))
+	assert.NotEqual(t, ConsentID("patient-001", OspedaleMarescaMSP, "doctor-001"), ConsentID("patient-001", OspedaleDelMareMSP, "doctor-001"))
+	assert.Len(t, ConsentID("patient-001", OspedaleMarescaMSP, "doctor-001"), 64)
+}
+
+func TestPayloadSubject(t *testing.T) {
+	resolve := PayloadSubject(0)
+
+	patientID, err := resolve(nil, []string{`{"id":"obs-001","subject":{"reference":"Patient/patient-001"}}`})
+	assert.NoError(t, err)
+	assert.Equal(t, "patient-001", patientID)
+
+	_, err = resolve(nil, []string{`{"id":"obs-001"}`})
+	assert.EqualError(t, err, "access denied: resource has no subject")
+}
+
+// mockKey expects the composite key objectType~id to be created and returns it
+func mockKey(stub *MockStub, objectType string, id string) string {
+	key := "\x00" + objectType + "\x00" + id + "\x00"
+	stub.On("CreateCompositeKey", objectType, []string{id}).Return(key, nil)
+	return key
+}
+
+func TestStoredSubject(t *testing.T) {
+	stub := new(MockStub)
+	stub.On("GetState", mockKey(stub, "MedicationRequest", "rx-001")).Return([]byte(`{"subject":{"reference":"Patient/patient-001"}}`), nil)
+	stub.On("GetState", mockKey(stub, "MedicationRequest", "rx-404")).Return(nil, nil)
+	ctx := newContext(stub, nil)
+	resolve := StoredSubject("MedicationRequest", 0)
+
+	patientID, err := resolve(ctx, []string{"rx-001"})
+	assert.NoError(t, err)
+	assert.Equal(t, "patient-001", patientID)
+
+	_, err = resolve(ctx, []string{"rx-404"})
+	assert.EqualError(t, err, "resource does not exist: rx-404")
+}
+
+func TestLastKnownSubject_FallsBackToHistory(t *testing.T) {
+	stub := new(MockStub)
+	created := time.Date(2024, time.April, 15, 10, 0, 0, 0, time.UTC)
+	history := &MockHistoryIterator{}
+	history.AddModification("tx-002", nil, created.Add(time.Hour), true)
+	history.AddModification("tx-001", []byte(`{"subject":{"reference":"Patient/patient-001"}}`), created, false)
+	deletedKey := mockKey(stub, "Condition", "cond-001")
+	stub.On("GetState", deletedKey).Return(nil, nil)
+	stub.On("GetHistoryForKey", deletedKey).Return(history, nil)
+	missingKey := mockKey(stub, "Condition", "cond-404")
+	stub.On("GetState", missingKey).Return(nil, nil)
+	stub.On("GetHistoryForKey", missingKey).Return(&MockHistoryIterator{}, nil)
+	ctx := newContext(stub, nil)
+	resolve := LastKnownSubject("Condition", 0)
+
+	patientID, err := resolve(ctx, []string{"cond-001"})
+	assert.NoError(t, err)
+	assert.Equal(t, "patient-001", patientID)
+
+	_, err = resolve(ctx, []string{"cond-404"})
+	assert.EqualError(t, err, "resource does not exist: cond-404")
+}
+
+func TestChaincodeEmergency_OnlyQueriedForHospitalReads(t *testing.T) {
+	stub := new(MockStub)
+	args := [][]byte{[]byte("FindEmergencyAccess"), []byte("patient-001"), []byte(OspedaleDelMareMSP), []byte("doctor-001")}
+	stub.On("InvokeChaincode", EmergencyChaincode, args, EmergencyChannel).Return(peer.Response{Status: 200, Payload: []byte("tx-001")})
+	ctx := newContext(stub, nil)
+
+	hospitalDoctor := &Identity{UserID: "doctor-001", MSPID: OspedaleDelMareMSP}
+	grant, err := EmergencyConsent.HasConsent(ctx, "patient-001", hospitalDoctor, readObservation)
+	assert.NoError(t, err)
+	assert.Equal(t, &Grant{Basis: BasisEmergency, ID: "tx-001"}, grant)
+
+	writeObservation := AccessRequest{ResourceType: "Observation", Action: ActionWrite, Purpose: PurposeTreatment}
+	grant, err = EmergencyConsent.HasConsent(ctx, "patient-001", hospitalDoctor, writeObservation)
+	assert.NoError(t, err)
+	assert.Nil(t, grant)
+
+	clinicDoctor := &Identity{UserID: "doctor-001", MSPID: MedicinaGeneraleNapoliMSP}
+	grant, err = EmergencyConsent.HasConsent(ctx, "patient-001", clinicDoctor, readObservation)
+	assert.NoError(t, err)
+	assert.Nil(t, grant)
+
+	stub.AssertNumberOfCalls(t, "InvokeChaincode", 1)
+}
+
+func TestChaincodeEmergency_NoAccess(t *testing.T) {
+	stub := new(MockStub)
+	stub.On("InvokeChaincode", EmergencyChaincode, mock.Anything, EmergencyChannel).Return(peer.Response{Status: 200, Payload: []byte("")})
+	ctx := newContext(stub, nil)
+
+	grant, err := EmergencyConsent.HasConsent(ctx, "patient-001", &Identity{UserID: "doctor-001", MSPID: OspedaleMarescaMSP}, readObservation)
+
+	assert.NoError(t, err)
+	assert.Nil(t, grant)
+}
+
+func TestAnyConsent(t *testing.T) {
+	consent := AnyConsent(consentFor(map[string]string{"patient-001": "doctor-001"}), consentFor(map[string]string{"patient-001": "doctor-002"}))
+
+	grant, err := consent.HasConsent(nil, "patient-001", &Identity{UserID: "doctor-002"}, readObservation)
+	assert.NoError(t, err)
+	assert.Equal(t, ConsentID("patient-001", "", "doctor-002"), grant.ID)
+
+	grant, err = consent.HasConsent(nil, "patient-001", &Identity{UserID: "doctor-003"}, readObservation)
+	assert.NoError(t, err)
+	assert.Nil(t, grant)
+}
+
+func TestAudit_LogsTheGrant(t *testing.T) {
+	enforcer := &Enforcer{Policies: testPolicies, Consent: consentFor(map[string]string{"patient-001": "doctor-001"})}
+	stub := new(MockStub)
+	ctx := newContext(stub, newCaller(OspedaleMarescaMSP, RoleDoctor, "doctor-001"))
+	now := time.Date(2024, time.May, 1, 9, 0, 0, 0, time.UTC)
+
+	stub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: now.Unix()}, nil)
+	stub.On("GetTxID").Return("tx-001")
+	stub.On("CreateCompositeKey", "AccessLog", []string{"patient-001", "tx-001"}).Return("log-key", nil)
+	stub.On("PutState", "log-key", mock.Anything).Return(nil)
+
+	entry, err := enforcer.Audit(ctx, "ReadRecord", []string{"patient-001"}, "Observation", "obs-001")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "doctor-001", entry.UserID)
+	assert.Equal(t, "obs-001", entry.ResourceID)
+	assert.Equal(t, &Grant{Basis: BasisConsent, ID: ConsentID("patient-001", OspedaleMarescaMSP, "doctor-001")}, entry.Grant)
+	assert.True(t, entry.Timestamp.Equal(now))
+	stub.AssertExpectations(t)
+}
+
+func TestAudit_DeniedReadIsNotLogged(t *testing.T) {
+	enforcer := &Enforcer{Policies: testPolicies, Consent: consentFor(map[string]string{})}
+	stub := new(MockStub)
+	ctx := newContext(stub, newCaller(OspedaleMarescaMSP, RoleDoctor, "doctor-001"))
+
+	_, err := enforcer.Audit(ctx, "ReadRecord", []string{"patient-001"}, "Observation", "obs-001")
+
+	assert.EqualError(t, err, "access denied: no consent from patient patient-001")
+	stub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestGetAccessLog(t *testing.T) {
+	stub := new(MockStub)
+	entryJSON, _ := json.Marshal(AccessLogEntry{ID: "tx-001", PatientID: "patient-001", UserID: "doctor-001"})
+	iterator := new(MockIterator)
+	iterator.AddRecord("log-key", entryJSON)
+	stub.On("GetStateByPartialCompositeKey", "AccessLog", []string{"patient-001"}).Return(iterator, nil)
+	ctx := newContext(stub, nil)
+
+	entries, err := GetAccessLog(ctx, "patient-001")
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "doctor-001", entries[0].UserID)
+}
+
+// recordsContract is a contract with two transactions, to check policies against
+type recordsContract struct {
+	contractapi.Contract
+}
+
+func (c *recordsContract) ReadRecord(ctx contractapi.TransactionContextInterface, recordID string) (string, error) {
+	return "", nil
+}
+
+func (c *recordsContract) WriteRecord(ctx contractapi.TransactionContextInterface, recordJSON string) error {
+	return nil
+}
+
+func TestMissingPolicies(t *testing.T) {
+	missing := MissingPolicies(new(recordsContract), Policies{"ReadRecord": {Roles: []string{RoleDoctor}}})
+
+	// The methods inherited from contractapi.Contract are not transactions of the contract
+	assert.Equal(t, []string{"WriteRecord"}, missing)
+}
+
+func TestMissingPolicies_EveryTransactionCovered(t *testing.T) {
+	missing := MissingPolicies(new(recordsContract), Policies{"ReadRecord": {}, "WriteRecord": {}})
+
+	assert.Empty(t, missing)
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Consent actions, from the http://terminology.hl7.org/CodeSystem/consentaction code system
+const (
+	ActionRead  = "access"  // Retrieve the data
+	ActionWrite = "correct" // Create, amend or delete the data
+)
+
+// Purposes of use, from the http://terminology.hl7.org/CodeSystem/v3-ActReason code system
+const (
+	PurposeTreatment = "TREAT"  // Treatment
+	PurposeResearch  = "HRESCH" // Healthcare research
+	PurposeEmergency = "ETREAT" // Emergency treatment
+)
+
+// Bases on which access to a patient's data is allowed
+const (
+	BasisPatient   = "patient"   // The caller is the patient
+	BasisConsent   = "consent"   // A Consent of the patient permits the access
+	BasisEmergency = "emergency" // The caller broke the glass
+	BasisRole      = "role"      // The caller's role needs no consent, e.g. a pharmacist dispensing
+)
+
+// Grant tells what allowed an access to a patient's data
+type Grant struct {
+	Basis string `json:"basis"`        // One of the Basis* constants
+	ID    string `json:"id,omitempty"` // ID of the Consent or emergency access, when there is one
+}
+
+// ConsentID returns the ID of the Consent of patientID concerning requesterID of requesterMSP.
+// It is the hex SHA-256 of the three IDs separated by a NUL byte, which no ID can contain,
+// so that distinct requesters never share an ID and the result is a valid FHIR id.
+func ConsentID(patientID string, requesterMSP string, requesterID string) string {
+	sum := sha256.Sum256([]byte(patientID + "\x00" + requesterMSP + "\x00" + requesterID))
+	return hex.EncodeToString(sum[:])
+}
+
+// AccessRequest describes the access a consent provision must permit
+type AccessRequest struct {
+	ResourceType string // FHIR resource type of the data, e.g. Observation
+	Action       string // ActionRead or ActionWrite
+	Purpose      string // One of the Purpose* constants
+}
+
+// ConsentChecker tells whether a patient consented to the caller accessing their data,
+// returning the grant that allows the access or nil when there is none
+type ConsentChecker interface {
+	HasConsent(ctx contractapi.TransactionContextInterface, patientID string, caller *Identity, request AccessRequest) (*Grant, error)
+}
+
+// ConsentFunc adapts a plain function to the ConsentChecker interface
+type ConsentFunc func(ctx contractapi.TransactionContextInterface, patientID string, caller *Identity, request AccessRequest) (*Grant, error)
+
+// HasConsent calls f(ctx, patientID, caller, request)
+func (f ConsentFunc) HasConsent(ctx contractapi.TransactionContextInterface, patientID string, caller *Identity, request AccessRequest) (*Grant, error) {
+	return f(ctx, patientID, caller, request)
+}
+
+// Location of the patient chaincode, which owns the consent records
+const (
+	PatientChaincode = "patient"
+	PatientChannel   = "patient-records-channel"
+)
+
+// ChaincodeConsent reads the consent state by querying the EvaluateConsent function
+// of the patient chaincode. When the chaincode runs on another channel the call
+// is a read-only query, which is all a consent check needs.
+type ChaincodeConsent struct {
+	Chaincode string // Name of the patient chaincode
+	Channel   string // Channel the patient chaincode is installed on
+}
+
+// PatientConsent is the ConsentChecker used by chaincodes other than patient
+var PatientConsent = &ChaincodeConsent{Chaincode: PatientChaincode, Channel: PatientChannel}
+
+// HasConsent asks the patient chaincode whether the patient's consents permit the request
+func (c *ChaincodeConsent) HasConsent(ctx contractapi.TransactionContextInterface, patientID string, caller *Identity, request AccessRequest) (*Grant, error) {
+	args := [][]byte{
+		[]byte("EvaluateConsent"),
+		[]byte(patientID),
+		[]byte(caller.MSPID),
+		[]byte(caller.UserID),
+		[]byte(request.ResourceType),
+		[]byte(request.Action),
+		[]byte(request.Purpose),
+	}
+
+	response := ctx.GetStub().InvokeChaincode(c.Chaincode, args, c.Channel)
+	if response.Status != 200 {
+		return nil, errors.New("failed to check consent: " + response.Message)
+	}
+
+	granted, err := strconv.ParseBool(string(response.Payload))
+	if err != nil {
+		return nil, errors.New("failed to parse consent response: " + err.Error())
+	}
+	if !granted {
+		return nil, nil
+	}
+	return &Grant{Basis: BasisConsent, ID: ConsentID(patientID, caller.MSPID, caller.UserID)}, nil
+}
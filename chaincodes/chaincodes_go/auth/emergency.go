@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Location of the emergency chaincode, which records break-the-glass accesses
+const (
+	EmergencyChaincode = "emergency"
+	EmergencyChannel   = "emergency-channel"
+)
+
+// ChaincodeEmergency honours the break-the-glass accesses recorded by the emergency
+// chaincode. Only hospitals take part in the emergency channel, and an emergency
+// access only allows reading, so other callers and actions are never queried for.
+type ChaincodeEmergency struct {
+	Chaincode string // Name of the emergency chaincode
+	Channel   string // Channel the emergency chaincode is installed on
+}
+
+// EmergencyConsent is the ConsentChecker of break-the-glass accesses
+var EmergencyConsent = &ChaincodeEmergency{Chaincode: EmergencyChaincode, Channel: EmergencyChannel}
+
+// HasConsent asks the emergency chaincode whether the caller broke the glass on the patient's record
+func (c *ChaincodeEmergency) HasConsent(ctx contractapi.TransactionContextInterface, patientID string, caller *Identity, request AccessRequest) (*Grant, error) {
+	if request.Action != ActionRead || !caller.InMSP(HospitalMSPs...) {
+		return nil, nil
+	}
+
+	args := [][]byte{[]byte("FindEmergencyAccess"), []byte(patientID), []byte(caller.MSPID), []byte(caller.UserID)}
+
+	response := ctx.GetStub().InvokeChaincode(c.Chaincode, args, c.Channel)
+	if response.Status != 200 {
+		return nil, errors.New("failed to check emergency access: " + response.Message)
+	}
+
+	// The payload is the ID of the emergency access, empty when there is none
+	accessID := string(response.Payload)
+	if accessID == "" {
+		return nil, nil
+	}
+	return &Grant{Basis: BasisEmergency, ID: accessID}, nil
+}
+
+// AnyConsent grants access when any of the checkers does, trying them in order
+func AnyConsent(checkers ...ConsentChecker) ConsentChecker {
+	return ConsentFunc(func(ctx contractapi.TransactionContextInterface, patientID string, caller *Identity, request AccessRequest) (*Grant, error) {
+		for _, checker := range checkers {
+			grant, err := checker.HasConsent(ctx, patientID, caller, request)
+			if err != nil {
+				return nil, err
+			}
+			if grant != nil {
+				return grant, nil
+			}
+		}
+		return nil, nil
+	})
+}
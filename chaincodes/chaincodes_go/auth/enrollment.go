@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// EnrollmentChecker tells whether a caller's certificate may act for their user ID: it
+// returns an error when another certificate is enrolled for the user
+type EnrollmentChecker interface {
+	CheckEnrollment(ctx contractapi.TransactionContextInterface, caller *Identity) error
+}
+
+// EnrollmentFunc adapts a plain function to the EnrollmentChecker interface
+type EnrollmentFunc func(ctx contractapi.TransactionContextInterface, caller *Identity) error
+
+// CheckEnrollment calls f(ctx, caller)
+func (f EnrollmentFunc) CheckEnrollment(ctx contractapi.TransactionContextInterface, caller *Identity) error {
+	return f(ctx, caller)
+}
+
+// ChaincodeEnrollment checks the caller's certificate by querying the VerifyIdentity function
+// of the patient chaincode, which keeps the identity mappings. The invoked chaincode sees the
+// same signed proposal, so it checks the certificate of the original caller.
+type ChaincodeEnrollment struct {
+	Chaincode string // Name of the patient chaincode
+	Channel   string // Channel the patient chaincode is installed on
+}
+
+// CheckEnrollment asks the patient chaincode whether the caller's certificate is the enrolled one
+func (c *ChaincodeEnrollment) CheckEnrollment(ctx contractapi.TransactionContextInterface, caller *Identity) error {
+	response := ctx.GetStub().InvokeChaincode(c.Chaincode, [][]byte{[]byte("VerifyIdentity")}, c.Channel)
+	if response.Status != 200 {
+		return errors.New("failed to verify identity of " + caller.UserID + ": " + response.Message)
+	}
+	return nil
+}
+
+// Enrollment checks the certificates of the patients in GetIdentity. The patient chaincode,
+// which keeps the identity mappings, replaces it with a lookup of its own world state.
+var Enrollment EnrollmentChecker = &ChaincodeEnrollment{Chaincode: PatientChaincode, Channel: PatientChannel}
@@ -0,0 +1,147 @@
+// Package auth implements the attribute-based access control shared by the
+// MedChain chaincodes. Every contract declares a Policy per transaction and
+// enforces it through an Enforcer installed as the contract's BeforeTransaction.
+package auth
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Certificate attributes read from the caller's enrollment certificate
+const (
+	UserIDAttribute = "userId" // Application-level identifier of the user (patient ID, practitioner ID, ...)
+	RoleAttribute   = "role"   // Clinical role of the user, one of the Role* constants
+)
+
+// Roles recognised by the access policies
+const (
+	RoleDoctor        = "doctor"
+	RoleNurse         = "nurse"
+	RolePharmacist    = "pharmacist"
+	RoleLabTechnician = "lab_technician"
+	RolePatient       = "patient"
+	RoleAdmin         = "admin"
+	RoleCompliance    = "compliance_officer"
+)
+
+// MSP IDs of the organizations taking part in the network
+const (
+	OspedaleMarescaMSP        = "OspedaleMarescaMSP"
+	OspedaleDelMareMSP        = "OspedaleDelMareMSP"
+	OspedaleSGiulianoMSP      = "OspedaleSGiulianoMSP"
+	MedicinaGeneraleNapoliMSP = "MedicinaGeneraleNapoliMSP"
+	NeurologiaNapoliMSP       = "NeurologiaNapoliMSP"
+	FarmaciaPetroneMSP        = "FarmaciaPetroneMSP"
+	FarmaciaCarboneMSP        = "FarmaciaCarboneMSP"
+	LaboratorioAnalisiCMOMSP  = "LaboratorioAnalisiCMOMSP"
+	LaboratorioAnalisiSDNMSP  = "LaboratorioAnalisiSDNMSP"
+	PatientMSP                = "PatientMSP"
+)
+
+// Groups of organizations, used to build the MSP lists of a Policy
+var (
+	HospitalMSPs   = []string{OspedaleMarescaMSP, OspedaleDelMareMSP, OspedaleSGiulianoMSP}
+	ClinicMSPs     = []string{MedicinaGeneraleNapoliMSP, NeurologiaNapoliMSP}
+	PharmacyMSPs   = []string{FarmaciaPetroneMSP, FarmaciaCarboneMSP}
+	LaboratoryMSPs = []string{LaboratorioAnalisiCMOMSP, LaboratorioAnalisiSDNMSP}
+)
+
+// RoleIssuers binds each role to the organizations whose CA may issue it. Authorize rejects a
+// caller whose certificate carries a role its organization may not issue, so that no CA can
+// mint, say, an admin or a patient of another organization.
+var RoleIssuers = map[string][]string{
+	RoleDoctor:        Join(HospitalMSPs, ClinicMSPs),
+	RoleNurse:         Join(HospitalMSPs, ClinicMSPs),
+	RolePharmacist:    PharmacyMSPs,
+	RoleLabTechnician: LaboratoryMSPs,
+	RolePatient:       {PatientMSP},
+	RoleAdmin:         Join(HospitalMSPs, ClinicMSPs),
+	RoleCompliance:    HospitalMSPs,
+}
+
+// Join concatenates groups of MSP IDs or roles into a single list
+func Join(groups ...[]string) []string {
+	var joined []string
+	for _, group := range groups {
+		joined = append(joined, group...)
+	}
+	return joined
+}
+
+// Identity describes the caller of a transaction
+type Identity struct {
+	ID     string `json:"id"`     // Unique identity of the certificate (x509 subject and issuer)
+	MSPID  string `json:"mspId"`  // MSP of the organization that enrolled the caller
+	UserID string `json:"userId"` // Value of the userId attribute, empty when the certificate has none
+	Role   string `json:"role"`   // Value of the role attribute, empty when the certificate has none
+}
+
+// GetIdentity reads the caller's identity from the transaction context. Callers from the
+// patient MSP must use the certificate enrolled for their user ID, if there is one.
+func GetIdentity(ctx contractapi.TransactionContextInterface) (*Identity, error) {
+	clientIdentity := ctx.GetClientIdentity()
+
+	id, err := clientIdentity.GetID()
+	if err != nil {
+		return nil, errors.New("failed to get client ID: " + err.Error())
+	}
+
+	mspID, err := clientIdentity.GetMSPID()
+	if err != nil {
+		return nil, errors.New("failed to get client MSP ID: " + err.Error())
+	}
+
+	userID, _, err := clientIdentity.GetAttributeValue(UserIDAttribute)
+	if err != nil {
+		return nil, errors.New("failed to get " + UserIDAttribute + " attribute: " + err.Error())
+	}
+
+	role, _, err := clientIdentity.GetAttributeValue(RoleAttribute)
+	if err != nil {
+		return nil, errors.New("failed to get " + RoleAttribute + " attribute: " + err.Error())
+	}
+
+	identity := &Identity{ID: id, MSPID: mspID, UserID: userID, Role: role}
+
+	// A patient's user ID is only accepted with the certificate the patient enrolled
+	if identity.MSPID == PatientMSP && identity.UserID != "" {
+		if err := Enrollment.CheckEnrollment(ctx, identity); err != nil {
+			return nil, err
+		}
+	}
+
+	return identity, nil
+}
+
+// HasRole reports whether the caller holds one of the given roles
+func (i *Identity) HasRole(roles ...string) bool {
+	return contains(roles, i.Role)
+}
+
+// InMSP reports whether the caller belongs to one of the given organizations
+func (i *Identity) InMSP(mspIDs ...string) bool {
+	return contains(mspIDs, i.MSPID)
+}
+
+// PatientID extracts the patient ID from a FHIR reference such as "Patient/123"
+func PatientID(reference string) string {
+	return strings.TrimPrefix(reference, "Patient/")
+}
+
+// OrganizationReference returns the FHIR reference of the organization enrolled by an MSP,
+// such as "Organization/FarmaciaPetrone" for FarmaciaPetroneMSP
+func OrganizationReference(mspID string) string {
+	return "Organization/" + strings.TrimSuffix(mspID, "MSP")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SubjectResolver returns the ID of the patient a transaction refers to,
+// given the transaction arguments. It may read the world state, e.g. to find
+// the subject of a stored resource.
+type SubjectResolver func(ctx contractapi.TransactionContextInterface, args []string) (string, error)
+
+// Policy declares who may call a contract function
+type Policy struct {
+	MSPs         []string        // Organizations whose members may call the function; empty means any organization
+	Roles        []string        // Roles allowed to call the function; empty means any role
+	Subject      SubjectResolver // Resolves the patient the call is about; nil when the function is not patient-scoped
+	ConsentRoles []string        // Roles that additionally need the patient's consent to act on the subject
+	Resource     string          // FHIR resource type the function reads or writes, checked against the consent
+	Action       string          // ActionRead or ActionWrite, checked against the consent
+}
+
+// Access describes a call allowed by an Enforcer
+type Access struct {
+	Caller    *Identity // Identity of the caller
+	PatientID string    // Patient the call is about; empty when the function is not patient-scoped
+	Grant     *Grant    // What allowed access to the patient's data; nil when not patient-scoped
+}
+
+// Policies maps contract function names to their policy
+type Policies map[string]Policy
+
+// MissingPolicies returns the transactions of a contract that have no policy: its exported
+// methods, other than those it inherits from contractapi.Contract, missing from policies.
+// The Enforcer denies them every call.
+func MissingPolicies(contract interface{}, policies Policies) []string {
+	contractType := reflect.TypeOf(contract)
+	baseType := reflect.TypeOf(new(contractapi.Contract))
+
+	var missing []string
+	for i := 0; i < contractType.NumMethod(); i++ {
+		name := contractType.Method(i).Name
+		if _, inherited := baseType.MethodByName(name); inherited {
+			continue
+		}
+		if _, ok := policies[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// Enforcer evaluates the policies of a contract
+type Enforcer struct {
+	Policies Policies       // Policy of every function exposed by the contract
+	Consent  ConsentChecker // Source of the patients' consent state
+}
+
+// BeforeTransaction checks the policy of the invoked function. It is meant to be
+// installed as the BeforeTransaction handler of a contract, so that every
+// transaction is rejected unless its policy allows the caller.
+func (e *Enforcer) BeforeTransaction(ctx contractapi.TransactionContextInterface) error {
+	function, args := ctx.GetStub().GetFunctionAndParameters()
+	_, err := e.Authorize(ctx, function, args)
+	return err
+}
+
+// Authorize checks the caller against the policy of the given function and
+// describes the access when it is allowed
+func (e *Enforcer) Authorize(ctx contractapi.TransactionContextInterface, function string, args []string) (*Access, error) {
+	// Contract functions may be invoked as "ContractName:Function"
+	if i := strings.LastIndex(function, ":"); i >= 0 {
+		function = function[i+1:]
+	}
+
+	policy, ok := e.Policies[function]
+	if !ok {
+		return nil, errors.New("access denied: no policy defined for " + function)
+	}
+
+	caller, err := GetIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if caller.Role != "" && !caller.InMSP(RoleIssuers[caller.Role]...) {
+		return nil, errors.New("access denied: organization " + caller.MSPID + " may not issue role " + caller.Role)
+	}
+	if len(policy.MSPs) > 0 && !caller.InMSP(policy.MSPs...) {
+		return nil, errors.New("access denied: organization " + caller.MSPID + " may not call " + function)
+	}
+	if len(policy.Roles) > 0 && !caller.HasRole(policy.Roles...) {
+		return nil, errors.New("access denied: role " + caller.Role + " may not call " + function)
+	}
+	if policy.Subject == nil {
+		return &Access{Caller: caller}, nil
+	}
+
+	patientID, err := policy.Subject(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Patients may only act on their own data
+	if caller.HasRole(RolePatient) {
+		if caller.UserID == "" || caller.UserID != patientID {
+			return nil, errors.New("access denied: patients may only access their own data")
+		}
+		return &Access{Caller: caller, PatientID: patientID, Grant: &Grant{Basis: BasisPatient}}, nil
+	}
+
+	if !caller.HasRole(policy.ConsentRoles...) {
+		return &Access{Caller: caller, PatientID: patientID, Grant: &Grant{Basis: BasisRole}}, nil
+	}
+
+	if e.Consent == nil {
+		return nil, errors.New("access denied: no consent source configured for " + function)
+	}
+	request := AccessRequest{ResourceType: policy.Resource, Action: policy.Action, Purpose: PurposeTreatment}
+	grant, err := e.Consent.HasConsent(ctx, patientID, caller, request)
+	if err != nil {
+		return nil, err
+	}
+	if grant == nil {
+		return nil, errors.New("access denied: no consent from patient " + patientID)
+	}
+
+	return &Access{Caller: caller, PatientID: patientID, Grant: grant}, nil
+}
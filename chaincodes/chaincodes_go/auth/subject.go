@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// subjectOf is the part of a FHIR resource naming the patient it is about
+type subjectOf struct {
+	Subject *struct {
+		Reference string `json:"reference"`
+	} `json:"subject"`
+}
+
+// Arg returns a SubjectResolver reading the patient ID (or a patient reference)
+// from the transaction argument at the given position
+func Arg(position int) SubjectResolver {
+	return func(ctx contractapi.TransactionContextInterface, args []string) (string, error) {
+		if position >= len(args) {
+			return "", errors.New("access denied: missing patient argument")
+		}
+		return PatientID(args[position]), nil
+	}
+}
+
+// PayloadSubject returns a SubjectResolver reading subject.reference from the
+// JSON resource passed as the argument at the given position
+func PayloadSubject(position int) SubjectResolver {
+	return func(ctx contractapi.TransactionContextInterface, args []string) (string, error) {
+		if position >= len(args) {
+			return "", errors.New("access denied: missing resource argument")
+		}
+		return parseSubject([]byte(args[position]))
+	}
+}
+
+// StoredSubject returns a SubjectResolver reading subject.reference from the resource
+// of the given object type whose ID is passed as the argument at the given position
+func StoredSubject(objectType string, position int) SubjectResolver {
+	return func(ctx contractapi.TransactionContextInterface, args []string) (string, error) {
+		key, err := resourceKey(ctx, objectType, args, position)
+		if err != nil {
+			return "", err
+		}
+
+		resourceJSON, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return "", errors.New("failed to read from world state: " + err.Error())
+		}
+		if resourceJSON == nil {
+			return "", errors.New("resource does not exist: " + args[position])
+		}
+		return parseSubject(resourceJSON)
+	}
+}
+
+// LastKnownSubject works like StoredSubject, but falls back to the last version in the
+// key's history when the resource has been deleted, so that its history stays reachable
+func LastKnownSubject(objectType string, position int) SubjectResolver {
+	return func(ctx contractapi.TransactionContextInterface, args []string) (string, error) {
+		key, err := resourceKey(ctx, objectType, args, position)
+		if err != nil {
+			return "", err
+		}
+
+		resourceJSON, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return "", errors.New("failed to read from world state: " + err.Error())
+		}
+		if resourceJSON != nil {
+			return parseSubject(resourceJSON)
+		}
+
+		historyIterator, err := ctx.GetStub().GetHistoryForKey(key)
+		if err != nil {
+			return "", errors.New("failed to get history: " + err.Error())
+		}
+		defer historyIterator.Close()
+
+		var lastVersion []byte
+		var lastTime time.Time
+		for historyIterator.HasNext() {
+			modification, err := historyIterator.Next()
+			if err != nil {
+				return "", errors.New("failed to iterate history: " + err.Error())
+			}
+			if modification.IsDelete || modification.Timestamp.AsTime().Before(lastTime) {
+				continue
+			}
+			lastVersion = modification.Value
+			lastTime = modification.Timestamp.AsTime()
+		}
+		if lastVersion == nil {
+			return "", errors.New("resource does not exist: " + args[position])
+		}
+		return parseSubject(lastVersion)
+	}
+}
+
+// resourceKey returns the composite key objectType~id of the resource whose ID is
+// the argument at the given position, as laid out by the ledger module
+func resourceKey(ctx contractapi.TransactionContextInterface, objectType string, args []string, position int) (string, error) {
+	if position >= len(args) {
+		return "", errors.New("access denied: missing resource ID argument")
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, []string{args[position]})
+	if err != nil {
+		return "", errors.New("failed to create " + objectType + " key: " + err.Error())
+	}
+	return key, nil
+}
+
+func parseSubject(resourceJSON []byte) (string, error) {
+	var resource subjectOf
+	if err := json.Unmarshal(resourceJSON, &resource); err != nil {
+		return "", errors.New("failed to unmarshal resource: " + err.Error())
+	}
+	if resource.Subject == nil || resource.Subject.Reference == "" {
+		return "", errors.New("access denied: resource has no subject")
+	}
+	return PatientID(resource.Subject.Reference), nil
+}
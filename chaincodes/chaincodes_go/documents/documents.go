@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+	"github.com/xDaryamo/MedChain/history"
+	"github.com/xDaryamo/MedChain/ledger"
+	"github.com/xDaryamo/MedChain/query"
+)
+
+// Document references are stored under the composite key DocumentReference~documentID
+const documentReferenceObjectType = "DocumentReference"
+
+// Statuses of the http://hl7.org/fhir/document-reference-status code system
+const (
+	StatusCurrent        = "current"
+	StatusSuperseded     = "superseded"
+	StatusEnteredInError = "entered-in-error"
+)
+
+// relationshipCodes are the codes of the http://hl7.org/fhir/document-relationship-type code system
+var relationshipCodes = []string{"replaces", "transforms", "signs", "appends"}
+
+// DocumentReferenceChaincode anchors documents kept off the ledger, such as radiology
+// reports, discharge letters and scanned consents. The ledger holds their metadata and the
+// hash of their content, never the content itself.
+type DocumentReferenceChaincode struct {
+	contractapi.Contract
+}
+
+/*
+================================
+	DOCUMENT REFERENCE OPERATIONS
+================================
+*/
+
+// CreateDocumentReference anchors a document stored off the ledger: its metadata and, for each
+// copy, the SHA-256 hash and size of the content and where it is stored. The caller's
+// organization becomes the custodian of the document, and the documents it replaces are
+// marked superseded.
+func (t *DocumentReferenceChaincode) CreateDocumentReference(ctx contractapi.TransactionContextInterface, documentJSON string) error {
+	var document fhir.DocumentReference
+	if err := json.Unmarshal([]byte(documentJSON), &document); err != nil {
+		return errors.New("failed to decode JSON")
+	}
+	if document.ID == "" {
+		return errors.New("document reference ID is required")
+	}
+
+	existing, err := findDocumentReference(ctx, document.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errors.New("the document reference already exists")
+	}
+
+	if document.Status == "" {
+		document.Status = StatusCurrent
+	} else if document.Status != StatusCurrent {
+		return errors.New("new document references must be current, not " + document.Status)
+	}
+	if document.Subject == nil || document.Subject.Reference == "" {
+		return errors.New("the patient of the document is required")
+	}
+	if err := validateContent(document.Content); err != nil {
+		return err
+	}
+
+	custodian, err := callerOrganization(ctx)
+	if err != nil {
+		return err
+	}
+	if document.Custodian == nil || document.Custodian.Reference == "" {
+		document.Custodian = &fhir.Reference{Reference: custodian}
+	} else if document.Custodian.Reference != custodian {
+		return errors.New("organizations may only anchor documents in their own custody")
+	}
+
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+	document.Date = timestamp.AsTime()
+
+	for _, relatesTo := range document.RelatesTo {
+		if err := relate(ctx, &document, relatesTo); err != nil {
+			return err
+		}
+	}
+	return putDocumentReference(ctx, &document)
+}
+
+// GetDocumentReference returns a document reference, with the hash its content must match
+func (t *DocumentReferenceChaincode) GetDocumentReference(ctx contractapi.TransactionContextInterface, documentID string) (*fhir.DocumentReference, error) {
+	return getDocumentReference(ctx, documentID)
+}
+
+// GetDocumentReferenceHistory returns every version of a document reference as a FHIR history
+// Bundle, with the transaction and identity that wrote each version
+func (t *DocumentReferenceChaincode) GetDocumentReferenceHistory(ctx contractapi.TransactionContextInterface, documentID string) (string, error) {
+	documentKey, err := ledger.Key(ctx, documentReferenceObjectType, documentID)
+	if err != nil {
+		return "", err
+	}
+	return history.GetHistoryJSON(ctx, documentKey, "DocumentReference", documentID)
+}
+
+// QueryDocumentReferencesByPatient returns the document references of a patient, newest first
+func (t *DocumentReferenceChaincode) QueryDocumentReferencesByPatient(ctx contractapi.TransactionContextInterface, patientID string) ([]*fhir.DocumentReference, error) {
+	queryJSON, err := query.New(query.Where("subject.reference", query.Eq("Patient/"+auth.PatientID(patientID)))).
+		UseIndex("indexBySubject", "indexBySubject").
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryJSON)
+	if err != nil {
+		return nil, errors.New("failed to query document references: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	documents := []*fhir.DocumentReference{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, errors.New("failed to iterate document references: " + err.Error())
+		}
+		var document fhir.DocumentReference
+		if err := json.Unmarshal(queryResponse.Value, &document); err != nil {
+			return nil, errors.New("failed to unmarshal document reference: " + err.Error())
+		}
+		documents = append(documents, &document)
+	}
+
+	sort.SliceStable(documents, func(i, j int) bool {
+		return documents[i].Date.After(documents[j].Date)
+	})
+	return documents, nil
+}
+
+// MarkDocumentReferenceEnteredInError retracts a document anchored by mistake. Only its
+// custodian may. The hash stays on the ledger, so copies of the document can still be
+// recognized as the retracted one.
+func (t *DocumentReferenceChaincode) MarkDocumentReferenceEnteredInError(ctx contractapi.TransactionContextInterface, documentID string) error {
+	document, err := custodianDocument(ctx, documentID)
+	if err != nil {
+		return err
+	}
+	if document.Status == StatusEnteredInError {
+		return errors.New("document reference " + documentID + " is already entered in error")
+	}
+	document.Status = StatusEnteredInError
+	return putDocumentReference(ctx, document)
+}
+
+// relate checks a relationship of a new document and, when the document replaces another one,
+// marks that one superseded
+func relate(ctx contractapi.TransactionContextInterface, document *fhir.DocumentReference, relatesTo fhir.DocumentReferenceRelatesTo) error {
+	known := false
+	for _, code := range relationshipCodes {
+		known = known || relatesTo.Code == code
+	}
+	if !known {
+		return errors.New("unknown document relationship " + relatesTo.Code)
+	}
+	if relatesTo.Target == nil {
+		return errors.New("the target of the " + relatesTo.Code + " relationship is required")
+	}
+	targetID := strings.TrimPrefix(relatesTo.Target.Reference, documentReferenceObjectType+"/")
+	if targetID == relatesTo.Target.Reference || targetID == "" {
+		return errors.New("documents may only relate to DocumentReferences, not " + relatesTo.Target.Reference)
+	}
+
+	target, err := getDocumentReference(ctx, targetID)
+	if err != nil {
+		return err
+	}
+	if target.Subject == nil || auth.PatientID(target.Subject.Reference) != auth.PatientID(document.Subject.Reference) {
+		return errors.New("document " + relatesTo.Target.Reference + " is about another patient")
+	}
+	if relatesTo.Code != "replaces" {
+		return nil
+	}
+
+	if target.Custodian == nil || target.Custodian.Reference != document.Custodian.Reference {
+		return errors.New("only the custodian of " + relatesTo.Target.Reference + " may replace it")
+	}
+	if target.Status != StatusCurrent {
+		return errors.New("document " + relatesTo.Target.Reference + " is " + target.Status + " and cannot be replaced")
+	}
+	target.Status = StatusSuperseded
+	return putDocumentReference(ctx, target)
+}
+
+// validateContent checks that each copy of a document has a SHA-256 hash, a size and a location,
+// and that none carries the content itself
+func validateContent(content []fhir.DocumentReferenceContent) error {
+	if len(content) == 0 {
+		return errors.New("the content of the document is required")
+	}
+	for _, entry := range content {
+		attachment := entry.Attachment
+		if attachment.Data != "" {
+			return errors.New("the content of a document must be stored off the ledger")
+		}
+		hash, err := base64.StdEncoding.DecodeString(attachment.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return errors.New("the hash of the content must be a base64 SHA-256 digest")
+		}
+		if attachment.Size <= 0 {
+			return errors.New("the size of the content is required")
+		}
+		if attachment.IPFSHash == "" && attachment.Url == "" {
+			return errors.New("the content address or URL of the content is required")
+		}
+	}
+	return nil
+}
+
+// custodianDocument returns a document reference after checking that the caller's organization is its custodian
+func custodianDocument(ctx contractapi.TransactionContextInterface, documentID string) (*fhir.DocumentReference, error) {
+	document, err := getDocumentReference(ctx, documentID)
+	if err != nil {
+		return nil, err
+	}
+	custodian, err := callerOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if document.Custodian == nil || document.Custodian.Reference != custodian {
+		return nil, errors.New("document reference " + documentID + " is in the custody of another organization")
+	}
+	return document, nil
+}
+
+// callerOrganization returns the reference of the organization the caller belongs to
+func callerOrganization(ctx contractapi.TransactionContextInterface) (string, error) {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return "", err
+	}
+	return auth.OrganizationReference(caller.MSPID), nil
+}
+
+func getDocumentReference(ctx contractapi.TransactionContextInterface, documentID string) (*fhir.DocumentReference, error) {
+	document, err := findDocumentReference(ctx, documentID)
+	if err != nil {
+		return nil, err
+	}
+	if document == nil {
+		return nil, errors.New("document reference " + documentID + " does not exist")
+	}
+	return document, nil
+}
+
+// findDocumentReference returns a document reference, or nil when it does not exist
+func findDocumentReference(ctx contractapi.TransactionContextInterface, documentID string) (*fhir.DocumentReference, error) {
+	documentKey, err := ledger.Key(ctx, documentReferenceObjectType, documentID)
+	if err != nil {
+		return nil, err
+	}
+	documentJSON, err := ctx.GetStub().GetState(documentKey)
+	if err != nil {
+		return nil, errors.New("failed to read from world state")
+	}
+	if documentJSON == nil {
+		return nil, nil
+	}
+	var document fhir.DocumentReference
+	if err := json.Unmarshal(documentJSON, &document); err != nil {
+		return nil, errors.New("failed to unmarshal document reference: " + err.Error())
+	}
+	return &document, nil
+}
+
+func putDocumentReference(ctx contractapi.TransactionContextInterface, document *fhir.DocumentReference) error {
+	documentKey, err := ledger.Key(ctx, documentReferenceObjectType, document.ID)
+	if err != nil {
+		return err
+	}
+	documentJSON, err := json.Marshal(document)
+	if err != nil {
+		return errors.New("failed to marshal document reference: " + err.Error())
+	}
+	if err := ctx.GetStub().PutState(documentKey, documentJSON); err != nil {
+		return errors.New("failed to put state: " + err.Error())
+	}
+	return nil
+}
+
+func main() {
+	documentChaincode := new(DocumentReferenceChaincode)
+	documentChaincode.BeforeTransaction = documentEnforcer.BeforeTransaction
+	documentChaincode.AfterTransaction = history.RecordSubmitter
+
+	chaincode, err := contractapi.NewChaincode(documentChaincode)
+	if err != nil {
+		log.Panic(errors.New("error creating document reference chaincode: " + err.Error()))
+	}
+
+	if err := chaincode.Start(); err != nil {
+		log.Panic(errors.New("error starting document reference chaincode: " + err.Error()))
+	}
+}
@@ -0,0 +1,678 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+)
+
+// MockStub is a mock implementation of the ChaincodeStubInterface
+type MockStub struct {
+	mock.Mock
+}
+
+func (m *MockStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	args := m.Called(objectType, attributes)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStub) DelPrivateData(collection string, key string) error {
+	args := m.Called(collection, key)
+	return args.Error(0)
+}
+
+func (m *MockStub) DelState(key string) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockStub) GetArgs() [][]byte {
+	args := m.Called()
+	return args.Get(0).([][]byte)
+}
+
+func (m *MockStub) GetArgsSlice() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetBinding() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetChannelID() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *MockStub) GetCreator() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetDecorations() map[string][]byte {
+	args := m.Called()
+	return args.Get(0).(map[string][]byte)
+}
+
+func (m *MockStub) GetFunctionAndParameters() (string, []string) {
+	args := m.Called()
+	return args.String(0), args.Get(1).([]string)
+}
+
+func (m *MockStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	args := m.Called(key)
+	return args.Get(0).(shim.HistoryQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	args := m.Called(collection, key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(collection, objectType, attributes)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(collection, startKey, endKey)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	args := m.Called(collection, key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(collection, query)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	args := m.Called(collection, key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(query)
+	// Check if the first argument is nil
+	if args.Get(0) == nil {
+		// Return a properly initialized MockIterator along with a nil error
+		return new(MockIterator), args.Error(1)
+	}
+	// Otherwise, return the mock iterator and the error as usual
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	args := m.Called(query, pageSize, bookmark)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Get(1).(*peer.QueryResponseMetadata), args.Error(2)
+}
+
+func (m *MockStub) GetSignedProposal() (*peer.SignedProposal, error) {
+	args := m.Called()
+	return args.Get(0).(*peer.SignedProposal), args.Error(1)
+}
+
+func (m *MockStub) GetState(key string) ([]byte, error) {
+	args := m.Called(key)
+	// Check if the first argument is nil
+	if args.Get(0) == nil {
+		// Return nil along with the error
+		return nil, args.Error(1)
+	}
+	// Otherwise, return the byte slice and the error as usual
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(objectType, attributes)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	args := m.Called(objectType, keys, pageSize, bookmark)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Get(1).(*peer.QueryResponseMetadata), args.Error(2)
+}
+
+func (m *MockStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(startKey, endKey)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	args := m.Called(startKey, endKey, pageSize, bookmark)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Get(1).(*peer.QueryResponseMetadata), args.Error(2)
+}
+
+func (m *MockStub) GetStateValidationParameter(key string) ([]byte, error) {
+	args := m.Called(key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetStringArgs() []string {
+	args := m.Called()
+	return args.Get(0).([]string)
+}
+
+func (m *MockStub) GetTransient() (map[string][]byte, error) {
+	args := m.Called()
+	return args.Get(0).(map[string][]byte), args.Error(1)
+}
+
+func (m *MockStub) GetTxID() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *MockStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	args := m.Called()
+	return args.Get(0).(*timestamp.Timestamp), args.Error(1)
+}
+
+func (m *MockStub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) peer.Response {
+	callArgs := m.Called(chaincodeName, args, channel)
+	return callArgs.Get(0).(peer.Response)
+}
+
+func (m *MockStub) PurgePrivateData(collection string, key string) error {
+	args := m.Called(collection, key)
+	return args.Error(0)
+}
+
+func (m *MockStub) PutPrivateData(collection string, key string, value []byte) error {
+	args := m.Called(collection, key, value)
+	return args.Error(0)
+}
+
+func (m *MockStub) PutState(key string, value []byte) error {
+	args := m.Called(key, value)
+	return args.Error(0)
+}
+
+func (m *MockStub) SetEvent(name string, payload []byte) error {
+	args := m.Called(name, payload)
+	return args.Error(0)
+}
+
+func (m *MockStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	args := m.Called(collection, key, ep)
+	return args.Error(0)
+}
+
+func (m *MockStub) SetStateValidationParameter(key string, ep []byte) error {
+	args := m.Called(key, ep)
+	return args.Error(0)
+}
+
+func (m *MockStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	args := m.Called(compositeKey)
+	return args.String(0), args.Get(1).([]string), args.Error(2)
+}
+
+type MockTransactionContext struct {
+	mock.Mock
+}
+
+func (m *MockTransactionContext) GetStub() shim.ChaincodeStubInterface {
+	args := m.Called()
+	return args.Get(0).(shim.ChaincodeStubInterface)
+}
+
+func (m *MockTransactionContext) GetClientIdentity() cid.ClientIdentity {
+	args := m.Called()
+	return args.Get(0).(cid.ClientIdentity)
+}
+
+// MockClientIdentity is a mock implementation of the cid.ClientIdentity interface
+type MockClientIdentity struct {
+	mock.Mock
+}
+
+func (mci *MockClientIdentity) GetID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetMSPID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	args := mci.Called(attrName)
+	return args.String(0), args.Bool(1), args.Error(2)
+}
+
+func (mci *MockClientIdentity) HasAttribute(attrName string) (bool, error) {
+	args := mci.Called(attrName)
+	return args.Bool(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	args := mci.Called(attrName, attrValue)
+	return args.Error(0)
+}
+
+func (mci *MockClientIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	args := mci.Called()
+	return args.Get(0).(*x509.Certificate), args.Error(1)
+}
+
+// KVPair represents a key-value pair for testing purposes
+type KVPair struct {
+	Key   string
+	Value []byte
+}
+
+// MockIterator is a mock implementation of the StateQueryIteratorInterface
+type MockIterator struct {
+	Records      []KVPair // Slice to hold the records for iteration
+	CurrentIndex int      // Index to keep track of the current position
+}
+
+// HasNext returns true if the iterator has more items to iterate over
+func (m *MockIterator) HasNext() bool {
+	return m.CurrentIndex < len(m.Records)
+}
+
+// Next returns the next key and value in the iterator
+func (m *MockIterator) Next() (*queryresult.KV, error) {
+	if m.CurrentIndex >= len(m.Records) {
+		return nil, nil
+	}
+	result := m.Records[m.CurrentIndex]
+	m.CurrentIndex++
+	kv := &queryresult.KV{
+		Key:   result.Key,
+		Value: result.Value,
+	}
+	return kv, nil
+}
+
+// AddRecord adds a key-value pair to the mock iterator
+func (m *MockIterator) AddRecord(key string, value []byte) {
+	m.Records = append(m.Records, KVPair{Key: key, Value: value})
+}
+
+// Close closes the mock iterator (implements shim.StateQueryIteratorInterface)
+func (m *MockIterator) Close() error {
+	// No action needed for a mock iterator, return nil
+	return nil
+}
+
+// mockDoctorCaller makes userID, a doctor of MedicinaGeneraleNapoli, the caller of the transaction
+func mockDoctorCaller(mockCtx *MockTransactionContext, userID string) {
+	mockCaller(mockCtx, userID, auth.MedicinaGeneraleNapoliMSP, auth.RoleDoctor)
+}
+
+// mockCaller makes userID, with role at the organization mspID, the caller of the transaction
+func mockCaller(mockCtx *MockTransactionContext, userID string, mspID string, role string) {
+	clientIdentity := new(MockClientIdentity)
+	clientIdentity.On("GetID").Return("x509::CN="+userID, nil)
+	clientIdentity.On("GetMSPID").Return(mspID, nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(userID, true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return(role, true, nil)
+	mockCtx.On("GetClientIdentity").Return(clientIdentity)
+}
+
+// mockKey expects the composite key objectType~id to be created and returns it
+func mockKey(stub *MockStub, objectType string, id string) string {
+	key := "\x00" + objectType + "\x00" + id + "\x00"
+	stub.On("CreateCompositeKey", objectType, []string{id}).Return(key, nil)
+	return key
+}
+
+// contentHash returns the base64 SHA-256 hash of content, as anchored on the ledger
+func contentHash(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// sampleDocument returns a discharge letter of patient1 stored off the ledger
+func sampleDocument(documentID string) fhir.DocumentReference {
+	return fhir.DocumentReference{
+		ID:      documentID,
+		Type:    &fhir.CodeableConcept{Coding: []fhir.Coding{{System: "http://loinc.org", Code: "18842-5", Display: "Discharge summary"}}},
+		Subject: &fhir.Reference{Reference: "Patient/patient1"},
+		Content: []fhir.DocumentReferenceContent{{Attachment: fhir.Attachment{
+			Hash:     contentHash("discharge letter"),
+			Size:     16,
+			IPFSHash: "bafkreiexample",
+			Title:    "Discharge letter",
+		}}},
+	}
+}
+
+func sampleDocumentJSON(document fhir.DocumentReference) string {
+	documentJSON, _ := json.Marshal(document)
+	return string(documentJSON)
+}
+
+// mockStoredDocument stores a current document of patient1 in the custody of MedicinaGeneraleNapoli and returns its key
+func mockStoredDocument(mockStub *MockStub, documentID string, status string) string {
+	document := sampleDocument(documentID)
+	document.Status = status
+	document.Custodian = &fhir.Reference{Reference: "Organization/MedicinaGeneraleNapoli"}
+	documentKey := mockKey(mockStub, "DocumentReference", documentID)
+	mockStub.On("GetState", documentKey).Return([]byte(sampleDocumentJSON(document)), nil)
+	return documentKey
+}
+
+// storedDocumentStatus matches a stored document reference with the given status
+func storedDocumentStatus(status string) interface{} {
+	return mock.MatchedBy(func(value []byte) bool {
+		var document fhir.DocumentReference
+		return json.Unmarshal(value, &document) == nil && document.Status == status
+	})
+}
+
+func TestCreateDocumentReference_Successful(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockDoctorCaller(mockCtx, "doctor1")
+
+	documentKey := mockKey(mockStub, "DocumentReference", "doc1")
+	mockStub.On("GetState", documentKey).Return(nil, nil)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: 1714550400}, nil)
+	mockStub.On("PutState", documentKey, mock.MatchedBy(func(value []byte) bool {
+		var document fhir.DocumentReference
+		return json.Unmarshal(value, &document) == nil && document.Status == StatusCurrent &&
+			document.Custodian.Reference == "Organization/MedicinaGeneraleNapoli" &&
+			document.Date.Equal(time.Unix(1714550400, 0)) && document.Content[0].Attachment.Hash == contentHash("discharge letter")
+	})).Return(nil)
+
+	err := documentChaincode.CreateDocumentReference(mockCtx, sampleDocumentJSON(sampleDocument("doc1")))
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestCreateDocumentReference_RejectsInlineContent(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	documentKey := mockKey(mockStub, "DocumentReference", "doc1")
+	mockStub.On("GetState", documentKey).Return(nil, nil)
+	document := sampleDocument("doc1")
+	document.Content[0].Attachment.Data = base64.StdEncoding.EncodeToString([]byte("discharge letter"))
+
+	err := documentChaincode.CreateDocumentReference(mockCtx, sampleDocumentJSON(document))
+
+	assert.EqualError(t, err, "the content of a document must be stored off the ledger")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestCreateDocumentReference_RejectsHashesThatAreNotSHA256(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	documentKey := mockKey(mockStub, "DocumentReference", "doc1")
+	mockStub.On("GetState", documentKey).Return(nil, nil)
+	document := sampleDocument("doc1")
+	sha1 := [20]byte{}
+	document.Content[0].Attachment.Hash = base64.StdEncoding.EncodeToString(sha1[:])
+
+	err := documentChaincode.CreateDocumentReference(mockCtx, sampleDocumentJSON(document))
+
+	assert.EqualError(t, err, "the hash of the content must be a base64 SHA-256 digest")
+}
+
+func TestCreateDocumentReference_RejectsContentWithoutLocation(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	documentKey := mockKey(mockStub, "DocumentReference", "doc1")
+	mockStub.On("GetState", documentKey).Return(nil, nil)
+	document := sampleDocument("doc1")
+	document.Content[0].Attachment.IPFSHash = ""
+
+	err := documentChaincode.CreateDocumentReference(mockCtx, sampleDocumentJSON(document))
+
+	assert.EqualError(t, err, "the content address or URL of the content is required")
+}
+
+func TestCreateDocumentReference_FailureDueToExistingID(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	mockStoredDocument(mockStub, "doc1", StatusCurrent)
+
+	err := documentChaincode.CreateDocumentReference(mockCtx, sampleDocumentJSON(sampleDocument("doc1")))
+
+	assert.EqualError(t, err, "the document reference already exists")
+}
+
+func TestCreateDocumentReference_OnlyInTheCallersCustody(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockDoctorCaller(mockCtx, "doctor1")
+
+	documentKey := mockKey(mockStub, "DocumentReference", "doc1")
+	mockStub.On("GetState", documentKey).Return(nil, nil)
+	document := sampleDocument("doc1")
+	document.Custodian = &fhir.Reference{Reference: "Organization/OspedaleMaresca"}
+
+	err := documentChaincode.CreateDocumentReference(mockCtx, sampleDocumentJSON(document))
+
+	assert.EqualError(t, err, "organizations may only anchor documents in their own custody")
+}
+
+func TestCreateDocumentReference_SupersedesTheDocumentItReplaces(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockDoctorCaller(mockCtx, "doctor1")
+
+	replacedKey := mockStoredDocument(mockStub, "doc1", StatusCurrent)
+	documentKey := mockKey(mockStub, "DocumentReference", "doc2")
+	mockStub.On("GetState", documentKey).Return(nil, nil)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: 1714550400}, nil)
+	mockStub.On("PutState", replacedKey, storedDocumentStatus(StatusSuperseded)).Return(nil)
+	mockStub.On("PutState", documentKey, storedDocumentStatus(StatusCurrent)).Return(nil)
+
+	document := sampleDocument("doc2")
+	document.RelatesTo = []fhir.DocumentReferenceRelatesTo{{Code: "replaces", Target: &fhir.Reference{Reference: "DocumentReference/doc1"}}}
+	err := documentChaincode.CreateDocumentReference(mockCtx, sampleDocumentJSON(document))
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestCreateDocumentReference_CannotReplaceAnotherPatientsDocument(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockDoctorCaller(mockCtx, "doctor1")
+
+	mockStoredDocument(mockStub, "doc1", StatusCurrent)
+	documentKey := mockKey(mockStub, "DocumentReference", "doc2")
+	mockStub.On("GetState", documentKey).Return(nil, nil)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: 1714550400}, nil)
+
+	document := sampleDocument("doc2")
+	document.Subject = &fhir.Reference{Reference: "Patient/patient2"}
+	document.RelatesTo = []fhir.DocumentReferenceRelatesTo{{Code: "replaces", Target: &fhir.Reference{Reference: "DocumentReference/doc1"}}}
+	err := documentChaincode.CreateDocumentReference(mockCtx, sampleDocumentJSON(document))
+
+	assert.EqualError(t, err, "document DocumentReference/doc1 is about another patient")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestCreateDocumentReference_CannotReplaceASupersededDocument(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockDoctorCaller(mockCtx, "doctor1")
+
+	mockStoredDocument(mockStub, "doc1", StatusSuperseded)
+	documentKey := mockKey(mockStub, "DocumentReference", "doc2")
+	mockStub.On("GetState", documentKey).Return(nil, nil)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: 1714550400}, nil)
+
+	document := sampleDocument("doc2")
+	document.RelatesTo = []fhir.DocumentReferenceRelatesTo{{Code: "replaces", Target: &fhir.Reference{Reference: "DocumentReference/doc1"}}}
+	err := documentChaincode.CreateDocumentReference(mockCtx, sampleDocumentJSON(document))
+
+	assert.EqualError(t, err, "document DocumentReference/doc1 is superseded and cannot be replaced")
+}
+
+func TestGetDocumentReference_Successful(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	mockStoredDocument(mockStub, "doc1", StatusCurrent)
+
+	document, err := documentChaincode.GetDocumentReference(mockCtx, "doc1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, contentHash("discharge letter"), document.Content[0].Attachment.Hash)
+}
+
+func TestGetDocumentReference_NotFound(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	documentKey := mockKey(mockStub, "DocumentReference", "missing")
+	mockStub.On("GetState", documentKey).Return(nil, nil)
+
+	_, err := documentChaincode.GetDocumentReference(mockCtx, "missing")
+
+	assert.EqualError(t, err, "document reference missing does not exist")
+}
+
+func TestQueryDocumentReferencesByPatient(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	older := sampleDocument("doc1")
+	older.Date = time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	newer := sampleDocument("doc2")
+	newer.Date = time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)
+	iterator := &MockIterator{}
+	iterator.AddRecord("\x00DocumentReference\x00doc1\x00", []byte(sampleDocumentJSON(older)))
+	iterator.AddRecord("\x00DocumentReference\x00doc2\x00", []byte(sampleDocumentJSON(newer)))
+	query := `{"selector":{"subject.reference":"Patient/patient1"},"use_index":["indexBySubject","indexBySubject"]}`
+	mockStub.On("GetQueryResult", query).Return(iterator, nil)
+
+	documents, err := documentChaincode.QueryDocumentReferencesByPatient(mockCtx, "patient1")
+
+	assert.NoError(t, err)
+	assert.Len(t, documents, 2)
+	assert.Equal(t, "doc2", documents[0].ID, "the newest document comes first")
+}
+
+func TestQueryDocumentReferencesByPatient_KeepsThePatientIDOutOfTheQuery(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	query := `{"selector":{"subject.reference":"Patient/x\",\"$or\":[{}],\"y\":\""},"use_index":["indexBySubject","indexBySubject"]}`
+	mockStub.On("GetQueryResult", query).Return(&MockIterator{}, nil)
+
+	documents, err := documentChaincode.QueryDocumentReferencesByPatient(mockCtx, `x","$or":[{}],"y":"`)
+
+	assert.NoError(t, err)
+	assert.Empty(t, documents)
+	mockStub.AssertExpectations(t)
+}
+
+func TestMarkDocumentReferenceEnteredInError_Successful(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockDoctorCaller(mockCtx, "doctor1")
+
+	documentKey := mockStoredDocument(mockStub, "doc1", StatusCurrent)
+	mockStub.On("PutState", documentKey, storedDocumentStatus(StatusEnteredInError)).Return(nil)
+
+	err := documentChaincode.MarkDocumentReferenceEnteredInError(mockCtx, "doc1")
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestMarkDocumentReferenceEnteredInError_OnlyTheCustodian(t *testing.T) {
+	documentChaincode := new(DocumentReferenceChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "doctor2", auth.OspedaleMarescaMSP, auth.RoleDoctor)
+
+	mockStoredDocument(mockStub, "doc1", StatusCurrent)
+
+	err := documentChaincode.MarkDocumentReferenceEnteredInError(mockCtx, "doc1")
+
+	assert.EqualError(t, err, "document reference doc1 is in the custody of another organization")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestBeforeTransaction_LabTechniciansNeedConsent(t *testing.T) {
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "technician1", auth.LaboratorioAnalisiCMOMSP, auth.RoleLabTechnician)
+
+	mockStub.On("GetFunctionAndParameters").Return("GetDocumentReference", []string{"doc1"})
+	mockStoredDocument(mockStub, "doc1", StatusCurrent)
+	var consentChecked bool
+	enforcer := &auth.Enforcer{Policies: documentPolicies, Consent: auth.ConsentFunc(func(ctx contractapi.TransactionContextInterface, patientID string, caller *auth.Identity, request auth.AccessRequest) (*auth.Grant, error) {
+		consentChecked = true
+		return nil, nil
+	})}
+
+	err := enforcer.BeforeTransaction(mockCtx)
+
+	assert.EqualError(t, err, "access denied: no consent from patient patient1")
+	assert.True(t, consentChecked)
+}
+
+func TestPoliciesCoverEveryTransaction(t *testing.T) {
+	assert.Empty(t, auth.MissingPolicies(new(DocumentReferenceChaincode), documentPolicies), "transactions without an access policy")
+}
+
+func TestChaincodeMetadataIsValid(t *testing.T) {
+	// contractapi rejects at startup any transaction whose types it cannot describe
+	_, err := contractapi.NewChaincode(new(DocumentReferenceChaincode))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,20 @@
+package main
+
+import "github.com/xDaryamo/MedChain/auth"
+
+var clinicalRoles = []string{auth.RoleDoctor, auth.RoleNurse}
+
+// authorRoles are the roles that anchor documents: clinicians and the laboratories issuing reports
+var authorRoles = auth.Join(clinicalRoles, []string{auth.RoleLabTechnician})
+
+// documentPolicies declares who may call each DocumentReferenceChaincode transaction.
+// Clinicians and lab technicians need the patient's consent to anchor or read the patient's documents.
+var documentPolicies = auth.Policies{
+	"CreateDocumentReference":             {Roles: authorRoles, Subject: auth.PayloadSubject(0), ConsentRoles: authorRoles, Resource: "DocumentReference", Action: auth.ActionWrite},
+	"GetDocumentReference":                {Roles: auth.Join(authorRoles, []string{auth.RolePatient}), Subject: auth.StoredSubject("DocumentReference", 0), ConsentRoles: authorRoles, Resource: "DocumentReference", Action: auth.ActionRead},
+	"GetDocumentReferenceHistory":         {Roles: auth.Join(authorRoles, []string{auth.RolePatient}), Subject: auth.LastKnownSubject("DocumentReference", 0), ConsentRoles: authorRoles, Resource: "DocumentReference", Action: auth.ActionRead},
+	"QueryDocumentReferencesByPatient":    {Roles: auth.Join(clinicalRoles, []string{auth.RolePatient}), Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "DocumentReference", Action: auth.ActionRead},
+	"MarkDocumentReferenceEnteredInError": {Roles: authorRoles, Subject: auth.StoredSubject("DocumentReference", 0), ConsentRoles: authorRoles, Resource: "DocumentReference", Action: auth.ActionWrite},
+}
+
+var documentEnforcer = &auth.Enforcer{Policies: documentPolicies, Consent: auth.AnyConsent(auth.PatientConsent, auth.EmergencyConsent)}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+)
+
+// EmergencyAccessDuration is how long a break-the-glass access lasts
+const EmergencyAccessDuration = 24 * time.Hour
+
+// Break-the-glass accesses are stored under the composite key EmergencyAccess~patientID~txID
+const emergencyAccessObjectType = "EmergencyAccess"
+
+// EmergencyAccessEvent is the chaincode event emitted when a clinician breaks the glass
+const EmergencyAccessEvent = "BreakTheGlass"
+
+// EmergencyAccess records a clinician reading a patient's data without prior consent
+type EmergencyAccess struct {
+	ID            string    `json:"id"`            // ID of the transaction that broke the glass
+	PatientID     string    `json:"patientId"`     // Patient whose data is accessed
+	UserID        string    `json:"userId"`        // userId attribute of the clinician
+	IdentityID    string    `json:"identityId"`    // x509 identity of the clinician's certificate
+	MSPID         string    `json:"mspId"`         // Hospital of the clinician
+	Role          string    `json:"role"`          // Role of the clinician, e.g., doctor, nurse
+	Justification string    `json:"justification"` // Reason given for the emergency access
+	Start         time.Time `json:"start"`         // When the glass was broken
+	End           time.Time `json:"end"`           // When the emergency access lapses
+}
+
+type EmergencyContract struct {
+	contractapi.Contract
+}
+
+// BreakTheGlass grants the calling hospital clinician read access to the data of patientID
+// for EmergencyAccessDuration, without the patient's consent. The access is recorded with
+// its justification and announced through the BreakTheGlass chaincode event.
+func (c *EmergencyContract) BreakTheGlass(ctx contractapi.TransactionContextInterface, patientID string, justification string) (*EmergencyAccess, error) {
+	if strings.TrimSpace(patientID) == "" {
+		return nil, errors.New("patient ID is required")
+	}
+	if strings.TrimSpace(justification) == "" {
+		return nil, errors.New("a justification is required to break the glass")
+	}
+
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if caller.UserID == "" {
+		return nil, errors.New("client ID attribute does not exist")
+	}
+
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+	start := timestamp.AsTime()
+
+	access := EmergencyAccess{
+		ID:            ctx.GetStub().GetTxID(),
+		PatientID:     patientID,
+		UserID:        caller.UserID,
+		IdentityID:    caller.ID,
+		MSPID:         caller.MSPID,
+		Role:          caller.Role,
+		Justification: justification,
+		Start:         start,
+		End:           start.Add(EmergencyAccessDuration),
+	}
+
+	accessKey, err := ctx.GetStub().CreateCompositeKey(emergencyAccessObjectType, []string{patientID, access.ID})
+	if err != nil {
+		return nil, errors.New("failed to create emergency access key: " + err.Error())
+	}
+
+	accessJSON, err := json.Marshal(access)
+	if err != nil {
+		return nil, errors.New("failed to marshal emergency access: " + err.Error())
+	}
+
+	if err := ctx.GetStub().PutState(accessKey, accessJSON); err != nil {
+		return nil, errors.New("failed to put state: " + err.Error())
+	}
+	if err := ctx.GetStub().SetEvent(EmergencyAccessEvent, accessJSON); err != nil {
+		return nil, errors.New("failed to set event: " + err.Error())
+	}
+
+	return &access, nil
+}
+
+// FindEmergencyAccess returns the ID of the emergency access userID of mspID holds on the data
+// of patientID at the transaction timestamp, or an empty string when there is none. The other
+// chaincodes query it when checking consent.
+func (c *EmergencyContract) FindEmergencyAccess(ctx contractapi.TransactionContextInterface, patientID string, mspID string, userID string) (string, error) {
+	accesses, err := c.ListEmergencyAccesses(ctx, patientID)
+	if err != nil {
+		return "", err
+	}
+
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+	now := timestamp.AsTime()
+
+	for _, access := range accesses {
+		if access.MSPID == mspID && access.UserID == userID && !now.Before(access.Start) && now.Before(access.End) {
+			return access.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// ListEmergencyAccesses returns every break-the-glass access to the data of patientID
+func (c *EmergencyContract) ListEmergencyAccesses(ctx contractapi.TransactionContextInterface, patientID string) ([]*EmergencyAccess, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(emergencyAccessObjectType, []string{patientID})
+	if err != nil {
+		return nil, errors.New("failed to get emergency accesses: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var accesses []*EmergencyAccess
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, errors.New("failed to iterate emergency accesses: " + err.Error())
+		}
+
+		var access EmergencyAccess
+		if err := json.Unmarshal(queryResponse.Value, &access); err != nil {
+			return nil, errors.New("failed to unmarshal emergency access: " + err.Error())
+		}
+		accesses = append(accesses, &access)
+	}
+
+	return accesses, nil
+}
+
+func main() {
+	emergencyContract := new(EmergencyContract)
+	emergencyContract.BeforeTransaction = emergencyEnforcer.BeforeTransaction
+
+	chaincode, err := contractapi.NewChaincode(emergencyContract)
+	if err != nil {
+		log.Panic(errors.New("Error creating emergency chaincode: " + err.Error()))
+	}
+
+	if err := chaincode.Start(); err != nil {
+		log.Panic(errors.New("Error starting emergency chaincode: " + err.Error()))
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/xDaryamo/MedChain/auth"
+)
+
+// MockStub is a mock implementation of the ChaincodeStubInterface
+type MockStub struct {
+	mock.Mock
+}
+
+func (m *MockStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	args := m.Called(objectType, attributes)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStub) DelPrivateData(collection string, key string) error {
+	args := m.Called(collection, key)
+	return args.Error(0)
+}
+
+func (m *MockStub) DelState(key string) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockStub) GetArgs() [][]byte {
+	args := m.Called()
+	return args.Get(0).([][]byte)
+}
+
+func (m *MockStub) GetArgsSlice() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetBinding() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetChannelID() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *MockStub) GetCreator() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetDecorations() map[string][]byte {
+	args := m.Called()
+	return args.Get(0).(map[string][]byte)
+}
+
+func (m *MockStub) GetFunctionAndParameters() (string, []string) {
+	args := m.Called()
+	return args.String(0), args.Get(1).([]string)
+}
+
+func (m *MockStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	args := m.Called(key)
+	return args.Get(0).(shim.HistoryQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	args := m.Called(collection, key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(collection, objectType, attributes)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(collection, startKey, endKey)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	args := m.Called(collection, key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(collection, query)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	args := m.Called(collection, key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(query)
+	// Check if the first argument is nil
+	if args.Get(0) == nil {
+		// Return a properly initialized MockIterator along with a nil error
+		return new(MockIterator), args.Error(1)
+	}
+	// Otherwise, return the mock iterator and the error as usual
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	args := m.Called(query, pageSize, bookmark)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Get(1).(*peer.QueryResponseMetadata), args.Error(2)
+}
+
+func (m *MockStub) GetSignedProposal() (*peer.SignedProposal, error) {
+	args := m.Called()
+	return args.Get(0).(*peer.SignedProposal), args.Error(1)
+}
+
+func (m *MockStub) GetState(key string) ([]byte, error) {
+	args := m.Called(key)
+	// Check if the first argument is nil
+	if args.Get(0) == nil {
+		// Return nil along with the error
+		return nil, args.Error(1)
+	}
+	// Otherwise, return the byte slice and the error as usual
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(objectType, attributes)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	args := m.Called(objectType, keys, pageSize, bookmark)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Get(1).(*peer.QueryResponseMetadata), args.Error(2)
+}
+
+func (m *MockStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(startKey, endKey)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	args := m.Called(startKey, endKey, pageSize, bookmark)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Get(1).(*peer.QueryResponseMetadata), args.Error(2)
+}
+
+func (m *MockStub) GetStateValidationParameter(key string) ([]byte, error) {
+	args := m.Called(key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetStringArgs() []string {
+	args := m.Called()
+	return args.Get(0).([]string)
+}
+
+func (m *MockStub) GetTransient() (map[string][]byte, error) {
+	args := m.Called()
+	return args.Get(0).(map[string][]byte), args.Error(1)
+}
+
+func (m *MockStub) GetTxID() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *MockStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	args := m.Called()
+	return args.Get(0).(*timestamp.Timestamp), args.Error(1)
+}
+
+func (m *MockStub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) peer.Response {
+	callArgs := m.Called(chaincodeName, args, channel)
+	return callArgs.Get(0).(peer.Response)
+}
+
+func (m *MockStub) PurgePrivateData(collection string, key string) error {
+	args := m.Called(collection, key)
+	return args.Error(0)
+}
+
+func (m *MockStub) PutPrivateData(collection string, key string, value []byte) error {
+	args := m.Called(collection, key, value)
+	return args.Error(0)
+}
+
+func (m *MockStub) PutState(key string, value []byte) error {
+	args := m.Called(key, value)
+	return args.Error(0)
+}
+
+func (m *MockStub) SetEvent(name string, payload []byte) error {
+	args := m.Called(name, payload)
+	return args.Error(0)
+}
+
+func (m *MockStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	args := m.Called(collection, key, ep)
+	return args.Error(0)
+}
+
+func (m *MockStub) SetStateValidationParameter(key string, ep []byte) error {
+	args := m.Called(key, ep)
+	return args.Error(0)
+}
+
+func (m *MockStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	args := m.Called(compositeKey)
+	return args.String(0), args.Get(1).([]string), args.Error(2)
+}
+
+type MockTransactionContext struct {
+	mock.Mock
+}
+
+func (m *MockTransactionContext) GetStub() shim.ChaincodeStubInterface {
+	args := m.Called()
+	return args.Get(0).(shim.ChaincodeStubInterface)
+}
+
+func (m *MockTransactionContext) GetClientIdentity() cid.ClientIdentity {
+	args := m.Called()
+	return args.Get(0).(cid.ClientIdentity)
+}
+
+// KVPair represents a key-value pair for testing purposes
+type KVPair struct {
+	Key   string
+	Value []byte
+}
+
+// MockIterator is a mock implementation of the StateQueryIteratorInterface
+type MockIterator struct {
+	Records      []KVPair // Slice to hold the records for iteration
+	CurrentIndex int      // Index to keep track of the current position
+}
+
+// HasNext returns true if the iterator has more items to iterate over
+func (m *MockIterator) HasNext() bool {
+	return m.CurrentIndex < len(m.Records)
+}
+
+// Next returns the next key and value in the iterator
+func (m *MockIterator) Next() (*queryresult.KV, error) {
+	if m.CurrentIndex >= len(m.Records) {
+		return nil, nil
+	}
+	result := m.Records[m.CurrentIndex]
+	m.CurrentIndex++
+	kv := &queryresult.KV{
+		Key:   result.Key,
+		Value: result.Value,
+	}
+	return kv, nil
+}
+
+// AddRecord adds a key-value pair to the mock iterator
+func (m *MockIterator) AddRecord(key string, value []byte) {
+	m.Records = append(m.Records, KVPair{Key: key, Value: value})
+}
+
+// Close closes the mock iterator (implements shim.StateQueryIteratorInterface)
+func (m *MockIterator) Close() error {
+	// No action needed for a mock iterator, return nil
+	return nil
+}
+
+type MockClientIdentity struct {
+	mock.Mock
+}
+
+func (mci *MockClientIdentity) GetID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetMSPID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	args := mci.Called(attrName)
+	return args.String(0), args.Bool(1), args.Error(2)
+}
+
+func (mci *MockClientIdentity) HasAttribute(attrName string) (bool, error) {
+	args := mci.Called(attrName)
+	return args.Bool(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	args := mci.Called(attrName, attrValue)
+	return args.Error(0)
+}
+
+func (mci *MockClientIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	args := mci.Called()
+	return args.Get(0).(*x509.Certificate), args.Error(1)
+}
+
+// Tests
+
+func newClinician(userID string) *MockClientIdentity {
+	clientIdentity := new(MockClientIdentity)
+	clientIdentity.On("GetID").Return("x509::CN="+userID, nil)
+	clientIdentity.On("GetMSPID").Return("OspedaleMarescaMSP", nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(userID, true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return("doctor", true, nil)
+	return clientIdentity
+}
+
+func TestBreakTheGlass_Success(t *testing.T) {
+	contract := new(EmergencyContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := newClinician("doctor-001")
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	now := time.Date(2024, time.May, 1, 3, 0, 0, 0, time.UTC)
+
+	stub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: now.Unix()}, nil)
+	stub.On("GetTxID").Return("tx-001")
+	stub.On("CreateCompositeKey", "EmergencyAccess", []string{"patient-001", "tx-001"}).Return("access-key", nil)
+	stub.On("PutState", "access-key", mock.Anything).Return(nil)
+	stub.On("SetEvent", "BreakTheGlass", mock.Anything).Return(nil)
+
+	access, err := contract.BreakTheGlass(ctx, "patient-001", "Unconscious patient admitted to the emergency room")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "doctor-001", access.UserID)
+	assert.Equal(t, "x509::CN=doctor-001", access.IdentityID)
+	assert.Equal(t, "OspedaleMarescaMSP", access.MSPID)
+	assert.Equal(t, "Unconscious patient admitted to the emergency room", access.Justification)
+	assert.True(t, access.End.Equal(now.Add(EmergencyAccessDuration)))
+
+	// The event carries the same record that is stored on the ledger
+	storedJSON := stub.Calls[3].Arguments.Get(1).([]byte)
+	eventJSON := stub.Calls[4].Arguments.Get(1).([]byte)
+	assert.Equal(t, storedJSON, eventJSON)
+	stub.AssertExpectations(t)
+}
+
+func TestBreakTheGlass_RequiresJustification(t *testing.T) {
+	contract := new(EmergencyContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+
+	ctx.On("GetStub").Return(stub)
+
+	access, err := contract.BreakTheGlass(ctx, "patient-001", "   ")
+
+	assert.Nil(t, access)
+	assert.EqualError(t, err, "a justification is required to break the glass")
+	stub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestFindEmergencyAccess(t *testing.T) {
+	contract := new(EmergencyContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+
+	ctx.On("GetStub").Return(stub)
+	now := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+	current := EmergencyAccess{ID: "tx-002", PatientID: "patient-001", UserID: "doctor-002", MSPID: "OspedaleMarescaMSP", Start: now.Add(-time.Hour), End: now.Add(23 * time.Hour)}
+	lapsed := EmergencyAccess{ID: "tx-001", PatientID: "patient-001", UserID: "doctor-001", MSPID: "OspedaleMarescaMSP", Start: now.Add(-48 * time.Hour), End: now.Add(-24 * time.Hour)}
+	currentJSON, _ := json.Marshal(current)
+	lapsedJSON, _ := json.Marshal(lapsed)
+
+	stub.On("GetStateByPartialCompositeKey", "EmergencyAccess", []string{"patient-001"}).Return(func() shim.StateQueryIteratorInterface {
+		iterator := new(MockIterator)
+		iterator.AddRecord("key-1", lapsedJSON)
+		iterator.AddRecord("key-2", currentJSON)
+		return iterator
+	}(), nil).Once()
+	stub.On("GetStateByPartialCompositeKey", "EmergencyAccess", []string{"patient-001"}).Return(func() shim.StateQueryIteratorInterface {
+		iterator := new(MockIterator)
+		iterator.AddRecord("key-1", lapsedJSON)
+		iterator.AddRecord("key-2", currentJSON)
+		return iterator
+	}(), nil).Once()
+	stub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: now.Unix()}, nil)
+
+	accessID, err := contract.FindEmergencyAccess(ctx, "patient-001", "OspedaleMarescaMSP", "doctor-002")
+	assert.Nil(t, err)
+	assert.Equal(t, "tx-002", accessID)
+
+	accessID, err = contract.FindEmergencyAccess(ctx, "patient-001", "OspedaleMarescaMSP", "doctor-001")
+	assert.Nil(t, err)
+	assert.Empty(t, accessID)
+}
+
+func TestFindEmergencyAccess_MatchesTheOrganization(t *testing.T) {
+	contract := new(EmergencyContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+
+	ctx.On("GetStub").Return(stub)
+	now := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+	// doctor-001 of OspedaleMaresca broke the glass; doctor-001 of OspedaleDelMare is someone else
+	access := EmergencyAccess{ID: "tx-001", PatientID: "patient-001", UserID: "doctor-001", MSPID: "OspedaleMarescaMSP", Start: now.Add(-time.Hour), End: now.Add(23 * time.Hour)}
+	accessJSON, _ := json.Marshal(access)
+	iterator := new(MockIterator)
+	iterator.AddRecord("key-1", accessJSON)
+	stub.On("GetStateByPartialCompositeKey", "EmergencyAccess", []string{"patient-001"}).Return(iterator, nil)
+	stub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: now.Unix()}, nil)
+
+	accessID, err := contract.FindEmergencyAccess(ctx, "patient-001", "OspedaleDelMareMSP", "doctor-001")
+
+	assert.Nil(t, err)
+	assert.Empty(t, accessID)
+}
+
+func TestListEmergencyAccesses(t *testing.T) {
+	contract := new(EmergencyContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+
+	ctx.On("GetStub").Return(stub)
+
+	access := EmergencyAccess{ID: "tx-001", PatientID: "patient-001", UserID: "doctor-001", Justification: "Cardiac arrest"}
+	accessJSON, _ := json.Marshal(access)
+	iterator := new(MockIterator)
+	iterator.AddRecord("key-1", accessJSON)
+	stub.On("GetStateByPartialCompositeKey", "EmergencyAccess", []string{"patient-001"}).Return(iterator, nil)
+
+	accesses, err := contract.ListEmergencyAccesses(ctx, "patient-001")
+
+	assert.Nil(t, err)
+	assert.Len(t, accesses, 1)
+	assert.Equal(t, "Cardiac arrest", accesses[0].Justification)
+}
+
+func TestBeforeTransaction_OnlyHospitalCliniciansBreakTheGlass(t *testing.T) {
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	stub.On("GetFunctionAndParameters").Return("BreakTheGlass", []string{"patient-001", "Emergency"})
+	clientIdentity.On("GetID").Return("x509::CN=pharmacist-001", nil)
+	clientIdentity.On("GetMSPID").Return("FarmaciaPetroneMSP", nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return("pharmacist-001", true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return("pharmacist", true, nil)
+
+	err := emergencyEnforcer.BeforeTransaction(ctx)
+
+	assert.EqualError(t, err, "access denied: organization FarmaciaPetroneMSP may not call BreakTheGlass")
+}
+
+func TestPoliciesCoverEveryTransaction(t *testing.T) {
+	assert.Empty(t, auth.MissingPolicies(new(EmergencyContract), emergencyPolicies), "transactions without an access policy")
+}
+
+func TestChaincodeMetadataIsValid(t *testing.T) {
+	// contractapi rejects at startup any transaction whose types it cannot describe
+	_, err := contractapi.NewChaincode(new(EmergencyContract))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,13 @@
+package main
+
+import "github.com/xDaryamo/MedChain/auth"
+
+// emergencyPolicies declares who may call each EmergencyContract transaction.
+// Only hospital clinicians may break the glass; the patient and compliance officers review the accesses.
+var emergencyPolicies = auth.Policies{
+	"BreakTheGlass":         {MSPs: auth.HospitalMSPs, Roles: []string{auth.RoleDoctor, auth.RoleNurse}},
+	"FindEmergencyAccess":   {},
+	"ListEmergencyAccesses": {Roles: []string{auth.RolePatient, auth.RoleCompliance}, Subject: auth.Arg(0)},
+}
+
+var emergencyEnforcer = &auth.Enforcer{Policies: emergencyPolicies}
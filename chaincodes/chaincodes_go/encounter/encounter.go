@@ -8,6 +8,23 @@ import (
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+	"github.com/xDaryamo/MedChain/history"
+	"github.com/xDaryamo/MedChain/ledger"
+	"github.com/xDaryamo/MedChain/query"
+)
+
+// Encounters are stored under the composite key Encounter~encounterID
+const encounterObjectType = "Encounter"
+
+// dayLayout formats the calendar days the date range lookup selects encounters by
+const dayLayout = "2006-01-02"
+
+// CouchDB indexes of the lookups, shipped under META-INF/statedb/couchdb/indexes
+const (
+	subjectIndex = "indexBySubject"          // subject.reference
+	periodIndex  = "indexBySubjectAndPeriod" // subject.reference, period.start
 )
 
 // EncounterChaincode represents the Chaincode for managing Encounters on the blockchain
@@ -18,7 +35,7 @@ type EncounterChaincode struct {
 // CreateEncounter creates a new Encounter
 func (ec *EncounterChaincode) CreateEncounter(ctx contractapi.TransactionContextInterface, encounterID string, encounterJSON string) error {
 	// Deserialize JSON data into a Go data structure
-	var encounter Encounter
+	var encounter fhir.Encounter
 	if err := json.Unmarshal([]byte(encounterJSON), &encounter); err != nil {
 		return err
 	}
@@ -32,18 +49,24 @@ func (ec *EncounterChaincode) CreateEncounter(ctx contractapi.TransactionContext
 		return errors.New("encounter record already exists")
 	}
 
+	normalizeSubject(&encounter)
+
 	// Serialize the Encounter record and save it on the blockchain
 	encounterJSONBytes, err := json.Marshal(encounter)
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(encounterID, encounterJSONBytes)
+	return ec.putEncounter(ctx, encounterID, encounterJSONBytes)
 }
 
 // GetEncounter retrieves an Encounter from the blockchain
-func (ec *EncounterChaincode) GetEncounter(ctx contractapi.TransactionContextInterface, encounterID string) (*Encounter, error) {
+func (ec *EncounterChaincode) GetEncounter(ctx contractapi.TransactionContextInterface, encounterID string) (*fhir.Encounter, error) {
 	// Retrieve the Encounter record from the blockchain
-	encounterJSON, err := ctx.GetStub().GetState(encounterID)
+	encounterKey, err := ledger.Key(ctx, encounterObjectType, encounterID)
+	if err != nil {
+		return nil, err
+	}
+	encounterJSON, err := ctx.GetStub().GetState(encounterKey)
 	if err != nil {
 		return nil, err
 	}
@@ -52,7 +75,7 @@ func (ec *EncounterChaincode) GetEncounter(ctx contractapi.TransactionContextInt
 	}
 
 	// Deserialize the Encounter record
-	var encounter Encounter
+	var encounter fhir.Encounter
 	err = json.Unmarshal(encounterJSON, &encounter)
 	if err != nil {
 		return nil, err
@@ -61,6 +84,16 @@ func (ec *EncounterChaincode) GetEncounter(ctx contractapi.TransactionContextInt
 	return &encounter, nil
 }
 
+// GetEncounterHistory returns every version of an encounter as a FHIR history Bundle,
+// including deletions, with the transaction and identity that wrote each version
+func (ec *EncounterChaincode) GetEncounterHistory(ctx contractapi.TransactionContextInterface, encounterID string) (string, error) {
+	encounterKey, err := ledger.Key(ctx, encounterObjectType, encounterID)
+	if err != nil {
+		return "", err
+	}
+	return history.GetHistoryJSON(ctx, encounterKey, "Encounter", encounterID)
+}
+
 // UpdateEncounter updates an existing Encounter
 func (ec *EncounterChaincode) UpdateEncounter(ctx contractapi.TransactionContextInterface, encounterID string, updatedEncounterJSON string) error {
 	// Retrieve the existing Encounter record
@@ -73,21 +106,27 @@ func (ec *EncounterChaincode) UpdateEncounter(ctx contractapi.TransactionContext
 	}
 
 	// Deserialize the updated JSON data into a Go data structure
-	var updatedEncounter Encounter
+	var updatedEncounter fhir.Encounter
 	if err := json.Unmarshal([]byte(updatedEncounterJSON), &updatedEncounter); err != nil {
 		return err
 	}
 
+	// The policy checked consent for the stored subject only, so the encounter stays with that patient
+	if subjectPatientID(&updatedEncounter) != subjectPatientID(existingEncounter) {
+		return errors.New("the subject of encounter " + encounterID + " cannot be changed")
+	}
+
 	// Update the existing Encounter record with the new data
 	// (you may need to implement your own logic for updating specific fields)
 	*existingEncounter = updatedEncounter
+	normalizeSubject(existingEncounter)
 
 	// Serialize the updated Encounter record and save it on the blockchain
 	updatedEncounterJSONBytes, err := json.Marshal(existingEncounter)
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(encounterID, updatedEncounterJSONBytes)
+	return ec.putEncounter(ctx, encounterID, updatedEncounterJSONBytes)
 }
 
 // DeleteEncounter removes an existing Encounter
@@ -102,212 +141,75 @@ func (ec *EncounterChaincode) DeleteEncounter(ctx contractapi.TransactionContext
 	}
 
 	// Remove the Encounter record from the blockchain
-	return ctx.GetStub().DelState(encounterID)
-}
-
-// SearchEncounter allows searching for Encounter based on certain criteria
-func (ec *EncounterChaincode) SearchEncounter(ctx contractapi.TransactionContextInterface, query string) ([]*Encounter, error) {
-	var results []*Encounter
-
-	// Retrieve all Encounter records stored on the blockchain
-	iterator, err := ctx.GetStub().GetStateByRange("", "")
+	encounterKey, err := ledger.Key(ctx, encounterObjectType, encounterID)
 	if err != nil {
-		return nil, err
-	}
-	defer iterator.Close()
-
-	// Iterate through all records and filter those that match the query
-	for iterator.HasNext() {
-		result, err := iterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		var encounter Encounter
-		err = json.Unmarshal(result.Value, &encounter)
-		if err != nil {
-			return nil, err
-		}
-
-		// Add Encounter records that match the query to the results
-		if strings.Contains(encounter.ID.Value, query) {
-			results = append(results, &encounter)
-		}
+		return err
 	}
-
-	return results, nil
+	return ctx.GetStub().DelState(encounterKey)
 }
 
-// GetEncountersByPatientID retrieves all Encounters associated with a specific patient ID
-func (ec *EncounterChaincode) GetEncountersByPatientID(ctx contractapi.TransactionContextInterface, patientID string) ([]*Encounter, error) {
-	var results []*Encounter
-
-	// Retrieve all Encounter records stored on the blockchain
-	iterator, err := ctx.GetStub().GetStateByRange("", "")
+// SearchEncounter searches the Encounters of a patient whose ID contains the query
+func (ec *EncounterChaincode) SearchEncounter(ctx contractapi.TransactionContextInterface, patientID string, query string) ([]*fhir.Encounter, error) {
+	encounters, err := ec.queryEncounters(ctx, subjectSelector(patientID), subjectIndex)
 	if err != nil {
 		return nil, err
 	}
-	defer iterator.Close()
 
-	// Iterate through all records and filter those associated with the specified patient ID
-	for iterator.HasNext() {
-		result, err := iterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		var encounter Encounter
-		err = json.Unmarshal(result.Value, &encounter)
-		if err != nil {
-			return nil, err
-		}
-
-		// Check if the Encounter record is associated with the specified patient ID
-		if encounter.Subject != nil && encounter.Subject.Reference == patientID {
-			results = append(results, &encounter)
+	// Keep the Encounter records that match the query
+	var results []*fhir.Encounter
+	for _, encounter := range encounters {
+		if encounter.ID != nil && strings.Contains(encounter.ID.Value, query) {
+			results = append(results, encounter)
 		}
 	}
 
 	return results, nil
 }
 
-// GetEncountersByDateRange retrieves all Encounters that occurred within a specified date range
-func (ec *EncounterChaincode) GetEncountersByDateRange(ctx contractapi.TransactionContextInterface, startDate time.Time, endDate time.Time) ([]*Encounter, error) {
-	var results []*Encounter
-
-	// Retrieve all Encounter records stored on the blockchain
-	iterator, err := ctx.GetStub().GetStateByRange("", "")
-	if err != nil {
-		return nil, err
-	}
-	defer iterator.Close()
-
-	// Iterate through all records and filter those that occurred within the specified date range
-	for iterator.HasNext() {
-		result, err := iterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		var encounter Encounter
-		err = json.Unmarshal(result.Value, &encounter)
-		if err != nil {
-			return nil, err
-		}
-
-		// Check if the Encounter record occurred within the specified date range
-		if encounter.Period.Start.After(startDate) && encounter.Period.End.Before(endDate) {
-			results = append(results, &encounter)
-		}
-	}
-
-	return results, nil
+// GetEncountersByPatientID retrieves all Encounters associated with a specific patient ID
+func (ec *EncounterChaincode) GetEncountersByPatientID(ctx contractapi.TransactionContextInterface, patientID string) ([]*fhir.Encounter, error) {
+	return ec.queryEncounters(ctx, subjectSelector(patientID), subjectIndex)
 }
 
-// GetEncountersByType retrieves all Encounters of a specific type
-func (ec *EncounterChaincode) GetEncountersByType(ctx contractapi.TransactionContextInterface, encounterType string) ([]*Encounter, error) {
-	var results []*Encounter
-
-	// Retrieve all Encounter records stored on the blockchain
-	iterator, err := ctx.GetStub().GetStateByRange("", "")
+// GetEncountersByDateRange retrieves the Encounters of a patient that occurred within a specified date range
+func (ec *EncounterChaincode) GetEncountersByDateRange(ctx contractapi.TransactionContextInterface, patientID string, startDate time.Time, endDate time.Time) ([]*fhir.Encounter, error) {
+	// Periods are stored as RFC 3339 strings, which only sort like the times they denote
+	// when they share a time zone. CouchDB selects the encounters by calendar day, widened
+	// to cover any time zone, and the exact bounds are checked here.
+	candidates, err := ec.queryEncounters(ctx, subjectSelector(patientID).
+		And("period.start", query.Gt(startDate.UTC().AddDate(0, 0, -1).Format(dayLayout))).
+		And("period.end", query.Lt(endDate.UTC().AddDate(0, 0, 2).Format(dayLayout))), periodIndex)
 	if err != nil {
 		return nil, err
 	}
-	defer iterator.Close()
-
-	// Iterate through all records and filter those of the specified type
-	for iterator.HasNext() {
-		result, err := iterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		var encounter Encounter
-		err = json.Unmarshal(result.Value, &encounter)
-		if err != nil {
-			return nil, err
-		}
 
-		// Check if the Encounter record has the specified type
-		for _, eType := range encounter.Type {
-			if eType.Text == encounterType {
-				results = append(results, &encounter)
-				break
-			}
+	var results []*fhir.Encounter
+	for _, encounter := range candidates {
+		if !encounter.Period.Start.IsZero() && !encounter.Period.End.IsZero() &&
+			encounter.Period.Start.After(startDate) && encounter.Period.End.Before(endDate) {
+			results = append(results, encounter)
 		}
 	}
-
 	return results, nil
 }
 
-// GetEncountersByLocation retrieves all Encounters that occurred at a specific location
-func (ec *EncounterChaincode) GetEncountersByLocation(ctx contractapi.TransactionContextInterface, locationID string) ([]*Encounter, error) {
-	var results []*Encounter
-
-	// Retrieve all Encounter records stored on the blockchain
-	iterator, err := ctx.GetStub().GetStateByRange("", "")
-	if err != nil {
-		return nil, err
-	}
-	defer iterator.Close()
-
-	// Iterate through all records and filter those that occurred at the specified location
-	for iterator.HasNext() {
-		result, err := iterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		var encounter Encounter
-		err = json.Unmarshal(result.Value, &encounter)
-		if err != nil {
-			return nil, err
-		}
-
-		// Check if the Encounter record occurred at the specified location
-		for _, loc := range encounter.Location {
-			if loc.ID.Value == locationID {
-				results = append(results, &encounter)
-				break
-			}
-		}
-	}
-
-	return results, nil
+// GetEncountersByType retrieves the Encounters of a patient of a specific type
+func (ec *EncounterChaincode) GetEncountersByType(ctx contractapi.TransactionContextInterface, patientID string, encounterType string) ([]*fhir.Encounter, error) {
+	return ec.queryEncounters(ctx, subjectSelector(patientID).And("type", query.ElemMatch(query.Where("text", query.Eq(encounterType)))), subjectIndex)
 }
 
-// GetEncountersByPractitioner retrieves all Encounters involving a specific practitioner
-func (ec *EncounterChaincode) GetEncountersByPractitioner(ctx contractapi.TransactionContextInterface, practitionerID string) ([]*Encounter, error) {
-	var results []*Encounter
-
-	// Retrieve all Encounter records stored on the blockchain
-	iterator, err := ctx.GetStub().GetStateByRange("", "")
-	if err != nil {
-		return nil, err
-	}
-	defer iterator.Close()
-
-	// Iterate through all records and filter those involving the specified practitioner
-	for iterator.HasNext() {
-		result, err := iterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		var encounter Encounter
-		err = json.Unmarshal(result.Value, &encounter)
-		if err != nil {
-			return nil, err
-		}
-
-		// Check if the Encounter record involves the specified practitioner
-		for _, participant := range encounter.Participant {
-			if participant.Individual != nil && participant.Individual.Reference == practitionerID {
-				results = append(results, &encounter)
-				break
-			}
-		}
-	}
+// GetEncountersByLocation retrieves the Encounters of a patient that occurred at a specific location
+func (ec *EncounterChaincode) GetEncountersByLocation(ctx contractapi.TransactionContextInterface, patientID string, locationID string) ([]*fhir.Encounter, error) {
+	return ec.queryEncounters(ctx, subjectSelector(patientID).And("location", query.ElemMatch(query.Where("identifier.value", query.Eq(locationID)))), subjectIndex)
+}
 
-	return results, nil
+// GetEncountersByPractitioner retrieves the Encounters of a patient involving a specific practitioner
+func (ec *EncounterChaincode) GetEncountersByPractitioner(ctx contractapi.TransactionContextInterface, patientID string, practitionerID string) ([]*fhir.Encounter, error) {
+	return ec.queryEncounters(ctx, subjectSelector(patientID).And("participant", query.ElemMatch(query.Where("individual.reference", query.Eq(practitionerID)))), subjectIndex)
 }
 
 // UpdateEncounterStatus updates the status of an existing Encounter
-func (ec *EncounterChaincode) UpdateEncounterStatus(ctx contractapi.TransactionContextInterface, encounterID string, newStatus Code) error {
+func (ec *EncounterChaincode) UpdateEncounterStatus(ctx contractapi.TransactionContextInterface, encounterID string, newStatus fhir.Code) error {
 	// Retrieve the existing Encounter record
 	existingEncounter, err := ec.GetEncounter(ctx, encounterID)
 	if err != nil {
@@ -318,18 +220,18 @@ func (ec *EncounterChaincode) UpdateEncounterStatus(ctx contractapi.TransactionC
 	}
 
 	// Update the status of the existing Encounter record
-	existingEncounter.Status = newStatus
+	existingEncounter.Status = &newStatus
 
 	// Serialize the updated Encounter record and save it on the blockchain
 	updatedEncounterJSONBytes, err := json.Marshal(existingEncounter)
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(encounterID, updatedEncounterJSONBytes)
+	return ec.putEncounter(ctx, encounterID, updatedEncounterJSONBytes)
 }
 
 // AddDiagnosisToEncounter adds a new diagnosis to an existing Encounter
-func (ec *EncounterChaincode) AddDiagnosisToEncounter(ctx contractapi.TransactionContextInterface, encounterID string, diagnosis EncounterDiagnosis) error {
+func (ec *EncounterChaincode) AddDiagnosisToEncounter(ctx contractapi.TransactionContextInterface, encounterID string, diagnosis fhir.EncounterDiagnosis) error {
 	// Retrieve the existing Encounter record
 	existingEncounter, err := ec.GetEncounter(ctx, encounterID)
 	if err != nil {
@@ -347,11 +249,11 @@ func (ec *EncounterChaincode) AddDiagnosisToEncounter(ctx contractapi.Transactio
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(encounterID, updatedEncounterJSONBytes)
+	return ec.putEncounter(ctx, encounterID, updatedEncounterJSONBytes)
 }
 
 // AddParticipantToEncounter adds a new participant to an existing Encounter
-func (ec *EncounterChaincode) AddParticipantToEncounter(ctx contractapi.TransactionContextInterface, encounterID string, participant EncounterParticipant) error {
+func (ec *EncounterChaincode) AddParticipantToEncounter(ctx contractapi.TransactionContextInterface, encounterID string, participant fhir.EncounterParticipant) error {
 	// Retrieve the existing Encounter record
 	existingEncounter, err := ec.GetEncounter(ctx, encounterID)
 	if err != nil {
@@ -369,7 +271,7 @@ func (ec *EncounterChaincode) AddParticipantToEncounter(ctx contractapi.Transact
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(encounterID, updatedEncounterJSONBytes)
+	return ec.putEncounter(ctx, encounterID, updatedEncounterJSONBytes)
 }
 
 // RemoveParticipantFromEncounter removes a participant from an existing Encounter
@@ -394,11 +296,11 @@ func (ec *EncounterChaincode) RemoveParticipantFromEncounter(ctx contractapi.Tra
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(encounterID, updatedEncounterJSONBytes)
+	return ec.putEncounter(ctx, encounterID, updatedEncounterJSONBytes)
 }
 
 // AddLocationToEncounter adds a new location to an existing Encounter
-func (ec *EncounterChaincode) AddLocationToEncounter(ctx contractapi.TransactionContextInterface, encounterID string, location Location) error {
+func (ec *EncounterChaincode) AddLocationToEncounter(ctx contractapi.TransactionContextInterface, encounterID string, location fhir.Location) error {
 	// Retrieve the existing Encounter record
 	existingEncounter, err := ec.GetEncounter(ctx, encounterID)
 	if err != nil {
@@ -416,7 +318,7 @@ func (ec *EncounterChaincode) AddLocationToEncounter(ctx contractapi.Transaction
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(encounterID, updatedEncounterJSONBytes)
+	return ec.putEncounter(ctx, encounterID, updatedEncounterJSONBytes)
 }
 
 // RemoveLocationFromEncounter removes a location from an existing Encounter
@@ -441,80 +343,96 @@ func (ec *EncounterChaincode) RemoveLocationFromEncounter(ctx contractapi.Transa
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(encounterID, updatedEncounterJSONBytes)
+	return ec.putEncounter(ctx, encounterID, updatedEncounterJSONBytes)
 }
 
-// GetEncountersByReason retrieves all Encounters with a specific reason for the encounter
-func (ec *EncounterChaincode) GetEncountersByReason(ctx contractapi.TransactionContextInterface, reason string) ([]*Encounter, error) {
-	var results []*Encounter
+// GetEncountersByReason retrieves the Encounters of a patient with a specific reason for the encounter
+func (ec *EncounterChaincode) GetEncountersByReason(ctx contractapi.TransactionContextInterface, patientID string, reason string) ([]*fhir.Encounter, error) {
+	return ec.queryEncounters(ctx, subjectSelector(patientID).And("reasonReference", query.ElemMatch(
+		query.Where("coding", query.ElemMatch(query.Where("display", query.Eq(reason)))),
+	)), subjectIndex)
+}
 
-	// Retrieve all Encounter records stored on the blockchain
-	iterator, err := ctx.GetStub().GetStateByRange("", "")
+// GetEncountersByServiceProvider retrieves the Encounters of a patient provided by a specific healthcare service provider
+func (ec *EncounterChaincode) GetEncountersByServiceProvider(ctx contractapi.TransactionContextInterface, patientID string, serviceProviderID string) ([]*fhir.Encounter, error) {
+	return ec.queryEncounters(ctx, subjectSelector(patientID).And("serviceProvider.reference", query.Eq(serviceProviderID)), subjectIndex)
+}
+
+// MigrateKeys moves up to limit encounters stored under their bare ID, as written before
+// encounters were namespaced, to their Encounter~encounterID key. It returns how many
+// it moved; call it until it returns 0.
+func (ec *EncounterChaincode) MigrateKeys(ctx contractapi.TransactionContextInterface, limit int) (int, error) {
+	return ledger.MigrateKeys(ctx, func(key string, value []byte) (string, string, bool) {
+		return encounterObjectType, key, true
+	}, limit)
+}
+
+// queryEncounters returns the encounters matching a CouchDB Mango selector, read through the
+// given index of META-INF/statedb/couchdb/indexes. The indexes only hold scalar fields, so
+// every lookup selects the patient's encounters by subject and matches arrays with $elemMatch.
+func (ec *EncounterChaincode) queryEncounters(ctx contractapi.TransactionContextInterface, selector *query.Selector, index string) ([]*fhir.Encounter, error) {
+	queryJSON, err := query.New(selector).UseIndex(index, index).Build()
 	if err != nil {
 		return nil, err
 	}
+
+	iterator, err := ctx.GetStub().GetQueryResult(queryJSON)
+	if err != nil {
+		return nil, errors.New("failed to query encounters: " + err.Error())
+	}
 	defer iterator.Close()
 
-	// Iterate through all records and filter those with the specified reason
+	var results []*fhir.Encounter
 	for iterator.HasNext() {
 		result, err := iterator.Next()
 		if err != nil {
-			return nil, err
-		}
-		var encounter Encounter
-		err = json.Unmarshal(result.Value, &encounter)
-		if err != nil {
-			return nil, err
+			return nil, errors.New("failed to iterate encounters: " + err.Error())
 		}
-
-		// Check if the Encounter record has the specified reason
-		for _, r := range encounter.ReasonReference {
-			for _, coding := range r.Coding {
-				if coding.Display == reason {
-					results = append(results, &encounter)
-					break
-				}
-			}
+		var encounter fhir.Encounter
+		if err := json.Unmarshal(result.Value, &encounter); err != nil {
+			return nil, errors.New("failed to unmarshal encounter: " + err.Error())
 		}
+		results = append(results, &encounter)
 	}
 
 	return results, nil
 }
 
-// GetEncountersByServiceProvider retrieves all Encounters provided by a specific healthcare service provider
-func (ec *EncounterChaincode) GetEncountersByServiceProvider(ctx contractapi.TransactionContextInterface, serviceProviderID string) ([]*Encounter, error) {
-	var results []*Encounter
+// subjectSelector selects the encounters of a patient, given as an ID or a Patient/ reference
+func subjectSelector(patientID string) *query.Selector {
+	return query.Where("subject.reference", query.Eq("Patient/"+auth.PatientID(patientID)))
+}
 
-	// Retrieve all Encounter records stored on the blockchain
-	iterator, err := ctx.GetStub().GetStateByRange("", "")
-	if err != nil {
-		return nil, err
+// subjectPatientID returns the ID of the patient an encounter is about, empty when it has no subject
+func subjectPatientID(encounter *fhir.Encounter) string {
+	if encounter.Subject == nil {
+		return ""
 	}
-	defer iterator.Close()
-
-	// Iterate through all records and filter those provided by the specified service provider
-	for iterator.HasNext() {
-		result, err := iterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		var encounter Encounter
-		err = json.Unmarshal(result.Value, &encounter)
-		if err != nil {
-			return nil, err
-		}
+	return auth.PatientID(encounter.Subject.Reference)
+}
 
-		// Check if the Encounter record is provided by the specified service provider
-		if encounter.ServiceProvider != nil && encounter.ServiceProvider.Reference == serviceProviderID {
-			results = append(results, &encounter)
-		}
+// normalizeSubject stores the subject as a Patient/ reference, so the queries by patient find it
+// whether the client sent a bare ID or a reference
+func normalizeSubject(encounter *fhir.Encounter) {
+	if patientID := subjectPatientID(encounter); patientID != "" {
+		encounter.Subject.Reference = "Patient/" + patientID
 	}
+}
 
-	return results, nil
+func (ec *EncounterChaincode) putEncounter(ctx contractapi.TransactionContextInterface, encounterID string, encounterJSON []byte) error {
+	encounterKey, err := ledger.Key(ctx, encounterObjectType, encounterID)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(encounterKey, encounterJSON)
 }
 
 func main() {
-	chaincode, err := contractapi.NewChaincode(new(EncounterChaincode))
+	encounterChaincode := new(EncounterChaincode)
+	encounterChaincode.BeforeTransaction = encounterEnforcer.BeforeTransaction
+	encounterChaincode.AfterTransaction = history.RecordSubmitter
+
+	chaincode, err := contractapi.NewChaincode(encounterChaincode)
 	if err != nil {
 		log.Panic(errors.New("Error creating encounter chaincode: " + err.Error()))
 	}
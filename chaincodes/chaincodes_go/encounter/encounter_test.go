@@ -8,10 +8,13 @@ import (
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
 )
 
 // MockStub is a mock implementation of the ChaincodeStubInterface
@@ -277,6 +280,13 @@ func (m *MockIterator) Close() error {
 	return nil
 }
 
+// mockKey expects the composite key objectType~id to be created and returns it
+func mockKey(stub *MockStub, objectType string, id string) string {
+	key := "\x00" + objectType + "\x00" + id + "\x00"
+	stub.On("CreateCompositeKey", objectType, []string{id}).Return(key, nil)
+	return key
+}
+
 func TestCreateEncounter(t *testing.T) {
 
 	var mockStub *MockStub
@@ -305,10 +315,11 @@ func TestCreateEncounter(t *testing.T) {
 	}`
 
 	// Mocking GetState method to return nil for encounterID
-	mockStub.On("GetState", mock.Anything).Return(nil, nil)
+	encounterKey := mockKey(mockStub, "Encounter", encounterID)
+	mockStub.On("GetState", encounterKey).Return(nil, nil)
 
 	// Mocking PutState method to return nil
-	mockStub.On("PutState", mock.Anything, mock.Anything).Return(nil)
+	mockStub.On("PutState", encounterKey, mock.Anything).Return(nil)
 
 	// Call the function under test
 	err := ec.CreateEncounter(mockCtx, encounterID, encounterJSON)
@@ -346,7 +357,8 @@ func TestGetEncounter(t *testing.T) {
 	}`
 
 	// Mocking GetState method to return the sample encounter JSON
-	mockStub.On("GetState", encounterID).Return([]byte(encounterJSON), nil)
+	encounterKey := mockKey(mockStub, "Encounter", encounterID)
+	mockStub.On("GetState", encounterKey).Return([]byte(encounterJSON), nil)
 
 	// Call the function under test
 	resultEncounter, err := ec.GetEncounter(mockCtx, encounterID)
@@ -356,7 +368,7 @@ func TestGetEncounter(t *testing.T) {
 	assert.NotNil(t, resultEncounter)
 
 	// Deserialize the expected encounter JSON
-	var expectedEncounter Encounter
+	var expectedEncounter fhir.Encounter
 	err = json.Unmarshal([]byte(encounterJSON), &expectedEncounter)
 	assert.NoError(t, err)
 
@@ -380,17 +392,17 @@ func TestSearchEncounter(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Define sample encounter data
-	encounter1 := Encounter{ID: Identifier{System: "http://example.com/enc1", Value: "123456"}}
-	encounter2 := Encounter{ID: Identifier{System: "http://example.com/enc2", Value: "789012"}}
-	encounter3 := Encounter{ID: Identifier{System: "http://example.com/enc3", Value: "345678"}}
+	encounter1 := fhir.Encounter{ID: &fhir.Identifier{System: "http://example.com/enc1", Value: "123456"}}
+	encounter2 := fhir.Encounter{ID: &fhir.Identifier{System: "http://example.com/enc2", Value: "789012"}}
+	encounter3 := fhir.Encounter{ID: &fhir.Identifier{System: "http://example.com/enc3", Value: "345678"}}
 
 	// Serialize sample encounters to JSON
 	encounter1JSON, _ := json.Marshal(encounter1)
 	encounter2JSON, _ := json.Marshal(encounter2)
 	encounter3JSON, _ := json.Marshal(encounter3)
 
-	// Mocking GetStateByRange method to return sample encounter data
-	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{
+	// Mocking GetQueryResult to return the patient's encounters
+	mockStub.On("GetQueryResult", `{"selector":{"subject.reference":"Patient/patientID"},"use_index":["indexBySubject","indexBySubject"]}`).Return(&MockIterator{
 		Records: []KVPair{
 			{Key: "enc1", Value: encounter1JSON},
 			{Key: "enc2", Value: encounter2JSON},
@@ -400,7 +412,7 @@ func TestSearchEncounter(t *testing.T) {
 
 	// Call the function under test with a query
 	query := "789012" // Search for encounters containing "enc2" in the ID
-	resultEncounters, err := ec.SearchEncounter(mockCtx, query)
+	resultEncounters, err := ec.SearchEncounter(mockCtx, "patientID", query)
 
 	// Verify that the result is as expected
 	assert.NoError(t, err)
@@ -411,6 +423,34 @@ func TestSearchEncounter(t *testing.T) {
 	assert.Equal(t, encounter2, *resultEncounters[0])
 }
 
+func TestCreateEncounter_StoresBareSubjectIDAsReference(t *testing.T) {
+	mockCtx := new(MockTransactionContext)
+	ec := new(EncounterChaincode)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	encounterKey := mockKey(mockStub, "Encounter", "enc1")
+	mockStub.On("GetState", encounterKey).Return(nil, nil)
+	var stored []byte
+	mockStub.On("PutState", encounterKey, mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(1).([]byte)
+	}).Return(nil)
+
+	err := ec.CreateEncounter(mockCtx, "enc1", `{"id":{"value":"enc1"},"subject":{"reference":"patientID"}}`)
+	assert.NoError(t, err)
+
+	mockStub.On("GetQueryResult", `{"selector":{"subject.reference":"Patient/patientID"},"use_index":["indexBySubject","indexBySubject"]}`).Return(&MockIterator{
+		Records: []KVPair{{Key: encounterKey, Value: stored}},
+	}, nil)
+
+	results, err := ec.GetEncountersByPatientID(mockCtx, "patientID")
+
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "Patient/patientID", results[0].Subject.Reference)
+	}
+}
+
 func TestUpdateEncounter(t *testing.T) {
 
 	var mockStub *MockStub
@@ -427,18 +467,19 @@ func TestUpdateEncounter(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Define sample encounter data
-	existingEncounter := Encounter{ID: Identifier{System: "http://example.com/enc1", Value: "123456"}}
-	updatedEncounter := Encounter{ID: Identifier{System: "http://example.com/enc1", Value: "123456"} /* Add any updates */}
+	existingEncounter := fhir.Encounter{ID: &fhir.Identifier{System: "http://example.com/enc1", Value: "123456"}}
+	updatedEncounter := fhir.Encounter{ID: &fhir.Identifier{System: "http://example.com/enc1", Value: "123456"} /* Add any updates */}
 
 	// Serialize sample encounters to JSON
 	existingEncounterJSON, _ := json.Marshal(existingEncounter)
 	updatedEncounterJSON, _ := json.Marshal(updatedEncounter)
 
 	// Mocking GetEncounter method to return existing encounter data
-	mockStub.On("GetState", "123456").Return(existingEncounterJSON, nil)
+	encounterKey := mockKey(mockStub, "Encounter", "123456")
+	mockStub.On("GetState", encounterKey).Return(existingEncounterJSON, nil)
 
 	// Mocking PutState method to ensure the updated encounter is saved
-	mockStub.On("PutState", "123456", updatedEncounterJSON).Return(nil)
+	mockStub.On("PutState", encounterKey, updatedEncounterJSON).Return(nil)
 
 	// Call the function under test
 	err := ec.UpdateEncounter(mockCtx, "123456", string(updatedEncounterJSON))
@@ -447,6 +488,47 @@ func TestUpdateEncounter(t *testing.T) {
 	assert.NoError(t, err, "UpdateEncounter should not return an error")
 }
 
+func TestUpdateEncounter_RejectsAnotherSubject(t *testing.T) {
+	mockCtx := new(MockTransactionContext)
+	ec := new(EncounterChaincode)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	existingEncounter := fhir.Encounter{ID: &fhir.Identifier{Value: "123456"}, Subject: &fhir.Reference{Reference: "Patient/patientA"}}
+	updatedEncounter := fhir.Encounter{ID: &fhir.Identifier{Value: "123456"}, Subject: &fhir.Reference{Reference: "Patient/patientB"}}
+	existingEncounterJSON, _ := json.Marshal(existingEncounter)
+	updatedEncounterJSON, _ := json.Marshal(updatedEncounter)
+
+	encounterKey := mockKey(mockStub, "Encounter", "123456")
+	mockStub.On("GetState", encounterKey).Return(existingEncounterJSON, nil)
+
+	err := ec.UpdateEncounter(mockCtx, "123456", string(updatedEncounterJSON))
+
+	assert.EqualError(t, err, "the subject of encounter 123456 cannot be changed")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestUpdateEncounter_StoresBareSubjectIDAsReference(t *testing.T) {
+	mockCtx := new(MockTransactionContext)
+	ec := new(EncounterChaincode)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	existingEncounter := fhir.Encounter{ID: &fhir.Identifier{Value: "123456"}, Subject: &fhir.Reference{Reference: "Patient/patientA"}}
+	updatedEncounter := fhir.Encounter{ID: &fhir.Identifier{Value: "123456"}, Subject: &fhir.Reference{Reference: "patientA"}}
+	existingEncounterJSON, _ := json.Marshal(existingEncounter)
+	updatedEncounterJSON, _ := json.Marshal(updatedEncounter)
+
+	encounterKey := mockKey(mockStub, "Encounter", "123456")
+	mockStub.On("GetState", encounterKey).Return(existingEncounterJSON, nil)
+	mockStub.On("PutState", encounterKey, existingEncounterJSON).Return(nil)
+
+	err := ec.UpdateEncounter(mockCtx, "123456", string(updatedEncounterJSON))
+
+	assert.NoError(t, err)
+	mockStub.AssertCalled(t, "PutState", encounterKey, existingEncounterJSON)
+}
+
 func TestDeleteEncounter(t *testing.T) {
 
 	var mockStub *MockStub
@@ -463,16 +545,17 @@ func TestDeleteEncounter(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Define sample encounter data
-	existingEncounter := Encounter{ID: Identifier{System: "http://example.com/enc1", Value: "123456"}}
+	existingEncounter := fhir.Encounter{ID: &fhir.Identifier{System: "http://example.com/enc1", Value: "123456"}}
 
 	// Serialize sample encounter to JSON
 	existingEncounterJSON, _ := json.Marshal(existingEncounter)
 
 	// Mocking GetEncounter method to return existing encounter data
-	mockStub.On("GetState", "123456").Return(existingEncounterJSON, nil)
+	encounterKey := mockKey(mockStub, "Encounter", "123456")
+	mockStub.On("GetState", encounterKey).Return(existingEncounterJSON, nil)
 
 	// Mocking DelState method to ensure the encounter is deleted
-	mockStub.On("DelState", "123456").Return(nil)
+	mockStub.On("DelState", encounterKey).Return(nil)
 
 	// Call the function under test
 	err := ec.DeleteEncounter(mockCtx, "123456")
@@ -496,8 +579,8 @@ func TestGetEncountersByPatientID(t *testing.T) {
 	// Ensure GetStub returns the same instance of mockStub
 	mockCtx.On("GetStub").Return(mockStub)
 
-	// Mocking GetStateByRange to return a range of encounter data
-	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{}, nil)
+	// Mocking GetQueryResult to return no encounter for the CouchDB query
+	mockStub.On("GetQueryResult", `{"selector":{"subject.reference":"Patient/patientID"},"use_index":["indexBySubject","indexBySubject"]}`).Return(&MockIterator{}, nil)
 
 	// Call the function under test
 	results, err := ec.GetEncountersByPatientID(mockCtx, "patientID")
@@ -507,6 +590,22 @@ func TestGetEncountersByPatientID(t *testing.T) {
 	assert.Empty(t, results, "GetEncountersByPatientID should return empty results as no encounters are stored")
 }
 
+func TestGetEncountersByPatientID_AcceptsPatientReference(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	ec := new(EncounterChaincode)
+
+	mockStub.On("GetQueryResult", `{"selector":{"subject.reference":"Patient/patientID"},"use_index":["indexBySubject","indexBySubject"]}`).Return(&MockIterator{
+		Records: []KVPair{{Key: "enc1", Value: []byte(`{"id":{"value":"enc1"},"subject":{"reference":"Patient/patientID"}}`)}},
+	}, nil)
+
+	results, err := ec.GetEncountersByPatientID(mockCtx, "Patient/patientID")
+
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+}
+
 func TestGetEncountersByDateRange(t *testing.T) {
 
 	var mockStub *MockStub
@@ -522,21 +621,45 @@ func TestGetEncountersByDateRange(t *testing.T) {
 	// Ensure GetStub returns the same instance of mockStub
 	mockCtx.On("GetStub").Return(mockStub)
 
-	// Mocking GetStateByRange to return a range of encounter data
-	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{}, nil)
+	// Mocking GetQueryResult to return no encounter for the CouchDB query
+	mockStub.On("GetQueryResult", mock.Anything).Return(&MockIterator{}, nil)
 
 	// Define sample start and end dates
 	startDate := time.Now().AddDate(0, -1, 0) // 1 month ago
 	endDate := time.Now()
 
 	// Call the function under test
-	results, err := ec.GetEncountersByDateRange(mockCtx, startDate, endDate)
+	results, err := ec.GetEncountersByDateRange(mockCtx, "patientID", startDate, endDate)
 
 	// Verify that the result is as expected
 	assert.NoError(t, err, "GetEncountersByDateRange should not return an error")
 	assert.Empty(t, results, "GetEncountersByDateRange should return empty results as no encounters are stored")
 }
 
+func TestGetEncountersByDateRange_ChecksExactBounds(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	ec := new(EncounterChaincode)
+
+	startDate := time.Date(2024, time.March, 10, 8, 0, 0, 0, time.UTC)
+	endDate := time.Date(2024, time.March, 20, 18, 0, 0, 0, time.UTC)
+
+	// CouchDB returns every encounter of the widened calendar window
+	inside := `{"id":{"value":"enc1"},"period":{"start":"2024-03-12T09:00:00+01:00","end":"2024-03-12T10:00:00+01:00"}}`
+	early := `{"id":{"value":"enc2"},"period":{"start":"2024-03-10T07:00:00Z","end":"2024-03-10T09:00:00Z"}}`
+	mockStub.On("GetQueryResult", `{"selector":{"period.end":{"$lt":"2024-03-22"},"period.start":{"$gt":"2024-03-09"},"subject.reference":"Patient/patientID"},"use_index":["indexBySubjectAndPeriod","indexBySubjectAndPeriod"]}`).Return(&MockIterator{
+		Records: []KVPair{{Key: "enc1", Value: []byte(inside)}, {Key: "enc2", Value: []byte(early)}},
+	}, nil)
+
+	results, err := ec.GetEncountersByDateRange(mockCtx, "patientID", startDate, endDate)
+
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "enc1", results[0].ID.Value)
+	}
+}
+
 func TestGetEncountersByType(t *testing.T) {
 
 	var mockStub *MockStub
@@ -552,11 +675,11 @@ func TestGetEncountersByType(t *testing.T) {
 	// Ensure GetStub returns the same instance of mockStub
 	mockCtx.On("GetStub").Return(mockStub)
 
-	// Mocking GetStateByRange to return a range of encounter data
-	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{}, nil)
+	// Mocking GetQueryResult to return no encounter for the CouchDB query
+	mockStub.On("GetQueryResult", `{"selector":{"subject.reference":"Patient/patientID","type":{"$elemMatch":{"text":"emergency"}}},"use_index":["indexBySubject","indexBySubject"]}`).Return(&MockIterator{}, nil)
 
 	// Call the function under test
-	results, err := ec.GetEncountersByType(mockCtx, "emergency")
+	results, err := ec.GetEncountersByType(mockCtx, "patientID", "emergency")
 
 	// Verify that the result is as expected
 	assert.NoError(t, err, "GetEncountersByType should not return an error")
@@ -578,11 +701,11 @@ func TestGetEncountersByLocation(t *testing.T) {
 	// Ensure GetStub returns the same instance of mockStub
 	mockCtx.On("GetStub").Return(mockStub)
 
-	// Mocking GetStateByRange to return a range of encounter data
-	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{}, nil)
+	// Mocking GetQueryResult to return no encounter for the CouchDB query
+	mockStub.On("GetQueryResult", `{"selector":{"location":{"$elemMatch":{"identifier.value":"locationID"}},"subject.reference":"Patient/patientID"},"use_index":["indexBySubject","indexBySubject"]}`).Return(&MockIterator{}, nil)
 
 	// Call the function under test
-	results, err := ec.GetEncountersByLocation(mockCtx, "locationID")
+	results, err := ec.GetEncountersByLocation(mockCtx, "patientID", "locationID")
 
 	// Verify that the result is as expected
 	assert.NoError(t, err, "GetEncountersByLocation should not return an error")
@@ -604,11 +727,11 @@ func TestGetEncountersByPractitioner(t *testing.T) {
 	// Ensure GetStub returns the same instance of mockStub
 	mockCtx.On("GetStub").Return(mockStub)
 
-	// Mocking GetStateByRange to return a range of encounter data
-	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{}, nil)
+	// Mocking GetQueryResult to return no encounter for the CouchDB query
+	mockStub.On("GetQueryResult", `{"selector":{"participant":{"$elemMatch":{"individual.reference":"practitionerID"}},"subject.reference":"Patient/patientID"},"use_index":["indexBySubject","indexBySubject"]}`).Return(&MockIterator{}, nil)
 
 	// Call the function under test
-	results, err := ec.GetEncountersByPractitioner(mockCtx, "practitionerID")
+	results, err := ec.GetEncountersByPractitioner(mockCtx, "patientID", "practitionerID")
 
 	// Verify that the result is as expected
 	assert.NoError(t, err, "GetEncountersByPractitioner should not return an error")
@@ -628,7 +751,7 @@ func TestUpdateEncounterStatus(t *testing.T) {
 	mockStub = new(MockStub)
 
 	// Define sample encounter data
-	encounter := Encounter{ID: Identifier{System: "http://example.com/enc1", Value: "123456"}}
+	encounter := fhir.Encounter{ID: &fhir.Identifier{System: "http://example.com/enc1", Value: "123456"}}
 
 	// Serialize sample encounters to JSON
 	encounterJSON, _ := json.Marshal(encounter)
@@ -642,22 +765,23 @@ func TestUpdateEncounterStatus(t *testing.T) {
 	// Ensure GetStub returns the same instance of mockStub
 	mockCtx.On("GetStub").Return(mockStub)
 
-	// Mocking GetStateByRange to return a query result containing the desired encounter
-	mockStub.On("GetStateByRange", "", "").Return(mockIterator)
+	// Mocking GetStateByPartialCompositeKey to return a query result containing the desired encounter
+	mockStub.On("GetStateByPartialCompositeKey", "Encounter", []string{}).Return(mockIterator)
 
-	mockStub.On("GetState", mock.Anything).Return(mockIterator.Records[0].Value, nil)
+	encounterKey := mockKey(mockStub, "Encounter", "encounterID")
+	mockStub.On("GetState", encounterKey).Return(mockIterator.Records[0].Value, nil)
 
-	mockStub.On("PutState", mock.Anything, mock.Anything).Return(nil)
+	mockStub.On("PutState", encounterKey, mock.Anything).Return(nil)
 
 	// Create a sample Coding struct
-	coding := Coding{
+	coding := fhir.Coding{
 		System:  "http://example.com/coding/system",
 		Code:    "12345",
 		Display: "Sample Coding",
 	}
 
 	// Create a sample Code struct with the coding
-	statusCode := Code{Coding: []Coding{coding}}
+	statusCode := fhir.Code{Coding: []fhir.Coding{coding}}
 
 	// Call the function under test
 	err := ec.UpdateEncounterStatus(mockCtx, "encounterID", statusCode)
@@ -682,12 +806,13 @@ func TestAddDiagnosisToEncounter(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Mocking GetState to return a sample encounter
-	mockStub.On("GetState", mock.Anything).Return([]byte(`{"ID":{"System":"http://example.com/enc1","Value":"123456"}}`), nil)
+	encounterKey := mockKey(mockStub, "Encounter", "encounterID")
+	mockStub.On("GetState", encounterKey).Return([]byte(`{"ID":{"System":"http://example.com/enc1","Value":"123456"}}`), nil)
 
-	mockStub.On("PutState", mock.Anything, mock.Anything).Return(nil)
+	mockStub.On("PutState", encounterKey, mock.Anything).Return(nil)
 
 	// Call the function under test
-	err := ec.AddDiagnosisToEncounter(mockCtx, "encounterID", EncounterDiagnosis{})
+	err := ec.AddDiagnosisToEncounter(mockCtx, "encounterID", fhir.EncounterDiagnosis{})
 
 	// Verify that the result is as expected
 	assert.NoError(t, err, "AddDiagnosisToEncounter should not return an error")
@@ -709,12 +834,13 @@ func TestAddParticipantToEncounter(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Mocking GetState to return a sample encounter
-	mockStub.On("GetState", mock.Anything).Return([]byte(`{"ID":{"System":"http://example.com/enc1","Value":"123456"}}`), nil)
+	encounterKey := mockKey(mockStub, "Encounter", "encounterID")
+	mockStub.On("GetState", encounterKey).Return([]byte(`{"ID":{"System":"http://example.com/enc1","Value":"123456"}}`), nil)
 
-	mockStub.On("PutState", mock.Anything, mock.Anything).Return(nil)
+	mockStub.On("PutState", encounterKey, mock.Anything).Return(nil)
 
 	// Call the function under test
-	err := ec.AddParticipantToEncounter(mockCtx, "encounterID", EncounterParticipant{})
+	err := ec.AddParticipantToEncounter(mockCtx, "encounterID", fhir.EncounterParticipant{})
 
 	// Verify that the result is as expected
 	assert.NoError(t, err, "AddParticipantToEncounter should not return an error")
@@ -736,12 +862,13 @@ func TestRemoveParticipantFromEncounter(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Mocking GetState to return a sample encounter
-	mockStub.On("GetState", mock.Anything).Return([]byte(`{"ID":{"System":"http://example.com/enc1","Value":"123456"},
+	encounterKey := mockKey(mockStub, "Encounter", "encounterID")
+	mockStub.On("GetState", encounterKey).Return([]byte(`{"ID":{"System":"http://example.com/enc1","Value":"123456"},
 	"Participant":[{"Type":[{"Coding":[{"System":"http://example.com/coding/system","Code":"12345","Display":"Sample Coding"}]}],
 	"Period":{"Start":"2024-04-17T08:00:00Z","End":"2024-04-17T12:00:00Z"},
 	"Individual":{"Reference":"http://example.com/individual/123"}}]}`), nil)
 
-	mockStub.On("PutState", mock.Anything, mock.Anything).Return(nil)
+	mockStub.On("PutState", encounterKey, mock.Anything).Return(nil)
 
 	// Call the function under test
 	err := ec.RemoveParticipantFromEncounter(mockCtx, "encounterID", 0)
@@ -766,12 +893,13 @@ func TestAddLocationToEncounter(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Mocking GetState to return a sample encounter
-	mockStub.On("GetState", mock.Anything).Return([]byte(`{"ID":{"System":"http://example.com/enc1","Value":"123456"}}`), nil)
+	encounterKey := mockKey(mockStub, "Encounter", "encounterID")
+	mockStub.On("GetState", encounterKey).Return([]byte(`{"ID":{"System":"http://example.com/enc1","Value":"123456"}}`), nil)
 
-	mockStub.On("PutState", mock.Anything, mock.Anything).Return(nil)
+	mockStub.On("PutState", encounterKey, mock.Anything).Return(nil)
 
 	// Call the function under test
-	err := ec.AddLocationToEncounter(mockCtx, "encounterID", Location{})
+	err := ec.AddLocationToEncounter(mockCtx, "encounterID", fhir.Location{})
 
 	// Verify that the result is as expected
 	assert.NoError(t, err, "AddLocationToEncounter should not return an error")
@@ -793,9 +921,10 @@ func TestRemoveLocationFromEncounter(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Mocking GetState to return a sample encounter
-	mockStub.On("GetState", mock.Anything).Return([]byte(`{"ID":{"System":"http://example.com/enc1","Value":"123456"}, "Location":[{"Name":"Location1"},{"Name":"Location2"}]}`), nil)
+	encounterKey := mockKey(mockStub, "Encounter", "encounterID")
+	mockStub.On("GetState", encounterKey).Return([]byte(`{"ID":{"System":"http://example.com/enc1","Value":"123456"}, "Location":[{"Name":"Location1"},{"Name":"Location2"}]}`), nil)
 
-	mockStub.On("PutState", mock.Anything, mock.Anything).Return(nil)
+	mockStub.On("PutState", encounterKey, mock.Anything).Return(nil)
 
 	// Call the function under test
 	err := ec.RemoveLocationFromEncounter(mockCtx, "encounterID", 0)
@@ -819,11 +948,11 @@ func TestGetEncountersByReason(t *testing.T) {
 	// Ensure GetStub returns the same instance of mockStub
 	mockCtx.On("GetStub").Return(mockStub)
 
-	// Mocking GetStateByRange to return a range of encounter data
-	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{}, nil)
+	// Mocking GetQueryResult to return no encounter for the CouchDB query
+	mockStub.On("GetQueryResult", `{"selector":{"reasonReference":{"$elemMatch":{"coding":{"$elemMatch":{"display":"reason"}}}},"subject.reference":"Patient/patientID"},"use_index":["indexBySubject","indexBySubject"]}`).Return(&MockIterator{}, nil)
 
 	// Call the function under test
-	results, err := ec.GetEncountersByReason(mockCtx, "reason")
+	results, err := ec.GetEncountersByReason(mockCtx, "patientID", "reason")
 
 	// Verify that the result is as expected
 	assert.NoError(t, err, "GetEncountersByReason should not return an error")
@@ -845,13 +974,45 @@ func TestGetEncountersByServiceProvider(t *testing.T) {
 	// Ensure GetStub returns the same instance of mockStub
 	mockCtx.On("GetStub").Return(mockStub)
 
-	// Mocking GetStateByRange to return a range of encounter data
-	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{}, nil)
+	// Mocking GetQueryResult to return no encounter for the CouchDB query
+	mockStub.On("GetQueryResult", `{"selector":{"serviceProvider.reference":"serviceProviderID","subject.reference":"Patient/patientID"},"use_index":["indexBySubject","indexBySubject"]}`).Return(&MockIterator{}, nil)
 
 	// Call the function under test
-	results, err := ec.GetEncountersByServiceProvider(mockCtx, "serviceProviderID")
+	results, err := ec.GetEncountersByServiceProvider(mockCtx, "patientID", "serviceProviderID")
 
 	// Verify that the result is as expected
 	assert.NoError(t, err, "GetEncountersByServiceProvider should not return an error")
 	assert.Empty(t, results, "GetEncountersByServiceProvider should return empty results as no encounters are stored")
 }
+
+func TestPoliciesCoverEveryTransaction(t *testing.T) {
+	assert.Empty(t, auth.MissingPolicies(new(EncounterChaincode), encounterPolicies), "transactions without an access policy")
+}
+
+func TestMigrateKeys(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	ec := new(EncounterChaincode)
+
+	encounterJSON := []byte(`{"ID":{"System":"http://example.com/enc1","Value":"123456"}}`)
+	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{
+		Records: []KVPair{{Key: "enc1", Value: encounterJSON}},
+	}, nil)
+	encounterKey := mockKey(mockStub, "Encounter", "enc1")
+	mockStub.On("GetState", encounterKey).Return(nil, nil)
+	mockStub.On("PutState", encounterKey, encounterJSON).Return(nil)
+	mockStub.On("DelState", "enc1").Return(nil)
+
+	migrated, err := ec.MigrateKeys(mockCtx, 100)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+	mockStub.AssertExpectations(t)
+}
+
+func TestChaincodeMetadataIsValid(t *testing.T) {
+	// contractapi rejects at startup any transaction whose types it cannot describe
+	_, err := contractapi.NewChaincode(new(EncounterChaincode))
+	assert.NoError(t, err)
+}
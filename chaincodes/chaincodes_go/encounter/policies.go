@@ -0,0 +1,35 @@
+package main
+
+import "github.com/xDaryamo/MedChain/auth"
+
+var (
+	clinicalRoles = []string{auth.RoleDoctor, auth.RoleNurse}
+	careProviders = auth.Join(auth.HospitalMSPs, auth.ClinicMSPs)
+)
+
+// encounterPolicies declares who may call each EncounterChaincode transaction.
+// Searches are scoped to one patient and need that patient's consent, like any other read.
+var encounterPolicies = auth.Policies{
+	"CreateEncounter":                {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.PayloadSubject(1), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionWrite},
+	"GetEncounter":                   {Roles: auth.Join(clinicalRoles, []string{auth.RolePatient}), Subject: auth.StoredSubject("Encounter", 0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionRead},
+	"GetEncounterHistory":            {Roles: auth.Join(clinicalRoles, []string{auth.RolePatient}), Subject: auth.LastKnownSubject("Encounter", 0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionRead},
+	"UpdateEncounter":                {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.StoredSubject("Encounter", 0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionWrite},
+	"DeleteEncounter":                {MSPs: careProviders, Roles: []string{auth.RoleDoctor}, Subject: auth.StoredSubject("Encounter", 0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionWrite},
+	"UpdateEncounterStatus":          {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.StoredSubject("Encounter", 0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionWrite},
+	"AddDiagnosisToEncounter":        {MSPs: careProviders, Roles: []string{auth.RoleDoctor}, Subject: auth.StoredSubject("Encounter", 0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionWrite},
+	"AddParticipantToEncounter":      {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.StoredSubject("Encounter", 0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionWrite},
+	"RemoveParticipantFromEncounter": {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.StoredSubject("Encounter", 0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionWrite},
+	"AddLocationToEncounter":         {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.StoredSubject("Encounter", 0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionWrite},
+	"RemoveLocationFromEncounter":    {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.StoredSubject("Encounter", 0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionWrite},
+	"GetEncountersByPatientID":       {Roles: auth.Join(clinicalRoles, []string{auth.RolePatient}), Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionRead},
+	"SearchEncounter":                {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionRead},
+	"GetEncountersByDateRange":       {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionRead},
+	"GetEncountersByType":            {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionRead},
+	"GetEncountersByLocation":        {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionRead},
+	"GetEncountersByPractitioner":    {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionRead},
+	"GetEncountersByReason":          {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionRead},
+	"GetEncountersByServiceProvider": {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "Encounter", Action: auth.ActionRead},
+	"MigrateKeys":                    {Roles: []string{auth.RoleAdmin}},
+}
+
+var encounterEnforcer = &auth.Enforcer{Policies: encounterPolicies, Consent: auth.AnyConsent(auth.PatientConsent, auth.EmergencyConsent)}
@@ -0,0 +1,201 @@
+package fhir
+
+import (
+	"time"
+)
+
+// Patient represents a person receiving care or other health-related services
+type Patient struct {
+	ID                   *Identifier      `json:"identifier"`                     // Unique identifier for individuals receiving care
+	Active               bool             `json:"active,omitempty"`               // Whether the patient's record is in active use
+	Name                 *HumanName       `json:"name,omitempty"`                 // A name associated with the patient
+	Telecom              []ContactPoint   `json:"telecom,omitempty"`              // A contact detail for the individual
+	Gender               *Code            `json:"gender,omitempty"`               // Gender of the patient
+	BirthDate            time.Time        `json:"date,omitempty"`                 // The birth date for the patient
+	Deceased             bool             `json:"deceased,omitempty"`             // Indicates if the patient is deceased
+	Address              []Address        `json:"address,omitempty"`              // Addresses for the individual
+	MaritalStatus        *CodeableConcept `json:"maritalstatus,omitempty"`        // Marital (civil) status of a patient
+	MultipleBirth        []int            `json:"multiplebirth,omitempty"`        // Indicates if the patient is part of a multiple birth
+	Photo                *Attachment      `json:"photo,omitempty"`                // Image of the patient
+	Contact              []Contact        `json:"contact,omitempty"`              // A contact party (e.g., guardian, partner, friend) for the patient
+	Communication        []Communication  `json:"communication,omitempty"`        // A list of Languages which may be used to communicate with the patient
+	GeneralPractitioner  *Reference       `json:"generalpractitioner,omitempty"`  // Patient's primary care provider
+	ManagingOrganization *Reference       `json:"managingorganization,omitempty"` // Organization that is the custodian of the patient record
+}
+
+// Contact details for a person or organization associated with the patient
+type Contact struct {
+	Relationship *CodeableConcept `json:"relationship,omitempty"` // The kind of relationship
+	Name         *HumanName       `json:"name,omitempty"`         // A name associated with the contact person
+	Telecom      *ContactPoint    `json:"telecom,omitempty"`      // Contact details for the person
+	Address      *Address         `json:"address,omitempty"`      // Address for the contact person
+	Gender       *Code            `json:"gender,omitempty"`       // Gender of the contact person
+	Organization *Reference       `json:"organization,omitempty"` // Organization that is associated with the contact
+}
+
+// Communication specifies a language which can be used to communicate with the patient
+type Communication struct {
+	Language  *CodeableConcept `json:"language,omitempty"`  // The language which can be used
+	Preferred bool             `json:"preferred,omitempty"` // True if this is the preferred language for communications
+}
+
+// Practitioner represents a healthcare provider involved in the care of patients
+type Practitioner struct {
+	ID            *Identifier     `json:"identifier"`              // Unique identifier for the practitioner
+	Active        bool            `json:"active,omitempty"`        // Whether the practitioner's record is active
+	Name          []HumanName     `json:"name,omitempty"`          // Names associated with the practitioner
+	Telecom       *ContactPoint   `json:"telecom,omitempty"`       // Contact details for the practitioner
+	Gender        *Code           `json:"gender,omitempty"`        // Gender of the practitioner
+	BirthDate     time.Time       `json:"date,omitempty"`          // Birth date of the practitioner
+	Deceased      bool            `json:"deceased,omitempty"`      // Indicates if the practitioner is deceased
+	Address       *Address        `json:"address,omitempty"`       // Addresses for the practitioner
+	Photo         *Attachment     `json:"photo,omitempty"`         // Photos associated with the practitioner
+	Qualification []Qualification `json:"qualification,omitempty"` // Qualifications held by the practitioner
+	Communication []Communication `json:"communication,omitempty"` // Languages the practitioner can communicate in
+}
+
+// Qualification represents credentials a healthcare provider holds
+type Qualification struct {
+	ID     *Identifier      `json:"identifier"`       // Unique identifier for the qualification
+	Code   *CodeableConcept `json:"code,omitempty"`   // Coded representation of the qualification
+	Status *CodeableConcept `json:"status,omitempty"` // Status of the qualification
+	Period *Period          `json:"period,omitempty"` // Period during which the qualification is valid
+	Issuer *Reference       `json:"issuer,omitempty"` // Organization that issued the qualification
+}
+
+// Organization represents an organized group of people or entities formed for a purpose
+type Organization struct {
+	ID            *Identifier            `json:"identifier"`              // Unique identifier for the organization
+	Active        bool                   `json:"active,omitempty"`        // Whether the organization's record is still in active use
+	Type          *CodeableConcept       `json:"type,omitempty"`          // The kind of organization
+	Name          string                 `json:"name,omitempty"`          // A name given to the organization
+	Alias         string                 `json:"alias,omitempty"`         // A list of alternate names that the organization is known as
+	Description   string                 `json:"description,omitempty"`   // Additional details about the organization
+	Contact       *ExtendedContactDetail `json:"contact,omitempty"`       // Contact details for the organization
+	PartOf        *Reference             `json:"partof,omitempty"`        // The parent of the organization
+	EndPoint      *Reference             `json:"endpoint,omitempty"`      // Technical endpoints providing access to services operated for the organization
+	Qualification []Qualification        `json:"qualification,omitempty"` // Qualifications that the organization has
+}
+
+// Location represents a physical place where services are provided and resources and participants may be stored, found, contained, or accommodated
+type Location struct {
+	ID                   *Identifier            `json:"identifier"`            // Unique identifier for the location
+	Status               *Code                  `json:"status,omitempty"`      // The operational status of the location (e.g., active, suspended, inactive)
+	Name                 string                 `json:"name,omitempty"`        // A name given to the location
+	Alias                string                 `json:"alias,omitempty"`       // A list of alternate names that the location is known by
+	Description          string                 `json:"description,omitempty"` // A description of the location
+	Type                 *CodeableConcept       `json:"type,omitempty"`        // The type of location (e.g., hospital, clinic)
+	Mode                 *Code                  `json:"mode,omitempty"`        // The mode of operation of the location
+	Contact              *ExtendedContactDetail `json:"contact,omitempty"`     // Contact details of the location
+	Address              *Address               `json:"address,omitempty"`     // Physical location
+	ManagingOrganization *Reference             `json:"managedby,omitempty"`   // Organization responsible for provisioning and upkeep
+	HoursOfOperation     *Availability          `json:"available,omitempty"`   // The usual hours of operation
+}
+
+// Availability specifies when the location is available for use or not
+type Availability struct {
+	Period         *Period       `json:"period,omitempty"`         // The overall period during which this location is available
+	DaysOfWeek     []Code        `json:"days,omitempty"`           // The days of the week on which this location is available
+	AllDay         bool          `json:"allday,omitempty"`         // Whether this location is available all day
+	StartTime      time.Duration `json:"start,omitempty"`          // The opening time of day
+	EndTime        time.Duration `json:"end,omitempty"`            // The closing time of day
+	Unavailability *Period       `json:"unavailability,omitempty"` // Periods during which the location is not available
+}
+
+// Encounter represents an interaction between a patient and healthcare provider(s) for the provision of healthcare service(s)
+type Encounter struct {
+	ID              *Identifier            `json:"id"`                        // The logical id of the resource
+	Status          *Code                  `json:"status"`                    // Current state of the encounter (e.g., planned, in-progress, onhold, completed, cancelled)
+	Class           *Coding                `json:"class"`                     // Classification of the encounter (e.g., inpatient, outpatient, emergency)
+	Type            []CodeableConcept      `json:"type,omitempty"`            // Specific type of the encounter (e.g., consultation, follow-up)
+	ServiceType     *CodeableConcept       `json:"serviceType,omitempty"`     // The broad type of service that is to be provided (e.g., primary care, surgical, rehabilitation)
+	Priority        *CodeableConcept       `json:"priority,omitempty"`        // Indicates the urgency of the encounter
+	Subject         *Reference             `json:"subject"`                   // The patient or group present at the encounter
+	BasedOn         []Reference            `json:"basedOn,omitempty"`         // The request that initiated this encounter
+	Participant     []EncounterParticipant `json:"participant,omitempty"`     // Persons involved in the encounter other than the patient
+	Appointment     *Reference             `json:"appointment,omitempty"`     // The appointment that scheduled this encounter
+	Period          *Period                `json:"period,omitempty"`          // The start and end time of the encounter
+	Length          *Duration              `json:"length,omitempty"`          // Quantity of time the encounter lasted (in seconds)
+	ReasonCode      *CodeableConcept       `json:"reasonCode,omitempty"`      // Reason the encounter takes place, expressed as a code
+	ReasonReference []CodeableConcept      `json:"reasonReference,omitempty"` // Reasons the encounter takes place, referenced as a resource
+	Diagnosis       []EncounterDiagnosis   `json:"diagnosis,omitempty"`       // The list of diagnosis relevant to this encounter
+	Location        []Location             `json:"location,omitempty"`        // List of locations where the encounter takes place
+	ServiceProvider *Reference             `json:"serviceProvider,omitempty"` // The organization that is primarily responsible for this Encounter's services
+	PartOf          *Reference             `json:"partOf,omitempty"`          // Another Encounter of which this encounter is a part of (e.g., follow-up)
+}
+
+// EncounterParticipant represents individuals involved in the encounter besides the patient
+type EncounterParticipant struct {
+	Type       []CodeableConcept `json:"type,omitempty"`       // Role of the participant in the encounter
+	Period     *Period           `json:"period,omitempty"`     // The period of time during the encounter that the participant participated
+	Individual *Reference        `json:"individual,omitempty"` // Persons involved in the encounter other than the patient
+}
+
+// EncounterDiagnosis represents the diagnosis relevant to the encounter
+type EncounterDiagnosis struct {
+	Condition *Reference       `json:"condition"`      // The condition diagnosed
+	Use       *CodeableConcept `json:"use,omitempty"`  // Role that this diagnosis has within the encounter (e.g., admission, billing, discharge)
+	Rank      int              `json:"rank,omitempty"` // Ranking of the diagnosis (primary, secondary, etc.)
+}
+
+// Appointment represents a scheduled healthcare event for a patient
+type Appointment struct {
+	ID          *Identifier   `json:"identifier"`            // Unique identifier for the appointment
+	Status      *Coding       `json:"status,omitempty"`      // Current status of the appointment (booked, cancelled, etc.)
+	Subject     *Reference    `json:"subject,omitempty"`     // The patient that the appointment is for
+	Participant []Participant `json:"participant,omitempty"` // Individuals involved in the appointment
+	Start       time.Time     `json:"start,omitempty"`       // Scheduled start time of the appointment
+	End         time.Time     `json:"end,omitempty"`         // Scheduled end time of the appointment
+	Booked      time.Time     `json:"booked,omitempty"`      // The time when the appointment was initially booked
+}
+
+// Participant details an individual's role in an appointment
+type Participant struct {
+	Type     *CodeableConcept `json:"type,omitempty"`     // The role of the participant in the appointment
+	Actor    *Reference       `json:"actor,omitempty"`    // The person participating in the appointment
+	Required bool             `json:"required,omitempty"` // Whether the participant's presence is required
+	Status   *Coding          `json:"status,omitempty"`   // Participation status of the participant (accepted, declined, etc.)
+}
+
+// Insurance represents coverage provided to an individual or organization for healthcare costs
+type Insurance struct {
+	ID           *Identifier      `json:"identifier"`             // Unique identifier for the insurance policy
+	Status       *Coding          `json:"status,omitempty"`       // The status of the insurance (active, cancelled, etc.)
+	Type         *CodeableConcept `json:"type,omitempty"`         // The type of insurance (e.g., health, auto, property)
+	Subject      *Reference       `json:"subject,omitempty"`      // The individual or entity covered by the insurance
+	SubscriberID *Identifier      `json:"subscriberId,omitempty"` // Identifier for the subscriber of the policy
+	Plan         *Reference       `json:"plan,omitempty"`         // Specific plan details of the insurance
+	Payor        *Reference       `json:"payor,omitempty"`        // The organization or entity covering the insurance
+	Beneficiary  *Reference       `json:"beneficiary,omitempty"`  // Beneficiary of the insurance policy
+	Period       *Period          `json:"period,omitempty"`       // Time period the insurance coverage is in effect
+}
+
+// Consent records a patient's choices to permit or deny access to their data
+type Consent struct {
+	ID        string             `json:"id"`
+	Status    string             `json:"status"`              // draft | active | inactive | not-done | entered-in-error
+	Category  []CodeableConcept  `json:"category,omitempty"`  // Classification of the consent statement
+	Subject   *Reference         `json:"subject"`             // Patient the consent applies to
+	Date      time.Time          `json:"date,omitempty"`      // When the consent was agreed to
+	Period    *Period            `json:"period,omitempty"`    // Effective period for this Consent
+	Grantor   []Reference        `json:"grantor,omitempty"`   // Who is granting rights according to the policy and rules
+	Grantee   []Reference        `json:"grantee,omitempty"`   // Who is agreeing to the policy and rules
+	Decision  string             `json:"decision,omitempty"`  // deny | permit
+	Provision []ConsentProvision `json:"provision,omitempty"` // Constraints to the base Consent.decision
+}
+
+// ConsentProvision is a rule that applies the consent decision to a subset of the data
+type ConsentProvision struct {
+	Period       *Period            `json:"period,omitempty"`       // Timeframe for this provision
+	Actor        []ConsentActor     `json:"actor,omitempty"`        // Who|what controlled by this provision
+	Action       []CodeableConcept  `json:"action,omitempty"`       // Actions controlled by this provision, e.g., access, correct
+	Purpose      []Coding           `json:"purpose,omitempty"`      // Context of activities covered by this provision, e.g., TREAT, HRESCH, ETREAT
+	ResourceType []Coding           `json:"resourceType,omitempty"` // FHIR resource types covered, e.g., Observation, MedicationRequest
+	Provision    []ConsentProvision `json:"provision,omitempty"`    // Nested exception provisions
+}
+
+// ConsentActor is an actor whose access is controlled by a provision
+type ConsentActor struct {
+	Role      *CodeableConcept `json:"role,omitempty"`      // How the actor is involved
+	Reference *Reference       `json:"reference,omitempty"` // Resource for the actor
+}
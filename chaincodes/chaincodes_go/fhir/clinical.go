@@ -0,0 +1,176 @@
+package fhir
+
+import (
+	"time"
+)
+
+// AllergyIntolerance represents a patient's allergies or intolerances
+type AllergyIntolerance struct {
+	ID                 *Identifier         `json:"identifier"`                   // Unique identifier for the allergy or intolerance record
+	ClinicalStatus     *CodeableConcept    `json:"clinicalStatus,omitempty"`     // Clinical status of the allergy or intolerance
+	VerificationStatus *CodeableConcept    `json:"verificationStatus,omitempty"` // Verification status of the allergy or intolerance
+	Type               string              `json:"type,omitempty"`               // Type of the record (allergy or intolerance)
+	Category           []string            `json:"category,omitempty"`           // Categories of substances associated with the allergy or intolerance
+	Criticality        string              `json:"criticality,omitempty"`        // The criticality of the allergy or intolerance
+	Patient            *Reference          `json:"patient,omitempty"`            // Reference to the patient who has the allergy or intolerance
+	Code               *CodeableConcept    `json:"code,omitempty"`               // The allergen or intolerant substance
+	Reaction           []ReactionComponent `json:"reaction,omitempty"`           // Reactions triggered by the allergen
+}
+
+// ReactionComponent provides details about the reaction to an allergen
+type ReactionComponent struct {
+	Substance     *CodeableConcept  `json:"substance,omitempty"`     // The substance that caused the reaction
+	Manifestation []CodeableConcept `json:"manifestation"`           // Clinical symptoms/signs of the reaction
+	Severity      string            `json:"severity,omitempty"`      // Severity of the reaction (mild, moderate, severe)
+	ExposureRoute *CodeableConcept  `json:"exposureRoute,omitempty"` // How the substance was encountered
+	Note          []Annotation      `json:"note,omitempty"`          // Additional notes about the reaction
+}
+
+// Condition captures information about a health condition diagnosed or identified in a patient
+type Condition struct {
+	ID                 *Identifier         `json:"id"`                           // Unique identifier for the condition instance
+	ClinicalStatus     *CodeableConcept    `json:"clinicalStatus,omitempty"`     // Clinical status of the condition
+	VerificationStatus *CodeableConcept    `json:"verificationStatus,omitempty"` // Verification status of the condition
+	Category           []CodeableConcept   `json:"category,omitempty"`           // Categorization of the condition
+	Severity           *CodeableConcept    `json:"severity,omitempty"`           // Severity of the condition
+	Code               []CodeableConcept   `json:"code,omitempty"`               // Code that identifies the condition
+	Subject            *Reference          `json:"subject,omitempty"`            // The patient who has the condition
+	OnsetDateTime      string              `json:"onsetDateTime,omitempty"`      // The date/time when the condition began
+	AbatementDateTime  string              `json:"abatementDateTime,omitempty"`  // The date/time when the condition resolved
+	RecordedDate       string              `json:"recordedDate,omitempty"`       // Date and time the condition was first recorded
+	Recorder           *Reference          `json:"recorder,omitempty"`           // Who recorded the condition
+	Asserter           *Reference          `json:"asserter,omitempty"`           // Individual making the condition statement
+	Evidence           []ConditionEvidence `json:"evidence,omitempty"`           // Evidence supporting the existence of the condition
+}
+
+// ConditionEvidence links a condition to the findings that support it
+type ConditionEvidence struct {
+	Code   *CodeableConcept `json:"code,omitempty"`   // A manifestation or symptom that led to the recording of this condition
+	Detail []Reference      `json:"detail,omitempty"` // Links to other relevant information, including diagnostic reports, observations documenting symptoms, or other conditions that are due to the same underlying cause
+}
+
+// Procedure represents a healthcare procedure performed on a patient
+type Procedure struct {
+	ID                *Identifier      `json:"identifier"`           // Unique identifier for the procedure
+	Subject           *Reference       `json:"subject,omitempty"`    // The patient the procedure was performed on
+	Code              *CodeableConcept `json:"code,omitempty"`       // The specific procedure performed
+	Status            *Code            `json:"status,omitempty"`     // The status of the procedure (completed, planned, etc.)
+	Category          *CodeableConcept `json:"category,omitempty"`   // Classification of the procedure
+	Performer         *Reference       `json:"performed,omitempty"`  // The entities who performed the procedure
+	PartOf            *Reference       `json:"contained,omitempty"`  // A larger event of which this particular procedure is a component
+	BasedOn           *Reference       `json:"basedon,omitempty"`    // A request for this procedure
+	Reason            *CodeableConcept `json:"reason,omitempty"`     // The reason the procedure was performed
+	Encounter         *Reference       `json:"encounter,omitempty"`  // The encounter during which the procedure was performed
+	Note              []Annotation     `json:"note,omitempty"`       // Additional notes about the procedure
+	ReportedReference *Reference       `json:"reportedby,omitempty"` // Who reported the procedure
+}
+
+// Immunization records information about a vaccination event
+type Immunization struct {
+	ID                  *Identifier          `json:"identifier"`             // Unique identifier for the immunization event
+	Patient             *Reference           `json:"patient,omitempty"`      // The patient who received the vaccine
+	VaccineCode         *CodeableConcept     `json:"vaccineCode,omitempty"`  // Vaccine that was administered
+	Occurrence          time.Time            `json:"occurrence,omitempty"`   // The date/time the vaccine was administered
+	Location            *Reference           `json:"location,omitempty"`     // The location where the vaccine was administered
+	Status              *Code                `json:"status,omitempty"`       // The status of the immunization (completed, entered in error, etc.)
+	Reason              *CodeableConcept     `json:"reason,omitempty"`       // The reason for the vaccination
+	Manufacturer        *Organization        `json:"manufacturer,omitempty"` // The manufacturer of the vaccine
+	LotNumber           string               `json:"lotnumber,omitempty"`    // The lot number of the vaccine
+	ExpirationDate      time.Time            `json:"expiration,omitempty"`   // The expiration date of the vaccine
+	Encounter           *Reference           `json:"encounter,omitempty"`    // The encounter during which the vaccine was given
+	AdministeredProduct *MedicationStatement `json:"product,omitempty"`      // Information about the vaccine product
+	Site                *CodeableConcept     `json:"site,omitempty"`         // The body site where the vaccine was administered
+	Note                []Annotation         `json:"note,omitempty"`         // Additional notes about the immunization event
+	Reaction            []ReactionComponent  `json:"reaction,omitempty"`     // Any adverse reactions to the vaccine
+}
+
+// Observation represents measurements or simple assertions made about a patient
+type Observation struct {
+	ID                   string                      `json:"id"`                             // Unique identifier for this Observation
+	BasedOn              []Reference                 `json:"basedOn,omitempty"`              // The order, such as a ServiceRequest, this observation fulfils
+	Status               string                      `json:"status"`                         // The status of the observation (registered | preliminary | final | amended +)
+	Category             []CodeableConcept           `json:"category,omitempty"`             // Classification of the observation (e.g., laboratory, vital signs)
+	Code                 *CodeableConcept            `json:"code"`                           // Describes what was observed
+	Subject              *Reference                  `json:"subject"`                        // Who and/or what the observation is about
+	Encounter            *Reference                  `json:"encounter,omitempty"`            // The healthcare event (e.g., a patient encounter) during which the observation was made
+	EffectivePeriod      *Period                     `json:"effectivePeriod,omitempty"`      // A period of time during which the observation was made
+	Issued               time.Time                   `json:"issued,omitempty"`               // The date and time this observation was made available
+	Performer            []Reference                 `json:"performer,omitempty"`            // Who made the observation
+	ValueQuantity        *Quantity                   `json:"valueQuantity,omitempty"`        // The result of the observation
+	ValueCodeableConcept *CodeableConcept            `json:"valueCodeableConcept,omitempty"` // The result of the observation
+	ValueString          string                      `json:"valueString,omitempty"`          // The result of the observation
+	ValueRange           *Range                      `json:"valueRange,omitempty"`           // The result of the observation
+	Interpretation       []CodeableConcept           `json:"interpretation,omitempty"`       // High-level interpretation of observation
+	Note                 []Annotation                `json:"note,omitempty"`                 // Comments about the observation
+	ReferenceRange       []ObservationReferenceRange `json:"referenceRange,omitempty"`       // The range the result was interpreted against
+	Component            []ObservationComponent      `json:"component,omitempty"`            // Provides a specific result
+}
+
+// ObservationComponent represents a component of the observation
+type ObservationComponent struct {
+	Code                 *CodeableConcept            `json:"code"`                           // Describes what was observed
+	ValueQuantity        *Quantity                   `json:"valueQuantity,omitempty"`        // The result of the component
+	ValueCodeableConcept *CodeableConcept            `json:"valueCodeableConcept,omitempty"` // The result of the component
+	ValueString          string                      `json:"valueString,omitempty"`          // The result of the component
+	ValueBoolean         bool                        `json:"valueBoolean,omitempty"`         // The result of the component
+	ValueInteger         int                         `json:"valueInteger,omitempty"`         // The result of the component
+	ValueRange           *Range                      `json:"valueRange,omitempty"`           // The result of the component
+	ValueRatio           *Ratio                      `json:"valueRatio,omitempty"`           // The result of the component
+	Interpretation       []CodeableConcept           `json:"interpretation,omitempty"`       // Interpretation of the component
+	ReferenceRange       []ObservationReferenceRange `json:"referenceRange,omitempty"`       // The range the component was interpreted against
+}
+
+// ObservationReferenceRange is the range of values a result is interpreted against
+type ObservationReferenceRange struct {
+	Low       *Quantity         `json:"low,omitempty"`       // Low limit of the normal range
+	High      *Quantity         `json:"high,omitempty"`      // High limit of the normal range
+	AppliesTo []CodeableConcept `json:"appliesTo,omitempty"` // The population the range applies to, such as a sex
+	Age       *Range            `json:"age,omitempty"`       // The ages the range applies to, in years
+	Text      string            `json:"text,omitempty"`      // The range as text, for ranges that are not numeric
+}
+
+// DiagnosticReport groups the results of a set of tests, such as a lab panel, with their conclusion
+type DiagnosticReport struct {
+	ID              string            `json:"id"`                        // Unique identifier for this DiagnosticReport
+	BasedOn         []Reference       `json:"basedOn,omitempty"`         // The orders the report fulfils
+	Status          string            `json:"status"`                    // The status of the report (registered | partial | preliminary | final +)
+	Category        []CodeableConcept `json:"category,omitempty"`        // Service category, e.g., haematology, chemistry
+	Code            *CodeableConcept  `json:"code"`                      // Name of the report, e.g., full blood count
+	Subject         *Reference        `json:"subject"`                   // The patient the report is about
+	EffectivePeriod *Period           `json:"effectivePeriod,omitempty"` // The period the specimens were collected in
+	Issued          time.Time         `json:"issued,omitempty"`          // When this version of the report was made available
+	Performer       []Reference       `json:"performer,omitempty"`       // The laboratory responsible for the report
+	Result          []Reference       `json:"result,omitempty"`          // The Observations the report groups
+	Conclusion      string            `json:"conclusion,omitempty"`      // Clinical interpretation of the results
+	ConclusionCode  []CodeableConcept `json:"conclusionCode,omitempty"`  // Codes for the clinical conclusion
+	PresentedForm   []Attachment      `json:"presentedForm,omitempty"`   // The report as issued, e.g., a signed PDF
+}
+
+// DocumentReference describes a document about a patient, such as a radiology report or a
+// discharge letter, whose content is stored off the ledger
+type DocumentReference struct {
+	ID          string                       `json:"id"`                    // Unique identifier for this DocumentReference
+	Status      string                       `json:"status"`                // The status of the reference (current | superseded | entered-in-error)
+	DocStatus   string                       `json:"docStatus,omitempty"`   // The status of the document itself (preliminary | final | amended | entered-in-error)
+	Type        *CodeableConcept             `json:"type,omitempty"`        // Kind of document, e.g., LOINC 18842-5 Discharge summary
+	Category    []CodeableConcept            `json:"category,omitempty"`    // Categorization of the document, e.g., imaging
+	Subject     *Reference                   `json:"subject"`               // The patient the document is about
+	Date        time.Time                    `json:"date,omitempty"`        // When this reference was created
+	Author      []Reference                  `json:"author,omitempty"`      // Who wrote the document
+	Custodian   *Reference                   `json:"custodian,omitempty"`   // Organization that maintains the document
+	RelatesTo   []DocumentReferenceRelatesTo `json:"relatesTo,omitempty"`   // Documents this one replaces or appends to
+	Description string                       `json:"description,omitempty"` // Human-readable description of the document
+	Content     []DocumentReferenceContent   `json:"content"`               // Where the document is and how to check it
+}
+
+// DocumentReferenceRelatesTo links a document to another one it replaces, transforms, signs or appends to
+type DocumentReferenceRelatesTo struct {
+	Code   string     `json:"code"`   // replaces | transforms | signs | appends
+	Target *Reference `json:"target"` // The other DocumentReference
+}
+
+// DocumentReferenceContent is one copy of the document, located and verified through its attachment
+type DocumentReferenceContent struct {
+	Attachment Attachment `json:"attachment"`       // Where to find the content, with its hash and size
+	Format     *Coding    `json:"format,omitempty"` // Format or content rules of the document
+}
@@ -0,0 +1,163 @@
+// Package fhir contains the HL7 FHIR (R4/R5) datatypes and resources shared by
+// every MedChain chaincode, so that a resource written by one chaincode can be
+// read by any other one.
+package fhir
+
+import (
+	"time"
+)
+
+// Code represents a coded value following a coding system like LOINC or SNOMED CT
+type Code struct {
+	Coding []Coding `json:"coding"` // A reference to a code defined by a terminology system
+}
+
+// Coding provides reference information to a coding system and a code within that system
+type Coding struct {
+	System  string `json:"system,omitempty"`  // The identification of the code system that defines the meaning of the symbol in the code
+	Code    string `json:"code,omitempty"`    // The symbol in syntax defined by the system
+	Display string `json:"display,omitempty"` // The representation of the code that can be displayed to a human
+}
+
+// CodeableConcept provides a text description and optional coding for the concept
+type CodeableConcept struct {
+	Coding []Coding `json:"coding,omitempty"` // A reference to a code defined by a terminology system
+	Text   string   `json:"text,omitempty"`   // A human language representation of the concept as seen/selected/uttered by the user who entered the data
+}
+
+// Reference is a reference from one resource to another
+type Reference struct {
+	Reference string `json:"reference,omitempty"` // A reference to a location at which the other resource is found
+	Display   string `json:"display,omitempty"`
+}
+
+// Extension carries an element the base resource does not define, identified by its URL
+type Extension struct {
+	URL            string     `json:"url"`                      // Identifies the meaning of the extension
+	ValueReference *Reference `json:"valueReference,omitempty"` // Value of the extension, when it is a reference
+}
+
+// Identifier is used to identify a specific instance of a resource
+type Identifier struct {
+	System string `json:"system,omitempty"` // The namespace for the identifier
+	Value  string `json:"value,omitempty"`  // The value of the identifier
+}
+
+// Period represents a start and an end time. A zero Start or End leaves that side
+// of the period open.
+type Period struct {
+	Start time.Time `json:"start,omitempty"` // The start of the period
+	End   time.Time `json:"end,omitempty"`   // The end of the period
+}
+
+// Quantity represents a measured amount (e.g. the amount of medication or a lab value)
+type Quantity struct {
+	Value  float64 `json:"value"`            // The numeric value of the quantity
+	Unit   string  `json:"unit,omitempty"`   // The unit of measurement for the quantity, e.g., mg for milligrams
+	System string  `json:"system,omitempty"` // The system that the unit is derived from
+	Code   string  `json:"code,omitempty"`   // Coded form of the unit
+}
+
+// Range specifies a range of values
+type Range struct {
+	Low  *Quantity `json:"low,omitempty"`  // Low limit
+	High *Quantity `json:"high,omitempty"` // High limit
+}
+
+// Ratio represents a relationship between two quantities
+type Ratio struct {
+	Numerator   *Quantity `json:"numerator"`   // The value of the numerator
+	Denominator *Quantity `json:"denominator"` // The value of the denominator
+}
+
+// Duration represents a length of time
+type Duration struct {
+	Value  float64 `json:"value"`            // The numeric value of the duration
+	Unit   string  `json:"unit,omitempty"`   // The unit of measurement for the duration, e.g., days, weeks
+	System string  `json:"system,omitempty"` // The system that the unit is derived from
+}
+
+// Annotation represents a comment or explanatory note
+type Annotation struct {
+	AuthorReference *Reference `json:"authorReference,omitempty"` // Reference to who made the note
+	AuthorString    string     `json:"authorString,omitempty"`    // String identifying who made the note
+	Time            time.Time  `json:"time,omitempty"`            // Time the note was made
+	Text            string     `json:"text"`                      // The content of the note
+}
+
+// Attachment holds content in a variety of formats
+type Attachment struct {
+	ContentType *Code     `json:"type,omitempty"`     // Mime type of the content
+	Language    *Code     `json:"language,omitempty"` // Human language of the content
+	Data        string    `json:"data,omitempty"`     // Data package
+	Url         string    `json:"url,omitempty"`      // URL where the data can be found
+	Size        int64     `json:"size,omitempty"`     // Number of bytes of content
+	Hash        string    `json:"hash,omitempty"`     // Hash of the data (base64 SHA-256)
+	IPFSHash    string    `json:"ipfsHash,omitempty"` // The IPFS CID for the content
+	Title       string    `json:"title,omitempty"`    // Label to display in place of the data
+	Creation    time.Time `json:"creation,omitempty"` // Date attachment was first created
+	Height      uint64    `json:"height,omitempty"`   // Height in pixels for images
+	Width       uint64    `json:"width,omitempty"`    // Width in pixels for images
+	Frames      uint64    `json:"frames,omitempty"`   // Number of frames for videos
+	Duration    *Duration `json:"duration,omitempty"` // Length in seconds for audio/video
+	Pages       uint64    `json:"pages,omitempty"`    // Number of pages for documents
+}
+
+// HumanName represents the name of a person
+type HumanName struct {
+	Text   string   `json:"text,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+	Prefix []string `json:"prefix,omitempty"`
+	Suffix []string `json:"suffix,omitempty"`
+}
+
+// ContactPoint specifies contact information for a person or organization
+type ContactPoint struct {
+	System *Code   `json:"system,omitempty"` // The system for the contact point, e.g., phone, email
+	Value  string  `json:"value,omitempty"`  // The actual contact point details
+	Use    *Code   `json:"use,omitempty"`    // The use of the contact point (e.g., home, work)
+	Rank   uint    `json:"rank,omitempty"`   // Specifies a preference order for the contact points
+	Period *Period `json:"period,omitempty"` // The period during which the contact point is valid
+}
+
+// Address represents an address expressed using postal conventions
+type Address struct {
+	Use        *Code  `json:"use,omitempty"`        // The use of the address (e.g., home, work)
+	Type       *Code  `json:"type,omitempty"`       // The type of address (e.g., postal, physical)
+	Text       string `json:"text,omitempty"`       // A full text representation of the address
+	Line       string `json:"line,omitempty"`       // Address line details (e.g., street, PO Box)
+	City       string `json:"city,omitempty"`       // The city name
+	State      string `json:"state,omitempty"`      // State or province name
+	PostalCode string `json:"postalcode,omitempty"` // Postal code
+	Country    string `json:"country,omitempty"`    // Country name
+}
+
+// ExtendedContactDetail contains detailed contact information including addresses and telecom details
+type ExtendedContactDetail struct {
+	Name         *HumanName    `json:"name,omitempty"`         // Human name associated with the contact
+	Telecom      *ContactPoint `json:"telecom,omitempty"`      // Contact details (phone, email, etc.)
+	Address      *Address      `json:"address,omitempty"`      // Address for the contact
+	Organization *Reference    `json:"organization,omitempty"` // Organization associated with the contact
+	Period       *Period       `json:"period,omitempty"`       // The period during which this contact detail is valid
+}
+
+// Timing represents the timing of an event, e.g. medication intake
+type Timing struct {
+	Repeat *Repeat `json:"repeat,omitempty"` // Codified representation of the schedule
+}
+
+// Repeat defines frequency and duration of a repeated event
+type Repeat struct {
+	Frequency  int     `json:"frequency,omitempty"`  // The number of times the event is to occur every period
+	Period     float64 `json:"period,omitempty"`     // The period over which the event is to occur
+	PeriodUnit string  `json:"periodUnit,omitempty"` // The unit of time for the period, e.g., days, weeks, months
+}
+
+// Dosage represents how the medication is/was taken or should be taken by the patient
+type Dosage struct {
+	Text         string           `json:"text,omitempty"`         // Free text dosage instructions e.g. "Take one tablet daily"
+	Timing       *Timing          `json:"timing,omitempty"`       // When the medication should be taken
+	Route        *CodeableConcept `json:"route,omitempty"`        // How the medication enters the body, e.g., oral, injection
+	DoseQuantity *Quantity        `json:"doseQuantity,omitempty"` // The amount of medication taken at one time
+}
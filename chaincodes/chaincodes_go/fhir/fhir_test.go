@@ -0,0 +1,139 @@
+package fhir
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatientRoundTrip(t *testing.T) {
+	birthDate := time.Date(1980, time.May, 12, 0, 0, 0, 0, time.UTC)
+	patient := Patient{
+		ID:        &Identifier{System: "urn:oid:2.16.840.1.113883.2.9.4.3.2", Value: "patient-001"},
+		Name:      &HumanName{Family: "Rossi", Given: []string{"Mario"}},
+		Gender:    &Code{Coding: []Coding{{System: "http://hl7.org/fhir/administrative-gender", Code: "male"}}},
+		BirthDate: birthDate,
+	}
+
+	patientJSON, err := json.Marshal(patient)
+	assert.NoError(t, err)
+
+	var decoded Patient
+	assert.NoError(t, json.Unmarshal(patientJSON, &decoded))
+	assert.Equal(t, patient, decoded)
+}
+
+func TestOptionalFieldsAreOmitted(t *testing.T) {
+	observation := Observation{ID: "obs-001", Status: "final"}
+
+	observationJSON, err := json.Marshal(observation)
+	assert.NoError(t, err)
+
+	var fields map[string]interface{}
+	assert.NoError(t, json.Unmarshal(observationJSON, &fields))
+	assert.NotContains(t, fields, "effectivePeriod")
+	assert.NotContains(t, fields, "issued")
+	assert.NotContains(t, fields, "note")
+}
+
+func TestSetTimesMarshalAsEncodingJSONDoes(t *testing.T) {
+	issued := time.Date(2024, time.April, 15, 10, 0, 0, 0, time.UTC)
+	observation := Observation{
+		ID:              "obs-001",
+		Status:          "final",
+		Subject:         &Reference{Reference: "Patient/patient-001"},
+		EffectivePeriod: &Period{Start: issued.Add(-time.Hour), End: issued},
+		Issued:          issued,
+		Note:            []Annotation{{Time: issued, Text: "<fasting>"}},
+	}
+	type plain Observation
+
+	observationJSON, err := json.Marshal(observation)
+	assert.NoError(t, err)
+	plainJSON, err := json.Marshal(plain(observation))
+	assert.NoError(t, err)
+	assert.Equal(t, string(plainJSON), string(observationJSON))
+}
+
+func TestMarshalOmittingZeroTimesHonoursEveryTagOption(t *testing.T) {
+	count := 3
+	issued := time.Date(2024, time.April, 15, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"omitempty before string", struct {
+			X int `json:"x,omitempty,string"`
+		}{}, `{}`},
+		{"string after omitempty", struct {
+			X int `json:"x,omitempty,string"`
+		}{X: 7}, `{"x":"7"}`},
+		{"string before omitempty", struct {
+			X bool `json:"x,string,omitempty"`
+		}{X: true}, `{"x":"true"}`},
+		{"string on a string", struct {
+			X string `json:"x,string"`
+		}{X: "a"}, `{"x":"\"a\""}`},
+		{"string on a pointer", struct {
+			X *int `json:"x,string"`
+		}{X: &count}, `{"x":"3"}`},
+		{"string on a nil pointer", struct {
+			X *int `json:"x,string"`
+		}{}, `{"x":null}`},
+		{"zero time with more options", struct {
+			Issued time.Time `json:"issued,omitempty,string"`
+		}{}, `{}`},
+		{"set time with more options", struct {
+			Issued time.Time `json:"issued,omitempty,string"`
+		}{Issued: issued}, `{"issued":"2024-04-15T10:00:00Z"}`},
+		{"no name", struct {
+			X int `json:",omitempty,string"`
+		}{X: 1}, `{"X":"1"}`},
+	}
+	for _, test := range tests {
+		valueJSON, err := MarshalOmittingZeroTimes(test.value)
+
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, string(valueJSON), test.name)
+	}
+}
+
+func TestPeriodOmitsItsOpenSide(t *testing.T) {
+	periodJSON, err := json.Marshal(Period{Start: time.Date(2024, time.April, 15, 10, 0, 0, 0, time.UTC)})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"start":"2024-04-15T10:00:00Z"}`, string(periodJSON))
+}
+
+func TestPeriodAcceptsOpenEndedRange(t *testing.T) {
+	var period Period
+	err := json.Unmarshal([]byte(`{"start":"2024-04-15T10:00:00Z"}`), &period)
+
+	assert.NoError(t, err)
+	assert.False(t, period.Start.IsZero())
+	assert.True(t, period.End.IsZero())
+}
+
+func TestMedicationRequestReadableAsRecordsRequest(t *testing.T) {
+	// A prescription written by the prescription chaincode must decode into the
+	// same type the records chaincode embeds in a MedicalRecords folder
+	prescriptionJSON := `{
+		"identifier": {"value": "rx-001"},
+		"status": {"coding": [{"code": "active"}]},
+		"intent": {"coding": [{"code": "order"}]},
+		"medicationCodeableConcept": {"text": "Amoxicillin 500mg"},
+		"subject": {"reference": "Patient/patient-001"},
+		"dispenseRequest": {"validityPeriod": {"start": "2024-04-15T00:00:00Z", "end": "2024-05-15T00:00:00Z"}, "numberOfRepeatsAllowed": 2}
+	}`
+
+	var request MedicationRequest
+	err := json.Unmarshal([]byte(prescriptionJSON), &request)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "rx-001", request.ID.Value)
+	assert.Equal(t, 2, request.DispenseRequest.NumberOfRepeatsAllowed)
+	assert.True(t, request.DispenseRequest.ValidityPeriod.End.After(request.DispenseRequest.ValidityPeriod.Start))
+}
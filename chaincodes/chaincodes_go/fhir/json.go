@@ -0,0 +1,118 @@
+package fhir
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Times are time.Time values rather than pointers, because contractapi cannot describe
+// *time.Time in transaction metadata. encoding/json never treats a struct as empty, so
+// omitempty alone would write a time never set as 0001-01-01T00:00:00Z. The types
+// holding times marshal through MarshalOmittingZeroTimes instead, which leaves them out.
+// Chaincode types holding times use it the same way.
+
+func (p Patient) MarshalJSON() ([]byte, error)                 { return MarshalOmittingZeroTimes(p) }
+func (p Practitioner) MarshalJSON() ([]byte, error)            { return MarshalOmittingZeroTimes(p) }
+func (a Appointment) MarshalJSON() ([]byte, error)             { return MarshalOmittingZeroTimes(a) }
+func (c Consent) MarshalJSON() ([]byte, error)                 { return MarshalOmittingZeroTimes(c) }
+func (i Immunization) MarshalJSON() ([]byte, error)            { return MarshalOmittingZeroTimes(i) }
+func (o Observation) MarshalJSON() ([]byte, error)             { return MarshalOmittingZeroTimes(o) }
+func (d DiagnosticReport) MarshalJSON() ([]byte, error)        { return MarshalOmittingZeroTimes(d) }
+func (d DocumentReference) MarshalJSON() ([]byte, error)       { return MarshalOmittingZeroTimes(d) }
+func (p Period) MarshalJSON() ([]byte, error)                  { return MarshalOmittingZeroTimes(p) }
+func (a Annotation) MarshalJSON() ([]byte, error)              { return MarshalOmittingZeroTimes(a) }
+func (a Attachment) MarshalJSON() ([]byte, error)              { return MarshalOmittingZeroTimes(a) }
+func (m MedicationRequest) MarshalJSON() ([]byte, error)       { return MarshalOmittingZeroTimes(m) }
+func (m MedicationDispense) MarshalJSON() ([]byte, error)      { return MarshalOmittingZeroTimes(m) }
+func (d DetectedIssue) MarshalJSON() ([]byte, error)           { return MarshalOmittingZeroTimes(d) }
+func (m DetectedIssueMitigation) MarshalJSON() ([]byte, error) { return MarshalOmittingZeroTimes(m) }
+func (s ServiceRequest) MarshalJSON() ([]byte, error)          { return MarshalOmittingZeroTimes(s) }
+
+// MarshalOmittingZeroTimes marshals a struct field by field, in order, as encoding/json
+// does, except that omitempty also leaves out a zero time.Time
+func MarshalOmittingZeroTimes(v interface{}) ([]byte, error) {
+	value := reflect.ValueOf(v)
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		if hasOption(options, "omitempty") && isEmpty(value.Field(i)) {
+			continue
+		}
+
+		nameJSON, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		fieldJSON, err := json.Marshal(value.Field(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		if hasOption(options, "string") {
+			if fieldJSON, err = quote(value.Field(i), fieldJSON); err != nil {
+				return nil, err
+			}
+		}
+		if buffer.Len() > 1 {
+			buffer.WriteByte(',')
+		}
+		buffer.Write(nameJSON)
+		buffer.WriteByte(':')
+		buffer.Write(fieldJSON)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+// hasOption reports whether the comma-separated options of a json tag include option
+func hasOption(options string, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// quote applies the string option to the JSON of a field: as in encoding/json, a string,
+// number or boolean, or a non-nil pointer to one, is written inside a JSON string
+func quote(field reflect.Value, fieldJSON []byte) ([]byte, error) {
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			return fieldJSON, nil
+		}
+		field = field.Elem()
+	}
+	switch field.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return json.Marshal(string(fieldJSON))
+	}
+	return fieldJSON, nil
+}
+
+// isEmpty reports whether omitempty leaves a field out: as in encoding/json, or a zero time
+func isEmpty(field reflect.Value) bool {
+	switch field.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return field.Len() == 0
+	case reflect.Struct:
+		t, isTime := field.Interface().(time.Time)
+		return isTime && t.IsZero()
+	default:
+		return field.IsZero()
+	}
+}
@@ -0,0 +1,81 @@
+package fhir
+
+import (
+	"time"
+)
+
+// MedicationRequest represents a request for prescribing medication to a patient
+type MedicationRequest struct {
+	Extension                 []Extension      `json:"extension,omitempty"`         // Additional content defined by MedChain, such as the prescriber's organization
+	ID                        *Identifier      `json:"identifier"`                  // Unique identifier for this medication request
+	Status                    *Code            `json:"status"`                      // The status of the prescription (e.g., active, cancelled, completed)
+	StatusReason              *CodeableConcept `json:"statusReason,omitempty"`      // Why the prescription was suspended, cancelled, stopped or entered in error
+	Intent                    *Code            `json:"intent"`                      // The intention behind the prescription order (e.g., order, proposal, plan)
+	MedicationCodeableConcept *CodeableConcept `json:"medicationCodeableConcept"`   // The medication to be prescribed
+	Subject                   *Reference       `json:"subject"`                     // The patient to whom the medication is prescribed
+	Encounter                 *Reference       `json:"encounter,omitempty"`         // The encounter during which the prescription was made
+	AuthoredOn                time.Time        `json:"authoredOn,omitempty"`        // The date and time when the prescription was authored
+	Requester                 *Reference       `json:"requester,omitempty"`         // The healthcare professional who requested the prescription
+	DosageInstruction         []Dosage         `json:"dosageInstruction,omitempty"` // Instructions for dosing of the medication
+	DispenseRequest           *DispenseRequest `json:"dispenseRequest,omitempty"`   // Details on how the medication should be dispensed to the patient
+	DetectedIssue             []Reference      `json:"detectedIssue,omitempty"`     // Clinical issues, such as interactions, the prescriber overrode
+}
+
+// DispenseRequest contains details about the dispensing of a prescribed medication
+type DispenseRequest struct {
+	ValidityPeriod         *Period    `json:"validityPeriod,omitempty"`         // The period during which the prescription is valid
+	NumberOfRepeatsAllowed int        `json:"numberOfRepeatsAllowed,omitempty"` // The number of refills allowed after the first dispense
+	Quantity               *Quantity  `json:"quantity,omitempty"`               // The quantity of medication to dispense
+	ExpectedSupplyDuration *Duration  `json:"expectedSupplyDuration,omitempty"` // The expected duration for which the supplied medication should last
+	Performer              *Reference `json:"performer,omitempty"`              // The designated pharmacy to dispense the medication
+}
+
+// MedicationDispense records medication handed over to a patient against a prescription
+type MedicationDispense struct {
+	ID                        *Identifier      `json:"identifier"`                // Unique identifier for this dispense
+	Status                    string           `json:"status"`                    // The status of the dispense (e.g., in-progress, completed)
+	MedicationCodeableConcept *CodeableConcept `json:"medicationCodeableConcept"` // The medication that was dispensed
+	Subject                   *Reference       `json:"subject"`                   // The patient the medication was dispensed to
+	Performer                 *Reference       `json:"performer,omitempty"`       // The pharmacy that dispensed the medication
+	AuthorizingPrescription   []Reference      `json:"authorizingPrescription"`   // The prescriptions this dispense fills
+	Type                      *CodeableConcept `json:"type,omitempty"`            // The kind of fill (e.g., first fill, refill, partial fill)
+	Quantity                  *Quantity        `json:"quantity,omitempty"`        // The amount of medication dispensed
+	WhenHandedOver            time.Time        `json:"whenHandedOver,omitempty"`  // When the medication was handed over to the patient
+}
+
+// DetectedIssue records a clinical issue with a patient's actual or proposed treatment, such as
+// a drug-drug interaction or an allergy to the prescribed medication
+type DetectedIssue struct {
+	ID         *Identifier               `json:"identifier"`                   // Unique identifier for this issue
+	Status     string                    `json:"status"`                       // The status of the issue (e.g., preliminary, final)
+	Code       *CodeableConcept          `json:"code,omitempty"`               // The kind of issue (e.g., drug interaction, allergy alert)
+	Severity   string                    `json:"severity,omitempty"`           // How serious the issue is (high, moderate, low)
+	Patient    *Reference                `json:"patient,omitempty"`            // The patient the issue concerns
+	Identified time.Time                 `json:"identifiedDateTime,omitempty"` // When the issue was identified
+	Implicated []Reference               `json:"implicated,omitempty"`         // The resources involved in the issue
+	Detail     string                    `json:"detail,omitempty"`             // Description of the issue
+	Mitigation []DetectedIssueMitigation `json:"mitigation,omitempty"`         // What was done about the issue
+}
+
+// DetectedIssueMitigation records an action taken in response to a detected issue
+type DetectedIssueMitigation struct {
+	Action *CodeableConcept `json:"action"`           // What was done (e.g., therapy judged appropriate)
+	Date   time.Time        `json:"date,omitempty"`   // When the action was taken
+	Author *Reference       `json:"author,omitempty"` // Who took the action
+	Note   []Annotation     `json:"note,omitempty"`   // Why the action was taken
+}
+
+// MedicationStatement represents information about medication that is being consumed by a patient
+type MedicationStatement struct {
+	ID                        string            `json:"id"`                                  // Unique identifier for this particular MedicationStatement
+	Status                    string            `json:"status"`                              // Medication status (active, completed, entered-in-error, intended, stopped, on-hold)
+	MedicationCodeableConcept *CodeableConcept  `json:"medicationCodeableConcept,omitempty"` // Identifies the medication being administered. This should be a codified drug name
+	Subject                   *Reference        `json:"subject"`                             // The patient or group who is taking the medication
+	Context                   *Reference        `json:"context,omitempty"`                   // The encounter or episode of care that establishes the context for this MedicationStatement
+	EffectiveDateTime         string            `json:"effectiveDateTime,omitempty"`         // The date/time when the medication was taken
+	EffectivePeriod           *Period           `json:"effectivePeriod,omitempty"`           // The period over which the medication was taken
+	DateAsserted              string            `json:"dateAsserted,omitempty"`              // The date when the medication statement was asserted by the information source
+	InformationSource         *Reference        `json:"informationSource,omitempty"`         // The person or organization that provided the information about the taking of this medication
+	ReasonCode                []CodeableConcept `json:"reasonCode,omitempty"`                // Reason for why the medication is being/was taken
+	Dosage                    []Dosage          `json:"dosage,omitempty"`                    // Details of how the medication was taken
+}
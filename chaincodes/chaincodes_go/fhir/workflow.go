@@ -0,0 +1,43 @@
+package fhir
+
+import (
+	"time"
+)
+
+// ServiceRequest represents an order for a service to be performed
+type ServiceRequest struct {
+	ID             *Identifier       `json:"identifier"`
+	Status         *Code             `json:"status"`                   // e.g., active, on-hold, completed
+	Intent         *Code             `json:"intent"`                   // e.g., order, original-order, reflex-order
+	Category       []CodeableConcept `json:"category,omitempty"`       // Classification of service
+	Priority       *Code             `json:"priority,omitempty"`       // e.g., routine, urgent, asap
+	Service        *CodeableConcept  `json:"service,omitempty"`        // The service that is to be performed
+	Subject        *Reference        `json:"subject"`                  // Who the service is for
+	Encounter      *Reference        `json:"encounter,omitempty"`      // Encounter during which the request was created
+	AuthoredOn     time.Time         `json:"authoredOn,omitempty"`     // When the request was made
+	Requester      *Reference        `json:"requester,omitempty"`      // Individual who initiated the request
+	Performer      *Reference        `json:"performer,omitempty"`      // Desired performer for service
+	ReasonCode     []CodeableConcept `json:"reasonCode,omitempty"`     // Reason for the service request
+	SupportingInfo []Reference       `json:"supportingInfo,omitempty"` // Additional information to support the service request
+	Note           []Annotation      `json:"note,omitempty"`           // Comments made about the ServiceRequest
+}
+
+// CarePlanActivity details a specific action planned as part of the care plan
+type CarePlanActivity struct {
+	OutcomeCodeableConcept []CodeableConcept       `json:"outcomeCodeableConcept,omitempty"` // Results of the activity
+	Detail                 *CarePlanActivityDetail `json:"detail,omitempty"`                 // In-line definition of the activity
+}
+
+// CarePlanActivityDetail is the in-line definition of a care plan activity
+type CarePlanActivityDetail struct {
+	Category               *CodeableConcept  `json:"category,omitempty"`               // Kind of activity, e.g., drug, encounter
+	Code                   *CodeableConcept  `json:"code,omitempty"`                   // Detail type of activity
+	ReasonCode             []CodeableConcept `json:"reasonCode,omitempty"`             // Why activity should be done
+	ScheduledTiming        *Timing           `json:"scheduledTiming,omitempty"`        // When activity is to occur
+	Location               *Reference        `json:"location,omitempty"`               // Where activity will take place
+	Performer              []Reference       `json:"performer,omitempty"`              // Who will be responsible?
+	ProductCodeableConcept *CodeableConcept  `json:"productCodeableConcept,omitempty"` // What is to be administered/supplied
+	DailyAmount            *Quantity         `json:"dailyAmount,omitempty"`            // How much to administer/supply/consume
+	Quantity               *Quantity         `json:"quantity,omitempty"`               // How much is administered/supplied/consumed
+	Description            string            `json:"description,omitempty"`            // Extra info describing activity
+}
@@ -0,0 +1,178 @@
+// Package history exposes the ledger history of the resources stored by the
+// MedChain chaincodes as FHIR history Bundles. Fabric keeps every version of a key
+// but not who wrote it, so each chaincode records the submitter of its transactions
+// by installing RecordSubmitter as its AfterTransaction handler.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+)
+
+// Submitters are stored under the composite key Submitter~txID
+const submitterObjectType = "Submitter"
+
+// Bundle is a FHIR Bundle of type history, listing the versions of a resource newest first
+type Bundle struct {
+	ResourceType string  `json:"resourceType"`    // Always "Bundle"
+	Type         string  `json:"type"`            // Always "history"
+	Total        int     `json:"total"`           // Number of versions
+	Entry        []Entry `json:"entry,omitempty"` // Versions of the resource, newest first
+}
+
+// Entry is a version of a resource, with the provenance of the transaction that wrote it
+type Entry struct {
+	FullURL   string          `json:"fullUrl"`             // Relative URL of the resource, e.g. Patient/123
+	Resource  json.RawMessage `json:"resource,omitempty"`  // The resource as written; absent when the version is a deletion
+	Request   Request         `json:"request"`             // Interaction that produced the version
+	Response  Response        `json:"response"`            // Outcome of the interaction
+	TxID      string          `json:"txId"`                // ID of the transaction that wrote the version
+	Timestamp time.Time       `json:"timestamp"`           // Timestamp of that transaction
+	Submitter *auth.Identity  `json:"submitter,omitempty"` // Identity that submitted the transaction; absent when it was not recorded
+	IsDelete  bool            `json:"isDelete"`            // True when the version is a deletion
+}
+
+// Request describes the interaction that produced a version
+type Request struct {
+	Method string `json:"method"` // POST for creations, PUT for updates, DELETE for deletions
+	URL    string `json:"url"`    // Relative URL of the resource
+}
+
+// Response describes the outcome of the interaction that produced a version
+type Response struct {
+	Status       string    `json:"status"`       // HTTP status of the interaction
+	Etag         string    `json:"etag"`         // Weak ETag naming the version by its transaction ID
+	LastModified time.Time `json:"lastModified"` // Timestamp of the transaction
+}
+
+// RecordSubmitter stores the identity that submitted the current transaction, so that
+// GetHistory can tell who wrote each version of a resource. It is meant to be installed
+// as the AfterTransaction handler of a contract; for evaluated transactions the record
+// is discarded together with the rest of the simulation.
+func RecordSubmitter(ctx contractapi.TransactionContextInterface) error {
+	submitter, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return err
+	}
+
+	submitterKey, err := ctx.GetStub().CreateCompositeKey(submitterObjectType, []string{ctx.GetStub().GetTxID()})
+	if err != nil {
+		return errors.New("failed to create submitter key: " + err.Error())
+	}
+
+	submitterJSON, err := json.Marshal(submitter)
+	if err != nil {
+		return errors.New("failed to marshal submitter: " + err.Error())
+	}
+
+	if err := ctx.GetStub().PutState(submitterKey, submitterJSON); err != nil {
+		return errors.New("failed to put state: " + err.Error())
+	}
+	return nil
+}
+
+// GetHistory returns the versions of the resource stored under key as a history Bundle.
+// resourceType and id name the resource in the fullUrl of the entries.
+func GetHistory(ctx contractapi.TransactionContextInterface, key string, resourceType string, id string) (*Bundle, error) {
+	historyIterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return nil, errors.New("failed to get history: " + err.Error())
+	}
+	defer historyIterator.Close()
+
+	url := resourceType + "/" + id
+	var entries []Entry
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+		if err != nil {
+			return nil, errors.New("failed to iterate history: " + err.Error())
+		}
+
+		submitter, err := getSubmitter(ctx, modification.TxId)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := Entry{
+			FullURL:   url,
+			TxID:      modification.TxId,
+			Timestamp: modification.Timestamp.AsTime(),
+			Submitter: submitter,
+			IsDelete:  modification.IsDelete,
+		}
+		if !modification.IsDelete {
+			entry.Resource = json.RawMessage(modification.Value)
+		}
+		entries = append(entries, entry)
+	}
+
+	// Fabric does not guarantee the order of the history, so sort it oldest first
+	// to tell creations from updates, then reverse it as FHIR lists the newest first
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+	for i := range entries {
+		entries[i].Request, entries[i].Response = interaction(entries, i, url)
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	return &Bundle{ResourceType: "Bundle", Type: "history", Total: len(entries), Entry: entries}, nil
+}
+
+// GetHistoryJSON returns the history Bundle of GetHistory serialized as JSON
+func GetHistoryJSON(ctx contractapi.TransactionContextInterface, key string, resourceType string, id string) (string, error) {
+	bundle, err := GetHistory(ctx, key, resourceType, id)
+	if err != nil {
+		return "", err
+	}
+
+	bundleJSON, err := json.Marshal(bundle)
+	if err != nil {
+		return "", errors.New("failed to marshal history: " + err.Error())
+	}
+	return string(bundleJSON), nil
+}
+
+// interaction describes the i-th version of a history sorted oldest first
+func interaction(entries []Entry, i int, url string) (Request, Response) {
+	response := Response{Etag: `W/"` + entries[i].TxID + `"`, LastModified: entries[i].Timestamp}
+	switch {
+	case entries[i].IsDelete:
+		response.Status = "204 No Content"
+		return Request{Method: "DELETE", URL: url}, response
+	case i == 0 || entries[i-1].IsDelete:
+		response.Status = "201 Created"
+		return Request{Method: "POST", URL: url}, response
+	default:
+		response.Status = "200 OK"
+		return Request{Method: "PUT", URL: url}, response
+	}
+}
+
+func getSubmitter(ctx contractapi.TransactionContextInterface, txID string) (*auth.Identity, error) {
+	submitterKey, err := ctx.GetStub().CreateCompositeKey(submitterObjectType, []string{txID})
+	if err != nil {
+		return nil, errors.New("failed to create submitter key: " + err.Error())
+	}
+
+	submitterJSON, err := ctx.GetStub().GetState(submitterKey)
+	if err != nil {
+		return nil, errors.New("failed to read submitter: " + err.Error())
+	}
+	if submitterJSON == nil {
+		return nil, nil // Written before submitters were recorded
+	}
+
+	var submitter auth.Identity
+	if err := json.Unmarshal(submitterJSON, &submitter); err != nil {
+		return nil, errors.New("failed to unmarshal submitter: " + err.Error())
+	}
+	return &submitter, nil
+}
@@ -0,0 +1,470 @@
+package history
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/xDaryamo/MedChain/auth"
+)
+
+// MockStub is a mock implementation of the ChaincodeStubInterface
+type MockStub struct {
+	mock.Mock
+}
+
+func (m *MockStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	args := m.Called(objectType, attributes)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStub) DelPrivateData(collection string, key string) error {
+	args := m.Called(collection, key)
+	return args.Error(0)
+}
+
+func (m *MockStub) DelState(key string) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockStub) GetArgs() [][]byte {
+	args := m.Called()
+	return args.Get(0).([][]byte)
+}
+
+func (m *MockStub) GetArgsSlice() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetBinding() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetChannelID() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *MockStub) GetCreator() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetDecorations() map[string][]byte {
+	args := m.Called()
+	return args.Get(0).(map[string][]byte)
+}
+
+func (m *MockStub) GetFunctionAndParameters() (string, []string) {
+	args := m.Called()
+	return args.String(0), args.Get(1).([]string)
+}
+
+func (m *MockStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	args := m.Called(key)
+	return args.Get(0).(shim.HistoryQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	args := m.Called(collection, key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(collection, objectType, attributes)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(collection, startKey, endKey)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	args := m.Called(collection, key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(collection, query)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	args := m.Called(collection, key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(query)
+	// Check if the first argument is nil
+	if args.Get(0) == nil {
+		// Return a properly initialized MockIterator along with a nil error
+		return new(MockIterator), args.Error(1)
+	}
+	// Otherwise, return the mock iterator and the error as usual
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	args := m.Called(query, pageSize, bookmark)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Get(1).(*peer.QueryResponseMetadata), args.Error(2)
+}
+
+func (m *MockStub) GetSignedProposal() (*peer.SignedProposal, error) {
+	args := m.Called()
+	return args.Get(0).(*peer.SignedProposal), args.Error(1)
+}
+
+func (m *MockStub) GetState(key string) ([]byte, error) {
+	args := m.Called(key)
+	// Check if the first argument is nil
+	if args.Get(0) == nil {
+		// Return nil along with the error
+		return nil, args.Error(1)
+	}
+	// Otherwise, return the byte slice and the error as usual
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(objectType, attributes)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	args := m.Called(objectType, keys, pageSize, bookmark)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Get(1).(*peer.QueryResponseMetadata), args.Error(2)
+}
+
+func (m *MockStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	args := m.Called(startKey, endKey)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Error(1)
+}
+
+func (m *MockStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	args := m.Called(startKey, endKey, pageSize, bookmark)
+	return args.Get(0).(shim.StateQueryIteratorInterface), args.Get(1).(*peer.QueryResponseMetadata), args.Error(2)
+}
+
+func (m *MockStub) GetStateValidationParameter(key string) ([]byte, error) {
+	args := m.Called(key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockStub) GetStringArgs() []string {
+	args := m.Called()
+	return args.Get(0).([]string)
+}
+
+func (m *MockStub) GetTransient() (map[string][]byte, error) {
+	args := m.Called()
+	return args.Get(0).(map[string][]byte), args.Error(1)
+}
+
+func (m *MockStub) GetTxID() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *MockStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	args := m.Called()
+	return args.Get(0).(*timestamp.Timestamp), args.Error(1)
+}
+
+func (m *MockStub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) peer.Response {
+	callArgs := m.Called(chaincodeName, args, channel)
+	return callArgs.Get(0).(peer.Response)
+}
+
+func (m *MockStub) PurgePrivateData(collection string, key string) error {
+	args := m.Called(collection, key)
+	return args.Error(0)
+}
+
+func (m *MockStub) PutPrivateData(collection string, key string, value []byte) error {
+	args := m.Called(collection, key, value)
+	return args.Error(0)
+}
+
+func (m *MockStub) PutState(key string, value []byte) error {
+	args := m.Called(key, value)
+	return args.Error(0)
+}
+
+func (m *MockStub) SetEvent(name string, payload []byte) error {
+	args := m.Called(name, payload)
+	return args.Error(0)
+}
+
+func (m *MockStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	args := m.Called(collection, key, ep)
+	return args.Error(0)
+}
+
+func (m *MockStub) SetStateValidationParameter(key string, ep []byte) error {
+	args := m.Called(key, ep)
+	return args.Error(0)
+}
+
+func (m *MockStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	args := m.Called(compositeKey)
+	return args.String(0), args.Get(1).([]string), args.Error(2)
+}
+
+type MockTransactionContext struct {
+	mock.Mock
+}
+
+func (m *MockTransactionContext) GetStub() shim.ChaincodeStubInterface {
+	args := m.Called()
+	return args.Get(0).(shim.ChaincodeStubInterface)
+}
+
+func (m *MockTransactionContext) GetClientIdentity() cid.ClientIdentity {
+	args := m.Called()
+	return args.Get(0).(cid.ClientIdentity)
+}
+
+// KVPair represents a key-value pair for testing purposes
+type KVPair struct {
+	Key   string
+	Value []byte
+}
+
+// MockIterator is a mock implementation of the StateQueryIteratorInterface
+type MockIterator struct {
+	Records      []KVPair // Slice to hold the records for iteration
+	CurrentIndex int      // Index to keep track of the current position
+}
+
+// HasNext returns true if the iterator has more items to iterate over
+func (m *MockIterator) HasNext() bool {
+	return m.CurrentIndex < len(m.Records)
+}
+
+// Next returns the next key and value in the iterator
+func (m *MockIterator) Next() (*queryresult.KV, error) {
+	if m.CurrentIndex >= len(m.Records) {
+		return nil, nil
+	}
+	result := m.Records[m.CurrentIndex]
+	m.CurrentIndex++
+	kv := &queryresult.KV{
+		Key:   result.Key,
+		Value: result.Value,
+	}
+	return kv, nil
+}
+
+// AddRecord adds a key-value pair to the mock iterator
+func (m *MockIterator) AddRecord(key string, value []byte) {
+	m.Records = append(m.Records, KVPair{Key: key, Value: value})
+}
+
+// Close closes the mock iterator (implements shim.StateQueryIteratorInterface)
+func (m *MockIterator) Close() error {
+	// No action needed for a mock iterator, return nil
+	return nil
+}
+
+type MockClientIdentity struct {
+	mock.Mock
+}
+
+func (mci *MockClientIdentity) GetID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetMSPID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	args := mci.Called(attrName)
+	return args.String(0), args.Bool(1), args.Error(2)
+}
+
+func (mci *MockClientIdentity) HasAttribute(attrName string) (bool, error) {
+	args := mci.Called(attrName)
+	return args.Bool(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	args := mci.Called(attrName, attrValue)
+	return args.Error(0)
+}
+
+func (mci *MockClientIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	args := mci.Called()
+	return args.Get(0).(*x509.Certificate), args.Error(1)
+}
+
+// MockHistoryIterator is a mock implementation of the HistoryQueryIteratorInterface
+type MockHistoryIterator struct {
+	Modifications []*queryresult.KeyModification // Versions of the key, in the order they are returned
+	CurrentIndex  int                            // Index to keep track of the current position
+}
+
+// HasNext returns true if the iterator has more versions to iterate over
+func (m *MockHistoryIterator) HasNext() bool {
+	return m.CurrentIndex < len(m.Modifications)
+}
+
+// Next returns the next version of the key
+func (m *MockHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	if m.CurrentIndex >= len(m.Modifications) {
+		return nil, nil
+	}
+	modification := m.Modifications[m.CurrentIndex]
+	m.CurrentIndex++
+	return modification, nil
+}
+
+// AddModification adds a version of the key written by txID at the given time
+func (m *MockHistoryIterator) AddModification(txID string, value []byte, at time.Time, isDelete bool) {
+	m.Modifications = append(m.Modifications, &queryresult.KeyModification{
+		TxId:      txID,
+		Value:     value,
+		Timestamp: &timestamp.Timestamp{Seconds: at.Unix()},
+		IsDelete:  isDelete,
+	})
+}
+
+// Close closes the mock iterator (implements shim.HistoryQueryIteratorInterface)
+func (m *MockHistoryIterator) Close() error {
+	return nil
+}
+
+// Tests
+
+func newContext(stub *MockStub, clientIdentity *MockClientIdentity) *MockTransactionContext {
+	ctx := new(MockTransactionContext)
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	return ctx
+}
+
+func submitterKey(txID string) string {
+	return "\x00Submitter\x00" + txID + "\x00"
+}
+
+func mockSubmitter(stub *MockStub, txID string, submitter *auth.Identity) {
+	stub.On("CreateCompositeKey", "Submitter", []string{txID}).Return(submitterKey(txID), nil)
+	if submitter == nil {
+		stub.On("GetState", submitterKey(txID)).Return(nil, nil)
+		return
+	}
+	submitterJSON, _ := json.Marshal(submitter)
+	stub.On("GetState", submitterKey(txID)).Return(submitterJSON, nil)
+}
+
+func TestRecordSubmitter(t *testing.T) {
+	stub := new(MockStub)
+	clientIdentity := new(MockClientIdentity)
+	clientIdentity.On("GetID").Return("x509::CN=doctor-001", nil)
+	clientIdentity.On("GetMSPID").Return("OspedaleMarescaMSP", nil)
+	clientIdentity.On("GetAttributeValue", auth.UserIDAttribute).Return("doctor-001", true, nil)
+	clientIdentity.On("GetAttributeValue", auth.RoleAttribute).Return(auth.RoleDoctor, true, nil)
+	ctx := newContext(stub, clientIdentity)
+
+	stub.On("GetTxID").Return("tx-001")
+	stub.On("CreateCompositeKey", "Submitter", []string{"tx-001"}).Return(submitterKey("tx-001"), nil)
+	stub.On("PutState", submitterKey("tx-001"), mock.MatchedBy(func(value []byte) bool {
+		var submitter auth.Identity
+		return json.Unmarshal(value, &submitter) == nil &&
+			submitter.UserID == "doctor-001" && submitter.MSPID == "OspedaleMarescaMSP" && submitter.Role == auth.RoleDoctor
+	})).Return(nil)
+
+	assert.NoError(t, RecordSubmitter(ctx))
+	stub.AssertExpectations(t)
+}
+
+func TestGetHistory_ListsVersionsNewestFirst(t *testing.T) {
+	stub := new(MockStub)
+	ctx := newContext(stub, nil)
+	created := time.Date(2024, time.April, 15, 10, 0, 0, 0, time.UTC)
+
+	// Fabric does not guarantee the order of the versions
+	history := &MockHistoryIterator{}
+	history.AddModification("tx-002", []byte(`{"status":"final"}`), created.Add(time.Hour), false)
+	history.AddModification("tx-001", []byte(`{"status":"preliminary"}`), created, false)
+	history.AddModification("tx-003", nil, created.Add(2*time.Hour), true)
+	stub.On("GetHistoryForKey", "obs-001").Return(history, nil)
+
+	technician := &auth.Identity{ID: "x509::CN=tech-001", MSPID: "LaboratorioAnalisiCMOMSP", UserID: "tech-001", Role: auth.RoleLabTechnician}
+	mockSubmitter(stub, "tx-001", technician)
+	mockSubmitter(stub, "tx-002", technician)
+	mockSubmitter(stub, "tx-003", nil)
+
+	bundle, err := GetHistory(ctx, "obs-001", "Observation", "obs-001")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Bundle", bundle.ResourceType)
+	assert.Equal(t, "history", bundle.Type)
+	assert.Equal(t, 3, bundle.Total)
+
+	deleted, updated, createdEntry := bundle.Entry[0], bundle.Entry[1], bundle.Entry[2]
+	assert.Equal(t, "tx-003", deleted.TxID)
+	assert.True(t, deleted.IsDelete)
+	assert.Nil(t, deleted.Resource)
+	assert.Nil(t, deleted.Submitter)
+	assert.Equal(t, Request{Method: "DELETE", URL: "Observation/obs-001"}, deleted.Request)
+
+	assert.Equal(t, "tx-002", updated.TxID)
+	assert.Equal(t, "PUT", updated.Request.Method)
+	assert.Equal(t, "200 OK", updated.Response.Status)
+	assert.JSONEq(t, `{"status":"final"}`, string(updated.Resource))
+
+	assert.Equal(t, "tx-001", createdEntry.TxID)
+	assert.Equal(t, "POST", createdEntry.Request.Method)
+	assert.Equal(t, `W/"tx-001"`, createdEntry.Response.Etag)
+	assert.True(t, created.Equal(createdEntry.Timestamp))
+	assert.Equal(t, "tech-001", createdEntry.Submitter.UserID)
+	assert.Equal(t, "Observation/obs-001", createdEntry.FullURL)
+}
+
+func TestGetHistory_RecreatedResourceIsCreatedAgain(t *testing.T) {
+	stub := new(MockStub)
+	ctx := newContext(stub, nil)
+	created := time.Date(2024, time.April, 15, 10, 0, 0, 0, time.UTC)
+
+	history := &MockHistoryIterator{}
+	history.AddModification("tx-001", []byte(`{}`), created, false)
+	history.AddModification("tx-002", nil, created.Add(time.Hour), true)
+	history.AddModification("tx-003", []byte(`{}`), created.Add(2*time.Hour), false)
+	stub.On("GetHistoryForKey", "enc-001").Return(history, nil)
+	for _, txID := range []string{"tx-001", "tx-002", "tx-003"} {
+		mockSubmitter(stub, txID, nil)
+	}
+
+	bundle, err := GetHistory(ctx, "enc-001", "Encounter", "enc-001")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "POST", bundle.Entry[0].Request.Method)
+	assert.Equal(t, "201 Created", bundle.Entry[0].Response.Status)
+}
+
+func TestGetHistoryJSON_EmptyHistory(t *testing.T) {
+	stub := new(MockStub)
+	ctx := newContext(stub, nil)
+	stub.On("GetHistoryForKey", "patient-404").Return(&MockHistoryIterator{}, nil)
+
+	bundleJSON, err := GetHistoryJSON(ctx, "patient-404", "Patient", "patient-404")
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"resourceType":"Bundle","type":"history","total":0}`, bundleJSON)
+}
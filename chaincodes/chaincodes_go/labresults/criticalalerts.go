@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+	"github.com/xDaryamo/MedChain/ledger"
+	"github.com/xDaryamo/MedChain/query"
+)
+
+// Critical alerts are stored under the composite key CriticalAlert~labResultID
+const criticalAlertObjectType = "CriticalAlert"
+
+// CriticalLabResultEvent is the chaincode event emitted when a lab result has a critical value
+const CriticalLabResultEvent = "CriticalLabResult"
+
+// CriticalValue is a value of a lab result beyond the critical limits of its test
+type CriticalValue struct {
+	Code           string         `json:"code"`            // LOINC code of the test or component
+	Interpretation string         `json:"interpretation"`  // LL or HH
+	Value          *fhir.Quantity `json:"value,omitempty"` // The critical value
+}
+
+// CriticalAlert tells the ordering practitioner about a lab result with critical values until
+// a clinician acknowledges it, or until an update leaves the result without critical values
+type CriticalAlert struct {
+	ObservationID        string            `json:"observationId"`                  // ID of the lab result
+	Patient              string            `json:"patient"`                        // Reference to the patient, e.g., Patient/patient1
+	OrderingPractitioner string            `json:"orderingPractitioner,omitempty"` // Requester of the order the result is based on; empty when unknown
+	CriticalValues       []CriticalValue   `json:"criticalValues"`                 // The values beyond their critical limits
+	DetectedAt           time.Time         `json:"detectedAt"`                     // When the lab posted the critical values
+	Acknowledged         bool              `json:"acknowledged"`                   // Whether a clinician has seen the alert
+	AcknowledgedBy       string            `json:"acknowledgedBy,omitempty"`       // userId attribute of the clinician who acknowledged it
+	AcknowledgedAt       time.Time         `json:"acknowledgedAt,omitempty"`       // When it was acknowledged
+	Resolved             bool              `json:"resolved"`                       // Whether the result no longer has critical values or was cancelled
+	ResolvedAt           time.Time         `json:"resolvedAt,omitempty"`           // When the alert was resolved
+	Acknowledgements     []Acknowledgement `json:"acknowledgements,omitempty"`     // Earlier critical values of the result that were acknowledged, oldest first
+}
+
+// MarshalJSON leaves out AcknowledgedAt and ResolvedAt until they are set
+func (a CriticalAlert) MarshalJSON() ([]byte, error) { return fhir.MarshalOmittingZeroTimes(a) }
+
+// Acknowledgement records that a clinician saw earlier critical values of a lab result, which a
+// later update replaced
+type Acknowledgement struct {
+	CriticalValues []CriticalValue `json:"criticalValues"` // The critical values acknowledged
+	AcknowledgedBy string          `json:"acknowledgedBy"` // userId attribute of the clinician who acknowledged them
+	AcknowledgedAt time.Time       `json:"acknowledgedAt"` // When they were acknowledged
+}
+
+/*
+================================
+	CRITICAL ALERT OPERATIONS
+================================
+*/
+
+// AcknowledgeCriticalResult records that the calling doctor has seen the critical values of a
+// lab result. When the result is based on an order, only the practitioner who placed it may.
+func (t *LabResultsChaincode) AcknowledgeCriticalResult(ctx contractapi.TransactionContextInterface, labResultID string) (*CriticalAlert, error) {
+	alert, err := getCriticalAlert(ctx, labResultID)
+	if err != nil {
+		return nil, err
+	}
+	if alert == nil || alert.Resolved {
+		return nil, errors.New("lab result " + labResultID + " has no critical values")
+	}
+	if alert.Acknowledged {
+		return nil, errors.New("the critical values of lab result " + labResultID + " were already acknowledged by " + alert.AcknowledgedBy)
+	}
+
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if alert.OrderingPractitioner != "" && alert.OrderingPractitioner != "Practitioner/"+caller.UserID {
+		return nil, errors.New("only " + alert.OrderingPractitioner + ", who ordered lab result " + labResultID + ", may acknowledge its critical values")
+	}
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+
+	alert.Acknowledged = true
+	alert.AcknowledgedBy = caller.UserID
+	alert.AcknowledgedAt = timestamp.AsTime()
+	if _, err := putCriticalAlert(ctx, alert); err != nil {
+		return nil, err
+	}
+	return alert, nil
+}
+
+// QueryUnacknowledgedCriticalResults returns the critical alerts of a patient no clinician has
+// acknowledged yet, oldest first
+func (t *LabResultsChaincode) QueryUnacknowledgedCriticalResults(ctx contractapi.TransactionContextInterface, patientID string) ([]*CriticalAlert, error) {
+	return queryUnacknowledgedAlerts(ctx, query.Where("patient", query.Eq("Patient/"+auth.PatientID(patientID))), "indexCriticalAlertsByPatient")
+}
+
+// QueryUnacknowledgedCriticalResultsByRequester returns the unacknowledged critical alerts of
+// the lab results ordered by the calling practitioner, oldest first
+func (t *LabResultsChaincode) QueryUnacknowledgedCriticalResultsByRequester(ctx contractapi.TransactionContextInterface, practitionerID string) ([]*CriticalAlert, error) {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if caller.UserID != practitionerID {
+		return nil, errors.New("practitioners may only list the critical results they ordered")
+	}
+	return queryUnacknowledgedAlerts(ctx, query.Where("orderingPractitioner", query.Eq("Practitioner/"+practitionerID)), "indexCriticalAlertsByRequester")
+}
+
+// raiseCriticalAlert records an alert for the critical values of a lab result and announces it
+// through the CriticalLabResult chaincode event. previous is the version the lab result replaces,
+// nil for a new one. Updates that leave the critical values as they were keep the existing
+// alert, acknowledged or not. Updates that change them raise a new alert, which keeps the
+// acknowledgements of the earlier values, and updates that leave none, or cancel the result,
+// resolve the alert.
+func raiseCriticalAlert(ctx contractapi.TransactionContextInterface, labResultID string, previous *fhir.Observation, labResult *fhir.Observation) error {
+	var criticalValues []CriticalValue
+	if labResult.Status != StatusCancelled {
+		criticalValues = criticalValuesOf(labResult)
+	}
+	if len(criticalValues) == 0 {
+		if previous == nil || len(criticalValuesOf(previous)) == 0 {
+			return nil
+		}
+		return resolveCriticalAlert(ctx, labResultID)
+	}
+
+	existing, err := getCriticalAlert(ctx, labResultID)
+	if err != nil {
+		return err
+	}
+	if existing != nil && !existing.Resolved && sameCriticalValues(existing.CriticalValues, criticalValues) {
+		return nil
+	}
+
+	orderingPractitioner, err := orderingPractitioner(ctx, labResult)
+	if err != nil {
+		return err
+	}
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+
+	alert := &CriticalAlert{
+		ObservationID:        labResultID,
+		OrderingPractitioner: orderingPractitioner,
+		CriticalValues:       criticalValues,
+		DetectedAt:           timestamp.AsTime(),
+	}
+	if labResult.Subject != nil {
+		alert.Patient = "Patient/" + auth.PatientID(labResult.Subject.Reference)
+	}
+	if existing != nil {
+		alert.Acknowledgements = existing.Acknowledgements
+		if existing.Acknowledged {
+			alert.Acknowledgements = append(alert.Acknowledgements, Acknowledgement{
+				CriticalValues: existing.CriticalValues,
+				AcknowledgedBy: existing.AcknowledgedBy,
+				AcknowledgedAt: existing.AcknowledgedAt,
+			})
+		}
+	}
+
+	alertJSON, err := putCriticalAlert(ctx, alert)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().SetEvent(CriticalLabResultEvent, alertJSON); err != nil {
+		return errors.New("failed to set event: " + err.Error())
+	}
+	return nil
+}
+
+// resolveCriticalAlert marks the alert of a lab result that no longer has critical values as resolved
+func resolveCriticalAlert(ctx contractapi.TransactionContextInterface, labResultID string) error {
+	alert, err := getCriticalAlert(ctx, labResultID)
+	if err != nil || alert == nil || alert.Resolved {
+		return err
+	}
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+	alert.Resolved = true
+	alert.ResolvedAt = timestamp.AsTime()
+	_, err = putCriticalAlert(ctx, alert)
+	return err
+}
+
+// criticalValuesOf returns the values of a lab result and of its components interpreted as
+// critically low or high
+func criticalValuesOf(labResult *fhir.Observation) []CriticalValue {
+	var criticalValues []CriticalValue
+	if interpretation := criticalInterpretation(labResult.Interpretation); interpretation != "" {
+		criticalValues = append(criticalValues, CriticalValue{Code: loincCodeOf(labResult.Code), Interpretation: interpretation, Value: labResult.ValueQuantity})
+	}
+	for _, component := range labResult.Component {
+		if interpretation := criticalInterpretation(component.Interpretation); interpretation != "" {
+			criticalValues = append(criticalValues, CriticalValue{Code: loincCodeOf(component.Code), Interpretation: interpretation, Value: component.ValueQuantity})
+		}
+	}
+	return criticalValues
+}
+
+// criticalInterpretation returns LL or HH when an interpretation is critical, or an empty string
+func criticalInterpretation(interpretation []fhir.CodeableConcept) string {
+	for _, concept := range interpretation {
+		for _, coding := range concept.Coding {
+			if coding.System == interpretationSystem && (coding.Code == InterpretationCriticalLow || coding.Code == InterpretationCriticalHigh) {
+				return coding.Code
+			}
+		}
+	}
+	return ""
+}
+
+func sameCriticalValues(a []CriticalValue, b []CriticalValue) bool {
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	return string(aJSON) == string(bJSON)
+}
+
+// orderingPractitioner returns the requester of the first recorded lab order a lab result is
+// based on, or an empty string when it is based on none
+func orderingPractitioner(ctx contractapi.TransactionContextInterface, labResult *fhir.Observation) (string, error) {
+	for _, basedOn := range labResult.BasedOn {
+		orderID, isOrder := labOrderID(basedOn)
+		if !isOrder {
+			continue
+		}
+		order, err := findLabOrder(ctx, orderID)
+		if err != nil {
+			return "", err
+		}
+		if order != nil && order.Requester != nil && order.Requester.Reference != "" {
+			return order.Requester.Reference, nil
+		}
+	}
+	return "", nil
+}
+
+func getCriticalAlert(ctx contractapi.TransactionContextInterface, labResultID string) (*CriticalAlert, error) {
+	alertKey, err := ledger.Key(ctx, criticalAlertObjectType, labResultID)
+	if err != nil {
+		return nil, err
+	}
+	alertJSON, err := ctx.GetStub().GetState(alertKey)
+	if err != nil {
+		return nil, errors.New("failed to get critical alert: " + err.Error())
+	}
+	if alertJSON == nil {
+		return nil, nil
+	}
+	var alert CriticalAlert
+	if err := json.Unmarshal(alertJSON, &alert); err != nil {
+		return nil, errors.New("failed to unmarshal critical alert: " + err.Error())
+	}
+	return &alert, nil
+}
+
+func putCriticalAlert(ctx contractapi.TransactionContextInterface, alert *CriticalAlert) ([]byte, error) {
+	alertKey, err := ledger.Key(ctx, criticalAlertObjectType, alert.ObservationID)
+	if err != nil {
+		return nil, err
+	}
+	alertJSON, err := json.Marshal(alert)
+	if err != nil {
+		return nil, errors.New("failed to marshal critical alert: " + err.Error())
+	}
+	if err := ctx.GetStub().PutState(alertKey, alertJSON); err != nil {
+		return nil, errors.New("failed to put state: " + err.Error())
+	}
+	return alertJSON, nil
+}
+
+// queryUnacknowledgedAlerts runs a CouchDB query for the unacknowledged, unresolved critical
+// alerts matching selector, answered by index
+func queryUnacknowledgedAlerts(ctx contractapi.TransactionContextInterface, selector *query.Selector, index string) ([]*CriticalAlert, error) {
+	// Lab results share the namespace; only critical alerts have an acknowledged field
+	queryJSON, err := query.New(selector.And("acknowledged", query.Eq(false)).And("resolved", query.Eq(false))).UseIndex(index, index).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryJSON)
+	if err != nil {
+		return nil, errors.New("failed to query critical alerts: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	alerts := []*CriticalAlert{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, errors.New("failed to iterate critical alerts: " + err.Error())
+		}
+		var alert CriticalAlert
+		if err := json.Unmarshal(queryResponse.Value, &alert); err != nil {
+			return nil, errors.New("failed to unmarshal critical alert: " + err.Error())
+		}
+		alerts = append(alerts, &alert)
+	}
+
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alerts[i].DetectedAt.Before(alerts[j].DetectedAt)
+	})
+	return alerts, nil
+}
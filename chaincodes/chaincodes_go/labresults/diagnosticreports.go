@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+	"github.com/xDaryamo/MedChain/ledger"
+)
+
+// Diagnostic reports are stored under the composite key DiagnosticReport~reportID
+const diagnosticReportObjectType = "DiagnosticReport"
+
+// diagnosticReportStatuses are the statuses of the http://hl7.org/fhir/diagnostic-report-status code system
+var diagnosticReportStatuses = []string{
+	"registered", "partial", "preliminary", "final", "amended", "corrected", "appended", "cancelled", "entered-in-error",
+}
+
+// DiagnosticReportWithResults is a diagnostic report together with the lab results it groups
+type DiagnosticReportWithResults struct {
+	Report  *fhir.DiagnosticReport `json:"report"`  // The report
+	Results []*fhir.Observation    `json:"results"` // Its results, in the order the report lists them
+}
+
+/*
+================================
+	DIAGNOSTIC REPORT OPERATIONS
+================================
+*/
+
+// CreateDiagnosticReport stores the report the calling laboratory issues for some of the lab
+// results of a patient. The laboratory is recorded as its performer, and the transaction time
+// as the time it was issued.
+func (t *LabResultsChaincode) CreateDiagnosticReport(ctx contractapi.TransactionContextInterface, reportJSON string) error {
+	var report fhir.DiagnosticReport
+	if err := json.Unmarshal([]byte(reportJSON), &report); err != nil {
+		return errors.New("failed to decode JSON")
+	}
+	if report.ID == "" {
+		return errors.New("diagnostic report ID is required")
+	}
+
+	existing, err := findDiagnosticReport(ctx, report.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errors.New("the diagnostic report already exists")
+	}
+
+	laboratory, err := callerLaboratory(ctx)
+	if err != nil {
+		return err
+	}
+	if len(report.Performer) == 0 {
+		report.Performer = []fhir.Reference{{Reference: laboratory}}
+	} else if report.Performer[0].Reference != laboratory {
+		return errors.New("laboratories may only issue diagnostic reports in their own name")
+	}
+	if err := validateDiagnosticReport(ctx, &report); err != nil {
+		return err
+	}
+	if err := setIssued(ctx, &report); err != nil {
+		return err
+	}
+	return putDiagnosticReport(ctx, &report)
+}
+
+// GetDiagnosticReport returns a diagnostic report. Lab technicians may only read the reports
+// their laboratory issued.
+func (t *LabResultsChaincode) GetDiagnosticReport(ctx contractapi.TransactionContextInterface, reportID string) (*fhir.DiagnosticReport, error) {
+	return readableReport(ctx, reportID)
+}
+
+// GetDiagnosticReportWithResults returns a diagnostic report along with the lab results it groups
+func (t *LabResultsChaincode) GetDiagnosticReportWithResults(ctx contractapi.TransactionContextInterface, reportID string) (*DiagnosticReportWithResults, error) {
+	report, err := readableReport(ctx, reportID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*fhir.Observation{}
+	for _, result := range report.Result {
+		labResult, err := t.getLabResult(ctx, strings.TrimPrefix(result.Reference, labResultObjectType+"/"))
+		if err != nil {
+			return nil, errors.New("failed to read result " + result.Reference + ": " + err.Error())
+		}
+		results = append(results, labResult)
+	}
+	return &DiagnosticReportWithResults{Report: report, Results: results}, nil
+}
+
+// UpdateDiagnosticReport replaces a diagnostic report. Only the laboratory that issued it may,
+// and the report stays about the same patient.
+func (t *LabResultsChaincode) UpdateDiagnosticReport(ctx contractapi.TransactionContextInterface, reportID string, reportJSON string) error {
+	existing, err := t.issuedReport(ctx, reportID)
+	if err != nil {
+		return err
+	}
+
+	var report fhir.DiagnosticReport
+	if err := json.Unmarshal([]byte(reportJSON), &report); err != nil {
+		return errors.New("failed to decode JSON")
+	}
+	if report.ID != reportID {
+		return errors.New("the ID of the diagnostic report cannot change")
+	}
+	if len(report.Performer) == 0 {
+		report.Performer = existing.Performer
+	} else if report.Performer[0].Reference != existing.Performer[0].Reference {
+		return errors.New("the laboratory that issued a diagnostic report cannot change")
+	}
+	if err := validateDiagnosticReport(ctx, &report); err != nil {
+		return err
+	}
+	if auth.PatientID(report.Subject.Reference) != auth.PatientID(existing.Subject.Reference) {
+		return errors.New("the patient of a diagnostic report cannot change")
+	}
+	if err := setIssued(ctx, &report); err != nil {
+		return err
+	}
+	return putDiagnosticReport(ctx, &report)
+}
+
+// DeleteDiagnosticReport removes a diagnostic report, leaving its results in place. Only the
+// laboratory that issued it may.
+func (t *LabResultsChaincode) DeleteDiagnosticReport(ctx contractapi.TransactionContextInterface, reportID string) error {
+	if _, err := t.issuedReport(ctx, reportID); err != nil {
+		return err
+	}
+	reportKey, err := ledger.Key(ctx, diagnosticReportObjectType, reportID)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(reportKey)
+}
+
+// issuedReport returns a diagnostic report after checking that the caller's laboratory issued it
+func (t *LabResultsChaincode) issuedReport(ctx contractapi.TransactionContextInterface, reportID string) (*fhir.DiagnosticReport, error) {
+	report, err := getDiagnosticReport(ctx, reportID)
+	if err != nil {
+		return nil, err
+	}
+	laboratory, err := callerLaboratory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Performer) == 0 || report.Performer[0].Reference != laboratory {
+		return nil, errors.New("diagnostic report " + reportID + " was issued by another laboratory")
+	}
+	return report, nil
+}
+
+// readableReport returns a diagnostic report after checking that a lab technician caller's
+// laboratory issued it
+func readableReport(ctx contractapi.TransactionContextInterface, reportID string) (*fhir.DiagnosticReport, error) {
+	report, err := getDiagnosticReport(ctx, reportID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPerformerRead(ctx, "diagnostic report "+reportID, report.Performer); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// validateDiagnosticReport checks the status and patient of a diagnostic report, and that each
+// of its results is an existing lab result of that patient
+func validateDiagnosticReport(ctx contractapi.TransactionContextInterface, report *fhir.DiagnosticReport) error {
+	known := false
+	for _, status := range diagnosticReportStatuses {
+		known = known || report.Status == status
+	}
+	if !known {
+		return errors.New("unknown diagnostic report status " + describeStatus(report.Status))
+	}
+	if report.Subject == nil || report.Subject.Reference == "" {
+		return errors.New("the patient of the diagnostic report is required")
+	}
+	patientID := auth.PatientID(report.Subject.Reference)
+
+	seen := make(map[string]bool, len(report.Result))
+	for _, result := range report.Result {
+		labResultID := strings.TrimPrefix(result.Reference, labResultObjectType+"/")
+		if labResultID == result.Reference || labResultID == "" {
+			return errors.New("the results of a diagnostic report must reference Observations, not " + result.Reference)
+		}
+		if seen[labResultID] {
+			return errors.New("the diagnostic report lists " + result.Reference + " more than once")
+		}
+		seen[labResultID] = true
+
+		labResultKey, err := ledger.Key(ctx, labResultObjectType, labResultID)
+		if err != nil {
+			return err
+		}
+		labResultJSON, err := ctx.GetStub().GetState(labResultKey)
+		if err != nil {
+			return errors.New("failed to read from world state")
+		}
+		if labResultJSON == nil {
+			return errors.New("result " + result.Reference + " of the diagnostic report does not exist")
+		}
+		var labResult fhir.Observation
+		if err := json.Unmarshal(labResultJSON, &labResult); err != nil {
+			return errors.New("failed to unmarshal lab result")
+		}
+		if labResult.Subject == nil || auth.PatientID(labResult.Subject.Reference) != patientID {
+			return errors.New("result " + result.Reference + " of the diagnostic report is about another patient")
+		}
+	}
+	return nil
+}
+
+// setIssued records the transaction time as the time the version of a diagnostic report being
+// stored was made available
+func setIssued(ctx contractapi.TransactionContextInterface, report *fhir.DiagnosticReport) error {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+	report.Issued = timestamp.AsTime()
+	return nil
+}
+
+// callerLaboratory returns the reference of the laboratory the caller belongs to
+func callerLaboratory(ctx contractapi.TransactionContextInterface) (string, error) {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return "", err
+	}
+	return auth.OrganizationReference(caller.MSPID), nil
+}
+
+func getDiagnosticReport(ctx contractapi.TransactionContextInterface, reportID string) (*fhir.DiagnosticReport, error) {
+	report, err := findDiagnosticReport(ctx, reportID)
+	if err != nil {
+		return nil, err
+	}
+	if report == nil {
+		return nil, errors.New("diagnostic report " + reportID + " does not exist")
+	}
+	return report, nil
+}
+
+// findDiagnosticReport returns a diagnostic report, or nil when it does not exist
+func findDiagnosticReport(ctx contractapi.TransactionContextInterface, reportID string) (*fhir.DiagnosticReport, error) {
+	reportKey, err := ledger.Key(ctx, diagnosticReportObjectType, reportID)
+	if err != nil {
+		return nil, err
+	}
+	reportJSON, err := ctx.GetStub().GetState(reportKey)
+	if err != nil {
+		return nil, errors.New("failed to read from world state")
+	}
+	if reportJSON == nil {
+		return nil, nil
+	}
+	var report fhir.DiagnosticReport
+	if err := json.Unmarshal(reportJSON, &report); err != nil {
+		return nil, errors.New("failed to unmarshal diagnostic report: " + err.Error())
+	}
+	return &report, nil
+}
+
+func putDiagnosticReport(ctx contractapi.TransactionContextInterface, report *fhir.DiagnosticReport) error {
+	reportKey, err := ledger.Key(ctx, diagnosticReportObjectType, report.ID)
+	if err != nil {
+		return err
+	}
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return errors.New("failed to marshal diagnostic report: " + err.Error())
+	}
+	if err := ctx.GetStub().PutState(reportKey, reportJSON); err != nil {
+		return errors.New("failed to put state: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/fhir"
+)
+
+// Interpretations of a result, from the http://terminology.hl7.org/CodeSystem/v3-ObservationInterpretation code system
+const (
+	InterpretationNormal       = "N"  // Within the reference range
+	InterpretationLow          = "L"  // Below the reference range
+	InterpretationHigh         = "H"  // Above the reference range
+	InterpretationCriticalLow  = "LL" // Below the critical low limit
+	InterpretationCriticalHigh = "HH" // Above the critical high limit
+	InterpretationAbnormal     = "A"  // A coded result other than the normal ones
+)
+
+var interpretationDisplays = map[string]string{
+	InterpretationNormal:       "Normal",
+	InterpretationLow:          "Low",
+	InterpretationHigh:         "High",
+	InterpretationCriticalLow:  "Critical low",
+	InterpretationCriticalHigh: "Critical high",
+	InterpretationAbnormal:     "Abnormal",
+}
+
+const (
+	interpretationSystem = "http://terminology.hl7.org/CodeSystem/v3-ObservationInterpretation"
+	loincSystem          = "http://loinc.org"
+	ucumSystem           = "http://unitsofmeasure.org"
+	genderSystem         = "http://hl7.org/fhir/administrative-gender"
+)
+
+// demographicsField is the transient field carrying the patient's sex and birth date, as a FHIR
+// Patient with gender and birthDate. The laboratory passes them with the result: its peers are
+// not joined to patient-records-channel, and every endorser must interpret the result alike.
+// Transient data is not recorded in the block.
+const demographicsField = "patientDemographics"
+
+// patientDemographics reads, once and only when a reference range depends on them, the sex
+// and age of the patient of a lab result at the time it was observed
+type patientDemographics struct {
+	labResult *fhir.Observation
+	loaded    bool
+	sex       string // Empty when unknown
+	age       int    // In whole years
+	ageKnown  bool
+}
+
+// interpret fills in the interpretation and reference range of a lab result and of each of
+// its components from the reference ranges the performing laboratory set for their LOINC
+// codes. Results without an applicable range keep the interpretation they were submitted with.
+func interpret(ctx contractapi.TransactionContextInterface, laboratory string, labResult *fhir.Observation) error {
+	patient := &patientDemographics{labResult: labResult}
+
+	interpretation, referenceRange, err := interpretResult(ctx, laboratory, patient, labResult.Code, labResult.ValueQuantity, labResult.ValueCodeableConcept)
+	if err != nil {
+		return err
+	}
+	if interpretation != nil {
+		labResult.Interpretation = interpretation
+		labResult.ReferenceRange = referenceRange
+	}
+
+	for i := range labResult.Component {
+		component := &labResult.Component[i]
+		interpretation, referenceRange, err := interpretResult(ctx, laboratory, patient, component.Code, component.ValueQuantity, component.ValueCodeableConcept)
+		if err != nil {
+			return err
+		}
+		if interpretation != nil {
+			component.Interpretation = interpretation
+			component.ReferenceRange = referenceRange
+		}
+	}
+	return nil
+}
+
+// interpretResult interprets one value of a lab result, returning nil when no reference range
+// applies to it
+func interpretResult(ctx contractapi.TransactionContextInterface, laboratory string, patient *patientDemographics, code *fhir.CodeableConcept, quantity *fhir.Quantity, coded *fhir.CodeableConcept) ([]fhir.CodeableConcept, []fhir.ObservationReferenceRange, error) {
+	loincCode := loincCodeOf(code)
+	if loincCode == "" || (quantity == nil && coded == nil) {
+		return nil, nil, nil
+	}
+	referenceRange, err := applicableRange(ctx, laboratory, patient, loincCode)
+	if err != nil || referenceRange == nil {
+		return nil, nil, err
+	}
+
+	var interpretation string
+	if quantity != nil {
+		interpretation = referenceRange.classifyQuantity(quantity)
+	} else {
+		interpretation = referenceRange.classifyCode(coded)
+	}
+	if interpretation == "" {
+		return nil, nil, nil
+	}
+
+	concept := fhir.CodeableConcept{Coding: []fhir.Coding{{
+		System:  interpretationSystem,
+		Code:    interpretation,
+		Display: interpretationDisplays[interpretation],
+	}}}
+	return []fhir.CodeableConcept{concept}, []fhir.ObservationReferenceRange{referenceRange.toFHIR()}, nil
+}
+
+// applicableRange returns the reference range a laboratory set for a test that applies to the
+// patient, preferring a range for their sex to one for both, or nil when none applies
+func applicableRange(ctx contractapi.TransactionContextInterface, laboratory string, patient *patientDemographics, loincCode string) (*ReferenceRange, error) {
+	ranges, err := referenceRangesOf(ctx, laboratory, loincCode)
+	if err != nil || len(ranges) == 0 {
+		return nil, err
+	}
+
+	for _, referenceRange := range ranges {
+		if referenceRange.Sex != "" || referenceRange.MinAge != 0 || referenceRange.MaxAge != 0 {
+			if err := patient.load(ctx); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].Sex != "" && ranges[j].Sex == ""
+	})
+	for _, referenceRange := range ranges {
+		if referenceRange.appliesTo(patient.sex, patient.age, patient.ageKnown) {
+			return referenceRange, nil
+		}
+	}
+	return nil, nil
+}
+
+// load reads the patient's sex and birth date from the demographicsField transient field. When
+// the laboratory passes none, they stay unknown and only the ranges independent of them apply.
+func (p *patientDemographics) load(ctx contractapi.TransactionContextInterface) error {
+	if p.loaded {
+		return nil
+	}
+	p.loaded = true
+
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return errors.New("failed to get transient data: " + err.Error())
+	}
+	demographicsJSON, ok := transient[demographicsField]
+	if !ok {
+		return nil
+	}
+	var patient fhir.Patient
+	if err := json.Unmarshal(demographicsJSON, &patient); err != nil {
+		return errors.New("failed to unmarshal patient demographics: " + err.Error())
+	}
+
+	if patient.Gender != nil && len(patient.Gender.Coding) > 0 {
+		p.sex = patient.Gender.Coding[0].Code
+	}
+	if !patient.BirthDate.IsZero() {
+		observed, err := observedAt(ctx, p.labResult)
+		if err != nil {
+			return err
+		}
+		p.age = ageAt(patient.BirthDate, observed)
+		p.ageKnown = true
+	}
+	return nil
+}
+
+// classifyQuantity interprets a numeric result, or returns an empty string when it is in
+// another unit or the range has no numeric limits
+func (r *ReferenceRange) classifyQuantity(quantity *fhir.Quantity) string {
+	limits := r.limits()
+	if len(limits) == 0 {
+		return ""
+	}
+	if unit := limits[0].Code; unit != "" && quantity.Code != unit && quantity.Unit != unit {
+		return ""
+	}
+
+	value := quantity.Value
+	switch {
+	case r.criticalLow() != nil && value < r.criticalLow().Value:
+		return InterpretationCriticalLow
+	case r.criticalHigh() != nil && value > r.criticalHigh().Value:
+		return InterpretationCriticalHigh
+	case r.low() != nil && value < r.low().Value:
+		return InterpretationLow
+	case r.high() != nil && value > r.high().Value:
+		return InterpretationHigh
+	}
+	return InterpretationNormal
+}
+
+// classifyCode interprets a coded result, or returns an empty string when the range lists no
+// normal codes
+func (r *ReferenceRange) classifyCode(coded *fhir.CodeableConcept) string {
+	if len(r.NormalCodes) == 0 {
+		return ""
+	}
+	for _, coding := range coded.Coding {
+		for _, normal := range r.NormalCodes {
+			if coding.Code == normal {
+				return InterpretationNormal
+			}
+		}
+	}
+	return InterpretationAbnormal
+}
+
+// toFHIR describes a reference range as the FHIR referenceRange of the results interpreted against it
+func (r *ReferenceRange) toFHIR() fhir.ObservationReferenceRange {
+	referenceRange := fhir.ObservationReferenceRange{Low: r.low(), High: r.high()}
+	if r.Sex != "" {
+		referenceRange.AppliesTo = []fhir.CodeableConcept{{Coding: []fhir.Coding{{System: genderSystem, Code: r.Sex}}}}
+	}
+	if r.MinAge != 0 || r.MaxAge != 0 {
+		referenceRange.Age = &fhir.Range{Low: &fhir.Quantity{Value: float64(r.MinAge), Unit: "a", System: ucumSystem, Code: "a"}}
+		if r.MaxAge != 0 {
+			referenceRange.Age.High = &fhir.Quantity{Value: float64(r.MaxAge), Unit: "a", System: ucumSystem, Code: "a"}
+		}
+	}
+	if len(r.NormalCodes) > 0 {
+		referenceRange.Text = "normal: " + strings.Join(r.NormalCodes, ", ")
+	}
+	return referenceRange
+}
+
+// observedAt returns when a lab result was observed: the start of its effective period, else
+// when it was issued, else the transaction time
+func observedAt(ctx contractapi.TransactionContextInterface, labResult *fhir.Observation) (time.Time, error) {
+	if labResult.EffectivePeriod != nil && !labResult.EffectivePeriod.Start.IsZero() {
+		return labResult.EffectivePeriod.Start, nil
+	}
+	if !labResult.Issued.IsZero() {
+		return labResult.Issued, nil
+	}
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+	return timestamp.AsTime(), nil
+}
+
+// ageAt returns the age in whole years, at a time, of someone born on birthDate
+func ageAt(birthDate time.Time, at time.Time) int {
+	age := at.Year() - birthDate.Year()
+	if at.Month() < birthDate.Month() || (at.Month() == birthDate.Month() && at.Day() < birthDate.Day()) {
+		age--
+	}
+	return age
+}
+
+// loincCodeOf returns the LOINC code of a test, or an empty string when it has none
+func loincCodeOf(concept *fhir.CodeableConcept) string {
+	if concept == nil {
+		return ""
+	}
+	for _, coding := range concept.Coding {
+		if coding.System == loincSystem {
+			return coding.Code
+		}
+	}
+	return ""
+}
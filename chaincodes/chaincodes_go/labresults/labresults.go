@@ -3,19 +3,29 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+	"github.com/xDaryamo/MedChain/history"
+	"github.com/xDaryamo/MedChain/ledger"
+	"github.com/xDaryamo/MedChain/query"
 )
 
+// Lab results are stored under the composite key Observation~labResultID
+const labResultObjectType = "Observation"
+
 type LabResultsChaincode struct {
 	contractapi.Contract
 }
 
-// CreateLabResult crea un nuovo risultato di laboratorio sulla blockchain
+// CreateLabResult crea un nuovo risultato di laboratorio sulla blockchain. The calling laboratory
+// is recorded as its performer. Results and components with a reference range for their LOINC
+// code are interpreted against it, and critical values raise a CriticalLabResult event. A
+// released result completes the lab orders it is basedOn.
 func (t *LabResultsChaincode) CreateLabResult(ctx contractapi.TransactionContextInterface, labResultJSON string) error {
-	var labResult Observation
+	var labResult fhir.Observation
 	err := json.Unmarshal([]byte(labResultJSON), &labResult)
 	if err != nil {
 		return errors.New("failed to decode JSON")
@@ -24,6 +34,9 @@ func (t *LabResultsChaincode) CreateLabResult(ctx contractapi.TransactionContext
 	if labResult.ID == "" {
 		return errors.New("lab result ID is required")
 	}
+	if err := validateInitialStatus(&labResult); err != nil {
+		return err
+	}
 	exists, err := t.LabResultExists(ctx, labResult.ID)
 	if err != nil {
 		return err
@@ -31,53 +44,164 @@ func (t *LabResultsChaincode) CreateLabResult(ctx contractapi.TransactionContext
 	if exists {
 		return errors.New("the lab result already exists")
 	}
+	laboratory, err := callerLaboratory(ctx)
+	if err != nil {
+		return err
+	}
+	if len(labResult.Performer) == 0 {
+		labResult.Performer = []fhir.Reference{{Reference: laboratory}}
+	} else if !isPerformer(labResult.Performer, laboratory) {
+		return errors.New("laboratories may only post lab results in their own name")
+	}
+	normalizeEffectivePeriod(&labResult)
+	if err := interpret(ctx, laboratory, &labResult); err != nil {
+		return err
+	}
+	if err := fulfilOrders(ctx, &labResult); err != nil {
+		return err
+	}
+	if err := raiseCriticalAlert(ctx, labResult.ID, nil, &labResult); err != nil {
+		return err
+	}
 
 	labResultAsBytes, err := json.Marshal(labResult)
 	if err != nil {
 		return errors.New("failed to encode JSON")
 	}
-	return ctx.GetStub().PutState(labResult.ID, labResultAsBytes)
+	return t.putLabResult(ctx, labResult.ID, labResultAsBytes)
 }
 
-// UpdateLabResult aggiorna un risultato di laboratorio esistente sulla blockchain
+// UpdateLabResult replaces a lab result, moving it along the registered, preliminary, final
+// workflow. Once final, a result can only be amended, corrected or cancelled: the update must
+// carry a new note explaining the change, and the replaced version stays readable through
+// GetLabResultVersions. Only the laboratory that performed a result may update it, and its
+// subject cannot change.
 func (t *LabResultsChaincode) UpdateLabResult(ctx contractapi.TransactionContextInterface, labResultID string, labResultJSON string) error {
-	labResultAsBytes, err := ctx.GetStub().GetState(labResultID)
+	existing, err := t.getLabResult(ctx, labResultID)
 	if err != nil {
-		return errors.New("failed to read from world state")
-	}
-	if labResultAsBytes == nil {
-		return errors.New("the lab result does not exist")
+		return err
 	}
 
-	var labResult Observation
+	var labResult fhir.Observation
 	err = json.Unmarshal([]byte(labResultJSON), &labResult)
 	if err != nil {
 		return errors.New("failed to decode JSON")
 	}
 
+	if labResult.ID != "" && labResult.ID != labResultID {
+		return errors.New("the ID of lab result " + labResultID + " cannot be changed")
+	}
+	labResult.ID = labResultID
+	if subjectPatientID(&labResult) != subjectPatientID(existing) {
+		return errors.New("the subject of lab result " + labResultID + " cannot be changed")
+	}
+	laboratory, err := callerLaboratory(ctx)
+	if err != nil {
+		return err
+	}
+	if !isPerformer(existing.Performer, laboratory) {
+		return errors.New("only the laboratory that performed lab result " + labResultID + " may update it")
+	}
+	if len(labResult.Performer) == 0 {
+		labResult.Performer = existing.Performer
+	} else if !isPerformer(labResult.Performer, laboratory) {
+		return errors.New("laboratories may only post lab results in their own name")
+	}
+
+	if err := checkTransition(labResultID, existing.Status, labResult.Status); err != nil {
+		return err
+	}
+	if isReleased(existing.Status) {
+		if !hasNewNote(existing, &labResult) {
+			return errors.New("a note explaining the change is required to update released lab result " + labResultID)
+		}
+		if err := supersede(ctx, labResultID, existing); err != nil {
+			return err
+		}
+	}
+	normalizeEffectivePeriod(&labResult)
+	if err := interpret(ctx, laboratory, &labResult); err != nil {
+		return err
+	}
+	if err := fulfilOrders(ctx, &labResult); err != nil {
+		return err
+	}
+	if err := raiseCriticalAlert(ctx, labResultID, existing, &labResult); err != nil {
+		return err
+	}
+
 	updatedLabResultAsBytes, err := json.Marshal(labResult)
 	if err != nil {
 		return errors.New("failed to encode JSON")
 	}
-	return ctx.GetStub().PutState(labResultID, updatedLabResultAsBytes)
+	return t.putLabResult(ctx, labResultID, updatedLabResultAsBytes)
 }
 
-// GetLabResult recupera uno specifico risultato di laboratorio dalla blockchain
+// GetLabResult recupera uno specifico risultato di laboratorio dalla blockchain.
+// Lab technicians may only read the results their laboratory performed.
 func (t *LabResultsChaincode) GetLabResult(ctx contractapi.TransactionContextInterface, labResultID string) (string, error) {
-	labResultAsBytes, err := ctx.GetStub().GetState(labResultID)
+	labResultAsBytes, err := getLabResultJSON(ctx, labResultID)
 	if err != nil {
-		return "", errors.New("failed to read from world state")
+		return "", err
 	}
-	if labResultAsBytes == nil {
-		return "", errors.New("the lab result does not exist")
+
+	var labResult fhir.Observation
+	if err := json.Unmarshal(labResultAsBytes, &labResult); err != nil {
+		return "", errors.New("failed to unmarshal lab result")
+	}
+	if err := checkPerformerRead(ctx, "lab result "+labResultID, labResult.Performer); err != nil {
+		return "", err
 	}
 
 	return string(labResultAsBytes), nil
 }
 
+// AuditedGetLabResult reads a lab result like GetLabResult and records the read in the
+// patient's access log. It must be submitted for the log entry to be kept.
+func (t *LabResultsChaincode) AuditedGetLabResult(ctx contractapi.TransactionContextInterface, labResultID string) (string, error) {
+	if _, err := labResultsEnforcer.Audit(ctx, "GetLabResult", []string{labResultID}, "Observation", labResultID); err != nil {
+		return "", err
+	}
+	return t.GetLabResult(ctx, labResultID)
+}
+
+// GetAccessLog returns the audited reads of the lab results of patientID
+func (t *LabResultsChaincode) GetAccessLog(ctx contractapi.TransactionContextInterface, patientID string) ([]*auth.AccessLogEntry, error) {
+	return auth.GetAccessLog(ctx, patientID)
+}
+
+// GetLabResultHistory returns every version of a lab result as a FHIR history Bundle,
+// including deletions, with the transaction and identity that wrote each version.
+// Lab technicians may only read the history of existing results their laboratory performed.
+func (t *LabResultsChaincode) GetLabResultHistory(ctx contractapi.TransactionContextInterface, labResultID string) (string, error) {
+	technician, err := isLabTechnician(ctx)
+	if err != nil {
+		return "", err
+	}
+	if technician {
+		labResult, err := t.getLabResult(ctx, labResultID)
+		if err != nil {
+			return "", err
+		}
+		if err := checkPerformerRead(ctx, "lab result "+labResultID, labResult.Performer); err != nil {
+			return "", err
+		}
+	}
+
+	labResultKey, err := ledger.Key(ctx, labResultObjectType, labResultID)
+	if err != nil {
+		return "", err
+	}
+	return history.GetHistoryJSON(ctx, labResultKey, "Observation", labResultID)
+}
+
 // LabResultExists verifica se un risultato di laboratorio esiste nella blockchain
 func (t *LabResultsChaincode) LabResultExists(ctx contractapi.TransactionContextInterface, labResultID string) (bool, error) {
-	labResultAsBytes, err := ctx.GetStub().GetState(labResultID)
+	labResultKey, err := ledger.Key(ctx, labResultObjectType, labResultID)
+	if err != nil {
+		return false, err
+	}
+	labResultAsBytes, err := ctx.GetStub().GetState(labResultKey)
 	if err != nil {
 		return false, errors.New("failed to read from world state")
 	}
@@ -85,22 +209,31 @@ func (t *LabResultsChaincode) LabResultExists(ctx contractapi.TransactionContext
 }
 
 // QueryLabResults recupera i risultati di laboratorio per un paziente specifico utilizzando la struttura Observation
-func (t *LabResultsChaincode) QueryLabResults(ctx contractapi.TransactionContextInterface, patientID string) ([]Observation, error) {
-	queryString := fmt.Sprintf(`{"selector":{"subject.reference":"%s", "category.text":"Laboratory"}}`, patientID)
+func (t *LabResultsChaincode) QueryLabResults(ctx contractapi.TransactionContextInterface, patientID string) ([]fhir.Observation, error) {
+	// The patient is the one the policy checked consent for, and the query builder keeps its ID
+	// from altering the selector. Category is an array, so only the patient is indexed.
+	queryString, err := query.New(query.
+		Where("subject.reference", query.Eq("Patient/"+auth.PatientID(patientID))).
+		And("category", query.ElemMatch(query.Where("text", query.Eq("Laboratory"))))).
+		UseIndex("indexByPatient", "indexByPatient").
+		Build()
+	if err != nil {
+		return nil, err
+	}
 	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
 	if err != nil {
 		return nil, err
 	}
 	defer resultsIterator.Close()
 
-	var results []Observation
+	var results []fhir.Observation
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
 			return nil, err
 		}
 
-		var observation Observation
+		var observation fhir.Observation
 		if err := json.Unmarshal(queryResponse.Value, &observation); err != nil {
 			return nil, err
 		}
@@ -109,8 +242,104 @@ func (t *LabResultsChaincode) QueryLabResults(ctx contractapi.TransactionContext
 	return results, nil
 }
 
+// MigrateKeys moves up to limit lab results stored under their bare ID, as written before
+// lab results were namespaced, to their Observation~labResultID key. It returns how
+// many it moved; call it until it returns 0.
+func (t *LabResultsChaincode) MigrateKeys(ctx contractapi.TransactionContextInterface, limit int) (int, error) {
+	return ledger.MigrateKeys(ctx, func(key string, value []byte) (string, string, bool) {
+		return labResultObjectType, key, true
+	}, limit)
+}
+
+func (t *LabResultsChaincode) getLabResult(ctx contractapi.TransactionContextInterface, labResultID string) (*fhir.Observation, error) {
+	labResultJSON, err := getLabResultJSON(ctx, labResultID)
+	if err != nil {
+		return nil, err
+	}
+
+	var labResult fhir.Observation
+	if err := json.Unmarshal(labResultJSON, &labResult); err != nil {
+		return nil, errors.New("failed to unmarshal lab result")
+	}
+	return &labResult, nil
+}
+
+// getLabResultJSON returns a lab result as stored, whoever the caller is
+func getLabResultJSON(ctx contractapi.TransactionContextInterface, labResultID string) ([]byte, error) {
+	labResultKey, err := ledger.Key(ctx, labResultObjectType, labResultID)
+	if err != nil {
+		return nil, err
+	}
+	labResultAsBytes, err := ctx.GetStub().GetState(labResultKey)
+	if err != nil {
+		return nil, errors.New("failed to read from world state")
+	}
+	if labResultAsBytes == nil {
+		return nil, errors.New("the lab result does not exist")
+	}
+	return labResultAsBytes, nil
+}
+
+func (t *LabResultsChaincode) putLabResult(ctx contractapi.TransactionContextInterface, labResultID string, labResultJSON []byte) error {
+	labResultKey, err := ledger.Key(ctx, labResultObjectType, labResultID)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(labResultKey, labResultJSON)
+}
+
+// subjectPatientID returns the ID of the patient a lab result is about, empty when it has no subject
+func subjectPatientID(labResult *fhir.Observation) string {
+	if labResult.Subject == nil {
+		return ""
+	}
+	return auth.PatientID(labResult.Subject.Reference)
+}
+
+// isLabTechnician reports whether the caller is a lab technician. They read lab results and
+// reports without the patient's consent, so only those their own laboratory performed.
+func isLabTechnician(ctx contractapi.TransactionContextInterface) (bool, error) {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return false, err
+	}
+	return caller.HasRole(auth.RoleLabTechnician), nil
+}
+
+// checkPerformerRead checks that a lab technician caller belongs to one of the performers of
+// the resource described, such as "lab result 123"; other callers were already checked by the
+// access policy
+func checkPerformerRead(ctx contractapi.TransactionContextInterface, resource string, performers []fhir.Reference) error {
+	technician, err := isLabTechnician(ctx)
+	if err != nil || !technician {
+		return err
+	}
+	laboratory, err := callerLaboratory(ctx)
+	if err != nil {
+		return err
+	}
+	if !isPerformer(performers, laboratory) {
+		return errors.New(resource + " belongs to another laboratory")
+	}
+	return nil
+}
+
+// isPerformer reports whether laboratory is one of the performers of a lab result
+func isPerformer(performers []fhir.Reference, laboratory string) bool {
+	for _, performer := range performers {
+		if performer.Reference == laboratory {
+			return true
+		}
+	}
+	return false
+}
+
 func main() {
-	chaincode, err := contractapi.NewChaincode(new(LabResultsChaincode))
+	labResultsChaincode := new(LabResultsChaincode)
+	labResultsChaincode.BeforeTransaction = labResultsEnforcer.BeforeTransaction
+	labResultsChaincode.AfterTransaction = history.RecordSubmitter
+
+	chaincode, err := contractapi.NewChaincode(labResultsChaincode)
 	if err != nil {
 		log.Panic(errors.New("Error creating lab results chaincode: " + err.Error()))
 	}
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
 )
 
 // MockStub is a mock implementation of the ChaincodeStubInterface
@@ -235,6 +242,66 @@ func (m *MockTransactionContext) GetClientIdentity() cid.ClientIdentity {
 	return args.Get(0).(cid.ClientIdentity)
 }
 
+// MockClientIdentity is a mock implementation of the cid.ClientIdentity interface
+type MockClientIdentity struct {
+	mock.Mock
+}
+
+func (mci *MockClientIdentity) GetID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetMSPID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	args := mci.Called(attrName)
+	return args.String(0), args.Bool(1), args.Error(2)
+}
+
+func (mci *MockClientIdentity) HasAttribute(attrName string) (bool, error) {
+	args := mci.Called(attrName)
+	return args.Bool(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	args := mci.Called(attrName, attrValue)
+	return args.Error(0)
+}
+
+func (mci *MockClientIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	args := mci.Called()
+	return args.Get(0).(*x509.Certificate), args.Error(1)
+}
+
+// mockPatientCaller makes patientID the caller of the transaction, with an enrolled certificate
+func mockPatientCaller(mockCtx *MockTransactionContext, mockStub *MockStub, patientID string) *MockClientIdentity {
+	clientIdentity := new(MockClientIdentity)
+	clientIdentity.On("GetID").Return("x509::CN="+patientID, nil)
+	clientIdentity.On("GetMSPID").Return("PatientMSP", nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(patientID, true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return("patient", true, nil)
+	mockCtx.On("GetClientIdentity").Return(clientIdentity)
+	mockStub.On("InvokeChaincode", "patient", [][]byte{[]byte("VerifyIdentity")}, "patient-records-channel").Return(peer.Response{Status: 200})
+	return clientIdentity
+}
+
+// mockAccessLog expects an access log entry for patientID, written by transaction txID
+func mockAccessLog(mockStub *MockStub, patientID string, txID string, function string) {
+	logKey := "\x00AccessLog\x00" + patientID + "\x00" + txID + "\x00"
+	mockStub.On("GetTxID").Return(txID)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	mockStub.On("CreateCompositeKey", "AccessLog", []string{patientID, txID}).Return(logKey, nil)
+	mockStub.On("PutState", logKey, mock.MatchedBy(func(value []byte) bool {
+		var entry auth.AccessLogEntry
+		return json.Unmarshal(value, &entry) == nil && entry.Function == function &&
+			entry.UserID == patientID && entry.Grant.Basis == auth.BasisPatient
+	})).Return(nil)
+}
+
 // KVPair represents a key-value pair for testing purposes
 type KVPair struct {
 	Key   string
@@ -281,12 +348,13 @@ func (m *MockIterator) Close() error {
 
 // Helper function to create a sample observation JSON
 func sampleObservationJSON(id string) string {
-	observation := Observation{
+	observation := fhir.Observation{
 		ID:     id,
 		Status: "final",
-		Code: &CodeableConcept{
+		Code: &fhir.CodeableConcept{
 			Text: "Blood Test",
 		},
+		Performer: []fhir.Reference{{Reference: "Organization/LaboratorioAnalisiCMO"}},
 	}
 	bytes, err := json.Marshal(observation)
 	if err != nil {
@@ -297,13 +365,14 @@ func sampleObservationJSON(id string) string {
 
 // Helper function to create a sample observation JSON that includes patient data
 func sampleObservationJSONWithPatient(id string, patientID string) string {
-	observation := Observation{
+	observation := fhir.Observation{
 		ID:     id,
 		Status: "final",
-		Code: &CodeableConcept{
+		Code: &fhir.CodeableConcept{
 			Text: "Blood Test",
 		},
-		Subject: &Reference{
+		Performer: []fhir.Reference{{Reference: "Organization/LaboratorioAnalisiCMO"}},
+		Subject: &fhir.Reference{
 			Reference: "Patient/" + patientID,
 		},
 	}
@@ -314,20 +383,62 @@ func sampleObservationJSONWithPatient(id string, patientID string) string {
 	return string(bytes)
 }
 
+// mockKey expects the composite key objectType~id to be created and returns it
+func mockKey(stub *MockStub, objectType string, id string) string {
+	key := "\x00" + objectType + "\x00" + id + "\x00"
+	stub.On("CreateCompositeKey", objectType, []string{id}).Return(key, nil)
+	return key
+}
+
+// mockSupersede expects the current version of labResultID to be kept as the given version,
+// after version-1 earlier ones
+func mockSupersede(mockStub *MockStub, labResultID string, version int) {
+	iterator := &MockIterator{}
+	for v := 1; v < version; v++ {
+		versionJSON, _ := json.Marshal(LabResultVersion{Version: v, Observation: &fhir.Observation{ID: labResultID}, Superseded: true})
+		iterator.AddRecord("\x00ObservationVersion\x00"+labResultID+"\x00"+strconv.Itoa(v)+"\x00", versionJSON)
+	}
+	versionKey := "\x00ObservationVersion\x00" + labResultID + "\x00" + strconv.Itoa(version) + "\x00"
+	mockStub.On("GetStateByPartialCompositeKey", "ObservationVersion", []string{labResultID}).Return(iterator, nil)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	mockStub.On("CreateCompositeKey", "ObservationVersion", []string{labResultID, strconv.Itoa(version)}).Return(versionKey, nil)
+	mockStub.On("PutState", versionKey, mock.MatchedBy(func(value []byte) bool {
+		var kept LabResultVersion
+		return json.Unmarshal(value, &kept) == nil && kept.Superseded && kept.Version == version && kept.Observation.ID == labResultID
+	})).Return(nil)
+}
+
 func TestCreateLabResult_Successful(t *testing.T) {
 	labChaincode := new(LabResultsChaincode)
 	mockCtx := new(MockTransactionContext)
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
 
 	observationJSON := sampleObservationJSON("obs1")
-	mockStub.On("GetState", "obs1").Return(nil, nil) // Simulate that "obs1" does not exist
-	mockStub.On("PutState", "obs1", mock.Anything).Return(nil)
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(nil, nil) // Simulate that "obs1" does not exist
+	mockStub.On("PutState", labResultKey, mock.Anything).Return(nil)
 
 	err := labChaincode.CreateLabResult(mockCtx, observationJSON)
 	assert.NoError(t, err)
 }
 
+func TestCreateLabResult_RejectsAmendedStatus(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	var observation fhir.Observation
+	json.Unmarshal([]byte(sampleObservationJSON("obs1")), &observation)
+	observation.Status = StatusAmended
+	observationJSON, _ := json.Marshal(observation)
+
+	err := labChaincode.CreateLabResult(mockCtx, string(observationJSON))
+	assert.EqualError(t, err, "a new lab result must be registered, preliminary, final, not amended")
+}
+
 func TestCreateLabResult_FailureDueToExistingID(t *testing.T) {
 	labChaincode := new(LabResultsChaincode)
 	mockCtx := new(MockTransactionContext)
@@ -335,7 +446,8 @@ func TestCreateLabResult_FailureDueToExistingID(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	observationJSON := sampleObservationJSON("obs1")
-	mockStub.On("GetState", "obs1").Return([]byte(observationJSON), nil) // Simulate that "obs1" exists
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return([]byte(observationJSON), nil) // Simulate that "obs1" exists
 
 	err := labChaincode.CreateLabResult(mockCtx, observationJSON)
 	assert.Error(t, err, "expected an error when creating a lab result with an existing ID")
@@ -346,20 +458,117 @@ func TestUpdateLabResult_Successful(t *testing.T) {
 	mockCtx := new(MockTransactionContext)
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
 
 	originalObservationJSON := sampleObservationJSON("obs1")
-	var originalObservation Observation
+	var originalObservation fhir.Observation
 	json.Unmarshal([]byte(originalObservationJSON), &originalObservation)
 
 	updatedObservation := originalObservation
 	updatedObservation.Status = "amended"
+	updatedObservation.Note = []fhir.Annotation{{Text: "Sample re-run after haemolysis"}}
 	updatedObservationJSON, _ := json.Marshal(updatedObservation)
 
-	mockStub.On("GetState", "obs1").Return([]byte(originalObservationJSON), nil)
-	mockStub.On("PutState", "obs1", mock.Anything).Return(nil)
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return([]byte(originalObservationJSON), nil)
+	mockStub.On("PutState", labResultKey, mock.Anything).Return(nil)
+	mockSupersede(mockStub, "obs1", 1)
 
 	err := labChaincode.UpdateLabResult(mockCtx, "obs1", string(updatedObservationJSON))
 	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestUpdateLabResult_PreliminaryToFinal(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	var observation fhir.Observation
+	json.Unmarshal([]byte(sampleObservationJSON("obs1")), &observation)
+	observation.Status = StatusPreliminary
+	preliminaryJSON, _ := json.Marshal(observation)
+	observation.Status = StatusFinal
+	finalJSON, _ := json.Marshal(observation)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(preliminaryJSON, nil)
+	mockStub.On("PutState", labResultKey, finalJSON).Return(nil)
+
+	// Results not yet released change without a note and keep no earlier version
+	err := labChaincode.UpdateLabResult(mockCtx, "obs1", string(finalJSON))
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestUpdateLabResult_AmendingRequiresANote(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	var observation fhir.Observation
+	json.Unmarshal([]byte(sampleObservationJSON("obs1")), &observation)
+	observation.Note = []fhir.Annotation{{Text: "Fasting sample"}}
+	finalJSON, _ := json.Marshal(observation)
+	observation.Status = StatusCorrected
+	correctedJSON, _ := json.Marshal(observation)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(finalJSON, nil)
+
+	err := labChaincode.UpdateLabResult(mockCtx, "obs1", string(correctedJSON))
+	assert.EqualError(t, err, "a note explaining the change is required to update released lab result obs1")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestUpdateLabResult_RejectsIllegalTransition(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	var observation fhir.Observation
+	json.Unmarshal([]byte(sampleObservationJSON("obs1")), &observation)
+	observation.Status = StatusPreliminary
+	preliminaryJSON, _ := json.Marshal(observation)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return([]byte(sampleObservationJSON("obs1")), nil)
+
+	err := labChaincode.UpdateLabResult(mockCtx, "obs1", string(preliminaryJSON))
+	assert.EqualError(t, err, "cannot move lab result obs1 from final to preliminary")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestUpdateLabResult_KeepsEveryReplacedVersion(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	var observation fhir.Observation
+	json.Unmarshal([]byte(sampleObservationJSON("obs1")), &observation)
+	observation.Status = StatusAmended
+	observation.Note = []fhir.Annotation{{Text: "Sample re-run after haemolysis"}}
+	amendedJSON, _ := json.Marshal(observation)
+	observation.Status = StatusCancelled
+	observation.Note = append(observation.Note, fhir.Annotation{Text: "Sample mislabelled"})
+	cancelledJSON, _ := json.Marshal(observation)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(amendedJSON, nil)
+	mockStub.On("PutState", labResultKey, cancelledJSON).Return(nil)
+	mockSupersede(mockStub, "obs1", 2)
+
+	err := labChaincode.UpdateLabResult(mockCtx, "obs1", string(cancelledJSON))
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
 }
 
 func TestUpdateLabResult_NonExistentResult(t *testing.T) {
@@ -370,7 +579,8 @@ func TestUpdateLabResult_NonExistentResult(t *testing.T) {
 
 	updatedObservationJSON := sampleObservationJSON("obs2")
 
-	mockStub.On("GetState", "obs2").Return(nil, nil)
+	labResultKey := mockKey(mockStub, "Observation", "obs2")
+	mockStub.On("GetState", labResultKey).Return(nil, nil)
 
 	err := labChaincode.UpdateLabResult(mockCtx, "obs2", string(updatedObservationJSON))
 	assert.Error(t, err)
@@ -385,33 +595,160 @@ func TestUpdateLabResult_DecodingError(t *testing.T) {
 	originalObservationJSON := sampleObservationJSON("obs1")
 	invalidJSON := `{"ID":"obs1","Status":"invalid JSON"`
 
-	mockStub.On("GetState", "obs1").Return([]byte(originalObservationJSON), nil)
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return([]byte(originalObservationJSON), nil)
 
 	err := labChaincode.UpdateLabResult(mockCtx, "obs1", invalidJSON)
 	assert.Error(t, err)
 }
 
+func TestCreateLabResult_RecordsTheCallerAsPerformer(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiSDNMSP)
+
+	var observation fhir.Observation
+	json.Unmarshal([]byte(sampleObservationJSON("obs1")), &observation)
+	observation.Performer = nil
+	observationJSON, _ := json.Marshal(observation)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(nil, nil)
+	mockStub.On("PutState", labResultKey, mock.MatchedBy(func(value []byte) bool {
+		var stored fhir.Observation
+		return json.Unmarshal(value, &stored) == nil && len(stored.Performer) == 1 && stored.Performer[0].Reference == "Organization/LaboratorioAnalisiSDN"
+	})).Return(nil)
+
+	err := labChaincode.CreateLabResult(mockCtx, string(observationJSON))
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestCreateLabResult_RejectsAnotherLaboratoryAsPerformer(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiSDNMSP)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(nil, nil)
+
+	err := labChaincode.CreateLabResult(mockCtx, sampleObservationJSON("obs1"))
+	assert.EqualError(t, err, "laboratories may only post lab results in their own name")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestUpdateLabResult_RejectsAnotherLaboratory(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiSDNMSP)
+
+	var observation fhir.Observation
+	json.Unmarshal([]byte(sampleObservationJSONWithPatient("obs1", "patient1")), &observation)
+	observation.Status = StatusPreliminary
+	preliminaryJSON, _ := json.Marshal(observation)
+	observation.Status = StatusFinal
+	observation.Performer = nil
+	finalJSON, _ := json.Marshal(observation)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(preliminaryJSON, nil)
+
+	err := labChaincode.UpdateLabResult(mockCtx, "obs1", string(finalJSON))
+	assert.EqualError(t, err, "only the laboratory that performed lab result obs1 may update it")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestUpdateLabResult_RejectsAnotherSubject(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return([]byte(sampleObservationJSONWithPatient("obs1", "patient1")), nil)
+
+	err := labChaincode.UpdateLabResult(mockCtx, "obs1", sampleObservationJSONWithPatient("obs1", "patient2"))
+	assert.EqualError(t, err, "the subject of lab result obs1 cannot be changed")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestUpdateLabResult_RejectsAnotherID(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return([]byte(sampleObservationJSON("obs1")), nil)
+
+	err := labChaincode.UpdateLabResult(mockCtx, "obs1", sampleObservationJSON("obs2"))
+	assert.EqualError(t, err, "the ID of lab result obs1 cannot be changed")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
 func TestGetLabResult_Successful(t *testing.T) {
 	labChaincode := new(LabResultsChaincode)
 	mockCtx := new(MockTransactionContext)
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
 
+	mockDoctorCaller(mockCtx, "doctor1")
 	observationJSON := sampleObservationJSON("obs1")
-	mockStub.On("GetState", "obs1").Return([]byte(observationJSON), nil)
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return([]byte(observationJSON), nil)
 
 	result, err := labChaincode.GetLabResult(mockCtx, "obs1")
 	assert.NoError(t, err)
 	assert.Equal(t, observationJSON, result, "The retrieved lab result should match the stored one.")
 }
 
+func TestGetLabResult_TechnicianReadsTheirLaboratorysResults(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+	observationJSON := sampleObservationJSON("obs1")
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return([]byte(observationJSON), nil)
+
+	result, err := labChaincode.GetLabResult(mockCtx, "obs1")
+	assert.NoError(t, err)
+	assert.Equal(t, observationJSON, result)
+}
+
+func TestGetLabResult_RejectsTechnicianOfAnotherLaboratory(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiSDNMSP)
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return([]byte(sampleObservationJSON("obs1")), nil)
+
+	result, err := labChaincode.GetLabResult(mockCtx, "obs1")
+	assert.EqualError(t, err, "lab result obs1 belongs to another laboratory")
+	assert.Empty(t, result)
+}
+
 func TestGetLabResult_NonExistentResult(t *testing.T) {
 	labChaincode := new(LabResultsChaincode)
 	mockCtx := new(MockTransactionContext)
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
 
-	mockStub.On("GetState", "obs2").Return(nil, nil)
+	labResultKey := mockKey(mockStub, "Observation", "obs2")
+	mockStub.On("GetState", labResultKey).Return(nil, nil)
 
 	result, err := labChaincode.GetLabResult(mockCtx, "obs2")
 	assert.Error(t, err)
@@ -425,7 +762,8 @@ func TestGetLabResult_AccessError(t *testing.T) {
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
 
-	mockStub.On("GetState", "obs3").Return(nil, errors.New("ledger access error"))
+	labResultKey := mockKey(mockStub, "Observation", "obs3")
+	mockStub.On("GetState", labResultKey).Return(nil, errors.New("ledger access error"))
 
 	result, err := labChaincode.GetLabResult(mockCtx, "obs3")
 	assert.Error(t, err)
@@ -433,13 +771,44 @@ func TestGetLabResult_AccessError(t *testing.T) {
 	assert.Contains(t, err.Error(), "failed to read from world state", "Error message should indicate a failure to read from the world state.")
 }
 
+func TestGetLabResultVersions(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	var observation fhir.Observation
+	json.Unmarshal([]byte(sampleObservationJSON("obs1")), &observation)
+	observation.Status = StatusCorrected
+	correctedJSON, _ := json.Marshal(observation)
+
+	iterator := &MockIterator{}
+	originalJSON, _ := json.Marshal(LabResultVersion{Version: 1, Observation: &fhir.Observation{ID: "obs1", Status: StatusFinal}, Superseded: true})
+	iterator.AddRecord("\x00ObservationVersion\x00obs1\x001\x00", originalJSON)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(correctedJSON, nil)
+	mockStub.On("GetStateByPartialCompositeKey", "ObservationVersion", []string{"obs1"}).Return(iterator, nil)
+	mockDoctorCaller(mockCtx, "doctor1")
+
+	versions, err := labChaincode.GetLabResultVersions(mockCtx, "obs1")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+	assert.True(t, versions[0].Superseded)
+	assert.Equal(t, StatusFinal, versions[0].Observation.Status)
+	assert.False(t, versions[1].Superseded)
+	assert.Equal(t, 2, versions[1].Version)
+	assert.Equal(t, StatusCorrected, versions[1].Observation.Status)
+}
+
 func TestLabResultExists_Exists(t *testing.T) {
 	labChaincode := new(LabResultsChaincode)
 	mockCtx := new(MockTransactionContext)
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
 
-	mockStub.On("GetState", "existingID").Return([]byte("some data"), nil)
+	labResultKey := mockKey(mockStub, "Observation", "existingID")
+	mockStub.On("GetState", labResultKey).Return([]byte("some data"), nil)
 	exists, err := labChaincode.LabResultExists(mockCtx, "existingID")
 	assert.NoError(t, err)
 	assert.True(t, exists, "Lab result should exist when data is returned.")
@@ -451,7 +820,8 @@ func TestLabResultExists_DoesNotExist(t *testing.T) {
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
 
-	mockStub.On("GetState", "nonExistingID").Return(nil, nil)
+	labResultKey := mockKey(mockStub, "Observation", "nonExistingID")
+	mockStub.On("GetState", labResultKey).Return(nil, nil)
 	exists, err := labChaincode.LabResultExists(mockCtx, "nonExistingID")
 	assert.NoError(t, err)
 	assert.False(t, exists, "Lab result should not exist when no data is returned.")
@@ -463,7 +833,8 @@ func TestLabResultExists_WorldStateAccessError(t *testing.T) {
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
 
-	mockStub.On("GetState", "errorID").Return(nil, errors.New("ledger access error"))
+	labResultKey := mockKey(mockStub, "Observation", "errorID")
+	mockStub.On("GetState", labResultKey).Return(nil, errors.New("ledger access error"))
 	exists, err := labChaincode.LabResultExists(mockCtx, "errorID")
 	assert.Error(t, err)
 	assert.False(t, exists, "Lab result should not exist when an error occurs accessing the world state.")
@@ -513,3 +884,1168 @@ func TestQueryLabResults_ErrorHandling(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, results, "Results should be nil when an error occurs.")
 }
+
+func TestQueryLabResults_KeepsThePatientIDOutOfTheQuery(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	// Without the builder this ID closed the string and matched every patient's results
+	query := `{"selector":{"category":{"$elemMatch":{"text":"Laboratory"}},"subject.reference":"Patient/x\",\"subject.reference\":{\"$gt\":null},\"y\":\""},` +
+		`"use_index":["indexByPatient","indexByPatient"]}`
+	mockStub.On("GetQueryResult", query).Return(&MockIterator{}, nil)
+
+	results, err := labChaincode.QueryLabResults(mockCtx, `x","subject.reference":{"$gt":null},"y":"`)
+
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+	mockStub.AssertExpectations(t)
+}
+
+func TestQueryLabResults_QueriesThePatientReference(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	query := `{"selector":{"category":{"$elemMatch":{"text":"Laboratory"}},"subject.reference":"Patient/patient1"},` +
+		`"use_index":["indexByPatient","indexByPatient"]}`
+	mockStub.On("GetQueryResult", query).Return(&MockIterator{}, nil)
+
+	_, err := labChaincode.QueryLabResults(mockCtx, "Patient/patient1")
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestQueryLabResults_MatchesTheLaboratoryCategoryInTheArray(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	// category is an array of concepts, so the selector matches any of its elements
+	observation := fhir.Observation{
+		ID:       "obs1",
+		Status:   StatusFinal,
+		Category: []fhir.CodeableConcept{{Text: "Vital Signs"}, {Text: "Laboratory"}},
+		Code:     loinc("2823-3"),
+		Subject:  &fhir.Reference{Reference: "Patient/patient1"},
+	}
+	observationJSON, _ := json.Marshal(observation)
+	iterator := &MockIterator{}
+	iterator.AddRecord("\x00Observation\x00obs1\x00", observationJSON)
+	query := `{"selector":{"category":{"$elemMatch":{"text":"Laboratory"}},"subject.reference":"Patient/patient1"},` +
+		`"use_index":["indexByPatient","indexByPatient"]}`
+	mockStub.On("GetQueryResult", query).Return(iterator, nil)
+
+	results, err := labChaincode.QueryLabResults(mockCtx, "patient1")
+
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "Laboratory", results[0].Category[1].Text)
+	mockStub.AssertExpectations(t)
+}
+
+func TestPoliciesCoverEveryTransaction(t *testing.T) {
+	assert.Empty(t, auth.MissingPolicies(new(LabResultsChaincode), labResultsPolicies), "transactions without an access policy")
+}
+
+func TestAuditedGetLabResult_RecordsTheRead(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockPatientCaller(mockCtx, mockStub, "patient1")
+
+	observationJSON := sampleObservationJSONWithPatient("obs1", "patient1")
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return([]byte(observationJSON), nil)
+	mockAccessLog(mockStub, "patient1", "tx1", "GetLabResult")
+
+	result, err := labChaincode.AuditedGetLabResult(mockCtx, "obs1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, observationJSON, result)
+	mockStub.AssertExpectations(t)
+}
+
+func TestMigrateKeys(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	labChaincode := new(LabResultsChaincode)
+
+	observationJSON := []byte(sampleObservationJSON("obs1"))
+	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{
+		Records: []KVPair{{Key: "obs1", Value: observationJSON}},
+	}, nil)
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(nil, nil)
+	mockStub.On("PutState", labResultKey, observationJSON).Return(nil)
+	mockStub.On("DelState", "obs1").Return(nil)
+
+	migrated, err := labChaincode.MigrateKeys(mockCtx, 100)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+	mockStub.AssertExpectations(t)
+}
+
+func TestChaincodeMetadataIsValid(t *testing.T) {
+	// contractapi rejects at startup any transaction whose types it cannot describe
+	_, err := contractapi.NewChaincode(new(LabResultsChaincode))
+	assert.NoError(t, err)
+}
+
+// mockReferenceRanges sets up the reference ranges LaboratorioAnalisiCMO set for a LOINC code
+func mockReferenceRanges(mockStub *MockStub, code string, ranges ...ReferenceRange) {
+	laboratory := "Organization/LaboratorioAnalisiCMO"
+	iterator := &MockIterator{}
+	for _, referenceRange := range ranges {
+		referenceRange.Laboratory = laboratory
+		rangeJSON, _ := json.Marshal(referenceRange)
+		iterator.AddRecord("\x00ReferenceRange\x00"+laboratory+"\x00"+code+"\x00"+referenceRange.ID+"\x00", rangeJSON)
+	}
+	mockStub.On("GetStateByPartialCompositeKey", "ReferenceRange", []string{laboratory, code}).Return(iterator, nil)
+}
+
+// limits returns a range between low and high, in unit
+func limits(low float64, high float64, unit string) *fhir.Range {
+	return &fhir.Range{
+		Low:  &fhir.Quantity{Value: low, Unit: unit, System: "http://unitsofmeasure.org", Code: unit},
+		High: &fhir.Quantity{Value: high, Unit: unit, System: "http://unitsofmeasure.org", Code: unit},
+	}
+}
+
+// loinc returns the concept of a test with the given LOINC code
+func loinc(code string) *fhir.CodeableConcept {
+	return &fhir.CodeableConcept{Coding: []fhir.Coding{{System: "http://loinc.org", Code: code}}}
+}
+
+// interpretationOf returns the interpretation code of a result, or an empty string when it has none
+func interpretationOf(interpretation []fhir.CodeableConcept) string {
+	if len(interpretation) == 0 || len(interpretation[0].Coding) == 0 {
+		return ""
+	}
+	return interpretation[0].Coding[0].Code
+}
+
+func TestSetReferenceRange(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	rangeKey := "\x00ReferenceRange\x00Organization/LaboratorioAnalisiCMO\x002823-3\x00adult\x00"
+	mockStub.On("CreateCompositeKey", "ReferenceRange", []string{"Organization/LaboratorioAnalisiCMO", "2823-3", "adult"}).Return(rangeKey, nil)
+	mockStub.On("PutState", rangeKey, mock.MatchedBy(func(value []byte) bool {
+		var stored ReferenceRange
+		return json.Unmarshal(value, &stored) == nil && stored.Laboratory == "Organization/LaboratorioAnalisiCMO"
+	})).Return(nil)
+
+	rangeJSON, _ := json.Marshal(ReferenceRange{ID: "adult", Code: "2823-3", MinAge: 18, Normal: limits(3.5, 5.1, "mmol/L"), Critical: limits(2.5, 6.5, "mmol/L")})
+	err := labChaincode.SetReferenceRange(mockCtx, string(rangeJSON))
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestSetReferenceRange_RejectsInconsistentLimits(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+
+	rangeJSON, _ := json.Marshal(ReferenceRange{ID: "adult", Code: "2823-3", Normal: limits(3.5, 5.1, "mmol/L"), Critical: limits(4, 6.5, "mmol/L")})
+	err := labChaincode.SetReferenceRange(mockCtx, string(rangeJSON))
+	assert.EqualError(t, err, "the limits of a reference range must not decrease from critical low to low, high and critical high")
+
+	rangeJSON, _ = json.Marshal(ReferenceRange{ID: "adult", Code: "2823-3", Normal: limits(3.5, 5.1, "mmol/L"), Critical: limits(10, 30, "mg/dL")})
+	err = labChaincode.SetReferenceRange(mockCtx, string(rangeJSON))
+	assert.EqualError(t, err, "the limits of a reference range must share one unit")
+}
+
+func TestSetReferenceRange_RejectsAnotherLaboratory(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiSDNMSP)
+
+	rangeJSON, _ := json.Marshal(ReferenceRange{ID: "adult", Laboratory: "Organization/LaboratorioAnalisiCMO", Code: "2823-3", Normal: limits(3.5, 5.1, "mmol/L")})
+	err := labChaincode.SetReferenceRange(mockCtx, string(rangeJSON))
+
+	assert.EqualError(t, err, "laboratories may only set their own reference ranges")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestDeleteReferenceRange_OnlyDeletesTheCallersRanges(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiSDNMSP)
+
+	// LaboratorioAnalisiCMO has a range with this ID, but the caller's laboratory has none
+	rangeKey := "\x00ReferenceRange\x00Organization/LaboratorioAnalisiSDN\x002823-3\x00adult\x00"
+	mockStub.On("CreateCompositeKey", "ReferenceRange", []string{"Organization/LaboratorioAnalisiSDN", "2823-3", "adult"}).Return(rangeKey, nil)
+	mockStub.On("GetState", rangeKey).Return(nil, nil)
+
+	err := labChaincode.DeleteReferenceRange(mockCtx, "2823-3", "adult")
+
+	assert.EqualError(t, err, "reference range adult of test 2823-3 does not exist")
+	mockStub.AssertNotCalled(t, "DelState", mock.Anything)
+}
+
+func TestCreateLabResult_InterpretsAgainstReferenceRange(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	potassium := fhir.Observation{
+		ID:            "obs1",
+		Status:        StatusFinal,
+		Code:          loinc("2823-3"),
+		Subject:       &fhir.Reference{Reference: "Patient/patient1"},
+		ValueQuantity: &fhir.Quantity{Value: 5.8, Unit: "mmol/L", Code: "mmol/L"},
+	}
+	potassiumJSON, _ := json.Marshal(potassium)
+
+	// A range for every patient needs no demographics, so the patient is not read
+	mockReferenceRanges(mockStub, "2823-3", ReferenceRange{ID: "all", Code: "2823-3", Normal: limits(3.5, 5.1, "mmol/L"), Critical: limits(2.5, 6.5, "mmol/L")})
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(nil, nil)
+	mockStub.On("PutState", labResultKey, mock.MatchedBy(func(value []byte) bool {
+		var stored fhir.Observation
+		return json.Unmarshal(value, &stored) == nil && interpretationOf(stored.Interpretation) == InterpretationHigh &&
+			stored.ReferenceRange[0].High.Value == 5.1
+	})).Return(nil)
+
+	err := labChaincode.CreateLabResult(mockCtx, string(potassiumJSON))
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+	mockStub.AssertNotCalled(t, "InvokeChaincode", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateLabResult_InterpretsComponentsForPatientSexAndAge(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	panel := fhir.Observation{
+		ID:              "obs1",
+		Status:          StatusFinal,
+		Code:            &fhir.CodeableConcept{Text: "Blood and urine screening"},
+		Subject:         &fhir.Reference{Reference: "Patient/patient1"},
+		EffectivePeriod: &fhir.Period{Start: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)},
+		Component: []fhir.ObservationComponent{
+			{Code: loinc("718-7"), ValueQuantity: &fhir.Quantity{Value: 13, Unit: "g/dL", Code: "g/dL"}},
+			{Code: loinc("5802-4"), ValueCodeableConcept: &fhir.CodeableConcept{Coding: []fhir.Coding{{Code: "positive"}}}},
+			{Code: loinc("2345-7"), ValueQuantity: &fhir.Quantity{Value: 90, Unit: "mg/dL", Code: "mg/dL"}},
+		},
+	}
+	panelJSON, _ := json.Marshal(panel)
+
+	mockReferenceRanges(mockStub, "718-7",
+		ReferenceRange{ID: "men", Code: "718-7", Sex: SexMale, MinAge: 18, Normal: limits(13.5, 17.5, "g/dL")},
+		ReferenceRange{ID: "women", Code: "718-7", Sex: SexFemale, MinAge: 18, Normal: limits(12, 16, "g/dL")})
+	mockReferenceRanges(mockStub, "5802-4", ReferenceRange{ID: "all", Code: "5802-4", NormalCodes: []string{"negative"}})
+	mockReferenceRanges(mockStub, "2345-7")
+
+	patientJSON, _ := json.Marshal(fhir.Patient{
+		Gender:    &fhir.Code{Coding: []fhir.Coding{{System: "http://hl7.org/fhir/administrative-gender", Code: "female"}}},
+		BirthDate: time.Date(1990, 6, 15, 0, 0, 0, 0, time.UTC),
+	})
+	mockStub.On("GetTransient").Return(map[string][]byte{"patientDemographics": patientJSON}, nil).Once()
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(nil, nil)
+	var stored fhir.Observation
+	mockStub.On("PutState", labResultKey, mock.Anything).Run(func(args mock.Arguments) {
+		json.Unmarshal(args.Get(1).([]byte), &stored)
+	}).Return(nil)
+
+	err := labChaincode.CreateLabResult(mockCtx, string(panelJSON))
+
+	assert.NoError(t, err)
+	assert.Equal(t, InterpretationNormal, interpretationOf(stored.Component[0].Interpretation), "13 g/dL is normal for a woman, low for a man")
+	assert.Equal(t, "female", stored.Component[0].ReferenceRange[0].AppliesTo[0].Coding[0].Code)
+	assert.Equal(t, InterpretationAbnormal, interpretationOf(stored.Component[1].Interpretation))
+	assert.Empty(t, stored.Component[2].Interpretation, "tests without a reference range are not interpreted")
+	assert.Empty(t, stored.Interpretation)
+}
+
+func TestCreateLabResult_UsesRangesForEveryPatientWithoutDemographics(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	hemoglobin := fhir.Observation{
+		ID:            "obs1",
+		Status:        StatusFinal,
+		Code:          loinc("718-7"),
+		Subject:       &fhir.Reference{Reference: "Patient/patient1"},
+		ValueQuantity: &fhir.Quantity{Value: 11, Unit: "g/dL", Code: "g/dL"},
+	}
+	hemoglobinJSON, _ := json.Marshal(hemoglobin)
+
+	mockReferenceRanges(mockStub, "718-7",
+		ReferenceRange{ID: "all", Code: "718-7", Normal: limits(12, 17.5, "g/dL")},
+		ReferenceRange{ID: "women", Code: "718-7", Sex: SexFemale, MinAge: 18, Normal: limits(10, 16, "g/dL")})
+	mockStub.On("GetTransient").Return(map[string][]byte{}, nil)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(nil, nil)
+	var stored fhir.Observation
+	mockStub.On("PutState", labResultKey, mock.Anything).Run(func(args mock.Arguments) {
+		json.Unmarshal(args.Get(1).([]byte), &stored)
+	}).Return(nil)
+
+	err := labChaincode.CreateLabResult(mockCtx, string(hemoglobinJSON))
+
+	assert.NoError(t, err)
+	assert.Equal(t, InterpretationLow, interpretationOf(stored.Interpretation), "only the range for every patient applies")
+	assert.Empty(t, stored.ReferenceRange[0].AppliesTo)
+}
+
+func TestAgeAt(t *testing.T) {
+	birthDate := time.Date(1990, 6, 15, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 33, ageAt(birthDate, time.Date(2024, 6, 14, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 34, ageAt(birthDate, time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)))
+}
+
+// mockDoctorCaller makes the doctor userID the caller of the transaction
+func mockDoctorCaller(mockCtx *MockTransactionContext, userID string) {
+	clientIdentity := new(MockClientIdentity)
+	clientIdentity.On("GetID").Return("x509::CN="+userID, nil)
+	clientIdentity.On("GetMSPID").Return(auth.MedicinaGeneraleNapoliMSP, nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(userID, true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return(auth.RoleDoctor, true, nil)
+	mockCtx.On("GetClientIdentity").Return(clientIdentity)
+}
+
+// mockLabTechnicianCaller makes a lab technician of the laboratory mspID the caller of the transaction
+func mockLabTechnicianCaller(mockCtx *MockTransactionContext, mspID string) {
+	clientIdentity := new(MockClientIdentity)
+	clientIdentity.On("GetID").Return("x509::CN=technician1", nil)
+	clientIdentity.On("GetMSPID").Return(mspID, nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return("technician1", true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return(auth.RoleLabTechnician, true, nil)
+	mockCtx.On("GetClientIdentity").Return(clientIdentity)
+}
+
+// mockLabOrder sets up a lab order of patient1, placed by doctor1 with LaboratorioAnalisiCMO, and returns its key
+func mockLabOrder(mockStub *MockStub, orderID string, status string) string {
+	order := fhir.ServiceRequest{
+		ID:        &fhir.Identifier{Value: orderID},
+		Intent:    &fhir.Code{Coding: []fhir.Coding{{Code: "order"}}},
+		Subject:   &fhir.Reference{Reference: "Patient/patient1"},
+		Requester: &fhir.Reference{Reference: "Practitioner/doctor1"},
+		Performer: &fhir.Reference{Reference: "Organization/LaboratorioAnalisiCMO"},
+	}
+	setOrderStatus(&order, status)
+	orderJSON, _ := json.Marshal(order)
+	orderKey := mockKey(mockStub, "ServiceRequest", orderID)
+	mockStub.On("GetState", orderKey).Return(orderJSON, nil)
+	return orderKey
+}
+
+// mockCriticalAlert sets up the critical alert stored for labResultID and returns its key
+func mockCriticalAlert(mockStub *MockStub, labResultID string, alert *CriticalAlert) string {
+	alertKey := mockKey(mockStub, "CriticalAlert", labResultID)
+	if alert == nil {
+		mockStub.On("GetState", alertKey).Return(nil, nil)
+	} else {
+		alertJSON, _ := json.Marshal(alert)
+		mockStub.On("GetState", alertKey).Return(alertJSON, nil)
+	}
+	return alertKey
+}
+
+// criticalPotassium returns a potassium result of 6.8 mmol/L based on order1
+func criticalPotassium() fhir.Observation {
+	return fhir.Observation{
+		ID:            "obs1",
+		BasedOn:       []fhir.Reference{{Reference: "ServiceRequest/order1"}},
+		Status:        StatusFinal,
+		Code:          loinc("2823-3"),
+		Subject:       &fhir.Reference{Reference: "Patient/patient1"},
+		Performer:     []fhir.Reference{{Reference: "Organization/LaboratorioAnalisiCMO"}},
+		ValueQuantity: &fhir.Quantity{Value: 6.8, Unit: "mmol/L", Code: "mmol/L"},
+	}
+}
+
+func TestCreateLabResult_RaisesCriticalAlert(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	potassiumJSON, _ := json.Marshal(criticalPotassium())
+	mockReferenceRanges(mockStub, "2823-3", ReferenceRange{ID: "all", Code: "2823-3", Normal: limits(3.5, 5.1, "mmol/L"), Critical: limits(2.5, 6.5, "mmol/L")})
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(nil, nil)
+	mockStub.On("PutState", labResultKey, mock.Anything).Return(nil)
+
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+	orderKey := mockLabOrder(mockStub, "order1", OrderAccepted)
+	mockStub.On("PutState", orderKey, mock.Anything).Return(nil)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+
+	alertKey := mockCriticalAlert(mockStub, "obs1", nil)
+	isAlert := mock.MatchedBy(func(value []byte) bool {
+		var alert CriticalAlert
+		return json.Unmarshal(value, &alert) == nil && alert.ObservationID == "obs1" && alert.Patient == "Patient/patient1" &&
+			alert.OrderingPractitioner == "Practitioner/doctor1" && !alert.Acknowledged &&
+			len(alert.CriticalValues) == 1 && alert.CriticalValues[0].Interpretation == InterpretationCriticalHigh && alert.CriticalValues[0].Value.Value == 6.8
+	})
+	mockStub.On("PutState", alertKey, isAlert).Return(nil)
+	mockStub.On("SetEvent", "CriticalLabResult", isAlert).Return(nil)
+
+	err := labChaincode.CreateLabResult(mockCtx, string(potassiumJSON))
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestUpdateLabResult_KeepsTheAlertOfUnchangedCriticalValues(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	potassium := criticalPotassium()
+	potassium.Status = StatusPreliminary
+	preliminaryJSON, _ := json.Marshal(potassium)
+	potassium.Status = StatusFinal
+	finalJSON, _ := json.Marshal(potassium)
+
+	mockReferenceRanges(mockStub, "2823-3", ReferenceRange{ID: "all", Code: "2823-3", Normal: limits(3.5, 5.1, "mmol/L"), Critical: limits(2.5, 6.5, "mmol/L")})
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(preliminaryJSON, nil)
+	mockStub.On("PutState", labResultKey, mock.Anything).Return(nil)
+	orderKey := mockLabOrder(mockStub, "order1", OrderAccepted)
+	mockStub.On("PutState", orderKey, mock.Anything).Return(nil)
+	mockCriticalAlert(mockStub, "obs1", &CriticalAlert{
+		ObservationID:  "obs1",
+		CriticalValues: []CriticalValue{{Code: "2823-3", Interpretation: InterpretationCriticalHigh, Value: potassium.ValueQuantity}},
+		Acknowledged:   true,
+		AcknowledgedBy: "doctor1",
+	})
+
+	// The doctor has already acknowledged the preliminary value, so finalizing it raises no new alert
+	err := labChaincode.UpdateLabResult(mockCtx, "obs1", string(finalJSON))
+
+	assert.NoError(t, err)
+	mockStub.AssertNotCalled(t, "SetEvent", mock.Anything, mock.Anything)
+}
+
+// criticalPreliminaryPotassium returns the stored preliminary version of criticalPotassium,
+// interpreted as critically high
+func criticalPreliminaryPotassium() []byte {
+	potassium := criticalPotassium()
+	potassium.Status = StatusPreliminary
+	potassium.Interpretation = []fhir.CodeableConcept{{Coding: []fhir.Coding{{System: interpretationSystem, Code: InterpretationCriticalHigh}}}}
+	potassiumJSON, _ := json.Marshal(potassium)
+	return potassiumJSON
+}
+
+func TestUpdateLabResult_KeepsTheAcknowledgementOfReplacedCriticalValues(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	potassium := criticalPotassium()
+	potassium.ValueQuantity = &fhir.Quantity{Value: 7.4, Unit: "mmol/L", Code: "mmol/L"}
+	finalJSON, _ := json.Marshal(potassium)
+
+	mockReferenceRanges(mockStub, "2823-3", ReferenceRange{ID: "all", Code: "2823-3", Normal: limits(3.5, 5.1, "mmol/L"), Critical: limits(2.5, 6.5, "mmol/L")})
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(criticalPreliminaryPotassium(), nil)
+	mockStub.On("PutState", labResultKey, mock.Anything).Return(nil)
+	orderKey := mockLabOrder(mockStub, "order1", OrderAccepted)
+	mockStub.On("PutState", orderKey, mock.Anything).Return(nil)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	alertKey := mockCriticalAlert(mockStub, "obs1", &CriticalAlert{
+		ObservationID:  "obs1",
+		CriticalValues: []CriticalValue{{Code: "2823-3", Interpretation: InterpretationCriticalHigh, Value: criticalPotassium().ValueQuantity}},
+		Acknowledged:   true,
+		AcknowledgedBy: "doctor1",
+	})
+	isAlert := mock.MatchedBy(func(value []byte) bool {
+		var alert CriticalAlert
+		return json.Unmarshal(value, &alert) == nil && !alert.Acknowledged && alert.CriticalValues[0].Value.Value == 7.4 &&
+			len(alert.Acknowledgements) == 1 && alert.Acknowledgements[0].AcknowledgedBy == "doctor1" && alert.Acknowledgements[0].CriticalValues[0].Value.Value == 6.8
+	})
+	mockStub.On("PutState", alertKey, isAlert).Return(nil)
+	mockStub.On("SetEvent", "CriticalLabResult", isAlert).Return(nil)
+
+	// The new critical value must be acknowledged again, without losing who saw the first one
+	err := labChaincode.UpdateLabResult(mockCtx, "obs1", string(finalJSON))
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestUpdateLabResult_ResolvesTheAlertOfValuesNoLongerCritical(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	potassium := criticalPotassium()
+	potassium.ValueQuantity = &fhir.Quantity{Value: 4.8, Unit: "mmol/L", Code: "mmol/L"}
+	finalJSON, _ := json.Marshal(potassium)
+
+	mockReferenceRanges(mockStub, "2823-3", ReferenceRange{ID: "all", Code: "2823-3", Normal: limits(3.5, 5.1, "mmol/L"), Critical: limits(2.5, 6.5, "mmol/L")})
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(criticalPreliminaryPotassium(), nil)
+	mockStub.On("PutState", labResultKey, mock.Anything).Return(nil)
+	orderKey := mockLabOrder(mockStub, "order1", OrderAccepted)
+	mockStub.On("PutState", orderKey, mock.Anything).Return(nil)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	alertKey := mockCriticalAlert(mockStub, "obs1", &CriticalAlert{
+		ObservationID:  "obs1",
+		CriticalValues: []CriticalValue{{Code: "2823-3", Interpretation: InterpretationCriticalHigh, Value: criticalPotassium().ValueQuantity}},
+	})
+	mockStub.On("PutState", alertKey, mock.MatchedBy(func(value []byte) bool {
+		var alert CriticalAlert
+		return json.Unmarshal(value, &alert) == nil && alert.Resolved && !alert.ResolvedAt.IsZero()
+	})).Return(nil)
+
+	err := labChaincode.UpdateLabResult(mockCtx, "obs1", string(finalJSON))
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+	mockStub.AssertNotCalled(t, "SetEvent", mock.Anything, mock.Anything)
+}
+
+func TestUpdateLabResult_ResolvesTheAlertOfCancelledResults(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	potassium := criticalPotassium()
+	potassium.Status = StatusCancelled
+	cancelledJSON, _ := json.Marshal(potassium)
+
+	mockReferenceRanges(mockStub, "2823-3", ReferenceRange{ID: "all", Code: "2823-3", Normal: limits(3.5, 5.1, "mmol/L"), Critical: limits(2.5, 6.5, "mmol/L")})
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(criticalPreliminaryPotassium(), nil)
+	mockStub.On("PutState", labResultKey, mock.Anything).Return(nil)
+	mockLabOrder(mockStub, "order1", OrderAccepted)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	alertKey := mockCriticalAlert(mockStub, "obs1", &CriticalAlert{
+		ObservationID:  "obs1",
+		CriticalValues: []CriticalValue{{Code: "2823-3", Interpretation: InterpretationCriticalHigh, Value: criticalPotassium().ValueQuantity}},
+	})
+	mockStub.On("PutState", alertKey, mock.MatchedBy(func(value []byte) bool {
+		var alert CriticalAlert
+		return json.Unmarshal(value, &alert) == nil && alert.Resolved
+	})).Return(nil)
+
+	err := labChaincode.UpdateLabResult(mockCtx, "obs1", string(cancelledJSON))
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestAcknowledgeCriticalResult(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockDoctorCaller(mockCtx, "doctor1")
+
+	alertKey := mockCriticalAlert(mockStub, "obs1", &CriticalAlert{ObservationID: "obs1", Patient: "Patient/patient1", OrderingPractitioner: "Practitioner/doctor1"})
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	mockStub.On("PutState", alertKey, mock.MatchedBy(func(value []byte) bool {
+		var alert CriticalAlert
+		return json.Unmarshal(value, &alert) == nil && alert.Acknowledged && alert.AcknowledgedBy == "doctor1"
+	})).Return(nil)
+
+	alert, err := labChaincode.AcknowledgeCriticalResult(mockCtx, "obs1")
+
+	assert.NoError(t, err)
+	assert.True(t, alert.Acknowledged)
+	assert.False(t, alert.AcknowledgedAt.IsZero())
+	mockStub.AssertExpectations(t)
+}
+
+func TestCriticalAlert_LeavesOutTheTimesNotYetSet(t *testing.T) {
+	alertJSON, err := json.Marshal(CriticalAlert{ObservationID: "obs1", Patient: "Patient/patient1", DetectedAt: time.Now()})
+
+	assert.NoError(t, err)
+	assert.NotContains(t, string(alertJSON), "acknowledgedAt")
+	assert.NotContains(t, string(alertJSON), "resolvedAt")
+}
+
+func TestAcknowledgeCriticalResult_OnlyByTheOrderingPractitioner(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockDoctorCaller(mockCtx, "doctor2")
+
+	mockCriticalAlert(mockStub, "obs1", &CriticalAlert{ObservationID: "obs1", Patient: "Patient/patient1", OrderingPractitioner: "Practitioner/doctor1"})
+
+	_, err := labChaincode.AcknowledgeCriticalResult(mockCtx, "obs1")
+
+	assert.EqualError(t, err, "only Practitioner/doctor1, who ordered lab result obs1, may acknowledge its critical values")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestAcknowledgeCriticalResult_AlreadyAcknowledged(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	mockCriticalAlert(mockStub, "obs1", &CriticalAlert{ObservationID: "obs1", Acknowledged: true, AcknowledgedBy: "doctor1"})
+
+	_, err := labChaincode.AcknowledgeCriticalResult(mockCtx, "obs1")
+
+	assert.EqualError(t, err, "the critical values of lab result obs1 were already acknowledged by doctor1")
+}
+
+func TestQueryUnacknowledgedCriticalResults(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	earlier, _ := json.Marshal(CriticalAlert{ObservationID: "obs1", Patient: "Patient/patient1", DetectedAt: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)})
+	later, _ := json.Marshal(CriticalAlert{ObservationID: "obs2", Patient: "Patient/patient1", DetectedAt: time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)})
+	iterator := &MockIterator{}
+	iterator.AddRecord("\x00CriticalAlert\x00obs2\x00", later)
+	iterator.AddRecord("\x00CriticalAlert\x00obs1\x00", earlier)
+	mockStub.On("GetQueryResult", `{"selector":{"acknowledged":false,"patient":"Patient/patient1\"}","resolved":false},"use_index":["indexCriticalAlertsByPatient","indexCriticalAlertsByPatient"]}`).Return(iterator, nil)
+
+	// Quotes in the patient ID stay inside the selector value
+	alerts, err := labChaincode.QueryUnacknowledgedCriticalResults(mockCtx, `patient1"}`)
+
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 2)
+	assert.Equal(t, "obs1", alerts[0].ObservationID, "the oldest alert comes first")
+}
+
+// sampleLabOrderJSON returns a potassium lab order of patient1 addressed to laboratory
+func sampleLabOrderJSON(orderID string, laboratory string) string {
+	order := fhir.ServiceRequest{
+		ID:        &fhir.Identifier{Value: orderID},
+		Service:   loinc("2823-3"),
+		Subject:   &fhir.Reference{Reference: "Patient/patient1"},
+		Performer: &fhir.Reference{Reference: laboratory},
+	}
+	orderJSON, _ := json.Marshal(order)
+	return string(orderJSON)
+}
+
+// storedOrderStatus matches a stored lab order with the given status
+func storedOrderStatus(status string) interface{} {
+	return mock.MatchedBy(func(value []byte) bool {
+		var order fhir.ServiceRequest
+		return json.Unmarshal(value, &order) == nil && orderStatusOf(&order) == status
+	})
+}
+
+func TestCreateLabOrder(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockDoctorCaller(mockCtx, "doctor1")
+
+	orderKey := mockKey(mockStub, "ServiceRequest", "order1")
+	mockStub.On("GetState", orderKey).Return(nil, nil)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	mockStub.On("PutState", orderKey, mock.MatchedBy(func(value []byte) bool {
+		var order fhir.ServiceRequest
+		return json.Unmarshal(value, &order) == nil && orderStatusOf(&order) == OrderRequested &&
+			order.Requester.Reference == "Practitioner/doctor1" && order.Intent.Coding[0].Code == "order" && !order.AuthoredOn.IsZero()
+	})).Return(nil)
+
+	err := labChaincode.CreateLabOrder(mockCtx, sampleLabOrderJSON("order1", "Organization/LaboratorioAnalisiCMO"))
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestCreateLabOrder_MustBeAddressedToALaboratory(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+
+	err := labChaincode.CreateLabOrder(mockCtx, sampleLabOrderJSON("order1", "Organization/FarmaciaPetrone"))
+	assert.EqualError(t, err, "a lab order must be addressed to a laboratory")
+}
+
+func TestGetLabOrder_ByThePerformingLaboratory(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+	mockLabOrder(mockStub, "order1", OrderRequested)
+
+	order, err := labChaincode.GetLabOrder(mockCtx, "order1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "order1", order.ID.Value)
+}
+
+func TestGetLabOrder_RejectsAnotherLaboratory(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiSDNMSP)
+	mockLabOrder(mockStub, "order1", OrderRequested)
+
+	order, err := labChaincode.GetLabOrder(mockCtx, "order1")
+
+	assert.EqualError(t, err, "lab order order1 is addressed to another laboratory")
+	assert.Nil(t, order)
+}
+
+func TestAcceptLabOrder(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	orderKey := mockLabOrder(mockStub, "order1", OrderRequested)
+	mockStub.On("PutState", orderKey, storedOrderStatus(OrderAccepted)).Return(nil)
+
+	err := labChaincode.AcceptLabOrder(mockCtx, "order1")
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestAcceptLabOrder_AddressedToAnotherLaboratory(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiSDNMSP)
+
+	mockLabOrder(mockStub, "order1", OrderRequested)
+
+	err := labChaincode.AcceptLabOrder(mockCtx, "order1")
+
+	assert.EqualError(t, err, "lab order order1 is addressed to another laboratory")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestRejectLabOrder(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	orderKey := mockLabOrder(mockStub, "order1", OrderAccepted)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	mockStub.On("PutState", orderKey, mock.MatchedBy(func(value []byte) bool {
+		var order fhir.ServiceRequest
+		return json.Unmarshal(value, &order) == nil && orderStatusOf(&order) == OrderRejected &&
+			len(order.Note) == 1 && order.Note[0].Text == "Sample haemolysed"
+	})).Return(nil)
+
+	err := labChaincode.RejectLabOrder(mockCtx, "order1", "Sample haemolysed")
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestCreateLabResult_CompletesItsOrder(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	var observation fhir.Observation
+	json.Unmarshal([]byte(sampleObservationJSONWithPatient("obs1", "patient1")), &observation)
+	observation.BasedOn = []fhir.Reference{{Reference: "ServiceRequest/order1"}}
+	observationJSON, _ := json.Marshal(observation)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(nil, nil)
+	mockStub.On("PutState", labResultKey, observationJSON).Return(nil)
+	orderKey := mockLabOrder(mockStub, "order1", OrderAccepted)
+	mockStub.On("PutState", orderKey, storedOrderStatus(OrderCompleted)).Return(nil)
+
+	err := labChaincode.CreateLabResult(mockCtx, string(observationJSON))
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestCreateLabResult_RequiresAnAcceptedOrder(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	var observation fhir.Observation
+	json.Unmarshal([]byte(sampleObservationJSONWithPatient("obs1", "patient1")), &observation)
+	observation.BasedOn = []fhir.Reference{{Reference: "ServiceRequest/order1"}}
+	observationJSON, _ := json.Marshal(observation)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(nil, nil)
+	mockLabOrder(mockStub, "order1", OrderRequested)
+
+	err := labChaincode.CreateLabResult(mockCtx, string(observationJSON))
+
+	assert.EqualError(t, err, "results can only be posted for accepted lab orders; lab order order1 is requested")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestQueryLabOrdersByRequester_Outstanding(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockDoctorCaller(mockCtx, "doctor1")
+
+	older, _ := json.Marshal(fhir.ServiceRequest{ID: &fhir.Identifier{Value: "order1"}, AuthoredOn: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)})
+	newer, _ := json.Marshal(fhir.ServiceRequest{ID: &fhir.Identifier{Value: "order2"}, AuthoredOn: time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)})
+	iterator := &MockIterator{}
+	iterator.AddRecord("\x00ServiceRequest\x00order1\x00", older)
+	iterator.AddRecord("\x00ServiceRequest\x00order2\x00", newer)
+	query := `{"selector":{"intent":{"$exists":true},"requester.reference":"Practitioner/doctor1","status.coding":{"$elemMatch":{"code":{"$in":["requested","accepted"]}}}},` +
+		`"use_index":["indexLabOrdersByRequester","indexLabOrdersByRequester"]}`
+	mockStub.On("GetQueryResult", query).Return(iterator, nil)
+
+	orders, err := labChaincode.QueryLabOrdersByRequester(mockCtx, "doctor1", false)
+
+	assert.NoError(t, err)
+	assert.Len(t, orders, 2)
+	assert.Equal(t, "order2", orders[0].ID.Value, "the newest order comes first")
+}
+
+func TestQueryLabOrdersByRequester_OnlyTheCallersOrders(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockDoctorCaller(mockCtx, "doctor2")
+
+	_, err := labChaincode.QueryLabOrdersByRequester(mockCtx, "doctor1", true)
+	assert.EqualError(t, err, "practitioners may only list the lab orders they placed")
+}
+
+// sampleDiagnosticReport returns a final full blood count report of patient1 grouping labResultIDs
+func sampleDiagnosticReport(reportID string, labResultIDs ...string) fhir.DiagnosticReport {
+	report := fhir.DiagnosticReport{
+		ID:             reportID,
+		Status:         "final",
+		Code:           loinc("58410-2"),
+		Subject:        &fhir.Reference{Reference: "Patient/patient1"},
+		Conclusion:     "Iron deficiency anaemia",
+		ConclusionCode: []fhir.CodeableConcept{{Coding: []fhir.Coding{{System: "http://snomed.info/sct", Code: "87522002"}}}},
+	}
+	for _, labResultID := range labResultIDs {
+		report.Result = append(report.Result, fhir.Reference{Reference: "Observation/" + labResultID})
+	}
+	return report
+}
+
+// mockStoredDiagnosticReport sets up a report issued by LaboratorioAnalisiCMO and returns its key
+func mockStoredDiagnosticReport(mockStub *MockStub, report fhir.DiagnosticReport) string {
+	report.Performer = []fhir.Reference{{Reference: "Organization/LaboratorioAnalisiCMO"}}
+	reportJSON, _ := json.Marshal(report)
+	reportKey := mockKey(mockStub, "DiagnosticReport", report.ID)
+	mockStub.On("GetState", reportKey).Return(reportJSON, nil)
+	return reportKey
+}
+
+func TestCreateDiagnosticReport(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	mockStub.On("GetState", mockKey(mockStub, "Observation", "obs1")).Return([]byte(sampleObservationJSONWithPatient("obs1", "patient1")), nil)
+	mockStub.On("GetState", mockKey(mockStub, "Observation", "obs2")).Return([]byte(sampleObservationJSONWithPatient("obs2", "patient1")), nil)
+	reportKey := mockKey(mockStub, "DiagnosticReport", "report1")
+	mockStub.On("GetState", reportKey).Return(nil, nil)
+	issued := time.Date(2024, 5, 2, 9, 30, 0, 0, time.UTC)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: issued.Unix()}, nil)
+	mockStub.On("PutState", reportKey, mock.MatchedBy(func(value []byte) bool {
+		var report fhir.DiagnosticReport
+		return json.Unmarshal(value, &report) == nil && len(report.Result) == 2 &&
+			report.Performer[0].Reference == "Organization/LaboratorioAnalisiCMO" && report.ConclusionCode[0].Coding[0].Code == "87522002" &&
+			report.Issued.Equal(issued)
+	})).Return(nil)
+
+	reportJSON, _ := json.Marshal(sampleDiagnosticReport("report1", "obs1", "obs2"))
+	err := labChaincode.CreateDiagnosticReport(mockCtx, string(reportJSON))
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestCreateDiagnosticReport_RejectsResultOfAnotherPatient(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	mockStub.On("GetState", mockKey(mockStub, "Observation", "obs1")).Return([]byte(sampleObservationJSONWithPatient("obs1", "patient2")), nil)
+	mockStub.On("GetState", mockKey(mockStub, "DiagnosticReport", "report1")).Return(nil, nil)
+
+	reportJSON, _ := json.Marshal(sampleDiagnosticReport("report1", "obs1"))
+	err := labChaincode.CreateDiagnosticReport(mockCtx, string(reportJSON))
+
+	assert.EqualError(t, err, "result Observation/obs1 of the diagnostic report is about another patient")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestCreateDiagnosticReport_RejectsMissingResult(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	mockStub.On("GetState", mockKey(mockStub, "Observation", "obs9")).Return(nil, nil)
+	mockStub.On("GetState", mockKey(mockStub, "DiagnosticReport", "report1")).Return(nil, nil)
+
+	reportJSON, _ := json.Marshal(sampleDiagnosticReport("report1", "obs9"))
+	err := labChaincode.CreateDiagnosticReport(mockCtx, string(reportJSON))
+
+	assert.EqualError(t, err, "result Observation/obs9 of the diagnostic report does not exist")
+}
+
+func TestGetDiagnosticReportWithResults(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	mockStoredDiagnosticReport(mockStub, sampleDiagnosticReport("report1", "obs2", "obs1"))
+	mockStub.On("GetState", mockKey(mockStub, "Observation", "obs1")).Return([]byte(sampleObservationJSONWithPatient("obs1", "patient1")), nil)
+	mockStub.On("GetState", mockKey(mockStub, "Observation", "obs2")).Return([]byte(sampleObservationJSONWithPatient("obs2", "patient1")), nil)
+	mockDoctorCaller(mockCtx, "doctor1")
+
+	report, err := labChaincode.GetDiagnosticReportWithResults(mockCtx, "report1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Iron deficiency anaemia", report.Report.Conclusion)
+	assert.Len(t, report.Results, 2)
+	assert.Equal(t, "obs2", report.Results[0].ID, "results keep the order of the report")
+}
+
+func TestGetDiagnosticReport_RejectsTechnicianOfAnotherLaboratory(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	mockStoredDiagnosticReport(mockStub, sampleDiagnosticReport("report1", "obs1"))
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiSDNMSP)
+
+	report, err := labChaincode.GetDiagnosticReport(mockCtx, "report1")
+
+	assert.EqualError(t, err, "diagnostic report report1 belongs to another laboratory")
+	assert.Nil(t, report)
+}
+
+func TestUpdateDiagnosticReport_OnlyByTheIssuingLaboratory(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiSDNMSP)
+
+	mockStoredDiagnosticReport(mockStub, sampleDiagnosticReport("report1", "obs1"))
+
+	reportJSON, _ := json.Marshal(sampleDiagnosticReport("report1"))
+	err := labChaincode.UpdateDiagnosticReport(mockCtx, "report1", string(reportJSON))
+
+	assert.EqualError(t, err, "diagnostic report report1 was issued by another laboratory")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestUpdateDiagnosticReport_SetsIssued(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	stored := sampleDiagnosticReport("report1", "obs1")
+	stored.Issued = time.Date(2024, 5, 2, 9, 30, 0, 0, time.UTC)
+	reportKey := mockStoredDiagnosticReport(mockStub, stored)
+	mockStub.On("GetState", mockKey(mockStub, "Observation", "obs1")).Return([]byte(sampleObservationJSONWithPatient("obs1", "patient1")), nil)
+	reissued := time.Date(2024, 5, 3, 11, 0, 0, 0, time.UTC)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: reissued.Unix()}, nil)
+	mockStub.On("PutState", reportKey, mock.MatchedBy(func(value []byte) bool {
+		var report fhir.DiagnosticReport
+		return json.Unmarshal(value, &report) == nil && report.Status == "amended" && report.Issued.Equal(reissued)
+	})).Return(nil)
+
+	// The issued time the laboratory submits is replaced by the time of the update
+	report := sampleDiagnosticReport("report1", "obs1")
+	report.Status = "amended"
+	report.Issued = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	reportJSON, _ := json.Marshal(report)
+	err := labChaincode.UpdateDiagnosticReport(mockCtx, "report1", string(reportJSON))
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestUpdateDiagnosticReport_RejectsAnotherPatient(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	mockStoredDiagnosticReport(mockStub, sampleDiagnosticReport("report1"))
+
+	report := sampleDiagnosticReport("report1")
+	report.Subject = &fhir.Reference{Reference: "Patient/patient2"}
+	reportJSON, _ := json.Marshal(report)
+	err := labChaincode.UpdateDiagnosticReport(mockCtx, "report1", string(reportJSON))
+
+	assert.EqualError(t, err, "the patient of a diagnostic report cannot change")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestDeleteDiagnosticReport(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	reportKey := mockStoredDiagnosticReport(mockStub, sampleDiagnosticReport("report1", "obs1"))
+	mockStub.On("DelState", reportKey).Return(nil)
+
+	err := labChaincode.DeleteDiagnosticReport(mockCtx, "report1")
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+// hba1cTrendQuery is the trend query for the HbA1c results of patient1 in 2024
+const hba1cTrendQuery = `{"selector":{"code.coding":{"$elemMatch":{"code":"4548-4","system":"http://loinc.org"}},` +
+	`"effectivePeriod.start":{"$gte":"2024-01-01T00:00:00Z","$lte":"2024-12-31T23:59:59Z"},"status":{"$in":["final","amended","corrected"]},` +
+	`"subject.reference":"Patient/patient1"},"sort":[{"subject.reference":"asc"},{"effectivePeriod.start":"asc"}],` +
+	`"use_index":["indexByPatientAndEffectiveTime","indexByPatientAndEffectiveTime"]}`
+
+// hba1cIterator returns an iterator over HbA1c results of patient1 with the given values, a month apart
+func hba1cIterator(units []string, values ...float64) *MockIterator {
+	iterator := &MockIterator{}
+	for i, value := range values {
+		labResult := fhir.Observation{
+			ID:              "obs" + strconv.Itoa(i+1),
+			Status:          StatusFinal,
+			Code:            loinc("4548-4"),
+			Subject:         &fhir.Reference{Reference: "Patient/patient1"},
+			EffectivePeriod: &fhir.Period{Start: time.Date(2024, time.Month(i+1), 1, 8, 0, 0, 0, time.UTC)},
+			ValueQuantity:   &fhir.Quantity{Value: value, Unit: units[i], Code: units[i]},
+		}
+		labResultJSON, _ := json.Marshal(labResult)
+		iterator.AddRecord("\x00Observation\x00"+labResult.ID+"\x00", labResultJSON)
+	}
+	return iterator
+}
+
+func TestQueryLabResultTrend(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	metadata := &peer.QueryResponseMetadata{Bookmark: "next", FetchedRecordsCount: 2}
+	mockStub.On("GetQueryResultWithPagination", hba1cTrendQuery, int32(2), "").Return(hba1cIterator([]string{"%", "%"}, 7.1, 6.8), metadata, nil)
+
+	start := time.Date(2024, 1, 1, 1, 0, 0, 0, time.FixedZone("CET", 3600))
+	end := time.Date(2024, 12, 31, 23, 59, 59, 500, time.UTC)
+	page, err := labChaincode.QueryLabResultTrend(mockCtx, "patient1", "4548-4", start, end, 2, "", false)
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Results, 2)
+	assert.Equal(t, "next", page.Bookmark)
+	assert.Nil(t, page.Summary)
+	mockStub.AssertNotCalled(t, "GetQueryResult", mock.Anything)
+}
+
+func TestQueryLabResultTrend_Summary(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	units := []string{"%", "mmol/mol", "%", "%"}
+	metadata := &peer.QueryResponseMetadata{Bookmark: "next", FetchedRecordsCount: 1}
+	mockStub.On("GetQueryResultWithPagination", hba1cTrendQuery, int32(1), "").Return(hba1cIterator(units[:1], 7.9), metadata, nil)
+	mockStub.On("GetQueryResult", hba1cTrendQuery).Return(hba1cIterator(units, 7.9, 48, 6.4, 6.9), nil)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC)
+	page, err := labChaincode.QueryLabResultTrend(mockCtx, "patient1", "4548-4", start, end, 1, "", true)
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Results, 1)
+	assert.Equal(t, 4, page.Summary.Count, "the summary covers the whole window, not the page")
+	assert.Equal(t, 6.9, page.Summary.Last.Value)
+	assert.Equal(t, 6.4, page.Summary.Min.Value)
+	assert.Equal(t, 7.9, page.Summary.Max.Value, "values in mmol/mol do not compare with those in %")
+}
+
+func TestQueryLabResultTrend_RejectsInvertedRange(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+
+	start := time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)
+	_, err := labChaincode.QueryLabResultTrend(mockCtx, "patient1", "4548-4", start, start.AddDate(0, -1, 0), 10, "", false)
+	assert.EqualError(t, err, "the end of the range is before its start")
+}
+
+func TestCreateLabResult_StoresEffectivePeriodInUTC(t *testing.T) {
+	labChaincode := new(LabResultsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockLabTechnicianCaller(mockCtx, auth.LaboratorioAnalisiCMOMSP)
+
+	var observation fhir.Observation
+	json.Unmarshal([]byte(sampleObservationJSON("obs1")), &observation)
+	observation.EffectivePeriod = &fhir.Period{Start: time.Date(2024, 5, 1, 9, 30, 0, 250, time.FixedZone("CEST", 7200))}
+	observationJSON, _ := json.Marshal(observation)
+
+	labResultKey := mockKey(mockStub, "Observation", "obs1")
+	mockStub.On("GetState", labResultKey).Return(nil, nil)
+	mockStub.On("PutState", labResultKey, mock.MatchedBy(func(value []byte) bool {
+		return strings.Contains(string(value), `"effectivePeriod":{"start":"2024-05-01T07:30:00Z"`)
+	})).Return(nil)
+
+	err := labChaincode.CreateLabResult(mockCtx, string(observationJSON))
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+	"github.com/xDaryamo/MedChain/ledger"
+	"github.com/xDaryamo/MedChain/query"
+)
+
+// Lab orders are stored under the composite key ServiceRequest~orderID
+const labOrderObjectType = "ServiceRequest"
+
+// Lab order statuses, from the http://hl7.org/fhir/task-status code system, which FHIR uses
+// to track how the performer handles a request
+const (
+	OrderRequested = "requested" // Placed by the clinician, awaiting the laboratory
+	OrderAccepted  = "accepted"  // The laboratory will perform the tests
+	OrderRejected  = "rejected"  // The laboratory will not perform the tests
+	OrderCompleted = "completed" // A released result fulfils the order
+)
+
+const orderStatusSystem = "http://hl7.org/fhir/task-status"
+
+// outstandingOrderStatuses are the statuses of the orders still waiting for a result
+var outstandingOrderStatuses = []string{OrderRequested, OrderAccepted}
+
+/*
+================================
+	LAB ORDER OPERATIONS
+================================
+*/
+
+// CreateLabOrder places a lab order from the calling practitioner to the laboratory named as
+// its performer. Results the laboratory posts for the order reference it through basedOn.
+func (t *LabResultsChaincode) CreateLabOrder(ctx contractapi.TransactionContextInterface, orderJSON string) error {
+	var order fhir.ServiceRequest
+	if err := json.Unmarshal([]byte(orderJSON), &order); err != nil {
+		return errors.New("failed to decode JSON")
+	}
+	if order.ID == nil || order.ID.Value == "" {
+		return errors.New("lab order ID is required")
+	}
+	if order.Subject == nil || order.Subject.Reference == "" {
+		return errors.New("the patient of the lab order is required")
+	}
+	if order.Performer == nil || !isLaboratory(order.Performer.Reference) {
+		return errors.New("a lab order must be addressed to a laboratory")
+	}
+
+	existing, err := findLabOrder(ctx, order.ID.Value)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errors.New("the lab order already exists")
+	}
+
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	requester := "Practitioner/" + caller.UserID
+	if order.Requester != nil && order.Requester.Reference != requester {
+		return errors.New("practitioners may only place lab orders in their own name")
+	}
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+
+	order.Requester = &fhir.Reference{Reference: requester}
+	order.AuthoredOn = timestamp.AsTime()
+	if order.Intent == nil {
+		order.Intent = &fhir.Code{Coding: []fhir.Coding{{System: "http://hl7.org/fhir/request-intent", Code: "order"}}}
+	}
+	setOrderStatus(&order, OrderRequested)
+	return putLabOrder(ctx, &order)
+}
+
+// GetLabOrder returns a lab order. Laboratories may only read the lab orders addressed to them.
+func (t *LabResultsChaincode) GetLabOrder(ctx contractapi.TransactionContextInterface, orderID string) (*fhir.ServiceRequest, error) {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if caller.HasRole(auth.RoleLabTechnician) {
+		return t.performerOrder(ctx, orderID)
+	}
+	return getLabOrder(ctx, orderID)
+}
+
+// AcceptLabOrder records that the calling laboratory will perform a lab order addressed to it
+func (t *LabResultsChaincode) AcceptLabOrder(ctx contractapi.TransactionContextInterface, orderID string) error {
+	order, err := t.performerOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if status := orderStatusOf(order); status != OrderRequested {
+		return errors.New("only requested lab orders can be accepted; lab order " + orderID + " is " + status)
+	}
+	setOrderStatus(order, OrderAccepted)
+	return putLabOrder(ctx, order)
+}
+
+// RejectLabOrder records that the calling laboratory will not perform a lab order addressed to
+// it, adding the reason to its notes
+func (t *LabResultsChaincode) RejectLabOrder(ctx contractapi.TransactionContextInterface, orderID string, reason string) error {
+	if strings.TrimSpace(reason) == "" {
+		return errors.New("a reason is required to reject a lab order")
+	}
+	order, err := t.performerOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if status := orderStatusOf(order); status != OrderRequested && status != OrderAccepted {
+		return errors.New("only outstanding lab orders can be rejected; lab order " + orderID + " is " + status)
+	}
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+
+	setOrderStatus(order, OrderRejected)
+	order.Note = append(order.Note, fhir.Annotation{AuthorReference: order.Performer, Time: timestamp.AsTime(), Text: reason})
+	return putLabOrder(ctx, order)
+}
+
+// QueryLabOrdersByRequester returns the lab orders placed by the calling practitioner, newest
+// first: the outstanding ones, requested or accepted, or the fulfilled ones
+func (t *LabResultsChaincode) QueryLabOrdersByRequester(ctx contractapi.TransactionContextInterface, practitionerID string, fulfilled bool) ([]*fhir.ServiceRequest, error) {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if caller.UserID != practitionerID {
+		return nil, errors.New("practitioners may only list the lab orders they placed")
+	}
+	statuses := outstandingOrderStatuses
+	if fulfilled {
+		statuses = []string{OrderCompleted}
+	}
+	return queryLabOrders(ctx, query.Where("requester.reference", query.Eq("Practitioner/"+practitionerID)), "indexLabOrdersByRequester", statuses)
+}
+
+// QueryLabOrdersByPerformer returns the lab orders addressed to the caller's laboratory, newest
+// first, optionally only those with the given status
+func (t *LabResultsChaincode) QueryLabOrdersByPerformer(ctx contractapi.TransactionContextInterface, laboratoryID string, status string) ([]*fhir.ServiceRequest, error) {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	laboratory := "Organization/" + laboratoryID
+	if laboratory != auth.OrganizationReference(caller.MSPID) {
+		return nil, errors.New("laboratories may only list the lab orders addressed to them")
+	}
+	var statuses []string
+	if status != "" {
+		statuses = []string{status}
+	}
+	return queryLabOrders(ctx, query.Where("performer.reference", query.Eq(laboratory)), "indexLabOrdersByPerformer", statuses)
+}
+
+// performerOrder returns a lab order after checking that it is addressed to the caller's laboratory
+func (t *LabResultsChaincode) performerOrder(ctx contractapi.TransactionContextInterface, orderID string) (*fhir.ServiceRequest, error) {
+	order, err := getLabOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if order.Performer == nil || order.Performer.Reference != auth.OrganizationReference(caller.MSPID) {
+		return nil, errors.New("lab order " + orderID + " is addressed to another laboratory")
+	}
+	return order, nil
+}
+
+// fulfilOrders checks the lab orders a lab result is based on and completes them once the
+// result is released. A laboratory may only post results for the accepted orders addressed
+// to it, for the patient of the order.
+func fulfilOrders(ctx contractapi.TransactionContextInterface, labResult *fhir.Observation) error {
+	var caller *auth.Identity
+	for _, basedOn := range labResult.BasedOn {
+		orderID, isOrder := labOrderID(basedOn)
+		if !isOrder {
+			continue
+		}
+		order, err := getLabOrder(ctx, orderID)
+		if err != nil {
+			return err
+		}
+
+		if caller == nil {
+			if caller, err = auth.GetIdentity(ctx); err != nil {
+				return err
+			}
+		}
+		if order.Performer == nil || order.Performer.Reference != auth.OrganizationReference(caller.MSPID) {
+			return errors.New("lab order " + orderID + " is addressed to another laboratory")
+		}
+		if labResult.Subject == nil || auth.PatientID(labResult.Subject.Reference) != auth.PatientID(order.Subject.Reference) {
+			return errors.New("lab order " + orderID + " is for another patient")
+		}
+
+		status := orderStatusOf(order)
+		if status != OrderAccepted && status != OrderCompleted {
+			return errors.New("results can only be posted for accepted lab orders; lab order " + orderID + " is " + status)
+		}
+		if status == OrderAccepted && isReleased(labResult.Status) {
+			setOrderStatus(order, OrderCompleted)
+			if err := putLabOrder(ctx, order); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// labOrderID returns the ID of the lab order a reference points to, if it points to one
+func labOrderID(reference fhir.Reference) (string, bool) {
+	orderID := strings.TrimPrefix(reference.Reference, labOrderObjectType+"/")
+	return orderID, orderID != reference.Reference && orderID != ""
+}
+
+// isLaboratory reports whether reference points to one of the laboratories on the channel
+func isLaboratory(reference string) bool {
+	for _, mspID := range auth.LaboratoryMSPs {
+		if reference == auth.OrganizationReference(mspID) {
+			return true
+		}
+	}
+	return false
+}
+
+func orderStatusOf(order *fhir.ServiceRequest) string {
+	if order.Status == nil || len(order.Status.Coding) == 0 {
+		return ""
+	}
+	return order.Status.Coding[0].Code
+}
+
+func setOrderStatus(order *fhir.ServiceRequest, status string) {
+	order.Status = &fhir.Code{Coding: []fhir.Coding{{System: orderStatusSystem, Code: status, Display: status}}}
+}
+
+func getLabOrder(ctx contractapi.TransactionContextInterface, orderID string) (*fhir.ServiceRequest, error) {
+	order, err := findLabOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil {
+		return nil, errors.New("lab order " + orderID + " does not exist")
+	}
+	return order, nil
+}
+
+// findLabOrder returns a lab order, or nil when it does not exist
+func findLabOrder(ctx contractapi.TransactionContextInterface, orderID string) (*fhir.ServiceRequest, error) {
+	orderKey, err := ledger.Key(ctx, labOrderObjectType, orderID)
+	if err != nil {
+		return nil, err
+	}
+	orderJSON, err := ctx.GetStub().GetState(orderKey)
+	if err != nil {
+		return nil, errors.New("failed to get lab order: " + err.Error())
+	}
+	if orderJSON == nil {
+		return nil, nil
+	}
+	var order fhir.ServiceRequest
+	if err := json.Unmarshal(orderJSON, &order); err != nil {
+		return nil, errors.New("failed to unmarshal lab order: " + err.Error())
+	}
+	return &order, nil
+}
+
+func putLabOrder(ctx contractapi.TransactionContextInterface, order *fhir.ServiceRequest) error {
+	orderKey, err := ledger.Key(ctx, labOrderObjectType, order.ID.Value)
+	if err != nil {
+		return err
+	}
+	orderJSON, err := json.Marshal(order)
+	if err != nil {
+		return errors.New("failed to marshal lab order: " + err.Error())
+	}
+	if err := ctx.GetStub().PutState(orderKey, orderJSON); err != nil {
+		return errors.New("failed to put state: " + err.Error())
+	}
+	return nil
+}
+
+// queryLabOrders runs a CouchDB query for the lab orders matching selector with one of statuses,
+// or with any status when there are none, and returns them newest first. index answers the query.
+func queryLabOrders(ctx contractapi.TransactionContextInterface, selector *query.Selector, index string, statuses []string) ([]*fhir.ServiceRequest, error) {
+	// Lab results and critical alerts share the namespace; only orders have an intent
+	selector.And("intent", query.Exists(true))
+	if len(statuses) > 0 {
+		selector.And("status.coding", query.ElemMatch(query.Where("code", query.In(statuses...))))
+	}
+
+	queryJSON, err := query.New(selector).UseIndex(index, index).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryJSON)
+	if err != nil {
+		return nil, errors.New("failed to query lab orders: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	orders := []*fhir.ServiceRequest{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, errors.New("failed to iterate lab orders: " + err.Error())
+		}
+		var order fhir.ServiceRequest
+		if err := json.Unmarshal(queryResponse.Value, &order); err != nil {
+			return nil, errors.New("failed to unmarshal lab order: " + err.Error())
+		}
+		orders = append(orders, &order)
+	}
+
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].AuthoredOn.After(orders[j].AuthoredOn)
+	})
+	return orders, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import "github.com/xDaryamo/MedChain/auth"
+
+var clinicalRoles = []string{auth.RoleDoctor, auth.RoleNurse}
+
+// labResultsPolicies declares who may call each LabResultsChaincode transaction.
+// Only laboratory technicians write results; clinicians need the patient's consent to read them.
+var labResultsPolicies = auth.Policies{
+	"CreateLabResult":                               {MSPs: auth.LaboratoryMSPs, Roles: []string{auth.RoleLabTechnician}, Subject: auth.PayloadSubject(0)},
+	"UpdateLabResult":                               {MSPs: auth.LaboratoryMSPs, Roles: []string{auth.RoleLabTechnician}, Subject: auth.StoredSubject("Observation", 0)},
+	"GetLabResult":                                  {Roles: auth.Join(clinicalRoles, []string{auth.RoleLabTechnician, auth.RolePatient}), Subject: auth.StoredSubject("Observation", 0), ConsentRoles: clinicalRoles, Resource: "Observation", Action: auth.ActionRead},
+	"GetLabResultHistory":                           {Roles: auth.Join(clinicalRoles, []string{auth.RoleLabTechnician, auth.RolePatient}), Subject: auth.LastKnownSubject("Observation", 0), ConsentRoles: clinicalRoles, Resource: "Observation", Action: auth.ActionRead},
+	"GetLabResultVersions":                          {Roles: auth.Join(clinicalRoles, []string{auth.RoleLabTechnician, auth.RolePatient}), Subject: auth.StoredSubject("Observation", 0), ConsentRoles: clinicalRoles, Resource: "Observation", Action: auth.ActionRead},
+	"LabResultExists":                               {Roles: auth.Join(clinicalRoles, []string{auth.RoleLabTechnician})},
+	"AuditedGetLabResult":                           {Roles: auth.Join(clinicalRoles, []string{auth.RoleLabTechnician, auth.RolePatient}), Subject: auth.StoredSubject("Observation", 0), ConsentRoles: clinicalRoles, Resource: "Observation", Action: auth.ActionRead},
+	"GetAccessLog":                                  {Roles: []string{auth.RolePatient, auth.RoleCompliance}, Subject: auth.Arg(0)},
+	"QueryLabResults":                               {Roles: auth.Join(clinicalRoles, []string{auth.RolePatient}), Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "Observation", Action: auth.ActionRead},
+	"SetReferenceRange":                             {MSPs: auth.LaboratoryMSPs, Roles: []string{auth.RoleLabTechnician}},
+	"DeleteReferenceRange":                          {MSPs: auth.LaboratoryMSPs, Roles: []string{auth.RoleLabTechnician}},
+	"GetReferenceRanges":                            {Roles: auth.Join(clinicalRoles, []string{auth.RoleLabTechnician})},
+	"AcknowledgeCriticalResult":                     {Roles: []string{auth.RoleDoctor}, Subject: auth.StoredSubject("Observation", 0), ConsentRoles: clinicalRoles, Resource: "Observation", Action: auth.ActionRead},
+	"QueryUnacknowledgedCriticalResults":            {Roles: clinicalRoles, Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "Observation", Action: auth.ActionRead},
+	"QueryUnacknowledgedCriticalResultsByRequester": {Roles: []string{auth.RoleDoctor}},
+	"CreateLabOrder":                                {MSPs: auth.ClinicMSPs, Roles: []string{auth.RoleDoctor}, Subject: auth.PayloadSubject(0), ConsentRoles: []string{auth.RoleDoctor}, Resource: "ServiceRequest", Action: auth.ActionWrite},
+	"GetLabOrder":                                   {Roles: auth.Join(clinicalRoles, []string{auth.RoleLabTechnician, auth.RolePatient}), Subject: auth.StoredSubject("ServiceRequest", 0), ConsentRoles: clinicalRoles, Resource: "ServiceRequest", Action: auth.ActionRead},
+	"AcceptLabOrder":                                {MSPs: auth.LaboratoryMSPs, Roles: []string{auth.RoleLabTechnician}, Subject: auth.StoredSubject("ServiceRequest", 0)},
+	"RejectLabOrder":                                {MSPs: auth.LaboratoryMSPs, Roles: []string{auth.RoleLabTechnician}, Subject: auth.StoredSubject("ServiceRequest", 0)},
+	"QueryLabOrdersByRequester":                     {MSPs: auth.ClinicMSPs, Roles: []string{auth.RoleDoctor}},
+	"QueryLabOrdersByPerformer":                     {MSPs: auth.LaboratoryMSPs, Roles: []string{auth.RoleLabTechnician}},
+	"CreateDiagnosticReport":                        {MSPs: auth.LaboratoryMSPs, Roles: []string{auth.RoleLabTechnician}, Subject: auth.PayloadSubject(0)},
+	"GetDiagnosticReport":                           {Roles: auth.Join(clinicalRoles, []string{auth.RoleLabTechnician, auth.RolePatient}), Subject: auth.StoredSubject("DiagnosticReport", 0), ConsentRoles: clinicalRoles, Resource: "DiagnosticReport", Action: auth.ActionRead},
+	"GetDiagnosticReportWithResults":                {Roles: auth.Join(clinicalRoles, []string{auth.RoleLabTechnician, auth.RolePatient}), Subject: auth.StoredSubject("DiagnosticReport", 0), ConsentRoles: clinicalRoles, Resource: "Observation", Action: auth.ActionRead},
+	"UpdateDiagnosticReport":                        {MSPs: auth.LaboratoryMSPs, Roles: []string{auth.RoleLabTechnician}, Subject: auth.StoredSubject("DiagnosticReport", 0)},
+	"DeleteDiagnosticReport":                        {MSPs: auth.LaboratoryMSPs, Roles: []string{auth.RoleLabTechnician}, Subject: auth.StoredSubject("DiagnosticReport", 0)},
+	"QueryLabResultTrend":                           {Roles: auth.Join(clinicalRoles, []string{auth.RolePatient}), Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "Observation", Action: auth.ActionRead},
+	"MigrateKeys":                                   {Roles: []string{auth.RoleAdmin}},
+}
+
+var labResultsEnforcer = &auth.Enforcer{Policies: labResultsPolicies, Consent: auth.AnyConsent(auth.PatientConsent, auth.EmergencyConsent)}
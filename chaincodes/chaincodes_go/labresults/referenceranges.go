@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/fhir"
+)
+
+// Reference ranges are stored under the composite key ReferenceRange~laboratory~code~rangeID,
+// laboratory being the FHIR reference of the laboratory that set them
+const referenceRangeObjectType = "ReferenceRange"
+
+// Sexes a reference range may apply to, from the http://hl7.org/fhir/administrative-gender code system
+const (
+	SexMale   = "male"
+	SexFemale = "female"
+)
+
+// ReferenceRange is the normal range of a lab test, as set by one laboratory, for patients of
+// one sex and age band. A result in a unit other than that of the limits is not interpreted
+// against the range.
+type ReferenceRange struct {
+	ID          string      `json:"id"`                    // Unique identifier of the range among those of its test
+	Laboratory  string      `json:"laboratory"`            // Reference of the laboratory that set the range, such as Organization/LaboratorioAnalisiCMO
+	Code        string      `json:"code"`                  // LOINC code of the test
+	Sex         string      `json:"sex,omitempty"`         // male or female; empty for both
+	MinAge      int         `json:"minAge,omitempty"`      // Youngest age the range applies to, in years
+	MaxAge      int         `json:"maxAge,omitempty"`      // Age from which the range no longer applies, in years; 0 for no limit
+	Normal      *fhir.Range `json:"normal,omitempty"`      // Lowest and highest normal values, with their UCUM unit code
+	Critical    *fhir.Range `json:"critical,omitempty"`    // Values below its low or above its high limit are critical
+	NormalCodes []string    `json:"normalCodes,omitempty"` // Codes of the coded results considered normal, such as negative
+}
+
+/*
+================================
+	REFERENCE RANGE OPERATIONS
+================================
+*/
+
+// SetReferenceRange stores a reference range of the caller's laboratory, replacing the one of
+// the same test with the same ID
+func (t *LabResultsChaincode) SetReferenceRange(ctx contractapi.TransactionContextInterface, referenceRangeJSON string) error {
+	var referenceRange ReferenceRange
+	if err := json.Unmarshal([]byte(referenceRangeJSON), &referenceRange); err != nil {
+		return errors.New("failed to decode JSON")
+	}
+	if err := validateReferenceRange(&referenceRange); err != nil {
+		return err
+	}
+	laboratory, err := callerLaboratory(ctx)
+	if err != nil {
+		return err
+	}
+	if referenceRange.Laboratory == "" {
+		referenceRange.Laboratory = laboratory
+	} else if referenceRange.Laboratory != laboratory {
+		return errors.New("laboratories may only set their own reference ranges")
+	}
+
+	rangeKey, err := ctx.GetStub().CreateCompositeKey(referenceRangeObjectType, []string{laboratory, referenceRange.Code, referenceRange.ID})
+	if err != nil {
+		return errors.New("failed to create reference range key: " + err.Error())
+	}
+	rangeJSON, err := json.Marshal(referenceRange)
+	if err != nil {
+		return errors.New("failed to marshal reference range: " + err.Error())
+	}
+	if err := ctx.GetStub().PutState(rangeKey, rangeJSON); err != nil {
+		return errors.New("failed to put state: " + err.Error())
+	}
+	return nil
+}
+
+// DeleteReferenceRange removes a reference range the caller's laboratory set for a test
+func (t *LabResultsChaincode) DeleteReferenceRange(ctx contractapi.TransactionContextInterface, code string, rangeID string) error {
+	laboratory, err := callerLaboratory(ctx)
+	if err != nil {
+		return err
+	}
+	rangeKey, err := ctx.GetStub().CreateCompositeKey(referenceRangeObjectType, []string{laboratory, code, rangeID})
+	if err != nil {
+		return errors.New("failed to create reference range key: " + err.Error())
+	}
+	rangeJSON, err := ctx.GetStub().GetState(rangeKey)
+	if err != nil {
+		return errors.New("failed to read from world state")
+	}
+	if rangeJSON == nil {
+		return errors.New("reference range " + rangeID + " of test " + code + " does not exist")
+	}
+	return ctx.GetStub().DelState(rangeKey)
+}
+
+// GetReferenceRanges returns the reference ranges a laboratory set for a test, by ID
+func (t *LabResultsChaincode) GetReferenceRanges(ctx contractapi.TransactionContextInterface, laboratoryID string, code string) ([]*ReferenceRange, error) {
+	return referenceRangesOf(ctx, "Organization/"+laboratoryID, code)
+}
+
+func referenceRangesOf(ctx contractapi.TransactionContextInterface, laboratory string, code string) ([]*ReferenceRange, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(referenceRangeObjectType, []string{laboratory, code})
+	if err != nil {
+		return nil, errors.New("failed to get reference ranges: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var ranges []*ReferenceRange
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, errors.New("failed to iterate reference ranges: " + err.Error())
+		}
+
+		var referenceRange ReferenceRange
+		if err := json.Unmarshal(queryResponse.Value, &referenceRange); err != nil {
+			return nil, errors.New("failed to unmarshal reference range: " + err.Error())
+		}
+		ranges = append(ranges, &referenceRange)
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].ID < ranges[j].ID
+	})
+	return ranges, nil
+}
+
+// validateReferenceRange checks that a reference range can be applied
+func validateReferenceRange(referenceRange *ReferenceRange) error {
+	if referenceRange.ID == "" || referenceRange.Code == "" {
+		return errors.New("a reference range needs an ID and a test code")
+	}
+	if referenceRange.Sex != "" && referenceRange.Sex != SexMale && referenceRange.Sex != SexFemale {
+		return errors.New("a reference range applies to male or female patients, or to both")
+	}
+	if referenceRange.MinAge < 0 || referenceRange.MaxAge < 0 || (referenceRange.MaxAge > 0 && referenceRange.MaxAge <= referenceRange.MinAge) {
+		return errors.New("the age band of a reference range must be a non-empty band of non-negative ages")
+	}
+	limits := referenceRange.limits()
+	if len(limits) == 0 && len(referenceRange.NormalCodes) == 0 {
+		return errors.New("a reference range needs limits or normal codes")
+	}
+	for i, limit := range limits {
+		if i == 0 {
+			continue
+		}
+		if limit.Code != limits[0].Code {
+			return errors.New("the limits of a reference range must share one unit")
+		}
+		if limit.Value < limits[i-1].Value {
+			return errors.New("the limits of a reference range must not decrease from critical low to low, high and critical high")
+		}
+	}
+	return nil
+}
+
+// limits returns the limits a reference range sets, from critical low to low, high and critical high
+func (r *ReferenceRange) limits() []*fhir.Quantity {
+	var limits []*fhir.Quantity
+	for _, limit := range []*fhir.Quantity{r.criticalLow(), r.low(), r.high(), r.criticalHigh()} {
+		if limit != nil {
+			limits = append(limits, limit)
+		}
+	}
+	return limits
+}
+
+func (r *ReferenceRange) low() *fhir.Quantity {
+	if r.Normal == nil {
+		return nil
+	}
+	return r.Normal.Low
+}
+
+func (r *ReferenceRange) high() *fhir.Quantity {
+	if r.Normal == nil {
+		return nil
+	}
+	return r.Normal.High
+}
+
+func (r *ReferenceRange) criticalLow() *fhir.Quantity {
+	if r.Critical == nil {
+		return nil
+	}
+	return r.Critical.Low
+}
+
+func (r *ReferenceRange) criticalHigh() *fhir.Quantity {
+	if r.Critical == nil {
+		return nil
+	}
+	return r.Critical.High
+}
+
+// appliesTo reports whether a reference range applies to a patient of sex and age. Sex is
+// empty when the patient's sex is unknown and ageKnown false when their age is, so that only
+// ranges independent of them apply.
+func (r *ReferenceRange) appliesTo(sex string, age int, ageKnown bool) bool {
+	if r.Sex != "" && r.Sex != sex {
+		return false
+	}
+	if r.MinAge == 0 && r.MaxAge == 0 {
+		return true
+	}
+	return ageKnown && age >= r.MinAge && (r.MaxAge == 0 || age < r.MaxAge)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/fhir"
+)
+
+// Lab result statuses, from the http://hl7.org/fhir/observation-status code system
+const (
+	StatusRegistered  = "registered"  // The test was ordered; no result is available yet
+	StatusPreliminary = "preliminary" // An early result, which may change before it is final
+	StatusFinal       = "final"       // The result is complete and released
+	StatusAmended     = "amended"     // A final result was changed after its release
+	StatusCorrected   = "corrected"   // A final result was changed to fix an error
+	StatusCancelled   = "cancelled"   // The result is unavailable and will not be produced
+)
+
+// statusTransitions lists the statuses each status may move to. Cancelled results are final.
+var statusTransitions = map[string][]string{
+	StatusRegistered:  {StatusRegistered, StatusPreliminary, StatusFinal, StatusCancelled},
+	StatusPreliminary: {StatusPreliminary, StatusFinal, StatusCancelled},
+	StatusFinal:       {StatusAmended, StatusCorrected, StatusCancelled},
+	StatusAmended:     {StatusAmended, StatusCorrected, StatusCancelled},
+	StatusCorrected:   {StatusAmended, StatusCorrected, StatusCancelled},
+	StatusCancelled:   {},
+}
+
+// initialStatuses are the statuses a lab result may be created with
+var initialStatuses = []string{StatusRegistered, StatusPreliminary, StatusFinal}
+
+// Versions of a lab result replaced after its release are stored under the composite key
+// ObservationVersion~labResultID~version and are never updated or deleted
+const labResultVersionObjectType = "ObservationVersion"
+
+// LabResultVersion is a released version of a lab result
+type LabResultVersion struct {
+	Version      int               `json:"version"`                // 1 for the version first replaced, increasing with each change
+	Observation  *fhir.Observation `json:"observation"`            // The lab result as it was
+	Superseded   bool              `json:"superseded"`             // Whether a later amendment, correction or cancellation replaced it
+	SupersededAt time.Time         `json:"supersededAt,omitempty"` // When it was replaced
+}
+
+// GetLabResultVersions returns the released versions of a lab result, oldest first, ending
+// with the current one. Results never changed after their release have only the current one.
+func (t *LabResultsChaincode) GetLabResultVersions(ctx contractapi.TransactionContextInterface, labResultID string) ([]*LabResultVersion, error) {
+	current, err := t.getLabResult(ctx, labResultID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPerformerRead(ctx, "lab result "+labResultID, current.Performer); err != nil {
+		return nil, err
+	}
+	versions, err := supersededVersions(ctx, labResultID)
+	if err != nil {
+		return nil, err
+	}
+	return append(versions, &LabResultVersion{Version: len(versions) + 1, Observation: current}), nil
+}
+
+// supersede keeps the version of a lab result about to be replaced
+func supersede(ctx contractapi.TransactionContextInterface, labResultID string, previous *fhir.Observation) error {
+	versions, err := supersededVersions(ctx, labResultID)
+	if err != nil {
+		return err
+	}
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+
+	version := LabResultVersion{
+		Version:      len(versions) + 1,
+		Observation:  previous,
+		Superseded:   true,
+		SupersededAt: timestamp.AsTime(),
+	}
+	versionKey, err := ctx.GetStub().CreateCompositeKey(labResultVersionObjectType, []string{labResultID, strconv.Itoa(version.Version)})
+	if err != nil {
+		return errors.New("failed to create lab result version key: " + err.Error())
+	}
+	versionJSON, err := json.Marshal(version)
+	if err != nil {
+		return errors.New("failed to marshal lab result version: " + err.Error())
+	}
+	if err := ctx.GetStub().PutState(versionKey, versionJSON); err != nil {
+		return errors.New("failed to put state: " + err.Error())
+	}
+	return nil
+}
+
+// supersededVersions returns the replaced versions of a lab result, oldest first
+func supersededVersions(ctx contractapi.TransactionContextInterface, labResultID string) ([]*LabResultVersion, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(labResultVersionObjectType, []string{labResultID})
+	if err != nil {
+		return nil, errors.New("failed to get lab result versions: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var versions []*LabResultVersion
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, errors.New("failed to iterate lab result versions: " + err.Error())
+		}
+
+		var version LabResultVersion
+		if err := json.Unmarshal(queryResponse.Value, &version); err != nil {
+			return nil, errors.New("failed to unmarshal lab result version: " + err.Error())
+		}
+		versions = append(versions, &version)
+	}
+
+	// Keys sort by version number as text, so 10 would come before 2
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+// checkTransition checks that a lab result may move from one status to another
+func checkTransition(labResultID string, from string, to string) error {
+	if !isStatus(to) {
+		return errors.New("unknown lab result status " + describeStatus(to))
+	}
+	allowed, known := statusTransitions[from]
+	if !known {
+		// Results written before the workflow may carry any status; they join it anywhere
+		return nil
+	}
+	for _, status := range allowed {
+		if status == to {
+			return nil
+		}
+	}
+	return errors.New("cannot move lab result " + labResultID + " from " + describeStatus(from) + " to " + to)
+}
+
+// validateInitialStatus checks the status a lab result is created with
+func validateInitialStatus(labResult *fhir.Observation) error {
+	for _, allowed := range initialStatuses {
+		if labResult.Status == allowed {
+			return nil
+		}
+	}
+	return errors.New("a new lab result must be " + strings.Join(initialStatuses, ", ") + ", not " + describeStatus(labResult.Status))
+}
+
+// isReleased reports whether a lab result with status has been released to clinicians,
+// so that changing it must keep the version they may have seen
+func isReleased(status string) bool {
+	return status == StatusFinal || status == StatusAmended || status == StatusCorrected
+}
+
+// hasNewNote reports whether updated carries a note previous did not have
+func hasNewNote(previous *fhir.Observation, updated *fhir.Observation) bool {
+	known := make(map[string]bool, len(previous.Note))
+	for _, note := range previous.Note {
+		known[note.Text] = true
+	}
+	for _, note := range updated.Note {
+		if strings.TrimSpace(note.Text) != "" && !known[note.Text] {
+			return true
+		}
+	}
+	return false
+}
+
+func isStatus(status string) bool {
+	_, known := statusTransitions[status]
+	return known
+}
+
+func describeStatus(status string) string {
+	if status == "" {
+		return "no status"
+	}
+	return status
+}
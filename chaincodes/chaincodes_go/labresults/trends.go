@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+	"github.com/xDaryamo/MedChain/query"
+)
+
+// LabResultTrendPage is one page of the results of a test for a patient, oldest first
+type LabResultTrendPage struct {
+	Results  []*fhir.Observation    `json:"results"`           // The results on this page
+	Bookmark string                 `json:"bookmark"`          // Pass to the same query to get the next page
+	Count    int32                  `json:"count"`             // How many results are on this page
+	Summary  *LabResultTrendSummary `json:"summary,omitempty"` // Summary of the whole window, when requested
+}
+
+// LabResultTrendSummary summarizes the results of a test in a time window. Min and Max only
+// consider the values in the unit of the last one, since values in other units do not compare.
+type LabResultTrendSummary struct {
+	Count int            `json:"count"`          // How many results are in the window
+	Min   *fhir.Quantity `json:"min,omitempty"`  // Lowest value
+	Max   *fhir.Quantity `json:"max,omitempty"`  // Highest value
+	Last  *fhir.Quantity `json:"last,omitempty"` // Most recent value
+}
+
+// trendStatuses are the statuses of the results a trend includes: those released to clinicians
+var trendStatuses = []string{StatusFinal, StatusAmended, StatusCorrected}
+
+/*
+================================
+	TREND OPERATIONS
+================================
+*/
+
+// QueryLabResultTrend returns a page of the released results of the test with a LOINC code for
+// a patient whose effective period starts between start and end, inclusive, sorted by that
+// time. With summary set, the page also summarizes every result in the window.
+func (t *LabResultsChaincode) QueryLabResultTrend(ctx contractapi.TransactionContextInterface, patientID string, loincCode string, start time.Time, end time.Time, pageSize int32, bookmark string, summary bool) (*LabResultTrendPage, error) {
+	if end.Before(start) {
+		return nil, errors.New("the end of the range is before its start")
+	}
+	if pageSize <= 0 {
+		return nil, errors.New("the page size must be positive")
+	}
+
+	// effectivePeriod.start is stored in UTC to the second, so the stored strings sort like the
+	// times they denote and whole-second bounds select exactly the results in the window
+	selector := query.Where("subject.reference", query.Eq("Patient/"+auth.PatientID(patientID))).
+		And("effectivePeriod.start",
+			query.Gte(ceilSecond(start).Format(time.RFC3339)),
+			query.Lte(end.UTC().Truncate(time.Second).Format(time.RFC3339))).
+		And("code.coding", query.ElemMatch(query.Where("system", query.Eq(loincSystem)).And("code", query.Eq(loincCode)))).
+		And("status", query.In(trendStatuses...))
+	queryJSON, err := query.New(selector).
+		Sort("subject.reference", query.Ascending).
+		Sort("effectivePeriod.start", query.Ascending).
+		UseIndex("indexByPatientAndEffectiveTime", "indexByPatientAndEffectiveTime").
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(queryJSON, pageSize, bookmark)
+	if err != nil {
+		return nil, errors.New("failed to query lab results: " + err.Error())
+	}
+	defer iterator.Close()
+
+	page := &LabResultTrendPage{}
+	if page.Results, err = readLabResults(iterator); err != nil {
+		return nil, err
+	}
+	if metadata != nil {
+		page.Bookmark = metadata.Bookmark
+		page.Count = metadata.FetchedRecordsCount
+	}
+
+	if summary {
+		if page.Summary, err = summarizeTrend(ctx, queryJSON); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// summarizeTrend summarizes every result a trend query selects
+func summarizeTrend(ctx contractapi.TransactionContextInterface, queryJSON string) (*LabResultTrendSummary, error) {
+	iterator, err := ctx.GetStub().GetQueryResult(queryJSON)
+	if err != nil {
+		return nil, errors.New("failed to query lab results: " + err.Error())
+	}
+	defer iterator.Close()
+
+	results, err := readLabResults(iterator)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &LabResultTrendSummary{Count: len(results)}
+	for i := len(results) - 1; i >= 0 && summary.Last == nil; i-- {
+		summary.Last = results[i].ValueQuantity
+	}
+	if summary.Last == nil {
+		return summary, nil
+	}
+	for _, result := range results {
+		value := result.ValueQuantity
+		if value == nil || value.Code != summary.Last.Code || value.Unit != summary.Last.Unit {
+			continue
+		}
+		if summary.Min == nil || value.Value < summary.Min.Value {
+			summary.Min = value
+		}
+		if summary.Max == nil || value.Value > summary.Max.Value {
+			summary.Max = value
+		}
+	}
+	return summary, nil
+}
+
+func readLabResults(iterator shim.StateQueryIteratorInterface) ([]*fhir.Observation, error) {
+	results := []*fhir.Observation{}
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return nil, errors.New("failed to iterate lab results: " + err.Error())
+		}
+		var labResult fhir.Observation
+		if err := json.Unmarshal(queryResponse.Value, &labResult); err != nil {
+			return nil, errors.New("failed to unmarshal lab result: " + err.Error())
+		}
+		results = append(results, &labResult)
+	}
+	return results, nil
+}
+
+// normalizeEffectivePeriod stores the effective period of a lab result in UTC to the second,
+// so that trend queries can compare it as a string
+func normalizeEffectivePeriod(labResult *fhir.Observation) {
+	if labResult.EffectivePeriod == nil {
+		return
+	}
+	if !labResult.EffectivePeriod.Start.IsZero() {
+		labResult.EffectivePeriod.Start = labResult.EffectivePeriod.Start.UTC().Truncate(time.Second)
+	}
+	if !labResult.EffectivePeriod.End.IsZero() {
+		labResult.EffectivePeriod.End = labResult.EffectivePeriod.End.UTC().Truncate(time.Second)
+	}
+}
+
+// ceilSecond returns t in UTC, rounded up to the second
+func ceilSecond(t time.Time) time.Time {
+	truncated := t.UTC().Truncate(time.Second)
+	if truncated.Before(t) {
+		return truncated.Add(time.Second)
+	}
+	return truncated
+}
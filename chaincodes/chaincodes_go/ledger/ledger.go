@@ -0,0 +1,76 @@
+// Package ledger defines how the MedChain chaincodes lay out their world state.
+// Every resource is stored under the composite key objectType~id, so that the
+// resources of one type can be scanned without touching the others, and chaincodes
+// deployed before this layout move their bare keys over with MigrateKeys.
+package ledger
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Key returns the composite key objectType~id a resource is stored under
+func Key(ctx contractapi.TransactionContextInterface, objectType string, id string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, []string{id})
+	if err != nil {
+		return "", errors.New("failed to create " + objectType + " key: " + err.Error())
+	}
+	return key, nil
+}
+
+// Classifier tells which object type and ID the value stored under a legacy key
+// belongs to. It returns ok false for keys that must be left where they are.
+type Classifier func(key string, value []byte) (objectType string, id string, ok bool)
+
+// MigrateKeys moves up to limit values stored under bare, pre-composite keys to the
+// composite key their classifier assigns them, and returns how many it moved.
+// Migrated keys are deleted, so calling it again continues where the previous call
+// stopped; it returns 0 once nothing is left to migrate.
+func MigrateKeys(ctx contractapi.TransactionContextInterface, classify Classifier, limit int) (int, error) {
+	if limit <= 0 {
+		return 0, errors.New("migration limit must be positive")
+	}
+
+	// Range queries never return composite keys, so this only sees legacy keys
+	iterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, errors.New("failed to get legacy keys: " + err.Error())
+	}
+	defer iterator.Close()
+
+	migrated := 0
+	for migrated < limit && iterator.HasNext() {
+		legacy, err := iterator.Next()
+		if err != nil {
+			return migrated, errors.New("failed to iterate legacy keys: " + err.Error())
+		}
+
+		objectType, id, ok := classify(legacy.Key, legacy.Value)
+		if !ok {
+			continue
+		}
+
+		key, err := Key(ctx, objectType, id)
+		if err != nil {
+			return migrated, err
+		}
+		existing, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return migrated, errors.New("failed to read from world state: " + err.Error())
+		}
+		if existing != nil {
+			return migrated, errors.New("cannot migrate " + legacy.Key + ": " + objectType + " " + id + " already exists")
+		}
+
+		if err := ctx.GetStub().PutState(key, legacy.Value); err != nil {
+			return migrated, errors.New("failed to put state: " + err.Error())
+		}
+		if err := ctx.GetStub().DelState(legacy.Key); err != nil {
+			return migrated, errors.New("failed to delete legacy key: " + err.Error())
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
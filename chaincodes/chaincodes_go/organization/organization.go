@@ -7,8 +7,14 @@ import (
 	"strings"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/fhir"
+	"github.com/xDaryamo/MedChain/history"
+	"github.com/xDaryamo/MedChain/ledger"
 )
 
+// Organizations are stored under the composite key Organization~organizationID
+const organizationObjectType = "Organization"
+
 // OrganizationChaincode represents the contract for managing organizations on the blockchain
 type OrganizationChaincode struct {
 	contractapi.Contract
@@ -17,7 +23,7 @@ type OrganizationChaincode struct {
 // CreateOrganization creates a new organization
 func (oc *OrganizationChaincode) CreateOrganization(ctx contractapi.TransactionContextInterface, organizationID string, organizationJSON string) error {
 	// Deserialize JSON data into a Go data structure
-	var organization Organization
+	var organization fhir.Organization
 	if err := json.Unmarshal([]byte(organizationJSON), &organization); err != nil {
 		return err
 	}
@@ -36,13 +42,17 @@ func (oc *OrganizationChaincode) CreateOrganization(ctx contractapi.TransactionC
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(organizationID, organizationJSONBytes)
+	return oc.putOrganization(ctx, organizationID, organizationJSONBytes)
 }
 
 // GetOrganization retrieves an organization from the blockchain
-func (oc *OrganizationChaincode) GetOrganization(ctx contractapi.TransactionContextInterface, organizationID string) (*Organization, error) {
+func (oc *OrganizationChaincode) GetOrganization(ctx contractapi.TransactionContextInterface, organizationID string) (*fhir.Organization, error) {
 	// Retrieve the organization from the blockchain
-	organizationJSON, err := ctx.GetStub().GetState(organizationID)
+	organizationKey, err := ledger.Key(ctx, organizationObjectType, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	organizationJSON, err := ctx.GetStub().GetState(organizationKey)
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +61,7 @@ func (oc *OrganizationChaincode) GetOrganization(ctx contractapi.TransactionCont
 	}
 
 	// Deserialize the organization
-	var organization Organization
+	var organization fhir.Organization
 	err = json.Unmarshal(organizationJSON, &organization)
 	if err != nil {
 		return nil, err
@@ -60,6 +70,16 @@ func (oc *OrganizationChaincode) GetOrganization(ctx contractapi.TransactionCont
 	return &organization, nil
 }
 
+// GetOrganizationHistory returns every version of an organization as a FHIR history Bundle,
+// including deletions, with the transaction and identity that wrote each version
+func (oc *OrganizationChaincode) GetOrganizationHistory(ctx contractapi.TransactionContextInterface, organizationID string) (string, error) {
+	organizationKey, err := ledger.Key(ctx, organizationObjectType, organizationID)
+	if err != nil {
+		return "", err
+	}
+	return history.GetHistoryJSON(ctx, organizationKey, "Organization", organizationID)
+}
+
 // UpdateOrganization updates an existing organization
 func (oc *OrganizationChaincode) UpdateOrganization(ctx contractapi.TransactionContextInterface, organizationID string, updatedOrganizationJSON string) error {
 	// Retrieve the existing organization
@@ -72,7 +92,7 @@ func (oc *OrganizationChaincode) UpdateOrganization(ctx contractapi.TransactionC
 	}
 
 	// Deserialize the updated JSON data into a Go data structure
-	var updatedOrganization Organization
+	var updatedOrganization fhir.Organization
 	if err := json.Unmarshal([]byte(updatedOrganizationJSON), &updatedOrganization); err != nil {
 		return err
 	}
@@ -85,7 +105,7 @@ func (oc *OrganizationChaincode) UpdateOrganization(ctx contractapi.TransactionC
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(organizationID, updatedOrganizationJSONBytes)
+	return oc.putOrganization(ctx, organizationID, updatedOrganizationJSONBytes)
 }
 
 // DeleteOrganization removes an existing organization
@@ -100,15 +120,19 @@ func (oc *OrganizationChaincode) DeleteOrganization(ctx contractapi.TransactionC
 	}
 
 	// Remove the organization from the blockchain
-	return ctx.GetStub().DelState(organizationID)
+	organizationKey, err := ledger.Key(ctx, organizationObjectType, organizationID)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(organizationKey)
 }
 
 // SearchOrganizationsByType allows searching for organizations based on type
-func (oc *OrganizationChaincode) SearchOrganizationsByType(ctx contractapi.TransactionContextInterface, query string) ([]*Organization, error) {
-	var results []*Organization
+func (oc *OrganizationChaincode) SearchOrganizationsByType(ctx contractapi.TransactionContextInterface, query string) ([]*fhir.Organization, error) {
+	var results []*fhir.Organization
 
 	// Retrieve all organizations stored on the blockchain
-	iterator, err := ctx.GetStub().GetStateByRange("", "")
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(organizationObjectType, []string{})
 	if err != nil {
 		return nil, err
 	}
@@ -120,14 +144,14 @@ func (oc *OrganizationChaincode) SearchOrganizationsByType(ctx contractapi.Trans
 		if err != nil {
 			return nil, err
 		}
-		var organization Organization
+		var organization fhir.Organization
 		err = json.Unmarshal(result.Value, &organization)
 		if err != nil {
 			return nil, err
 		}
 
 		// Check if the value of the organization's type matches the query
-		if strings.Contains(organization.Type.Text, query) {
+		if organization.Type != nil && strings.Contains(organization.Type.Text, query) {
 			results = append(results, &organization)
 		}
 	}
@@ -136,11 +160,11 @@ func (oc *OrganizationChaincode) SearchOrganizationsByType(ctx contractapi.Trans
 }
 
 // SearchOrganizationByName allows searching for an organization based on name
-func (oc *OrganizationChaincode) SearchOrganizationByName(ctx contractapi.TransactionContextInterface, query string) (*Organization, error) {
-	var result *Organization
+func (oc *OrganizationChaincode) SearchOrganizationByName(ctx contractapi.TransactionContextInterface, query string) (*fhir.Organization, error) {
+	var result *fhir.Organization
 
 	// Retrieve all organizations stored on the blockchain
-	iterator, err := ctx.GetStub().GetStateByRange("", "")
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(organizationObjectType, []string{})
 	if err != nil {
 		return nil, err
 	}
@@ -152,7 +176,7 @@ func (oc *OrganizationChaincode) SearchOrganizationByName(ctx contractapi.Transa
 		if err != nil {
 			return nil, err
 		}
-		var organization Organization
+		var organization fhir.Organization
 		err = json.Unmarshal(record.Value, &organization)
 		if err != nil {
 			return nil, err
@@ -169,7 +193,7 @@ func (oc *OrganizationChaincode) SearchOrganizationByName(ctx contractapi.Transa
 }
 
 // AddEndpoint adds a technical endpoint to the organization
-func (oc *OrganizationChaincode) AddEndpoint(ctx contractapi.TransactionContextInterface, organizationID string, endpoint Reference) error {
+func (oc *OrganizationChaincode) AddEndpoint(ctx contractapi.TransactionContextInterface, organizationID string, endpoint fhir.Reference) error {
 	organization, err := oc.GetOrganization(ctx, organizationID)
 	if err != nil {
 		return err
@@ -186,11 +210,11 @@ func (oc *OrganizationChaincode) AddEndpoint(ctx contractapi.TransactionContextI
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(organizationID, organizationJSONBytes)
+	return oc.putOrganization(ctx, organizationID, organizationJSONBytes)
 }
 
 // AddQualification adds a qualification to the organization
-func (oc *OrganizationChaincode) AddQualification(ctx contractapi.TransactionContextInterface, organizationID string, qualification Qualification) error {
+func (oc *OrganizationChaincode) AddQualification(ctx contractapi.TransactionContextInterface, organizationID string, qualification fhir.Qualification) error {
 	organization, err := oc.GetOrganization(ctx, organizationID)
 	if err != nil {
 		return err
@@ -207,7 +231,7 @@ func (oc *OrganizationChaincode) AddQualification(ctx contractapi.TransactionCon
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(organizationID, organizationJSONBytes)
+	return oc.putOrganization(ctx, organizationID, organizationJSONBytes)
 }
 
 // RemoveEndpoint removes a technical endpoint from the organization
@@ -228,7 +252,7 @@ func (oc *OrganizationChaincode) RemoveEndpoint(ctx contractapi.TransactionConte
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(organizationID, organizationJSONBytes)
+	return oc.putOrganization(ctx, organizationID, organizationJSONBytes)
 }
 
 // RemoveQualification removes a qualification from the organization
@@ -252,11 +276,11 @@ func (oc *OrganizationChaincode) RemoveQualification(ctx contractapi.Transaction
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(organizationID, organizationJSONBytes)
+	return oc.putOrganization(ctx, organizationID, organizationJSONBytes)
 }
 
 // UpdateEndpoint updates a technical endpoint of the organization
-func (oc *OrganizationChaincode) UpdateEndpoint(ctx contractapi.TransactionContextInterface, organizationID string, updatedEndpoint Reference) error {
+func (oc *OrganizationChaincode) UpdateEndpoint(ctx contractapi.TransactionContextInterface, organizationID string, updatedEndpoint fhir.Reference) error {
 	organization, err := oc.GetOrganization(ctx, organizationID)
 	if err != nil {
 		return err
@@ -273,11 +297,11 @@ func (oc *OrganizationChaincode) UpdateEndpoint(ctx contractapi.TransactionConte
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(organizationID, organizationJSONBytes)
+	return oc.putOrganization(ctx, organizationID, organizationJSONBytes)
 }
 
 // UpdateContact updates contact details of the organization
-func (oc *OrganizationChaincode) UpdateContact(ctx contractapi.TransactionContextInterface, organizationID string, updatedContact ExtendedContactDetail) error {
+func (oc *OrganizationChaincode) UpdateContact(ctx contractapi.TransactionContextInterface, organizationID string, updatedContact fhir.ExtendedContactDetail) error {
 	organization, err := oc.GetOrganization(ctx, organizationID)
 	if err != nil {
 		return err
@@ -287,18 +311,18 @@ func (oc *OrganizationChaincode) UpdateContact(ctx contractapi.TransactionContex
 	}
 
 	// Updates the organization's contact with the new data
-	organization.Contact = updatedContact
+	organization.Contact = &updatedContact
 
 	// Serializes the updated organization and saves it on the blockchain
 	organizationJSONBytes, err := json.Marshal(organization)
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(organizationID, organizationJSONBytes)
+	return oc.putOrganization(ctx, organizationID, organizationJSONBytes)
 }
 
 // UpdateQualification updates a qualification of the organization
-func (oc *OrganizationChaincode) UpdateQualification(ctx contractapi.TransactionContextInterface, organizationID string, updatedQualification Qualification, qualificationIndex int) error {
+func (oc *OrganizationChaincode) UpdateQualification(ctx contractapi.TransactionContextInterface, organizationID string, updatedQualification fhir.Qualification, qualificationIndex int) error {
 	organization, err := oc.GetOrganization(ctx, organizationID)
 	if err != nil {
 		return err
@@ -318,11 +342,11 @@ func (oc *OrganizationChaincode) UpdateQualification(ctx contractapi.Transaction
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(organizationID, organizationJSONBytes)
+	return oc.putOrganization(ctx, organizationID, organizationJSONBytes)
 }
 
 // GetParentOrganization retrieves the parent organization of the current organization, if any.
-func (oc *OrganizationChaincode) GetParentOrganization(ctx contractapi.TransactionContextInterface, organizationID string) (*Reference, error) {
+func (oc *OrganizationChaincode) GetParentOrganization(ctx contractapi.TransactionContextInterface, organizationID string) (*fhir.Reference, error) {
 	// Retrieve the organization from the blockchain
 	organization, err := oc.GetOrganization(ctx, organizationID)
 	if err != nil {
@@ -337,7 +361,7 @@ func (oc *OrganizationChaincode) GetParentOrganization(ctx contractapi.Transacti
 }
 
 // UpdateParentOrganization updates the parent organization of the current organization.
-func (oc *OrganizationChaincode) UpdateParentOrganization(ctx contractapi.TransactionContextInterface, organizationID string, parentOrganization Reference) error {
+func (oc *OrganizationChaincode) UpdateParentOrganization(ctx contractapi.TransactionContextInterface, organizationID string, parentOrganization fhir.Reference) error {
 	// Retrieve the organization from the blockchain
 	organization, err := oc.GetOrganization(ctx, organizationID)
 	if err != nil {
@@ -355,11 +379,32 @@ func (oc *OrganizationChaincode) UpdateParentOrganization(ctx contractapi.Transa
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(organizationID, organizationJSONBytes)
+	return oc.putOrganization(ctx, organizationID, organizationJSONBytes)
+}
+
+// MigrateKeys moves up to limit organizations stored under their bare ID, as written before
+// organizations were namespaced, to their Organization~organizationID key. It returns how
+// many it moved; call it until it returns 0.
+func (oc *OrganizationChaincode) MigrateKeys(ctx contractapi.TransactionContextInterface, limit int) (int, error) {
+	return ledger.MigrateKeys(ctx, func(key string, value []byte) (string, string, bool) {
+		return organizationObjectType, key, true
+	}, limit)
+}
+
+func (oc *OrganizationChaincode) putOrganization(ctx contractapi.TransactionContextInterface, organizationID string, organizationJSON []byte) error {
+	organizationKey, err := ledger.Key(ctx, organizationObjectType, organizationID)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(organizationKey, organizationJSON)
 }
 
 func main() {
-	chaincode, err := contractapi.NewChaincode(new(OrganizationChaincode))
+	organizationChaincode := new(OrganizationChaincode)
+	organizationChaincode.BeforeTransaction = organizationEnforcer.BeforeTransaction
+	organizationChaincode.AfterTransaction = history.RecordSubmitter
+
+	chaincode, err := contractapi.NewChaincode(organizationChaincode)
 	if err != nil {
 		log.Panic(errors.New("error creating organization chaincode: " + err.Error()))
 	}
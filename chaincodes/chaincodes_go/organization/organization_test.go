@@ -8,11 +8,14 @@ import (
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
 )
 
 // MockStub is a mock implementation of the ChaincodeStubInterface
@@ -278,6 +281,13 @@ func (m *MockIterator) Close() error {
 	return nil
 }
 
+// mockKey expects the composite key objectType~id to be created and returns it
+func mockKey(stub *MockStub, objectType string, id string) string {
+	key := "\x00" + objectType + "\x00" + id + "\x00"
+	stub.On("CreateCompositeKey", objectType, []string{id}).Return(key, nil)
+	return key
+}
+
 func TestCreateOrganization(t *testing.T) {
 	// Create a new instance of the organization chaincode
 	cc := new(OrganizationChaincode)
@@ -310,10 +320,11 @@ func TestCreateOrganization(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Mock GetState to return nil when called during the test
-	mockStub.On("GetState", mock.Anything).Return(nil, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID)
+	mockStub.On("GetState", organizationKey).Return(nil, nil)
 
 	// Mock PutState method to return nil (indicating success)
-	mockStub.On("PutState", mock.Anything, mock.Anything).Return(nil)
+	mockStub.On("PutState", organizationKey, mock.Anything).Return(nil)
 
 	// Call the CreateOrganization function with the mocked context and organization data
 	err := cc.CreateOrganization(mockCtx, organizationID, organizationJSON)
@@ -350,7 +361,8 @@ func TestCreateOrganization_OrganizationExists(t *testing.T) {
 
 	// Mock GetState to return existing organization
 	existingOrganizationJSON := []byte(organizationJSON)
-	mockStub.On("GetState", organizationID).Return(existingOrganizationJSON, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID)
+	mockStub.On("GetState", organizationKey).Return(existingOrganizationJSON, nil)
 
 	err := cc.CreateOrganization(mockCtx, organizationID, organizationJSON)
 	assert.Error(t, err)
@@ -384,7 +396,8 @@ func TestCreateOrganization_InvalidJSON(t *testing.T) {
 	// Mock GetState method to return nil, indicating organization does not exist
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", organizationID).Return(nil, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID)
+	mockStub.On("GetState", organizationKey).Return(nil, nil)
 
 	err := cc.CreateOrganization(mockCtx, organizationID, organizationJSON)
 	assert.Error(t, err)
@@ -418,7 +431,8 @@ func TestGetOrganization(t *testing.T) {
 	// Mock GetState method to return organization data
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", organizationID).Return(organizationBytes, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID)
+	mockStub.On("GetState", organizationKey).Return(organizationBytes, nil)
 
 	// Call GetOrganization
 	result, err := cc.GetOrganization(mockCtx, organizationID)
@@ -426,7 +440,7 @@ func TestGetOrganization(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, result)
 
-	var expectedOrganization Organization
+	var expectedOrganization fhir.Organization
 	err = json.Unmarshal(organizationBytes, &expectedOrganization)
 	assert.NoError(t, err)
 
@@ -443,7 +457,8 @@ func TestGetOrganization_OrganizationNotFound(t *testing.T) {
 	// Mock GetState method to return nil, indicating organization not found
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", organizationID).Return(nil, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID)
+	mockStub.On("GetState", organizationKey).Return(nil, nil)
 
 	// Call GetOrganization
 	result, err := cc.GetOrganization(mockCtx, organizationID)
@@ -457,16 +472,16 @@ func TestSearchOrganizationsByType(t *testing.T) {
 	mockCtx := new(MockTransactionContext)
 
 	// Mock organization data
-	organizationID := Identifier{System: "exampleSystem", Value: "org1"}
-	organization1 := &Organization{ID: organizationID, Name: "Hospital A", Type: CodeableConcept{Text: "Hospital"}}
+	organizationID := &fhir.Identifier{System: "exampleSystem", Value: "org1"}
+	organization1 := &fhir.Organization{ID: organizationID, Name: "Hospital A", Type: &fhir.CodeableConcept{Text: "Hospital"}}
 
-	organizationID2 := Identifier{System: "exampleSystem", Value: "org2"}
-	organization2 := Organization{ID: organizationID2, Name: "Clinic B", Type: CodeableConcept{Text: "Clinic"}}
+	organizationID2 := &fhir.Identifier{System: "exampleSystem", Value: "org2"}
+	organization2 := fhir.Organization{ID: organizationID2, Name: "Clinic B", Type: &fhir.CodeableConcept{Text: "Clinic"}}
 
 	organizationBytes1, _ := json.Marshal(&organization1)
 	organizationBytes2, _ := json.Marshal(&organization2)
 
-	// Mock GetStateByRange method to return organization data
+	// Mock GetStateByPartialCompositeKey method to return organization data
 	mockIterator := &MockIterator{}
 	mockIterator.AddRecord("org1", organizationBytes1)
 	mockIterator.AddRecord("org2", organizationBytes2)
@@ -474,7 +489,7 @@ func TestSearchOrganizationsByType(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetStateByRange", "", "").Return(mockIterator, nil)
+	mockStub.On("GetStateByPartialCompositeKey", "Organization", []string{}).Return(mockIterator, nil)
 
 	// Call SearchOrganizationsByType
 	results, err := cc.SearchOrganizationsByType(mockCtx, "Hospital")
@@ -491,16 +506,16 @@ func TestSearchOrganizationByName(t *testing.T) {
 
 	// Mock organization data
 
-	organizationID := Identifier{System: "exampleSystem", Value: "org1"}
-	organization1 := &Organization{ID: organizationID, Name: "Hospital A", Type: CodeableConcept{Text: "Hospital"}}
+	organizationID := &fhir.Identifier{System: "exampleSystem", Value: "org1"}
+	organization1 := &fhir.Organization{ID: organizationID, Name: "Hospital A", Type: &fhir.CodeableConcept{Text: "Hospital"}}
 
-	organizationID2 := Identifier{System: "exampleSystem", Value: "org2"}
-	organization2 := Organization{ID: organizationID2, Name: "Clinic B", Type: CodeableConcept{Text: "Clinic"}}
+	organizationID2 := &fhir.Identifier{System: "exampleSystem", Value: "org2"}
+	organization2 := fhir.Organization{ID: organizationID2, Name: "Clinic B", Type: &fhir.CodeableConcept{Text: "Clinic"}}
 
 	organizationBytes1, _ := json.Marshal(&organization1)
 	organizationBytes2, _ := json.Marshal(&organization2)
 
-	// Mock GetStateByRange method to return organization data
+	// Mock GetStateByPartialCompositeKey method to return organization data
 	mockIterator := &MockIterator{}
 	mockIterator.AddRecord("org1", organizationBytes1)
 	mockIterator.AddRecord("org2", organizationBytes2)
@@ -508,7 +523,7 @@ func TestSearchOrganizationByName(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetStateByRange", "", "").Return(mockIterator, nil)
+	mockStub.On("GetStateByPartialCompositeKey", "Organization", []string{}).Return(mockIterator, nil)
 
 	// Call SearchOrganizationByName
 	result, err := cc.SearchOrganizationByName(mockCtx, "Hospital A")
@@ -526,11 +541,11 @@ func TestSearchOrganizationByName_OrganizationNotFound(t *testing.T) {
 	// Mock organization name
 	organizationName := "Hospital A"
 
-	// Mock GetStateByRange method to return nil, indicating no organizations found
+	// Mock GetStateByPartialCompositeKey method to return nil, indicating no organizations found
 	mockIterator := new(MockIterator)
 	mockIterator.Records = []KVPair{}
 	mockStub := new(MockStub)
-	mockStub.On("GetStateByRange", "", "").Return(mockIterator, nil)
+	mockStub.On("GetStateByPartialCompositeKey", "Organization", []string{}).Return(mockIterator, nil)
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Call SearchOrganizationByName
@@ -545,21 +560,22 @@ func TestAddEndpoint(t *testing.T) {
 	mockCtx := new(MockTransactionContext)
 
 	// Mock organization data
-	organizationID := Identifier{System: "exampleSystem", Value: "org1"}
-	organization := &Organization{ID: organizationID, Name: "Hospital A"}
-	endpoint := Reference{Reference: "http://hospital-a.com/api"}
+	organizationID := &fhir.Identifier{System: "exampleSystem", Value: "org1"}
+	organization := &fhir.Organization{ID: organizationID, Name: "Hospital A"}
+	endpoint := fhir.Reference{Reference: "http://hospital-a.com/api"}
 
 	// Mock GetOrganization method to return existing organization
 	organizationBytes, _ := json.Marshal(organization)
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", organizationID.Value).Return(organizationBytes, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID.Value)
+	mockStub.On("GetState", organizationKey).Return(organizationBytes, nil)
 
 	// Mock PutState method to return success
 	updatedOrganization := *organization
 	updatedOrganization.EndPoint = &endpoint
 	updatedOrganizationBytes, _ := json.Marshal(&updatedOrganization)
-	mockStub.On("PutState", organizationID.Value, updatedOrganizationBytes).Return(nil)
+	mockStub.On("PutState", organizationKey, updatedOrganizationBytes).Return(nil)
 
 	err := cc.AddEndpoint(mockCtx, organizationID.Value, endpoint)
 	assert.NoError(t, err)
@@ -571,12 +587,13 @@ func TestAddEndpoint_OrganizationNotFound(t *testing.T) {
 
 	// Mock organization ID
 	organizationID := "org1"
-	endpoint := Reference{Reference: "http://hospital-a.com/api"}
+	endpoint := fhir.Reference{Reference: "http://hospital-a.com/api"}
 
 	// Mock GetOrganization method to return nil, indicating organization not found
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", organizationID).Return(nil, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID)
+	mockStub.On("GetState", organizationKey).Return(nil, nil)
 
 	err := cc.AddEndpoint(mockCtx, organizationID, endpoint)
 	assert.Error(t, err)
@@ -588,21 +605,22 @@ func TestRemoveEndpoint(t *testing.T) {
 	mockCtx := new(MockTransactionContext)
 
 	// Mock organization data
-	organizationID := Identifier{System: "exampleSystem", Value: "org1"}
-	organization := &Organization{ID: organizationID, Name: "Hospital A", Type: CodeableConcept{Text: "Hospital"},
-		EndPoint: &Reference{Reference: "http://hospital-a.com/api"}}
+	organizationID := &fhir.Identifier{System: "exampleSystem", Value: "org1"}
+	organization := &fhir.Organization{ID: organizationID, Name: "Hospital A", Type: &fhir.CodeableConcept{Text: "Hospital"},
+		EndPoint: &fhir.Reference{Reference: "http://hospital-a.com/api"}}
 
 	// Mock GetOrganization method to return existing organization
 	organizationBytes, _ := json.Marshal(organization)
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", organizationID.Value).Return(organizationBytes, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID.Value)
+	mockStub.On("GetState", organizationKey).Return(organizationBytes, nil)
 
 	// Mock PutState method to return success
 	updatedOrganization := *organization
 	updatedOrganization.EndPoint = nil
 	updatedOrganizationBytes, _ := json.Marshal(&updatedOrganization)
-	mockStub.On("PutState", organizationID.Value, updatedOrganizationBytes).Return(nil)
+	mockStub.On("PutState", organizationKey, updatedOrganizationBytes).Return(nil)
 
 	err := cc.RemoveEndpoint(mockCtx, organizationID.Value)
 	assert.NoError(t, err)
@@ -613,12 +631,13 @@ func TestRemoveEndpoint_OrganizationNotFound(t *testing.T) {
 	mockCtx := new(MockTransactionContext)
 
 	// Mock organization ID
-	organizationID := Identifier{System: "exampleSystem", Value: "org1"}
+	organizationID := &fhir.Identifier{System: "exampleSystem", Value: "org1"}
 
 	// Mock GetOrganization method to return nil, indicating organization not found
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", organizationID.Value).Return(nil, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID.Value)
+	mockStub.On("GetState", organizationKey).Return(nil, nil)
 
 	err := cc.RemoveEndpoint(mockCtx, organizationID.Value)
 	assert.Error(t, err)
@@ -630,19 +649,20 @@ func TestGetParentOrganization(t *testing.T) {
 	mockCtx := new(MockTransactionContext)
 
 	// Mock organization data
-	organizationID := Identifier{System: "exampleSystem", Value: "org1"}
-	parentOrganization := Reference{Reference: "http://parent-com/api"}
+	organizationID := &fhir.Identifier{System: "exampleSystem", Value: "org1"}
+	parentOrganization := fhir.Reference{Reference: "http://parent-com/api"}
 
 	// Mock GetOrganization method to return existing organization
-	organization := &Organization{
-		ID:     Identifier{System: "exampleSystem", Value: organizationID.Value},
+	organization := &fhir.Organization{
+		ID:     &fhir.Identifier{System: "exampleSystem", Value: organizationID.Value},
 		Name:   "Hospital A",
 		PartOf: &parentOrganization,
 	}
 	organizationBytes, _ := json.Marshal(organization)
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", organizationID.Value).Return(organizationBytes, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID.Value)
+	mockStub.On("GetState", organizationKey).Return(organizationBytes, nil)
 
 	// Call GetParentOrganization
 	result, err := cc.GetParentOrganization(mockCtx, organizationID.Value)
@@ -655,25 +675,26 @@ func TestUpdateParentOrganization(t *testing.T) {
 	mockCtx := new(MockTransactionContext)
 
 	// Mock organization data
-	organizationID := Identifier{System: "exampleSystem", Value: "org1"}
-	parentOrganization := Reference{Reference: "http://parent-com/api"}
+	organizationID := &fhir.Identifier{System: "exampleSystem", Value: "org1"}
+	parentOrganization := fhir.Reference{Reference: "http://parent-com/api"}
 
 	// Mock GetOrganization method to return existing organization
-	organization := &Organization{
-		ID:     Identifier{System: "exampleSystem", Value: organizationID.Value},
+	organization := &fhir.Organization{
+		ID:     &fhir.Identifier{System: "exampleSystem", Value: organizationID.Value},
 		Name:   "Hospital A",
 		PartOf: &parentOrganization,
 	}
 	organizationBytes, _ := json.Marshal(organization)
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", organizationID.Value).Return(organizationBytes, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID.Value)
+	mockStub.On("GetState", organizationKey).Return(organizationBytes, nil)
 
 	// Mock PutState method to return success
 	updatedOrganization := *organization
 	updatedOrganization.PartOf = &parentOrganization
 	updatedOrganizationBytes, _ := json.Marshal(&updatedOrganization)
-	mockStub.On("PutState", organizationID.Value, updatedOrganizationBytes).Return(nil)
+	mockStub.On("PutState", organizationKey, updatedOrganizationBytes).Return(nil)
 
 	// Call UpdateParentOrganization
 	err := cc.UpdateParentOrganization(mockCtx, organizationID.Value, parentOrganization)
@@ -685,26 +706,27 @@ func TestAddQualification(t *testing.T) {
 	mockCtx := new(MockTransactionContext)
 
 	// Mock organization data
-	organizationID := Identifier{System: "exampleSystem", Value: "org1"}
-	organization := &Organization{ID: organizationID, Name: "Hospital A"}
-	qualification := Qualification{
-		ID:     Identifier{System: "exampleSystem", Value: "qualification1"},
-		Code:   CodeableConcept{Text: "Qualification Code", Coding: []Coding{{System: "exampleSystem", Code: "code1", Display: "Display 1"}}},
-		Status: CodeableConcept{Text: "Active", Coding: []Coding{{System: "exampleSystem", Code: "active", Display: "Active"}}},
-		Issuer: &Reference{Reference: "http://issuer.com"},
+	organizationID := &fhir.Identifier{System: "exampleSystem", Value: "org1"}
+	organization := &fhir.Organization{ID: organizationID, Name: "Hospital A"}
+	qualification := fhir.Qualification{
+		ID:     &fhir.Identifier{System: "exampleSystem", Value: "qualification1"},
+		Code:   &fhir.CodeableConcept{Text: "Qualification Code", Coding: []fhir.Coding{{System: "exampleSystem", Code: "code1", Display: "Display 1"}}},
+		Status: &fhir.CodeableConcept{Text: "Active", Coding: []fhir.Coding{{System: "exampleSystem", Code: "active", Display: "Active"}}},
+		Issuer: &fhir.Reference{Reference: "http://issuer.com"},
 	}
 
 	// Mock GetOrganization method to return existing organization
 	organizationBytes, _ := json.Marshal(organization)
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", organizationID.Value).Return(organizationBytes, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID.Value)
+	mockStub.On("GetState", organizationKey).Return(organizationBytes, nil)
 
 	// Mock PutState method to return success
 	updatedOrganization := *organization
 	updatedOrganization.Qualification = append(updatedOrganization.Qualification, qualification)
 	updatedOrganizationBytes, _ := json.Marshal(&updatedOrganization)
-	mockStub.On("PutState", organizationID.Value, updatedOrganizationBytes).Return(nil)
+	mockStub.On("PutState", organizationKey, updatedOrganizationBytes).Return(nil)
 
 	err := cc.AddQualification(mockCtx, organizationID.Value, qualification)
 	assert.NoError(t, err)
@@ -715,30 +737,31 @@ func TestRemoveQualification(t *testing.T) {
 	mockCtx := new(MockTransactionContext)
 
 	// Mock organization data
-	organizationID := Identifier{System: "exampleSystem", Value: "org1"}
-	qualificationID := Identifier{System: "exampleSystem", Value: "qual1"}
-	qualification := Qualification{
+	organizationID := &fhir.Identifier{System: "exampleSystem", Value: "org1"}
+	qualificationID := &fhir.Identifier{System: "exampleSystem", Value: "qual1"}
+	qualification := fhir.Qualification{
 		ID:     qualificationID,
-		Code:   CodeableConcept{Text: "Qualification Code"},
-		Status: CodeableConcept{Text: "Active"},
-		Issuer: &Reference{Reference: "http://issuer.com"},
+		Code:   &fhir.CodeableConcept{Text: "Qualification Code"},
+		Status: &fhir.CodeableConcept{Text: "Active"},
+		Issuer: &fhir.Reference{Reference: "http://issuer.com"},
 	}
-	organization := &Organization{
+	organization := &fhir.Organization{
 		ID:            organizationID,
 		Name:          "Hospital A",
-		Qualification: []Qualification{qualification},
+		Qualification: []fhir.Qualification{qualification},
 	}
 
 	// Mock GetOrganization method to return existing organization
 	organizationBytes, _ := json.Marshal(organization)
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", organizationID.Value).Return(organizationBytes, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID.Value)
+	mockStub.On("GetState", organizationKey).Return(organizationBytes, nil)
 
 	// Mock PutState method to return success
-	mockStub.On("PutState", organizationID.Value, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+	mockStub.On("PutState", organizationKey, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
 		arg := args.Get(1).([]byte)
-		var updatedOrganization Organization
+		var updatedOrganization fhir.Organization
 		err := json.Unmarshal(arg, &updatedOrganization)
 		require.NoError(t, err)
 		assert.Len(t, updatedOrganization.Qualification, 0) // Qualification should be removed
@@ -753,37 +776,38 @@ func TestUpdateQualification(t *testing.T) {
 	mockCtx := new(MockTransactionContext)
 
 	// Mock organization data
-	organizationID := Identifier{System: "exampleSystem", Value: "org1"}
-	qualificationID := Identifier{System: "exampleSystem", Value: "qual1"}
-	qualification := Qualification{
+	organizationID := &fhir.Identifier{System: "exampleSystem", Value: "org1"}
+	qualificationID := &fhir.Identifier{System: "exampleSystem", Value: "qual1"}
+	qualification := fhir.Qualification{
 		ID:     qualificationID,
-		Code:   CodeableConcept{Text: "Qualification Code"},
-		Status: CodeableConcept{Text: "Active"},
-		Issuer: &Reference{Reference: "http://issuer.com"},
+		Code:   &fhir.CodeableConcept{Text: "Qualification Code"},
+		Status: &fhir.CodeableConcept{Text: "Active"},
+		Issuer: &fhir.Reference{Reference: "http://issuer.com"},
 	}
-	organization := &Organization{
+	organization := &fhir.Organization{
 		ID:            organizationID,
 		Name:          "Hospital A",
-		Qualification: []Qualification{qualification},
+		Qualification: []fhir.Qualification{qualification},
 	}
 	qualificationIndex := 0
-	updatedQualification := Qualification{
-		ID:     Identifier{System: "exampleSystem", Value: "updatedQualification"},
-		Code:   CodeableConcept{Text: "Updated Qualification Code", Coding: []Coding{{System: "exampleSystem", Code: "updatedCode", Display: "Updated Display"}}},
-		Status: CodeableConcept{Text: "Inactive", Coding: []Coding{{System: "exampleSystem", Code: "inactive", Display: "Inactive"}}},
-		Issuer: &Reference{Reference: "http://updatedIssuer.com"},
+	updatedQualification := fhir.Qualification{
+		ID:     &fhir.Identifier{System: "exampleSystem", Value: "updatedQualification"},
+		Code:   &fhir.CodeableConcept{Text: "Updated Qualification Code", Coding: []fhir.Coding{{System: "exampleSystem", Code: "updatedCode", Display: "Updated Display"}}},
+		Status: &fhir.CodeableConcept{Text: "Inactive", Coding: []fhir.Coding{{System: "exampleSystem", Code: "inactive", Display: "Inactive"}}},
+		Issuer: &fhir.Reference{Reference: "http://updatedIssuer.com"},
 	}
 
 	// Mock GetOrganization method to return existing organization
 	organizationBytes, _ := json.Marshal(organization)
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", organizationID.Value).Return(organizationBytes, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID.Value)
+	mockStub.On("GetState", organizationKey).Return(organizationBytes, nil)
 
 	// Mock PutState method to return success
-	mockStub.On("PutState", organizationID.Value, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+	mockStub.On("PutState", organizationKey, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
 		arg := args.Get(1).([]byte)
-		var updatedOrganization Organization
+		var updatedOrganization fhir.Organization
 		err := json.Unmarshal(arg, &updatedOrganization)
 		require.NoError(t, err)
 		assert.Equal(t, updatedQualification, updatedOrganization.Qualification[qualificationIndex]) // Updated qualification should match
@@ -799,41 +823,43 @@ func TestUpdateContact(t *testing.T) {
 
 	// Mock organization data
 	organizationID := "org1"
-	organization := &Organization{
-		ID:   Identifier{System: "exampleSystem", Value: organizationID},
+	validFrom := time.Now()
+	validTo := validFrom.AddDate(1, 0, 0)
+	organization := &fhir.Organization{
+		ID:   &fhir.Identifier{System: "exampleSystem", Value: organizationID},
 		Name: "Hospital A",
-		Contact: ExtendedContactDetail{
-			Name: HumanName{
+		Contact: &fhir.ExtendedContactDetail{
+			Name: &fhir.HumanName{
 				Text: "John Doe",
 			},
-			Telecom: ContactPoint{
-				System: Code{Coding: []Coding{{System: "exampleSystem", Code: "email", Display: "jhnd"}}},
+			Telecom: &fhir.ContactPoint{
+				System: &fhir.Code{Coding: []fhir.Coding{{System: "exampleSystem", Code: "email", Display: "jhnd"}}},
 				Value:  "123456789",
-				Use:    Code{Coding: []Coding{{System: "useSystem", Code: "useCode", Display: "useDisplay"}}},
+				Use:    &fhir.Code{Coding: []fhir.Coding{{System: "useSystem", Code: "useCode", Display: "useDisplay"}}},
 				Rank:   1,
 			},
-			Address: Address{
+			Address: &fhir.Address{
 				City:    "New York",
 				Country: "USA",
 			},
-			Organization: &Reference{
+			Organization: &fhir.Reference{
 				Reference: "http://example.com/organization",
 			},
-			Period: Period{
-				Start: time.Now(),
-				End:   time.Now().AddDate(1, 0, 0),
+			Period: &fhir.Period{
+				Start: validFrom,
+				End:   validTo,
 			},
 		},
 	}
-	updatedTelecom := ContactPoint{
-		System: Code{Coding: []Coding{{System: "exampleSystem", Code: "email", Display: "johndoe"}}},
+	updatedTelecom := fhir.ContactPoint{
+		System: &fhir.Code{Coding: []fhir.Coding{{System: "exampleSystem", Code: "email", Display: "johndoe"}}},
 		Value:  "jane@example.com",
-		Use:    Code{Coding: []Coding{{System: "newUseSystem", Code: "newUseCode", Display: "newUseDisplay"}}},
+		Use:    &fhir.Code{Coding: []fhir.Coding{{System: "newUseSystem", Code: "newUseCode", Display: "newUseDisplay"}}},
 		Rank:   2, // Set the Rank field to the expected value
 	}
-	updatedContact := ExtendedContactDetail{
+	updatedContact := fhir.ExtendedContactDetail{
 		Name:         organization.Contact.Name,
-		Telecom:      organization.Contact.Telecom,
+		Telecom:      &updatedTelecom,
 		Address:      organization.Contact.Address,
 		Organization: organization.Contact.Organization,
 		Period:       organization.Contact.Period,
@@ -843,17 +869,50 @@ func TestUpdateContact(t *testing.T) {
 	organizationBytes, _ := json.Marshal(organization)
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", organizationID).Return(organizationBytes, nil)
+	organizationKey := mockKey(mockStub, "Organization", organizationID)
+	mockStub.On("GetState", organizationKey).Return(organizationBytes, nil)
 
 	// Mock PutState method to return success
-	mockStub.On("PutState", organizationID, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+	mockStub.On("PutState", organizationKey, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
 		arg := args.Get(1).([]byte)
-		var updatedOrganization Organization
+		var updatedOrganization fhir.Organization
 		err := json.Unmarshal(arg, &updatedOrganization)
 		require.NoError(t, err)
-		assert.Equal(t, updatedTelecom, updatedOrganization.Contact.Telecom) // Updated telecom should match
+		assert.Equal(t, &updatedTelecom, updatedOrganization.Contact.Telecom) // Updated telecom should match
 	})
 
 	err := cc.UpdateContact(mockCtx, organizationID, updatedContact)
 	assert.NoError(t, err)
 }
+
+func TestPoliciesCoverEveryTransaction(t *testing.T) {
+	assert.Empty(t, auth.MissingPolicies(new(OrganizationChaincode), organizationPolicies), "transactions without an access policy")
+}
+
+func TestMigrateKeys(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	cc := new(OrganizationChaincode)
+
+	organizationJSON := []byte(`{"name":"Hospital A"}`)
+	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{
+		Records: []KVPair{{Key: "org1", Value: organizationJSON}},
+	}, nil)
+	organizationKey := mockKey(mockStub, "Organization", "org1")
+	mockStub.On("GetState", organizationKey).Return(nil, nil)
+	mockStub.On("PutState", organizationKey, organizationJSON).Return(nil)
+	mockStub.On("DelState", "org1").Return(nil)
+
+	migrated, err := cc.MigrateKeys(mockCtx, 100)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+	mockStub.AssertExpectations(t)
+}
+
+func TestChaincodeMetadataIsValid(t *testing.T) {
+	// contractapi rejects at startup any transaction whose types it cannot describe
+	_, err := contractapi.NewChaincode(new(OrganizationChaincode))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,29 @@
+package main
+
+import "github.com/xDaryamo/MedChain/auth"
+
+var adminRoles = []string{auth.RoleAdmin}
+
+// organizationPolicies declares who may call each OrganizationChaincode transaction.
+// The organization registry is maintained by administrators and readable by anyone.
+var organizationPolicies = auth.Policies{
+	"CreateOrganization":        {Roles: adminRoles},
+	"GetOrganization":           {},
+	"GetOrganizationHistory":    {},
+	"UpdateOrganization":        {Roles: adminRoles},
+	"DeleteOrganization":        {Roles: adminRoles},
+	"SearchOrganizationsByType": {},
+	"SearchOrganizationByName":  {},
+	"AddEndpoint":               {Roles: adminRoles},
+	"AddQualification":          {Roles: adminRoles},
+	"RemoveEndpoint":            {Roles: adminRoles},
+	"RemoveQualification":       {Roles: adminRoles},
+	"UpdateEndpoint":            {Roles: adminRoles},
+	"UpdateContact":             {Roles: adminRoles},
+	"UpdateQualification":       {Roles: adminRoles},
+	"GetParentOrganization":     {},
+	"UpdateParentOrganization":  {Roles: adminRoles},
+	"MigrateKeys":               {Roles: adminRoles},
+}
+
+var organizationEnforcer = &auth.Enforcer{Policies: organizationPolicies}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+)
+
+// Every (patient, requester) pair has one FHIR Consent, stored under the composite key
+// Consent~patientID~requesterMSP~requesterID, since a userId is only unique within the
+// organization that enrolled it. The consent is a draft while the access request is
+// pending, active once the patient grants it and inactive once revoked. An active
+// consent permits the accesses described by any of its provisions.
+
+const consentObjectType = "Consent"
+
+// Consent statuses, from the http://hl7.org/fhir/consent-state-codes value set
+const (
+	ConsentDraft    = "draft"
+	ConsentActive   = "active"
+	ConsentInactive = "inactive"
+)
+
+// Access requests evaluated by the PatientContract itself
+var (
+	readPatient  = auth.AccessRequest{ResourceType: "Patient", Action: auth.ActionRead, Purpose: auth.PurposeTreatment}
+	writePatient = auth.AccessRequest{ResourceType: "Patient", Action: auth.ActionWrite, Purpose: auth.PurposeTreatment}
+)
+
+/*
+================================
+	CONSENT OPERATIONS
+================================
+*/
+
+// RequestAccess records a pending access request of requesterID, of the caller's organization,
+// to the data of patientID
+func (c *PatientContract) RequestAccess(ctx contractapi.TransactionContextInterface, patientID string, requesterID string) error {
+	// Access can only be requested on one's own behalf
+	clientID, err := c.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	if clientID != requesterID {
+		return errors.New("requester ID does not match the caller")
+	}
+	requesterMSP, err := c.getCallerMSPID(ctx)
+	if err != nil {
+		return err
+	}
+
+	consent, err := c.getConsent(ctx, patientID, requesterMSP, requesterID)
+	if err != nil {
+		return err
+	}
+	if consent == nil {
+		consent = newConsent(patientID, requesterMSP, requesterID)
+	}
+	if consent.Status == ConsentActive {
+		return nil // Access already granted
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	consent.Status = ConsentDraft
+	consent.Date = now
+
+	return c.putConsent(ctx, patientID, requesterMSP, requesterID, consent)
+}
+
+// GrantAccess grants requesterID of requesterMSP unrestricted access to the data of patientID
+func (c *PatientContract) GrantAccess(ctx contractapi.TransactionContextInterface, patientID string, requesterMSP string, requesterID string) error {
+	return c.grant(ctx, patientID, requesterMSP, requesterID, fhir.ConsentProvision{})
+}
+
+// GrantTemporaryAccess grants requesterID of requesterMSP unrestricted access to the data of
+// patientID for the given number of days, counted from the transaction timestamp
+func (c *PatientContract) GrantTemporaryAccess(ctx contractapi.TransactionContextInterface, patientID string, requesterMSP string, requesterID string, days int) error {
+	if days <= 0 {
+		return errors.New("access duration must be at least one day")
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	end := now.AddDate(0, 0, days)
+
+	return c.grant(ctx, patientID, requesterMSP, requesterID, fhir.ConsentProvision{Period: &fhir.Period{Start: now, End: end}})
+}
+
+// GrantConsent grants requesterID of requesterMSP the access described by a FHIR Consent provision,
+// which may restrict the resource types, the purposes of use, the actions and the time window
+func (c *PatientContract) GrantConsent(ctx contractapi.TransactionContextInterface, patientID string, requesterMSP string, requesterID string, provisionJSON string) error {
+	var provision fhir.ConsentProvision
+	if err := json.Unmarshal([]byte(provisionJSON), &provision); err != nil {
+		return errors.New("failed to unmarshal consent provision: " + err.Error())
+	}
+	if err := validateProvision(provision); err != nil {
+		return err
+	}
+
+	return c.grant(ctx, patientID, requesterMSP, requesterID, provision)
+}
+
+// RevokeAccess withdraws every access granted to requesterID of requesterMSP
+func (c *PatientContract) RevokeAccess(ctx contractapi.TransactionContextInterface, patientID string, requesterMSP string, requesterID string) error {
+	consent, err := c.getConsent(ctx, patientID, requesterMSP, requesterID)
+	if err != nil {
+		return err
+	}
+	if consent == nil {
+		return errors.New("no consent found for requester: " + requesterID)
+	}
+
+	// Solo il paziente può revocare l'accesso
+	clientID, err := c.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	if clientID != patientID {
+		return errors.New("only the patient can revoke access")
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	consent.Status = ConsentInactive
+	consent.Date = now
+	consent.Provision = nil
+
+	return c.putConsent(ctx, patientID, requesterMSP, requesterID, consent)
+}
+
+// ReadConsent returns the consent of patientID concerning requesterID of requesterMSP
+func (c *PatientContract) ReadConsent(ctx contractapi.TransactionContextInterface, patientID string, requesterMSP string, requesterID string) (*fhir.Consent, error) {
+	consent, err := c.getConsent(ctx, patientID, requesterMSP, requesterID)
+	if err != nil {
+		return nil, err
+	}
+	if consent == nil {
+		return nil, errors.New("no consent found for requester: " + requesterID)
+	}
+	return consent, nil
+}
+
+// ListConsents returns every consent of patientID, whatever its status
+func (c *PatientContract) ListConsents(ctx contractapi.TransactionContextInterface, patientID string) ([]*fhir.Consent, error) {
+	var consents []*fhir.Consent
+	err := c.iterateConsents(ctx, patientID, func(consentKey string, consent *fhir.Consent) error {
+		consents = append(consents, consent)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return consents, nil
+}
+
+// GetExpiredConsents reports the active consents of patientID holding grants whose period
+// ended before the transaction timestamp. An empty patientID reports every patient.
+func (c *PatientContract) GetExpiredConsents(ctx contractapi.TransactionContextInterface, patientID string) ([]*fhir.Consent, error) {
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []*fhir.Consent
+	err = c.iterateConsents(ctx, patientID, func(consentKey string, consent *fhir.Consent) error {
+		if consent.Status == ConsentActive && hasExpiredGrants(consent, now) {
+			expired = append(expired, consent)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// SweepExpiredConsents removes the expired grants from the consents of patientID and
+// deactivates the consents left without grants. An empty patientID sweeps every patient.
+// It returns the consents it changed.
+func (c *PatientContract) SweepExpiredConsents(ctx contractapi.TransactionContextInterface, patientID string) ([]*fhir.Consent, error) {
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var swept []*fhir.Consent
+	err = c.iterateConsents(ctx, patientID, func(consentKey string, consent *fhir.Consent) error {
+		if consent.Status != ConsentActive || !hasExpiredGrants(consent, now) {
+			return nil
+		}
+
+		var current []fhir.ConsentProvision
+		if !isExpired(consent.Period, now) {
+			for _, provision := range consent.Provision {
+				if !isExpired(provision.Period, now) {
+					current = append(current, provision)
+				}
+			}
+		}
+		consent.Provision = current
+		if len(current) == 0 {
+			consent.Status = ConsentInactive
+		}
+		consent.Date = now
+
+		consentJSON, err := json.Marshal(consent)
+		if err != nil {
+			return errors.New("failed to marshal consent: " + err.Error())
+		}
+		if err := ctx.GetStub().PutState(consentKey, consentJSON); err != nil {
+			return errors.New("failed to put state: " + err.Error())
+		}
+		swept = append(swept, consent)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return swept, nil
+}
+
+// EvaluateConsent reports whether the consents of patientID permit requesterID of requesterMSP
+// to perform action on resources of resourceType for the given purpose of use. Other
+// chaincodes query it to enforce the patient's consent. A chaincode-to-chaincode query runs
+// with the identity of the original caller, so only the requester or the patient may evaluate
+// a consent, and nobody can probe the consents of others.
+func (c *PatientContract) EvaluateConsent(ctx contractapi.TransactionContextInterface, patientID string, requesterMSP string, requesterID string, resourceType string, action string, purpose string) (bool, error) {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return false, err
+	}
+	isRequester := caller.MSPID == requesterMSP && caller.UserID == requesterID
+	isPatient := caller.MSPID == auth.PatientMSP && caller.UserID == patientID
+	if !isRequester && !isPatient {
+		return false, errors.New("only the requester or the patient may evaluate a consent")
+	}
+
+	if requesterMSP == auth.PatientMSP && requesterID == patientID {
+		return true, nil
+	}
+	request := auth.AccessRequest{ResourceType: resourceType, Action: action, Purpose: purpose}
+	return c.isAuthorized(ctx, patientID, requesterMSP, requesterID, request)
+}
+
+// hasEmergencyAccess reports whether the caller broke the glass on the record of patientID
+func (c *PatientContract) hasEmergencyAccess(ctx contractapi.TransactionContextInterface, patientID string, request auth.AccessRequest) (bool, error) {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return false, err
+	}
+	grant, err := auth.EmergencyConsent.HasConsent(ctx, patientID, caller, request)
+	if err != nil {
+		return false, err
+	}
+	return grant != nil, nil
+}
+
+func (c *PatientContract) grant(ctx contractapi.TransactionContextInterface, patientID string, requesterMSP string, requesterID string, provision fhir.ConsentProvision) error {
+	// Solo il paziente può concedere l'accesso
+	clientID, err := c.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	if clientID != patientID {
+		return errors.New("only the patient can grant access")
+	}
+
+	if requesterMSP == "" {
+		return errors.New("the organization of the requester is required")
+	}
+
+	consent, err := c.getConsent(ctx, patientID, requesterMSP, requesterID)
+	if err != nil {
+		return err
+	}
+	if consent == nil {
+		consent = newConsent(patientID, requesterMSP, requesterID)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	consent.Status = ConsentActive
+	consent.Date = now
+	consent.Provision = append(consent.Provision, provision)
+
+	return c.putConsent(ctx, patientID, requesterMSP, requesterID, consent)
+}
+
+func (c *PatientContract) isAuthorized(ctx contractapi.TransactionContextInterface, patientID string, clientMSP string, clientID string, request auth.AccessRequest) (bool, error) {
+	consent, err := c.getConsent(ctx, patientID, clientMSP, clientID)
+	if err != nil {
+		return false, err
+	}
+	if consent == nil || consent.Status != ConsentActive {
+		return false, nil // No consent granted, no access
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !inPeriod(consent.Period, now) {
+		return false, nil
+	}
+
+	for _, provision := range consent.Provision {
+		if matchesProvision(provision, request, now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchesProvision reports whether the request falls within the provision. Unset fields
+// of the provision place no restriction; nested provisions are exceptions to it.
+func matchesProvision(provision fhir.ConsentProvision, request auth.AccessRequest, now time.Time) bool {
+	if !inPeriod(provision.Period, now) {
+		return false
+	}
+	if len(provision.Action) > 0 && !hasConcept(provision.Action, request.Action) {
+		return false
+	}
+	if len(provision.Purpose) > 0 && !hasCoding(provision.Purpose, request.Purpose) {
+		return false
+	}
+	if len(provision.ResourceType) > 0 && !hasCoding(provision.ResourceType, request.ResourceType) {
+		return false
+	}
+
+	for _, exception := range provision.Provision {
+		if matchesProvision(exception, request, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasExpiredGrants reports whether the consent, or any of its provisions, ended before now
+func hasExpiredGrants(consent *fhir.Consent, now time.Time) bool {
+	if isExpired(consent.Period, now) {
+		return true
+	}
+	for _, provision := range consent.Provision {
+		if isExpired(provision.Period, now) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateProvision(provision fhir.ConsentProvision) error {
+	if provision.Period != nil && !provision.Period.Start.IsZero() && !provision.Period.End.IsZero() && provision.Period.End.Before(provision.Period.Start) {
+		return errors.New("invalid consent provision: period ends before it starts")
+	}
+	for _, action := range provision.Action {
+		for _, coding := range action.Coding {
+			if coding.Code != auth.ActionRead && coding.Code != auth.ActionWrite {
+				return errors.New("invalid consent provision: unsupported action " + coding.Code)
+			}
+		}
+	}
+	for _, purpose := range provision.Purpose {
+		if purpose.Code != auth.PurposeTreatment && purpose.Code != auth.PurposeResearch && purpose.Code != auth.PurposeEmergency {
+			return errors.New("invalid consent provision: unsupported purpose " + purpose.Code)
+		}
+	}
+	for _, exception := range provision.Provision {
+		if err := validateProvision(exception); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newConsent(patientID string, requesterMSP string, requesterID string) *fhir.Consent {
+	return &fhir.Consent{
+		ID:       auth.ConsentID(patientID, requesterMSP, requesterID),
+		Subject:  &fhir.Reference{Reference: "Patient/" + patientID},
+		Grantor:  []fhir.Reference{{Reference: "Patient/" + patientID}},
+		Grantee:  []fhir.Reference{{Reference: "Practitioner/" + requesterID, Display: requesterMSP}},
+		Decision: "permit",
+	}
+}
+
+func (c *PatientContract) getConsent(ctx contractapi.TransactionContextInterface, patientID string, requesterMSP string, requesterID string) (*fhir.Consent, error) {
+	consentKey, err := ctx.GetStub().CreateCompositeKey(consentObjectType, []string{patientID, requesterMSP, requesterID})
+	if err != nil {
+		return nil, errors.New("failed to create consent key: " + err.Error())
+	}
+
+	consentJSON, err := ctx.GetStub().GetState(consentKey)
+	if err != nil {
+		return nil, errors.New("failed to get consent: " + err.Error())
+	}
+	if consentJSON == nil {
+		return nil, nil
+	}
+
+	var consent fhir.Consent
+	if err := json.Unmarshal(consentJSON, &consent); err != nil {
+		return nil, errors.New("failed to unmarshal consent: " + err.Error())
+	}
+	return &consent, nil
+}
+
+// iterateConsents calls visit with every consent of patientID, or of every patient when patientID is empty
+func (c *PatientContract) iterateConsents(ctx contractapi.TransactionContextInterface, patientID string, visit func(consentKey string, consent *fhir.Consent) error) error {
+	var attributes []string
+	if patientID != "" {
+		attributes = []string{patientID}
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(consentObjectType, attributes)
+	if err != nil {
+		return errors.New("failed to get consents: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errors.New("failed to iterate consents: " + err.Error())
+		}
+
+		var consent fhir.Consent
+		if err := json.Unmarshal(queryResponse.Value, &consent); err != nil {
+			return errors.New("failed to unmarshal consent: " + err.Error())
+		}
+		if err := visit(queryResponse.Key, &consent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *PatientContract) putConsent(ctx contractapi.TransactionContextInterface, patientID string, requesterMSP string, requesterID string, consent *fhir.Consent) error {
+	consentKey, err := ctx.GetStub().CreateCompositeKey(consentObjectType, []string{patientID, requesterMSP, requesterID})
+	if err != nil {
+		return errors.New("failed to create consent key: " + err.Error())
+	}
+
+	consentJSON, err := json.Marshal(consent)
+	if err != nil {
+		return errors.New("failed to marshal consent: " + err.Error())
+	}
+	return ctx.GetStub().PutState(consentKey, consentJSON)
+}
+
+// legacyAuthorization is the access list stored under auth_patientID before consents
+type legacyAuthorization struct {
+	PatientID  string          `json:"patientId"`
+	Authorized map[string]bool `json:"authorized"` // Whether each requester was granted access or is still pending
+}
+
+// migrateAuthorizations converts up to limit legacy authorization records into consents:
+// granted requesters get an active consent with an unrestricted provision, pending ones a
+// draft. Requesters that already have a consent keep it. It returns how many records it converted.
+// Legacy records do not name the requester's organization, so their consents are stored
+// without one; EvaluateConsent never matches them until the patient grants the requester again.
+func (c *PatientContract) migrateAuthorizations(ctx contractapi.TransactionContextInterface, limit int) (int, error) {
+	// "`" follows "_", so the range holds exactly the keys starting with auth_
+	resultsIterator, err := ctx.GetStub().GetStateByRange(legacyAuthorizationPrefix, "auth`")
+	if err != nil {
+		return 0, errors.New("failed to get authorization records: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	converted := 0
+	for converted < limit && resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return converted, errors.New("failed to iterate authorization records: " + err.Error())
+		}
+
+		var authorization legacyAuthorization
+		if err := json.Unmarshal(queryResponse.Value, &authorization); err != nil {
+			return converted, errors.New("failed to unmarshal authorization record " + queryResponse.Key + ": " + err.Error())
+		}
+		patientID := strings.TrimPrefix(queryResponse.Key, legacyAuthorizationPrefix)
+
+		// Sorted so that every endorser writes the same consents in the same order
+		requesterIDs := make([]string, 0, len(authorization.Authorized))
+		for requesterID := range authorization.Authorized {
+			requesterIDs = append(requesterIDs, requesterID)
+		}
+		sort.Strings(requesterIDs)
+
+		for _, requesterID := range requesterIDs {
+			existing, err := c.getConsent(ctx, patientID, "", requesterID)
+			if err != nil {
+				return converted, err
+			}
+			if existing != nil {
+				continue
+			}
+
+			consent := newConsent(patientID, "", requesterID)
+			consent.Date = now
+			if authorization.Authorized[requesterID] {
+				consent.Status = ConsentActive
+				consent.Provision = []fhir.ConsentProvision{{}}
+			} else {
+				consent.Status = ConsentDraft
+			}
+			if err := c.putConsent(ctx, patientID, "", requesterID, consent); err != nil {
+				return converted, err
+			}
+		}
+
+		if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+			return converted, errors.New("failed to delete authorization record: " + err.Error())
+		}
+		converted++
+	}
+
+	return converted, nil
+}
+
+// getTxTime returns the transaction timestamp, which every endorser agrees on
+func getTxTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+	return timestamp.AsTime(), nil
+}
+
+func inPeriod(period *fhir.Period, now time.Time) bool {
+	if period == nil {
+		return true
+	}
+	if !period.Start.IsZero() && now.Before(period.Start) {
+		return false
+	}
+	if !period.End.IsZero() && now.After(period.End) {
+		return false
+	}
+	return true
+}
+
+// isExpired reports whether the period ended before now
+func isExpired(period *fhir.Period, now time.Time) bool {
+	return period != nil && !period.End.IsZero() && now.After(period.End)
+}
+
+func hasConcept(concepts []fhir.CodeableConcept, code string) bool {
+	for _, concept := range concepts {
+		if hasCoding(concept.Coding, code) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasCoding(codings []fhir.Coding, code string) bool {
+	for _, coding := range codings {
+		if coding.Code == code {
+			return true
+		}
+	}
+	return false
+}
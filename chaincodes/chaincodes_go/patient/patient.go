@@ -4,13 +4,32 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"strings"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+	"github.com/xDaryamo/MedChain/history"
+	"github.com/xDaryamo/MedChain/ledger"
 )
 
-type Authorization struct {
-	PatientID  string          `json:"patientId"`
-	Authorized map[string]bool `json:"authorized"` // Map of UserID and the authorizations
+// Object types of the composite keys the contract stores its resources under
+const (
+	patientObjectType  = "Patient"         // Patient~patientID
+	identityObjectType = "IdentityMapping" // IdentityMapping~userID
+)
+
+// Prefixes of the bare keys written before resources were namespaced
+const (
+	legacyIdentityPrefix      = "identity_"
+	legacyAuthorizationPrefix = "auth_"
+)
+
+// IdentityMapping links a user ID to the certificate enrolled for that user
+type IdentityMapping struct {
+	UserID     string `json:"userId"`
+	IdentityID string `json:"identityId"` // x509 identity of the certificate, as returned by GetID
+	MSPID      string `json:"mspId"`      // MSP that issued the certificate
 }
 
 type PatientContract struct {
@@ -23,7 +42,7 @@ func (c *PatientContract) CreatePatient(ctx contractapi.TransactionContextInterf
 
 
 	// Deserialize JSON data into a Go data structure
-	var patient Patient
+	var patient fhir.Patient
 	err := json.Unmarshal([]byte(patientJSON), &patient)
 	if err != nil {
 		return errors.New("failed to unmarshal patient: " + err.Error())
@@ -36,7 +55,7 @@ func (c *PatientContract) CreatePatient(ctx contractapi.TransactionContextInterf
 		return errors.New("patient request ID is required")
 	}
 
-	existingPatient, err := ctx.GetStub().GetState(patient.ID.Value)
+	existingPatient, err := getResource(ctx, patientObjectType, patient.ID.Value)
 	if err != nil {
 		return errors.New("failed to get patient " + patient.ID.Value + " from world state")
 	}
@@ -54,14 +73,14 @@ func (c *PatientContract) CreatePatient(ctx contractapi.TransactionContextInterf
 	log.Printf("Serialized Patient JSON: %s", string(patientJSONBytes))
 
 	// Save the new patient to the ledger
-	return ctx.GetStub().PutState(patient.ID.Value, patientJSONBytes)
+	return putResource(ctx, patientObjectType, patient.ID.Value, patientJSONBytes)
 }
 
 func (c *PatientContract) ReadPatient(ctx contractapi.TransactionContextInterface, patientID string) (string, error) {
-	var patient Patient
+	var patient fhir.Patient
 
 	// Leggi lo stato del paziente dal ledger
-	patientJSON, err := ctx.GetStub().GetState(patientID)
+	patientJSON, err := getResource(ctx, patientObjectType, patientID)
 	if err != nil {
 		return "", errors.New("failed to read patient: " + err.Error())
 	}
@@ -80,12 +99,9 @@ func (c *PatientContract) ReadPatient(ctx contractapi.TransactionContextInterfac
 	log.Printf("Patient object: %+v", patient)
 
 	// Ottieni l'ID del client richiedente
-	clientID, exists, err := ctx.GetClientIdentity().GetAttributeValue("userId")
+	clientID, err := c.getCallerID(ctx)
 	if err != nil {
-		return "", errors.New("failed to get client ID attribute: " + err.Error())
-	}
-	if !exists {
-		return "", errors.New("client ID attribute does not exist")
+		return "", err
 	}
 
 	log.Printf("Client ID (patientID attribute): %s", clientID)
@@ -99,10 +115,21 @@ func (c *PatientContract) ReadPatient(ctx contractapi.TransactionContextInterfac
 
 	log.Printf("Non Uguale")
 	// Altrimenti, verifica se il richiedente è autorizzato
-	authorized, err := c.isAuthorized(ctx, patientID, clientID)
+	clientMSP, err := c.getCallerMSPID(ctx)
 	if err != nil {
 		return "", err
 	}
+	authorized, err := c.isAuthorized(ctx, patientID, clientMSP, clientID, readPatient)
+	if err != nil {
+		return "", err
+	}
+	if !authorized {
+		// In emergenza, un clinico ospedaliero può accedere dopo aver rotto il vetro
+		authorized, err = c.hasEmergencyAccess(ctx, patientID, readPatient)
+		if err != nil {
+			return "", err
+		}
+	}
 	if authorized {
 		return string(patientJSON), nil
 	} else {
@@ -110,12 +137,36 @@ func (c *PatientContract) ReadPatient(ctx contractapi.TransactionContextInterfac
 	}
 }
 
+// AuditedReadPatient reads a patient record like ReadPatient and records the read in
+// the patient's access log. It must be submitted, not evaluated, for the log entry to be kept.
+func (c *PatientContract) AuditedReadPatient(ctx contractapi.TransactionContextInterface, patientID string) (string, error) {
+	if _, err := patientEnforcer.Audit(ctx, "ReadPatient", []string{patientID}, "Patient", patientID); err != nil {
+		return "", err
+	}
+	return c.ReadPatient(ctx, patientID)
+}
+
+// GetAccessLog returns the audited reads of the record of patientID
+func (c *PatientContract) GetAccessLog(ctx contractapi.TransactionContextInterface, patientID string) ([]*auth.AccessLogEntry, error) {
+	return auth.GetAccessLog(ctx, patientID)
+}
+
+
 
+// GetPatientHistory returns every version of a patient record as a FHIR history Bundle,
+// including deletions, with the transaction and identity that wrote each version
+func (c *PatientContract) GetPatientHistory(ctx contractapi.TransactionContextInterface, patientID string) (string, error) {
+	patientKey, err := ledger.Key(ctx, patientObjectType, patientID)
+	if err != nil {
+		return "", err
+	}
+	return history.GetHistoryJSON(ctx, patientKey, "Patient", patientID)
+}
 
 // UpdatePatient updates an existing patient record in the ledger
 func (c *PatientContract) UpdatePatient(ctx contractapi.TransactionContextInterface, patientID string, patientJSON string) error {
 
-	exists, err := ctx.GetStub().GetState(patientID)
+	exists, err := getResource(ctx, patientObjectType, patientID)
 	if err != nil {
 		return errors.New("failed to get patient: " + err.Error())
 	}
@@ -124,14 +175,18 @@ func (c *PatientContract) UpdatePatient(ctx contractapi.TransactionContextInterf
 	}
 
 	// Ottieni l'ID del client che effettua la richiesta
-	clientID, err := ctx.GetClientIdentity().GetID()
+	clientID, err := c.getCallerID(ctx)
 	if err != nil {
-		return errors.New("failed to get client ID")
+		return err
 	}
 
 	// Controlla se il richiedente è il paziente stesso o un ente autorizzato
 	if clientID != patientID {
-		authorized, err := c.isAuthorized(ctx, patientID, clientID)
+		clientMSP, err := c.getCallerMSPID(ctx)
+		if err != nil {
+			return err
+		}
+		authorized, err := c.isAuthorized(ctx, patientID, clientMSP, clientID, writePatient)
 		if err != nil {
 			return err
 		}
@@ -141,7 +196,7 @@ func (c *PatientContract) UpdatePatient(ctx contractapi.TransactionContextInterf
 	}
 
 	// Deserializza il JSON del paziente ricevuto
-	var patient Patient
+	var patient fhir.Patient
 	if err := json.Unmarshal([]byte(patientJSON), &patient); err != nil {
 		return errors.New("failed to unmarshal patient: " + err.Error())
 	}
@@ -153,7 +208,7 @@ func (c *PatientContract) UpdatePatient(ctx contractapi.TransactionContextInterf
 	}
 
 	// Aggiorna il paziente nel ledger
-	if err := ctx.GetStub().PutState(patientID, patientJSONBytes); err != nil {
+	if err := putResource(ctx, patientObjectType, patientID, patientJSONBytes); err != nil {
 		return errors.New("failed to put state: " + err.Error())
 	}
 
@@ -162,7 +217,7 @@ func (c *PatientContract) UpdatePatient(ctx contractapi.TransactionContextInterf
 
 // DeletePatient removes a patient record from the ledger
 func (c *PatientContract) DeletePatient(ctx contractapi.TransactionContextInterface, patientID string) error {
-	exists, err := ctx.GetStub().GetState(patientID)
+	exists, err := getResource(ctx, patientObjectType, patientID)
 	if err != nil {
 		return errors.New("failed to get patient: " + err.Error())
 	}
@@ -171,126 +226,217 @@ func (c *PatientContract) DeletePatient(ctx contractapi.TransactionContextInterf
 	}
 
 	// Remove the patient record
-	return ctx.GetStub().DelState(patientID)
+	return deleteResource(ctx, patientObjectType, patientID)
 }
 
 /*
 ================================
-	AUTHORIZATION OPERATIONS
+	IDENTITY OPERATIONS
 ================================
 */
 
-func (c *PatientContract) RequestAccess(ctx contractapi.TransactionContextInterface, patientID string, requesterID string) error {
-	// Check if an authorization record exists
-	authAsBytes, err := ctx.GetStub().GetState("auth_" + patientID)
+// EnrollIdentity links the caller's user ID to the certificate used to submit the transaction.
+// Only patients enroll, and the first certificate enrolled for a user ID keeps it until an
+// admin revokes it. Once enrolled, the user ID is only accepted together with that certificate.
+func (c *PatientContract) EnrollIdentity(ctx contractapi.TransactionContextInterface) error {
+	userID, exists, err := ctx.GetClientIdentity().GetAttributeValue("userId")
 	if err != nil {
-		return err
+		return errors.New("failed to get client ID attribute: " + err.Error())
 	}
-
-	var auth Authorization
-	if authAsBytes != nil {
-		json.Unmarshal(authAsBytes, &auth)
-	} else {
-		auth = Authorization{PatientID: patientID, Authorized: make(map[string]bool)}
+	if !exists {
+		return errors.New("client ID attribute does not exist")
 	}
 
-	// Add the request with False state
-	auth.Authorized[requesterID] = false
-	updatedAuthBytes, err := json.Marshal(auth)
+	identityID, err := ctx.GetClientIdentity().GetID()
 	if err != nil {
-		return err
+		return errors.New("failed to get client ID: " + err.Error())
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return errors.New("failed to get client MSP ID: " + err.Error())
 	}
 
-	return ctx.GetStub().PutState("auth_"+patientID, updatedAuthBytes)
-}
+	existing, err := c.ReadIdentity(ctx, userID)
+	if err == nil {
+		if existing.IdentityID == identityID && existing.MSPID == mspID {
+			return nil // Already enrolled with this certificate
+		}
+		return errors.New("user " + userID + " is already enrolled with another certificate")
+	}
 
-func (c *PatientContract) GrantAccess(ctx contractapi.TransactionContextInterface, patientID string, requesterID string) error {
-	authAsBytes, err := ctx.GetStub().GetState("auth_" + patientID)
+	mapping := IdentityMapping{UserID: userID, IdentityID: identityID, MSPID: mspID}
+	mappingJSON, err := json.Marshal(mapping)
 	if err != nil {
-		return err
-	}
-	if authAsBytes == nil {
-		return errors.New("no authorization record found")
+		return errors.New("failed to marshal identity mapping: " + err.Error())
 	}
 
-	var auth Authorization
-	json.Unmarshal(authAsBytes, &auth)
+	return putResource(ctx, identityObjectType, userID, mappingJSON)
+}
 
-	// Solo il paziente può concedere l'accesso
-	clientID, err := ctx.GetClientIdentity().GetID()
+// ReadIdentity returns the identity mapping of a user
+func (c *PatientContract) ReadIdentity(ctx contractapi.TransactionContextInterface, userID string) (*IdentityMapping, error) {
+	mapping, err := getIdentityMapping(ctx, userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if clientID != patientID {
-		return errors.New("only the patient can grant access")
+	if mapping == nil {
+		return nil, errors.New("no identity enrolled for user: " + userID)
 	}
+	return mapping, nil
+}
 
-	// Concede l'accesso
-	auth.Authorized[requesterID] = true
-	updatedAuthBytes, err := json.Marshal(auth)
-	if err != nil {
+// RevokeIdentity removes the identity mapping of a user, e.g. after the certificate is renewed or lost
+func (c *PatientContract) RevokeIdentity(ctx contractapi.TransactionContextInterface, userID string) error {
+	if _, err := c.ReadIdentity(ctx, userID); err != nil {
 		return err
 	}
+	return deleteResource(ctx, identityObjectType, userID)
+}
 
-	return ctx.GetStub().PutState("auth_"+patientID, updatedAuthBytes)
+// VerifyIdentity succeeds when the caller's certificate may act for their user ID. The other
+// chaincodes call it from auth.GetIdentity to check the certificates of the patients.
+func (c *PatientContract) VerifyIdentity(ctx contractapi.TransactionContextInterface) error {
+	_, err := auth.GetIdentity(ctx)
+	return err
 }
 
-func (c *PatientContract) RevokeAccess(ctx contractapi.TransactionContextInterface, patientID string, requesterID string) error {
-	authAsBytes, err := ctx.GetStub().GetState("auth_" + patientID)
+// checkEnrolledIdentity is the auth.Enrollment of this chaincode. Once a user has enrolled an
+// identity, their user ID is only accepted together with the enrolled certificate.
+func checkEnrolledIdentity(ctx contractapi.TransactionContextInterface, caller *auth.Identity) error {
+	mapping, err := getIdentityMapping(ctx, caller.UserID)
 	if err != nil {
 		return err
 	}
-	if authAsBytes == nil {
-		return errors.New("no authorization record found")
+	if mapping != nil && (mapping.IdentityID != caller.ID || mapping.MSPID != caller.MSPID) {
+		return errors.New("client certificate is not the identity enrolled for " + caller.UserID)
 	}
+	return nil
+}
 
-	var auth Authorization
-	json.Unmarshal(authAsBytes, &auth)
+// getCallerID resolves the caller to the user ID in the userId attribute of their certificate.
+// Every PatientContract method identifies the caller this way. When the user has enrolled an
+// identity, the certificate must be the enrolled one.
+func (c *PatientContract) getCallerID(ctx contractapi.TransactionContextInterface) (string, error) {
+	clientID, exists, err := ctx.GetClientIdentity().GetAttributeValue("userId")
+	if err != nil {
+		return "", errors.New("failed to get client ID attribute: " + err.Error())
+	}
+	if !exists {
+		return "", errors.New("client ID attribute does not exist")
+	}
 
-	// Solo il paziente può revocare l'accesso
-	clientID, err := ctx.GetClientIdentity().GetID()
+	mapping, err := getIdentityMapping(ctx, clientID)
 	if err != nil {
-		return err
+		return "", err
 	}
-	if clientID != patientID {
-		return errors.New("only the patient can revoke access")
+	if mapping == nil {
+		return clientID, nil // No certificate enrolled yet
 	}
 
-	// Revoca l'accesso
-	auth.Authorized[requesterID] = false
-	updatedAuthBytes, err := json.Marshal(auth)
+	identityID, err := ctx.GetClientIdentity().GetID()
 	if err != nil {
-		return err
+		return "", errors.New("failed to get client ID: " + err.Error())
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", errors.New("failed to get client MSP ID: " + err.Error())
 	}
+	if mapping.IdentityID != identityID || mapping.MSPID != mspID {
+		return "", errors.New("client certificate is not the identity enrolled for " + clientID)
+	}
+
+	return clientID, nil
+}
 
-	return ctx.GetStub().PutState("auth_"+patientID, updatedAuthBytes)
+// getCallerMSPID returns the MSP of the organization that enrolled the caller
+func (c *PatientContract) getCallerMSPID(ctx contractapi.TransactionContextInterface) (string, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", errors.New("failed to get client MSP ID: " + err.Error())
+	}
+	return mspID, nil
 }
 
-func (c *PatientContract) isAuthorized(ctx contractapi.TransactionContextInterface, patientID string, clientID string) (bool, error) {
-	authAsBytes, err := ctx.GetStub().GetState("auth_" + patientID)
+// getIdentityMapping returns the identity mapping of a user, or nil when none is enrolled
+func getIdentityMapping(ctx contractapi.TransactionContextInterface, userID string) (*IdentityMapping, error) {
+	mappingJSON, err := getResource(ctx, identityObjectType, userID)
 	if err != nil {
-		return false, errors.New("failed to get authorization data: " + err.Error())
+		return nil, errors.New("failed to get identity mapping: " + err.Error())
 	}
-	if authAsBytes == nil {
-		return false, nil // No Authorization found, no access
+	if mappingJSON == nil {
+		return nil, nil
 	}
 
-	var auth Authorization
-	err = json.Unmarshal(authAsBytes, &auth)
+	var mapping IdentityMapping
+	if err := json.Unmarshal(mappingJSON, &mapping); err != nil {
+		return nil, errors.New("failed to unmarshal identity mapping: " + err.Error())
+	}
+	return &mapping, nil
+}
+
+/*
+================================
+	MIGRATION
+================================
+*/
+
+// MigrateKeys moves up to limit patients and identity mappings stored under bare keys,
+// as written before resources were namespaced, to their composite key, and converts the
+// legacy auth_ authorization records into consents. It returns how many records it
+// migrated; call it until it returns 0.
+func (c *PatientContract) MigrateKeys(ctx contractapi.TransactionContextInterface, limit int) (int, error) {
+	migrated, err := ledger.MigrateKeys(ctx, classifyLegacyKey, limit)
+	if err != nil || migrated == limit {
+		return migrated, err
+	}
+
+	converted, err := c.migrateAuthorizations(ctx, limit-migrated)
+	return migrated + converted, err
+}
+
+// classifyLegacyKey tells identity mappings from patients by the prefix of their key.
+// Authorization records are left to migrateAuthorizations.
+func classifyLegacyKey(key string, value []byte) (string, string, bool) {
+	switch {
+	case strings.HasPrefix(key, legacyIdentityPrefix):
+		return identityObjectType, strings.TrimPrefix(key, legacyIdentityPrefix), true
+	case strings.HasPrefix(key, legacyAuthorizationPrefix):
+		return "", "", false
+	default:
+		return patientObjectType, key, true
+	}
+}
+
+func getResource(ctx contractapi.TransactionContextInterface, objectType string, id string) ([]byte, error) {
+	key, err := ledger.Key(ctx, objectType, id)
 	if err != nil {
-		return false, errors.New("failed to unmarshal authorization data: " + err.Error())
+		return nil, err
 	}
+	return ctx.GetStub().GetState(key)
+}
 
-	// Check if the clientID is in Authorized list and if the access is granted
-	if authorized, ok := auth.Authorized[clientID]; ok && authorized {
-		return true, nil
+func putResource(ctx contractapi.TransactionContextInterface, objectType string, id string, value []byte) error {
+	key, err := ledger.Key(ctx, objectType, id)
+	if err != nil {
+		return err
 	}
-	return false, nil
+	return ctx.GetStub().PutState(key, value)
 }
 
+func deleteResource(ctx contractapi.TransactionContextInterface, objectType string, id string) error {
+	key, err := ledger.Key(ctx, objectType, id)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
 
 func main() {
-	chaincode, err := contractapi.NewChaincode(new(PatientContract))
+	patientContract := new(PatientContract)
+	patientContract.BeforeTransaction = patientEnforcer.BeforeTransaction
+	patientContract.AfterTransaction = history.RecordSubmitter
+
+	chaincode, err := contractapi.NewChaincode(patientContract)
 	if err != nil {
 		log.Panic(errors.New("Error creating patient chaincode: " + err.Error()))
 	}
@@ -9,10 +9,13 @@ import (
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
 )
 
 // MockStub is a mock implementation of the ChaincodeStubInterface
@@ -316,16 +319,65 @@ func (mci *MockClientIdentity) GetX509Certificate() (*x509.Certificate, error) {
 
 func generatePatientJSON(id string) string {
 	now := time.Now()
-	patient := Patient{
-		ID:        &Identifier{Value: id},
-		Name:      &HumanName{Family: "Smith", Given: []string{"John"}},
-		Gender:    &Code{Coding: []Coding{{System: "http://hl7.org/fhir/ValueSet/administrative-gender", Code: "male", Display: "Male"}}},
-		BirthDate: &now,
+	patient := fhir.Patient{
+		ID:        &fhir.Identifier{Value: id},
+		Name:      &fhir.HumanName{Family: "Smith", Given: []string{"John"}},
+		Gender:    &fhir.Code{Coding: []fhir.Coding{{System: "http://hl7.org/fhir/ValueSet/administrative-gender", Code: "male", Display: "Male"}}},
+		BirthDate: now,
 	}
 	patientJSON, _ := json.Marshal(patient)
 	return string(patientJSON)
 }
 
+// requesterMSP is the organization of the requesters in the consent tests
+const requesterMSP = "OspedaleMarescaMSP"
+
+// mockConsent sets up the stored consent of patientID concerning requesterID of requesterMSP; nil means none
+func mockConsent(stub *MockStub, patientID string, requesterID string, consent *fhir.Consent) string {
+	return mockConsentOf(stub, patientID, requesterMSP, requesterID, consent)
+}
+
+// mockConsentOf sets up the stored consent of patientID concerning requesterID of mspID; nil means none
+func mockConsentOf(stub *MockStub, patientID string, mspID string, requesterID string, consent *fhir.Consent) string {
+	consentKey := "\x00Consent\x00" + patientID + "\x00" + mspID + "\x00" + requesterID + "\x00"
+	stub.On("CreateCompositeKey", "Consent", []string{patientID, mspID, requesterID}).Return(consentKey, nil)
+	if consent == nil {
+		stub.On("GetState", consentKey).Return(nil, nil)
+	} else {
+		consentBytes, _ := json.Marshal(consent)
+		stub.On("GetState", consentKey).Return(consentBytes, nil)
+	}
+	return consentKey
+}
+
+func activeConsent(patientID string, requesterID string, provisions ...fhir.ConsentProvision) *fhir.Consent {
+	consent := newConsent(patientID, requesterMSP, requesterID)
+	consent.Status = ConsentActive
+	consent.Provision = provisions
+	return consent
+}
+
+// mockCaller sets up the certificate of the caller of a transaction
+func mockCaller(ctx *MockTransactionContext, mspID string, userID string, role string) {
+	clientIdentity := new(MockClientIdentity)
+	clientIdentity.On("GetID").Return("x509::CN="+userID, nil)
+	clientIdentity.On("GetMSPID").Return(mspID, nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(userID, true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return(role, true, nil)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+}
+
+func mockTxTimestamp(stub *MockStub, now time.Time) {
+	stub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: now.Unix()}, nil)
+}
+
+// mockKey expects the composite key objectType~id to be created and returns it
+func mockKey(stub *MockStub, objectType string, id string) string {
+	key := "\x00" + objectType + "\x00" + id + "\x00"
+	stub.On("CreateCompositeKey", objectType, []string{id}).Return(key, nil)
+	return key
+}
+
 func TestCreatePatient_Success(t *testing.T) {
 	patientContract := new(PatientContract)
 
@@ -336,8 +388,9 @@ func TestCreatePatient_Success(t *testing.T) {
 	patientID := "patient-001"
 	patientJSON := generatePatientJSON(patientID)
 
-	stub.On("GetState", patientID).Return(nil, nil)
-	stub.On("PutState", patientID, mock.Anything).Return(nil)
+	patientKey := mockKey(stub, "Patient", patientID)
+	stub.On("GetState", patientKey).Return(nil, nil)
+	stub.On("PutState", patientKey, mock.Anything).Return(nil)
 
 	err := patientContract.CreatePatient(txContext, patientJSON)
 
@@ -358,7 +411,8 @@ func TestCreatePatient_FailureExists(t *testing.T) {
 	patientID := "patient-001"
 	patientJSON := generatePatientJSON(patientID)
 
-	stub.On("GetState", patientID).Return([]byte("existing patient data"), nil) // Simulate that the patient already exists
+	patientKey := mockKey(stub, "Patient", patientID)
+	stub.On("GetState", patientKey).Return([]byte("existing patient data"), nil) // Simulate that the patient already exists
 
 	err := patientContract.CreatePatient(txContext, patientJSON)
 
@@ -379,7 +433,8 @@ func TestCreatePatient_FailureJSONError(t *testing.T) {
 	patientID := "patient-001"
 	invalidJSON := "{" // Malformed JSON
 
-	stub.On("GetState", patientID).Return(nil, nil)
+	patientKey := mockKey(stub, "Patient", patientID)
+	stub.On("GetState", patientKey).Return(nil, nil)
 	err := patientContract.CreatePatient(txContext, invalidJSON)
 
 	assert.Error(t, err)
@@ -403,12 +458,14 @@ func TestUpdatePatient_Success(t *testing.T) {
 	dummyCert := &x509.Certificate{} // Prepare a dummy certificate if needed
 
 	// Mocking the conditions are met, if they depend on identity checks
-	clientIdentity.On("GetID").Return(patientID, nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(patientID, true, nil)
+	patientKey := mockKey(stub, "Patient", patientID)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", patientID)).Return(nil, nil)
 	// Only set this expectation if your chaincode logic definitely calls it under test conditions
 	clientIdentity.On("GetX509Certificate").Maybe().Return(dummyCert, nil) // Use Maybe() for conditional expectations
 
-	stub.On("GetState", patientID).Return(patientBytes, nil)
-	stub.On("PutState", patientID, mock.Anything).Return(nil)
+	stub.On("GetState", patientKey).Return(patientBytes, nil)
+	stub.On("PutState", patientKey, mock.Anything).Return(nil)
 
 	err := patientContract.UpdatePatient(txContext, patientID, patientJSON)
 
@@ -432,13 +489,15 @@ func TestUpdatePatient_Unauthorized(t *testing.T) {
 	patientBytes := []byte(patientJSON)
 
 	// Mock setup to return patient data
-	stub.On("GetState", patientID).Return(patientBytes, nil)
+	patientKey := mockKey(stub, "Patient", patientID)
+	stub.On("GetState", patientKey).Return(patientBytes, nil)
 
-	// Set up mock for authorization data retrieval
-	authKey := "auth_" + patientID
-	stub.On("GetState", authKey).Return(nil, nil) // Assuming no authorization data is found
+	// Set up mock for consent retrieval
+	mockConsent(stub, patientID, unauthorizedID, nil) // Assuming no consent is found
 
-	clientIdentity.On("GetID").Return(unauthorizedID, nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(unauthorizedID, true, nil)
+	clientIdentity.On("GetMSPID").Return(requesterMSP, nil)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", unauthorizedID)).Return(nil, nil)
 
 	err := patientContract.UpdatePatient(txContext, patientID, patientJSON)
 
@@ -458,8 +517,9 @@ func TestDeletePatient_Success(t *testing.T) {
 	patientBytes := []byte(generatePatientJSON(patientID))
 
 	// Mock the ledger response for existing patient
-	stub.On("GetState", patientID).Return(patientBytes, nil)
-	stub.On("DelState", patientID).Return(nil)
+	patientKey := mockKey(stub, "Patient", patientID)
+	stub.On("GetState", patientKey).Return(patientBytes, nil)
+	stub.On("DelState", patientKey).Return(nil)
 
 	// Execute the DeletePatient function
 	err := patientContract.DeletePatient(txContext, patientID)
@@ -477,7 +537,8 @@ func TestDeletePatient_NonExistent(t *testing.T) {
 	nonExistentID := "patient-999" // Assuming this ID does not exist in the ledger
 
 	// Setup stub to simulate no patient found for this ID
-	stub.On("GetState", nonExistentID).Return(nil, nil)
+	patientKey := mockKey(stub, "Patient", nonExistentID)
+	stub.On("GetState", patientKey).Return(nil, nil)
 
 	err := patientContract.DeletePatient(txContext, nonExistentID)
 
@@ -502,10 +563,12 @@ func TestReadPatient_SuccessByPatient(t *testing.T) {
 	patientBytes := []byte(patientData)
 
 	// Mock GetState to return the patient JSON data
-	stub.On("GetState", patientID).Return(patientBytes, nil)
+	patientKey := mockKey(stub, "Patient", patientID)
+	stub.On("GetState", patientKey).Return(patientBytes, nil)
 
 	// Mock GetAttributeValue to return the patient ID for the userId attribute
 	clientIdentity.On("GetAttributeValue", "userId").Return(patientID, true, nil)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", patientID)).Return(nil, nil)
 
 	// Call the ReadPatient method
 	patientJSON, err := contract.ReadPatient(ctx, patientID)
@@ -517,7 +580,7 @@ func TestReadPatient_SuccessByPatient(t *testing.T) {
 	assert.NotNil(t, patientJSON)
 
 	// Unmarshal the returned patient JSON
-	var patient Patient
+	var patient fhir.Patient
 	err = json.Unmarshal([]byte(patientJSON), &patient)
 
 	// Assert no error during unmarshaling
@@ -547,14 +610,16 @@ func TestReadPatient_SuccessByAuthorizedUser(t *testing.T) {
 	patientBytes := []byte(patientData)
 
 	// Mock patient data retrieval
-	stub.On("GetState", patientID).Return(patientBytes, nil)
+	patientKey := mockKey(stub, "Patient", patientID)
+	stub.On("GetState", patientKey).Return(patientBytes, nil)
 	// Mock client identity retrieval
 	clientIdentity.On("GetAttributeValue", "userId").Return(clientID, true, nil)
+	clientIdentity.On("GetMSPID").Return(requesterMSP, nil)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", clientID)).Return(nil, nil)
 
-	// Set up authorization data to indicate that the doctor is authorized
-	authData := Authorization{PatientID: patientID, Authorized: map[string]bool{clientID: true}}
-	authBytes, _ := json.Marshal(authData)
-	stub.On("GetState", "auth_"+patientID).Return(authBytes, nil)
+	// Set up a consent to indicate that the doctor is authorized
+	mockConsent(stub, patientID, clientID, activeConsent(patientID, clientID, fhir.ConsentProvision{}))
+	mockTxTimestamp(stub, time.Now())
 
 	// Attempt to read the patient data as an authorized user
 	patientJSON, err := contract.ReadPatient(ctx, patientID)
@@ -562,7 +627,7 @@ func TestReadPatient_SuccessByAuthorizedUser(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, patientJSON)
 
-	var patient Patient
+	var patient fhir.Patient
 	err = json.Unmarshal([]byte(patientJSON), &patient)
 	assert.Nil(t, err)
 	assert.Equal(t, "John", patient.Name.Given[0]) // Example of accessing nested fields
@@ -589,11 +654,17 @@ func TestReadPatient_UnauthorizedAccess(t *testing.T) {
 	patientBytes := []byte(patientData)
 
 	// Mock patient data retrieval
-	stub.On("GetState", patientID).Return(patientBytes, nil)
+	patientKey := mockKey(stub, "Patient", patientID)
+	stub.On("GetState", patientKey).Return(patientBytes, nil)
 	// Mock client identity retrieval
 	clientIdentity.On("GetAttributeValue", "userId").Return(unauthorizedClientID, true, nil)
-	// Mock authorization retrieval to return nil (no authorization found)
-	stub.On("GetState", "auth_"+patientID).Return(nil, nil)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", unauthorizedClientID)).Return(nil, nil)
+	// Mock consent retrieval to return nil (no consent found)
+	mockConsentOf(stub, patientID, "MedicinaGeneraleNapoliMSP", unauthorizedClientID, nil)
+	// A clinic is not part of the emergency channel, so no emergency access is looked up
+	clientIdentity.On("GetID").Return("x509::CN="+unauthorizedClientID, nil)
+	clientIdentity.On("GetMSPID").Return("MedicinaGeneraleNapoliMSP", nil)
+	clientIdentity.On("GetAttributeValue", "role").Return("doctor", true, nil)
 
 	// Attempt to read the patient data as an unauthorized user
 	patientJSON, err := contract.ReadPatient(ctx, patientID)
@@ -611,6 +682,75 @@ func TestReadPatient_UnauthorizedAccess(t *testing.T) {
 }
 
 
+func TestReadPatient_SuccessByEmergencyAccess(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+
+	patientID := "patient-001"
+	clientID := "doctor-009"
+	patientKey := mockKey(stub, "Patient", patientID)
+	stub.On("GetState", patientKey).Return([]byte(generatePatientJSON(patientID)), nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(clientID, true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return("doctor", true, nil)
+	clientIdentity.On("GetID").Return("x509::CN="+clientID, nil)
+	clientIdentity.On("GetMSPID").Return("OspedaleDelMareMSP", nil)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", clientID)).Return(nil, nil)
+
+	// No consent, but the doctor broke the glass on the emergency channel
+	mockConsentOf(stub, patientID, "OspedaleDelMareMSP", clientID, nil)
+	args := [][]byte{[]byte("FindEmergencyAccess"), []byte(patientID), []byte("OspedaleDelMareMSP"), []byte(clientID)}
+	stub.On("InvokeChaincode", "emergency", args, "emergency-channel").Return(peer.Response{Status: 200, Payload: []byte("tx-emergency-001")})
+
+	patientJSON, err := contract.ReadPatient(ctx, patientID)
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, patientJSON)
+	stub.AssertExpectations(t)
+}
+
+func TestAuditedReadPatient_RecordsTheRead(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+
+	patientID := "patient-001"
+	clientID := "doctor-002"
+	patientKey := mockKey(stub, "Patient", patientID)
+	stub.On("GetState", patientKey).Return([]byte(generatePatientJSON(patientID)), nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(clientID, true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return("doctor", true, nil)
+	clientIdentity.On("GetID").Return("x509::CN="+clientID, nil)
+	clientIdentity.On("GetMSPID").Return("MedicinaGeneraleNapoliMSP", nil)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", clientID)).Return(nil, nil)
+
+	mockConsentOf(stub, patientID, "MedicinaGeneraleNapoliMSP", clientID, activeConsent(patientID, clientID, fhir.ConsentProvision{}))
+	mockTxTimestamp(stub, time.Now())
+	stub.On("GetTxID").Return("tx-001")
+	logKey := "\x00AccessLog\x00" + patientID + "\x00tx-001\x00"
+	stub.On("CreateCompositeKey", "AccessLog", []string{patientID, "tx-001"}).Return(logKey, nil)
+	stub.On("PutState", logKey, mock.MatchedBy(func(value []byte) bool {
+		var entry auth.AccessLogEntry
+		return json.Unmarshal(value, &entry) == nil &&
+			entry.Function == "ReadPatient" && entry.UserID == clientID &&
+			entry.Grant.Basis == auth.BasisConsent && entry.Grant.ID == auth.ConsentID(patientID, "MedicinaGeneraleNapoliMSP", clientID)
+	})).Return(nil)
+
+	patientJSON, err := contract.AuditedReadPatient(ctx, patientID)
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, patientJSON)
+	stub.AssertExpectations(t)
+}
+
 func TestReadPatient_NonExistentPatient(t *testing.T) {
 	contract := new(PatientContract)
 	stub := new(MockStub)
@@ -621,7 +761,8 @@ func TestReadPatient_NonExistentPatient(t *testing.T) {
 	nonExistentPatientID := "patient-999"
 
 	// Mock GetState to return nil, indicating the patient does not exist
-	stub.On("GetState", nonExistentPatientID).Return(nil, nil)
+	patientKey := mockKey(stub, "Patient", nonExistentPatientID)
+	stub.On("GetState", patientKey).Return(nil, nil)
 
 	// Attempt to read the non-existent patient data
 	patientJSON, err := contract.ReadPatient(ctx, nonExistentPatientID)
@@ -641,14 +782,24 @@ func TestRequestAccess_NewAuthorization(t *testing.T) {
 	contract := new(PatientContract)
 	stub := new(MockStub)
 	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
 
 	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
 	patientID := "patient-001"
 	requesterID := "doctor-001"
 
-	// Assume no existing authorization record
-	stub.On("GetState", "auth_"+patientID).Return(nil, nil)
-	stub.On("PutState", "auth_"+patientID, mock.Anything).Return(nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(requesterID, true, nil)
+	clientIdentity.On("GetMSPID").Return(requesterMSP, nil)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", requesterID)).Return(nil, nil)
+
+	// Assume no existing consent
+	consentKey := mockConsent(stub, patientID, requesterID, nil)
+	mockTxTimestamp(stub, time.Now())
+	stub.On("PutState", consentKey, mock.MatchedBy(func(value []byte) bool {
+		var consent fhir.Consent
+		return json.Unmarshal(value, &consent) == nil && consent.Status == ConsentDraft
+	})).Return(nil)
 
 	err := contract.RequestAccess(ctx, patientID, requesterID)
 
@@ -660,20 +811,25 @@ func TestRequestAccess_ExistingAuthorization(t *testing.T) {
 	contract := new(PatientContract)
 	stub := new(MockStub)
 	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
 
 	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
 	patientID := "patient-001"
 	requesterID := "doctor-001"
 
-	existingAuth := Authorization{PatientID: patientID, Authorized: make(map[string]bool)}
-	existingAuthBytes, _ := json.Marshal(existingAuth)
-	stub.On("GetState", "auth_"+patientID).Return(existingAuthBytes, nil)
-	stub.On("PutState", "auth_"+patientID, mock.Anything).Return(nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(requesterID, true, nil)
+	clientIdentity.On("GetMSPID").Return(requesterMSP, nil)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", requesterID)).Return(nil, nil)
+
+	// An active consent is left untouched
+	mockConsent(stub, patientID, requesterID, activeConsent(patientID, requesterID, fhir.ConsentProvision{}))
 
 	err := contract.RequestAccess(ctx, patientID, requesterID)
 
 	assert.Nil(t, err)
 	stub.AssertExpectations(t)
+	stub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
 }
 
 func TestGrantAccess_Success(t *testing.T) {
@@ -687,13 +843,18 @@ func TestGrantAccess_Success(t *testing.T) {
 	patientID := "patient-001"
 	requesterID := "doctor-001"
 
-	auth := Authorization{PatientID: patientID, Authorized: map[string]bool{requesterID: false}}
-	authBytes, _ := json.Marshal(auth)
-	stub.On("GetState", "auth_"+patientID).Return(authBytes, nil)
-	stub.On("PutState", "auth_"+patientID, mock.Anything).Return(nil)
-	clientIdentity.On("GetID").Return(patientID, nil)
+	consent := newConsent(patientID, requesterMSP, requesterID)
+	consent.Status = ConsentDraft
+	consentKey := mockConsent(stub, patientID, requesterID, consent)
+	mockTxTimestamp(stub, time.Now())
+	stub.On("PutState", consentKey, mock.MatchedBy(func(value []byte) bool {
+		var consent fhir.Consent
+		return json.Unmarshal(value, &consent) == nil && consent.Status == ConsentActive && len(consent.Provision) == 1
+	})).Return(nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(patientID, true, nil)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", patientID)).Return(nil, nil)
 
-	err := contract.GrantAccess(ctx, patientID, requesterID)
+	err := contract.GrantAccess(ctx, patientID, requesterMSP, requesterID)
 
 	assert.Nil(t, err)
 	stub.AssertExpectations(t)
@@ -711,15 +872,462 @@ func TestRevokeAccess_Success(t *testing.T) {
 	patientID := "patient-001"
 	requesterID := "doctor-001"
 
-	auth := Authorization{PatientID: patientID, Authorized: map[string]bool{requesterID: true}}
-	authBytes, _ := json.Marshal(auth)
-	stub.On("GetState", "auth_"+patientID).Return(authBytes, nil)
-	stub.On("PutState", "auth_"+patientID, mock.Anything).Return(nil)
-	clientIdentity.On("GetID").Return(patientID, nil)
+	consentKey := mockConsent(stub, patientID, requesterID, activeConsent(patientID, requesterID, fhir.ConsentProvision{}))
+	mockTxTimestamp(stub, time.Now())
+	stub.On("PutState", consentKey, mock.MatchedBy(func(value []byte) bool {
+		var consent fhir.Consent
+		return json.Unmarshal(value, &consent) == nil && consent.Status == ConsentInactive && len(consent.Provision) == 0
+	})).Return(nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(patientID, true, nil)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", patientID)).Return(nil, nil)
 
-	err := contract.RevokeAccess(ctx, patientID, requesterID)
+	err := contract.RevokeAccess(ctx, patientID, requesterMSP, requesterID)
 
 	assert.Nil(t, err)
 	stub.AssertExpectations(t)
 	clientIdentity.AssertExpectations(t)
-}
\ No newline at end of file
+}
+
+func TestRequestAccess_OnBehalfOfAnotherUser(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	clientIdentity.On("GetAttributeValue", "userId").Return("doctor-002", true, nil)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", "doctor-002")).Return(nil, nil)
+
+	err := contract.RequestAccess(ctx, "patient-001", "doctor-001")
+
+	assert.EqualError(t, err, "requester ID does not match the caller")
+	stub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestGrantAccess_EnrolledPatient(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	patientID := "patient-001"
+	requesterID := "doctor-001"
+
+	mapping := IdentityMapping{UserID: patientID, IdentityID: "x509::CN=patient-001", MSPID: "OspedaleMarescaMSP"}
+	mappingBytes, _ := json.Marshal(mapping)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", patientID)).Return(mappingBytes, nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(patientID, true, nil)
+	clientIdentity.On("GetID").Return("x509::CN=patient-001", nil)
+	clientIdentity.On("GetMSPID").Return("OspedaleMarescaMSP", nil)
+
+	consentKey := mockConsent(stub, patientID, requesterID, nil)
+	mockTxTimestamp(stub, time.Now())
+	stub.On("PutState", consentKey, mock.Anything).Return(nil)
+
+	err := contract.GrantAccess(ctx, patientID, requesterMSP, requesterID)
+
+	assert.Nil(t, err)
+	stub.AssertExpectations(t)
+	clientIdentity.AssertExpectations(t)
+}
+
+func TestGrantAccess_CertificateNotEnrolled(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	patientID := "patient-001"
+
+	// The userId attribute matches, but the certificate is not the enrolled one
+	mapping := IdentityMapping{UserID: patientID, IdentityID: "x509::CN=patient-001", MSPID: "OspedaleMarescaMSP"}
+	mappingBytes, _ := json.Marshal(mapping)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", patientID)).Return(mappingBytes, nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(patientID, true, nil)
+	clientIdentity.On("GetID").Return("x509::CN=impostor", nil)
+	clientIdentity.On("GetMSPID").Return("OspedaleMarescaMSP", nil)
+
+	err := contract.GrantAccess(ctx, patientID, requesterMSP, "doctor-001")
+
+	assert.EqualError(t, err, "client certificate is not the identity enrolled for "+patientID)
+	stub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestEnrollIdentity_Success(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	clientIdentity.On("GetAttributeValue", "userId").Return("patient-001", true, nil)
+	clientIdentity.On("GetID").Return("x509::CN=patient-001", nil)
+	clientIdentity.On("GetMSPID").Return("OspedaleMarescaMSP", nil)
+	identityKey := mockKey(stub, "IdentityMapping", "patient-001")
+	stub.On("GetState", identityKey).Return(nil, nil)
+
+	expected, _ := json.Marshal(IdentityMapping{UserID: "patient-001", IdentityID: "x509::CN=patient-001", MSPID: "OspedaleMarescaMSP"})
+	stub.On("PutState", identityKey, expected).Return(nil)
+
+	err := contract.EnrollIdentity(ctx)
+
+	assert.Nil(t, err)
+	stub.AssertExpectations(t)
+}
+
+func TestEnrollIdentity_AlreadyEnrolledWithAnotherCertificate(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	clientIdentity.On("GetAttributeValue", "userId").Return("patient-001", true, nil)
+	clientIdentity.On("GetID").Return("x509::CN=impostor", nil)
+	clientIdentity.On("GetMSPID").Return("OspedaleMarescaMSP", nil)
+
+	mappingBytes, _ := json.Marshal(IdentityMapping{UserID: "patient-001", IdentityID: "x509::CN=patient-001", MSPID: "OspedaleMarescaMSP"})
+	identityKey := mockKey(stub, "IdentityMapping", "patient-001")
+	stub.On("GetState", identityKey).Return(mappingBytes, nil)
+
+	err := contract.EnrollIdentity(ctx)
+
+	assert.EqualError(t, err, "user patient-001 is already enrolled with another certificate")
+	stub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestGrantTemporaryAccess_Success(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	patientID := "patient-001"
+	requesterID := "doctor-001"
+	now := time.Date(2024, time.May, 1, 9, 0, 0, 0, time.UTC)
+
+	clientIdentity.On("GetAttributeValue", "userId").Return(patientID, true, nil)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", patientID)).Return(nil, nil)
+	consentKey := mockConsent(stub, patientID, requesterID, nil)
+	mockTxTimestamp(stub, now)
+	stub.On("PutState", consentKey, mock.MatchedBy(func(value []byte) bool {
+		var consent fhir.Consent
+		if json.Unmarshal(value, &consent) != nil || len(consent.Provision) != 1 {
+			return false
+		}
+		period := consent.Provision[0].Period
+		return consent.Status == ConsentActive && period.Start.Equal(now) && period.End.Equal(now.AddDate(0, 0, 30))
+	})).Return(nil)
+
+	err := contract.GrantTemporaryAccess(ctx, patientID, requesterMSP, requesterID, 30)
+
+	assert.Nil(t, err)
+	stub.AssertExpectations(t)
+}
+
+func TestGrantTemporaryAccess_RejectsEmptyDuration(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+
+	ctx.On("GetStub").Return(stub)
+
+	err := contract.GrantTemporaryAccess(ctx, "patient-001", requesterMSP, "doctor-001", 0)
+
+	assert.EqualError(t, err, "access duration must be at least one day")
+}
+
+func TestSweepExpiredConsents(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+
+	ctx.On("GetStub").Return(stub)
+	patientID := "patient-001"
+	now := time.Date(2024, time.May, 1, 9, 0, 0, 0, time.UTC)
+	lastWeek := now.AddDate(0, 0, -7)
+	nextWeek := now.AddDate(0, 0, 7)
+
+	expired := activeConsent(patientID, "doctor-001", fhir.ConsentProvision{Period: &fhir.Period{End: lastWeek}})
+	partly := activeConsent(patientID, "doctor-002",
+		fhir.ConsentProvision{Period: &fhir.Period{End: lastWeek}},
+		fhir.ConsentProvision{Period: &fhir.Period{End: nextWeek}},
+	)
+	current := activeConsent(patientID, "doctor-003", fhir.ConsentProvision{})
+
+	iterator := new(MockIterator)
+	for _, consent := range []*fhir.Consent{expired, partly, current} {
+		consentBytes, _ := json.Marshal(consent)
+		iterator.AddRecord("key-"+consent.ID, consentBytes)
+	}
+	stub.On("GetStateByPartialCompositeKey", "Consent", []string{patientID}).Return(iterator, nil)
+	mockTxTimestamp(stub, now)
+	stub.On("PutState", "key-"+expired.ID, mock.MatchedBy(func(value []byte) bool {
+		var consent fhir.Consent
+		return json.Unmarshal(value, &consent) == nil && consent.Status == ConsentInactive && len(consent.Provision) == 0
+	})).Return(nil)
+	stub.On("PutState", "key-"+partly.ID, mock.MatchedBy(func(value []byte) bool {
+		var consent fhir.Consent
+		return json.Unmarshal(value, &consent) == nil && consent.Status == ConsentActive && len(consent.Provision) == 1
+	})).Return(nil)
+
+	swept, err := contract.SweepExpiredConsents(ctx, patientID)
+
+	assert.Nil(t, err)
+	assert.Len(t, swept, 2)
+	stub.AssertExpectations(t)
+	stub.AssertNotCalled(t, "PutState", "key-"+current.ID, mock.Anything)
+}
+
+func TestPoliciesCoverEveryTransaction(t *testing.T) {
+	assert.Empty(t, auth.MissingPolicies(new(PatientContract), patientPolicies), "transactions without an access policy")
+}
+
+func TestEvaluateConsent(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+
+	ctx.On("GetStub").Return(stub)
+	patientID := "patient-001"
+	now := time.Now()
+	lastMonth := now.AddDate(0, -1, 0)
+	nextMonth := now.AddDate(0, 1, 0)
+
+	// doctor-001 may read lab results for treatment until next month
+	labResults := fhir.ConsentProvision{
+		Period:       &fhir.Period{Start: lastMonth, End: nextMonth},
+		Action:       []fhir.CodeableConcept{{Coding: []fhir.Coding{{Code: auth.ActionRead}}}},
+		Purpose:      []fhir.Coding{{Code: auth.PurposeTreatment}},
+		ResourceType: []fhir.Coding{{Code: "Observation"}},
+	}
+	mockConsent(stub, patientID, "doctor-001", activeConsent(patientID, "doctor-001", labResults))
+	// doctor-002 had access until last month
+	expired := fhir.ConsentProvision{Period: &fhir.Period{End: lastMonth}}
+	mockConsent(stub, patientID, "doctor-002", activeConsent(patientID, "doctor-002", expired))
+	mockConsent(stub, patientID, "doctor-003", nil)
+	// The same userId enrolled by another organization is another requester
+	mockConsentOf(stub, patientID, "OspedaleDelMareMSP", "doctor-001", nil)
+	mockTxTimestamp(stub, now)
+	// The patient may evaluate any of their consents
+	mockCaller(ctx, "PatientMSP", patientID, "patient")
+	stub.On("GetState", mockKey(stub, "IdentityMapping", patientID)).Return(nil, nil)
+
+	tests := []struct {
+		requesterMSP string
+		requesterID  string
+		resourceType string
+		action       string
+		purpose      string
+		granted      bool
+	}{
+		{"PatientMSP", patientID, "Observation", auth.ActionWrite, auth.PurposeTreatment, true},
+		{requesterMSP, "doctor-001", "Observation", auth.ActionRead, auth.PurposeTreatment, true},
+		{requesterMSP, "doctor-001", "Observation", auth.ActionWrite, auth.PurposeTreatment, false},
+		{requesterMSP, "doctor-001", "Observation", auth.ActionRead, auth.PurposeResearch, false},
+		{requesterMSP, "doctor-001", "MedicationRequest", auth.ActionRead, auth.PurposeTreatment, false},
+		{requesterMSP, "doctor-002", "Observation", auth.ActionRead, auth.PurposeTreatment, false},
+		{requesterMSP, "doctor-003", "Observation", auth.ActionRead, auth.PurposeTreatment, false},
+		{"OspedaleDelMareMSP", "doctor-001", "Observation", auth.ActionRead, auth.PurposeTreatment, false},
+	}
+	for _, test := range tests {
+		granted, err := contract.EvaluateConsent(ctx, patientID, test.requesterMSP, test.requesterID, test.resourceType, test.action, test.purpose)
+		assert.Nil(t, err)
+		assert.Equal(t, test.granted, granted, "%s %s %s %s %s", test.requesterMSP, test.requesterID, test.resourceType, test.action, test.purpose)
+	}
+}
+
+func TestEvaluateConsent_NestedProvisionIsAnException(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+
+	ctx.On("GetStub").Return(stub)
+	patientID := "patient-001"
+
+	// Everything but prescriptions
+	provision := fhir.ConsentProvision{
+		Provision: []fhir.ConsentProvision{{ResourceType: []fhir.Coding{{Code: "MedicationRequest"}}}},
+	}
+	mockConsent(stub, patientID, "doctor-001", activeConsent(patientID, "doctor-001", provision))
+	mockTxTimestamp(stub, time.Now())
+	mockCaller(ctx, requesterMSP, "doctor-001", "doctor")
+
+	granted, err := contract.EvaluateConsent(ctx, patientID, requesterMSP, "doctor-001", "Observation", auth.ActionRead, auth.PurposeTreatment)
+	assert.Nil(t, err)
+	assert.True(t, granted)
+
+	granted, err = contract.EvaluateConsent(ctx, patientID, requesterMSP, "doctor-001", "MedicationRequest", auth.ActionRead, auth.PurposeTreatment)
+	assert.Nil(t, err)
+	assert.False(t, granted)
+}
+
+func TestEvaluateConsent_RejectsOtherCallers(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+
+	ctx.On("GetStub").Return(stub)
+	mockCaller(ctx, "OspedaleDelMareMSP", "doctor-001", "doctor")
+
+	granted, err := contract.EvaluateConsent(ctx, "patient-001", requesterMSP, "doctor-001", "Observation", auth.ActionRead, auth.PurposeTreatment)
+
+	assert.EqualError(t, err, "only the requester or the patient may evaluate a consent")
+	assert.False(t, granted)
+	stub.AssertNotCalled(t, "GetState", mock.Anything)
+}
+
+func TestGrantConsent_RejectsUnsupportedPurpose(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+
+	ctx.On("GetStub").Return(stub)
+
+	err := contract.GrantConsent(ctx, "patient-001", requesterMSP, "doctor-001", `{"purpose":[{"code":"MARKETING"}]}`)
+
+	assert.EqualError(t, err, "invalid consent provision: unsupported purpose MARKETING")
+	stub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestBeforeTransaction_PatientCannotGrantAccessToOthersData(t *testing.T) {
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	stub.On("GetFunctionAndParameters").Return("GrantAccess", []string{"patient-002", requesterMSP, "doctor-001"})
+	clientIdentity.On("GetID").Return("x509::CN=patient-001", nil)
+	clientIdentity.On("GetMSPID").Return("PatientMSP", nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return("patient-001", true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return("patient", true, nil)
+	stub.On("GetState", mockKey(stub, "IdentityMapping", "patient-001")).Return(nil, nil)
+
+	err := patientEnforcer.BeforeTransaction(ctx)
+
+	assert.EqualError(t, err, "access denied: patients may only access their own data")
+}
+
+func TestBeforeTransaction_RejectsCertificateNotEnrolled(t *testing.T) {
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	stub.On("GetFunctionAndParameters").Return("ReadPatient", []string{"patient-001"})
+	clientIdentity.On("GetID").Return("x509::CN=impostor", nil)
+	clientIdentity.On("GetMSPID").Return("PatientMSP", nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return("patient-001", true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return("patient", true, nil)
+	mappingBytes, _ := json.Marshal(IdentityMapping{UserID: "patient-001", IdentityID: "x509::CN=patient-001", MSPID: "PatientMSP"})
+	stub.On("GetState", mockKey(stub, "IdentityMapping", "patient-001")).Return(mappingBytes, nil)
+
+	err := patientEnforcer.BeforeTransaction(ctx)
+
+	assert.EqualError(t, err, "client certificate is not the identity enrolled for patient-001")
+}
+
+func TestBeforeTransaction_OnlyPatientsEnrollIdentities(t *testing.T) {
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	stub.On("GetFunctionAndParameters").Return("EnrollIdentity", []string{})
+	clientIdentity.On("GetID").Return("x509::CN=patient-001", nil)
+	clientIdentity.On("GetMSPID").Return("OspedaleMarescaMSP", nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return("patient-001", true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return("doctor", true, nil)
+
+	err := patientEnforcer.BeforeTransaction(ctx)
+
+	assert.EqualError(t, err, "access denied: organization OspedaleMarescaMSP may not call EnrollIdentity")
+}
+
+func TestBeforeTransaction_NurseCannotDeletePatient(t *testing.T) {
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	clientIdentity := new(MockClientIdentity)
+
+	ctx.On("GetStub").Return(stub)
+	ctx.On("GetClientIdentity").Return(clientIdentity)
+	stub.On("GetFunctionAndParameters").Return("PatientContract:DeletePatient", []string{"patient-001"})
+	clientIdentity.On("GetID").Return("x509::CN=nurse-001", nil)
+	clientIdentity.On("GetMSPID").Return("OspedaleMarescaMSP", nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return("nurse-001", true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return("nurse", true, nil)
+
+	err := patientEnforcer.BeforeTransaction(ctx)
+
+	assert.EqualError(t, err, "access denied: role nurse may not call DeletePatient")
+}
+
+func TestMigrateKeys(t *testing.T) {
+	contract := new(PatientContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+
+	ctx.On("GetStub").Return(stub)
+	patientID := "patient-001"
+	mockTxTimestamp(stub, time.Date(2024, time.May, 1, 9, 0, 0, 0, time.UTC))
+
+	patientBytes := []byte(generatePatientJSON(patientID))
+	mappingBytes, _ := json.Marshal(IdentityMapping{UserID: patientID, IdentityID: "x509::CN=patient-001", MSPID: "PatientMSP"})
+	authorizationBytes := []byte(`{"patientId":"patient-001","authorized":{"doctor-001":true,"nurse-001":false,"doctor-002":true}}`)
+
+	legacy := new(MockIterator)
+	legacy.AddRecord("auth_"+patientID, authorizationBytes)
+	legacy.AddRecord("identity_"+patientID, mappingBytes)
+	legacy.AddRecord(patientID, patientBytes)
+	stub.On("GetStateByRange", "", "").Return(legacy, nil)
+
+	identityKey := mockKey(stub, "IdentityMapping", patientID)
+	stub.On("GetState", identityKey).Return(nil, nil)
+	stub.On("PutState", identityKey, mappingBytes).Return(nil)
+	stub.On("DelState", "identity_"+patientID).Return(nil)
+	patientKey := mockKey(stub, "Patient", patientID)
+	stub.On("GetState", patientKey).Return(nil, nil)
+	stub.On("PutState", patientKey, patientBytes).Return(nil)
+	stub.On("DelState", patientID).Return(nil)
+
+	// The authorization record becomes one consent per requester; doctor-002 already has one
+	authorizations := new(MockIterator)
+	authorizations.AddRecord("auth_"+patientID, authorizationBytes)
+	stub.On("GetStateByRange", "auth_", "auth`").Return(authorizations, nil)
+	granted := mockConsentOf(stub, patientID, "", "doctor-001", nil)
+	pending := mockConsentOf(stub, patientID, "", "nurse-001", nil)
+	mockConsentOf(stub, patientID, "", "doctor-002", activeConsent(patientID, "doctor-002", fhir.ConsentProvision{}))
+	stub.On("PutState", granted, mock.MatchedBy(func(value []byte) bool {
+		var consent fhir.Consent
+		return json.Unmarshal(value, &consent) == nil && consent.Status == ConsentActive && len(consent.Provision) == 1
+	})).Return(nil)
+	stub.On("PutState", pending, mock.MatchedBy(func(value []byte) bool {
+		var consent fhir.Consent
+		return json.Unmarshal(value, &consent) == nil && consent.Status == ConsentDraft && len(consent.Provision) == 0
+	})).Return(nil)
+	stub.On("DelState", "auth_"+patientID).Return(nil)
+
+	migrated, err := contract.MigrateKeys(ctx, 100)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, migrated)
+	stub.AssertExpectations(t)
+}
+
+func TestChaincodeMetadataIsValid(t *testing.T) {
+	// contractapi rejects at startup any transaction whose types it cannot describe
+	_, err := contractapi.NewChaincode(new(PatientContract))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+)
+
+var (
+	clinicalRoles  = []string{auth.RoleDoctor, auth.RoleNurse}
+	requesterRoles = []string{auth.RoleDoctor, auth.RoleNurse, auth.RolePharmacist, auth.RoleLabTechnician}
+	careProviders  = auth.Join(auth.HospitalMSPs, auth.ClinicMSPs)
+)
+
+// patientPolicies declares who may call each PatientContract transaction.
+var patientPolicies = auth.Policies{
+	"CreatePatient":     {MSPs: careProviders, Roles: clinicalRoles},
+	"ReadPatient":       {Roles: auth.Join(requesterRoles, []string{auth.RolePatient}), Subject: auth.Arg(0), ConsentRoles: requesterRoles, Resource: "Patient", Action: auth.ActionRead},
+	"GetPatientHistory": {Roles: auth.Join(requesterRoles, []string{auth.RolePatient}), Subject: auth.Arg(0), ConsentRoles: requesterRoles, Resource: "Patient", Action: auth.ActionRead},
+	"UpdatePatient":     {Roles: auth.Join(clinicalRoles, []string{auth.RolePatient}), Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "Patient", Action: auth.ActionWrite},
+	"DeletePatient":     {MSPs: careProviders, Roles: []string{auth.RoleDoctor}},
+
+	"AuditedReadPatient": {Roles: auth.Join(requesterRoles, []string{auth.RolePatient}), Subject: auth.Arg(0), ConsentRoles: requesterRoles, Resource: "Patient", Action: auth.ActionRead},
+	"GetAccessLog":       {Roles: []string{auth.RolePatient, auth.RoleCompliance}, Subject: auth.Arg(0)},
+
+	"RequestAccess":        {Roles: requesterRoles},
+	"GrantAccess":          {Roles: []string{auth.RolePatient}, Subject: auth.Arg(0)},
+	"GrantConsent":         {Roles: []string{auth.RolePatient}, Subject: auth.Arg(0)},
+	"GrantTemporaryAccess": {Roles: []string{auth.RolePatient}, Subject: auth.Arg(0)},
+	"RevokeAccess":         {Roles: []string{auth.RolePatient}, Subject: auth.Arg(0)},
+	"ReadConsent":          {Roles: auth.Join(requesterRoles, []string{auth.RolePatient}), Subject: auth.Arg(0)},
+	"ListConsents":         {Roles: []string{auth.RolePatient}, Subject: auth.Arg(0)},
+	"EvaluateConsent":      {Roles: auth.Join(requesterRoles, []string{auth.RolePatient})},
+	"GetExpiredConsents":   {Roles: []string{auth.RolePatient, auth.RoleAdmin}, Subject: auth.Arg(0)},
+	"SweepExpiredConsents": {Roles: []string{auth.RolePatient, auth.RoleAdmin}, Subject: auth.Arg(0)},
+
+	"EnrollIdentity": {MSPs: []string{auth.PatientMSP}, Roles: []string{auth.RolePatient}},
+	"VerifyIdentity": {},
+	"ReadIdentity":   {Roles: []string{auth.RoleAdmin, auth.RolePatient}, Subject: auth.Arg(0)},
+	"RevokeIdentity": {Roles: []string{auth.RoleAdmin}},
+
+	"MigrateKeys": {Roles: []string{auth.RoleAdmin}},
+}
+
+// patientEnforcer reads consent straight from the consent records of this chaincode,
+// and honours the break-the-glass accesses of the emergency chaincode
+var patientEnforcer = &auth.Enforcer{
+	Policies: patientPolicies,
+	Consent: auth.AnyConsent(
+		auth.ConsentFunc(func(ctx contractapi.TransactionContextInterface, patientID string, caller *auth.Identity, request auth.AccessRequest) (*auth.Grant, error) {
+			authorized, err := new(PatientContract).isAuthorized(ctx, patientID, caller.MSPID, caller.UserID, request)
+			if err != nil || !authorized {
+				return nil, err
+			}
+			return &auth.Grant{Basis: auth.BasisConsent, ID: auth.ConsentID(patientID, caller.MSPID, caller.UserID)}, nil
+		}),
+		auth.EmergencyConsent,
+	),
+}
+
+// This chaincode keeps the identity mappings, so it checks enrollments in its own world state
+func init() {
+	auth.Enrollment = auth.EnrollmentFunc(checkEnrolledIdentity)
+}
@@ -3,11 +3,15 @@ module github.com/xDaryamo/MedChain/practioner
 go 1.21
 
 require (
-	github.com/golang/protobuf v1.5.3
-	github.com/hyperledger/fabric-chaincode-go v0.0.0-20230731094759-d626e9ab09b9
+	github.com/golang/protobuf v1.5.4
+	github.com/hyperledger/fabric-chaincode-go v0.0.0-20240425200701-0431f709af2c
 	github.com/hyperledger/fabric-contract-api-go v1.2.2
-	github.com/hyperledger/fabric-protos-go v0.3.0
-	github.com/stretchr/testify v1.8.4
+	github.com/hyperledger/fabric-protos-go v0.3.3
+	github.com/stretchr/testify v1.9.0
+	github.com/xDaryamo/MedChain/auth v0.0.0
+	github.com/xDaryamo/MedChain/fhir v0.0.0
+	github.com/xDaryamo/MedChain/history v0.0.0
+	github.com/xDaryamo/MedChain/ledger v0.0.0
 )
 
 require (
@@ -24,16 +28,23 @@ require (
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rogpeppe/go-internal v1.11.0 // indirect
-	github.com/stretchr/objx v0.5.0 // indirect
+	github.com/stretchr/objx v0.5.2 // indirect
 	github.com/xeipuuv/gojsonpointer v0.0.0-20190905194746-02993c407bfb // indirect
 	github.com/xeipuuv/gojsonreference v0.0.0-20180127040603-bd5ef7bd5415 // indirect
 	github.com/xeipuuv/gojsonschema v1.2.0 // indirect
 	golang.org/x/mod v0.14.0 // indirect
-	golang.org/x/net v0.17.0 // indirect
-	golang.org/x/sys v0.14.0 // indirect
+	golang.org/x/net v0.23.0 // indirect
+	golang.org/x/sys v0.19.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
-	google.golang.org/genproto/googleapis/rpc v0.0.0-20231030173426-d783a09b4405 // indirect
-	google.golang.org/grpc v1.59.0 // indirect
-	google.golang.org/protobuf v1.31.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240415180920-8c6c420018be // indirect
+	google.golang.org/grpc v1.63.2 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace (
+	github.com/xDaryamo/MedChain/auth => ../auth
+	github.com/xDaryamo/MedChain/fhir => ../fhir
+	github.com/xDaryamo/MedChain/history => ../history
+	github.com/xDaryamo/MedChain/ledger => ../ledger
+)
@@ -0,0 +1,41 @@
+package main
+
+import "github.com/xDaryamo/MedChain/auth"
+
+var (
+	clinicalRoles = []string{auth.RoleDoctor, auth.RoleNurse}
+	readerRoles   = auth.Join(clinicalRoles, []string{auth.RolePatient})
+	careProviders = auth.Join(auth.HospitalMSPs, auth.ClinicMSPs)
+)
+
+// practitionerPolicies declares who may call each PractitionerContract transaction.
+// The practitioner registry is maintained by administrators and readable by anyone;
+// conditions and procedures are patient data and follow the consent rules.
+var practitionerPolicies = auth.Policies{
+	"CreatePractitioner":     {Roles: []string{auth.RoleAdmin}},
+	"ReadPractitioner":       {},
+	"GetPractitionerHistory": {},
+	"EvaluateQualification":  {},
+	"UpdatePractitioner":     {Roles: []string{auth.RoleAdmin}},
+	"DeletePractitioner":     {Roles: []string{auth.RoleAdmin}},
+	"MigrateKeys":            {Roles: []string{auth.RoleAdmin}},
+
+	"CreateCondition":     {MSPs: careProviders, Roles: []string{auth.RoleDoctor}, Subject: auth.PayloadSubject(1), ConsentRoles: clinicalRoles, Resource: "Condition", Action: auth.ActionWrite},
+	"ReadCondition":       {Roles: readerRoles, Subject: auth.StoredSubject("Condition", 0), ConsentRoles: clinicalRoles, Resource: "Condition", Action: auth.ActionRead},
+	"GetConditionHistory": {Roles: readerRoles, Subject: auth.LastKnownSubject("Condition", 0), ConsentRoles: clinicalRoles, Resource: "Condition", Action: auth.ActionRead},
+	"UpdateCondition":     {MSPs: careProviders, Roles: []string{auth.RoleDoctor}, Subject: auth.StoredSubject("Condition", 0), ConsentRoles: clinicalRoles, Resource: "Condition", Action: auth.ActionWrite},
+	"DeleteCondition":     {MSPs: careProviders, Roles: []string{auth.RoleDoctor}, Subject: auth.StoredSubject("Condition", 0), ConsentRoles: clinicalRoles, Resource: "Condition", Action: auth.ActionWrite},
+
+	"CreateProcedure":     {MSPs: careProviders, Roles: []string{auth.RoleDoctor}, Subject: auth.PayloadSubject(1), ConsentRoles: clinicalRoles, Resource: "Procedure", Action: auth.ActionWrite},
+	"ReadProcedure":       {Roles: readerRoles, Subject: auth.StoredSubject("Procedure", 0), ConsentRoles: clinicalRoles, Resource: "Procedure", Action: auth.ActionRead},
+	"GetProcedureHistory": {Roles: readerRoles, Subject: auth.LastKnownSubject("Procedure", 0), ConsentRoles: clinicalRoles, Resource: "Procedure", Action: auth.ActionRead},
+	"UpdateProcedure":     {MSPs: careProviders, Roles: []string{auth.RoleDoctor}, Subject: auth.StoredSubject("Procedure", 0), ConsentRoles: clinicalRoles, Resource: "Procedure", Action: auth.ActionWrite},
+	"DeleteProcedure":     {MSPs: careProviders, Roles: []string{auth.RoleDoctor}, Subject: auth.StoredSubject("Procedure", 0), ConsentRoles: clinicalRoles, Resource: "Procedure", Action: auth.ActionWrite},
+
+	"CreateAnnotation": {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.StoredSubject("Procedure", 0), ConsentRoles: clinicalRoles, Resource: "Procedure", Action: auth.ActionWrite},
+	"ReadAnnotation":   {Roles: readerRoles, Subject: auth.StoredSubject("Procedure", 0), ConsentRoles: clinicalRoles, Resource: "Procedure", Action: auth.ActionRead},
+	"UpdateAnnotation": {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.StoredSubject("Procedure", 0), ConsentRoles: clinicalRoles, Resource: "Procedure", Action: auth.ActionWrite},
+	"DeleteAnnotation": {MSPs: careProviders, Roles: clinicalRoles, Subject: auth.StoredSubject("Procedure", 0), ConsentRoles: clinicalRoles, Resource: "Procedure", Action: auth.ActionWrite},
+}
+
+var practitionerEnforcer = &auth.Enforcer{Policies: practitionerPolicies, Consent: auth.AnyConsent(auth.PatientConsent, auth.EmergencyConsent)}
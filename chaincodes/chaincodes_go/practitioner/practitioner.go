@@ -4,8 +4,20 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+	"github.com/xDaryamo/MedChain/history"
+	"github.com/xDaryamo/MedChain/ledger"
+)
+
+// Object types of the composite keys the contract stores its resources under
+const (
+	practitionerObjectType = "Practitioner" // Practitioner~practitionerID
+	conditionObjectType    = "Condition"    // Condition~conditionID
+	procedureObjectType    = "Procedure"    // Procedure~procedureID
 )
 
 // PractitionerContract represents the smart contract for managing practitioners
@@ -21,7 +33,7 @@ type PractitionerContract struct {
 
 // CreatePractitioner adds a new practitioner record to the ledger
 func (c *PractitionerContract) CreatePractitioner(ctx contractapi.TransactionContextInterface, practitionerID string, practitionerJSON string) error {
-	exists, err := ctx.GetStub().GetState(practitionerID)
+	exists, err := getResource(ctx, practitionerObjectType, practitionerID)
 	if err != nil {
 		return errors.New("failed to get practitioner: " + err.Error())
 	}
@@ -29,7 +41,7 @@ func (c *PractitionerContract) CreatePractitioner(ctx contractapi.TransactionCon
 		return errors.New("practitioner already exists: " + practitionerID)
 	}
 
-	var practitioner Practitioner
+	var practitioner fhir.Practitioner
 	err = json.Unmarshal([]byte(practitionerJSON), &practitioner)
 	if err != nil {
 		return errors.New("failed to unmarshal practitioner: " + err.Error())
@@ -41,12 +53,12 @@ func (c *PractitionerContract) CreatePractitioner(ctx contractapi.TransactionCon
 	}
 
 	// Save the new practitioner to the ledger
-	return ctx.GetStub().PutState(practitionerID, practitionerJSONBytes)
+	return putResource(ctx, practitionerObjectType, practitionerID, practitionerJSONBytes)
 }
 
 // ReadPractitioner retrieves a practitioner record from the ledger
-func (c *PractitionerContract) ReadPractitioner(ctx contractapi.TransactionContextInterface, practitionerID string) (*Practitioner, error) {
-	practitionerJSON, err := ctx.GetStub().GetState(practitionerID)
+func (c *PractitionerContract) ReadPractitioner(ctx contractapi.TransactionContextInterface, practitionerID string) (*fhir.Practitioner, error) {
+	practitionerJSON, err := getResource(ctx, practitionerObjectType, practitionerID)
 	if err != nil {
 		return nil, errors.New("failed to read practitioner: " + err.Error())
 	}
@@ -54,7 +66,7 @@ func (c *PractitionerContract) ReadPractitioner(ctx contractapi.TransactionConte
 		return nil, errors.New("practitioner does not exist: " + practitionerID)
 	}
 
-	var practitioner Practitioner
+	var practitioner fhir.Practitioner
 	err = json.Unmarshal(practitionerJSON, &practitioner)
 	if err != nil {
 		return nil, errors.New("failed to unmarshal practitioner: " + err.Error())
@@ -63,9 +75,53 @@ func (c *PractitionerContract) ReadPractitioner(ctx contractapi.TransactionConte
 	return &practitioner, nil
 }
 
+// EvaluateQualification reports whether practitionerID is an active practitioner holding a
+// qualification with the given code that is active and valid at the transaction time. Other
+// chaincodes query it, e.g. to check that a prescriber is a qualified doctor.
+func (c *PractitionerContract) EvaluateQualification(ctx contractapi.TransactionContextInterface, practitionerID string, code string) (bool, error) {
+	practitionerJSON, err := getResource(ctx, practitionerObjectType, practitionerID)
+	if err != nil {
+		return false, errors.New("failed to read practitioner: " + err.Error())
+	}
+	if practitionerJSON == nil {
+		return false, nil
+	}
+
+	var practitioner fhir.Practitioner
+	if err := json.Unmarshal(practitionerJSON, &practitioner); err != nil {
+		return false, errors.New("failed to unmarshal practitioner: " + err.Error())
+	}
+	if !practitioner.Active || practitioner.Deceased {
+		return false, nil
+	}
+
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return false, errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+	now := timestamp.AsTime()
+
+	for _, qualification := range practitioner.Qualification {
+		if hasCode(qualification.Code, code) && isValidQualification(qualification, now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetPractitionerHistory returns every version of a practitioner as a FHIR history Bundle,
+// including deletions, with the transaction and identity that wrote each version
+func (c *PractitionerContract) GetPractitionerHistory(ctx contractapi.TransactionContextInterface, practitionerID string) (string, error) {
+	key, err := ledger.Key(ctx, practitionerObjectType, practitionerID)
+	if err != nil {
+		return "", err
+	}
+	return history.GetHistoryJSON(ctx, key, "Practitioner", practitionerID)
+}
+
 // UpdatePractitioner updates an existing practitioner record in the ledger
 func (c *PractitionerContract) UpdatePractitioner(ctx contractapi.TransactionContextInterface, practitionerID string, practitionerJSON string) error {
-	exists, err := ctx.GetStub().GetState(practitionerID)
+	exists, err := getResource(ctx, practitionerObjectType, practitionerID)
 	if err != nil {
 		return errors.New("failed to get practitioner: " + err.Error())
 	}
@@ -73,7 +129,7 @@ func (c *PractitionerContract) UpdatePractitioner(ctx contractapi.TransactionCon
 		return errors.New("practitioner does not exist: " + practitionerID)
 	}
 
-	var practitioner Practitioner
+	var practitioner fhir.Practitioner
 	err = json.Unmarshal([]byte(practitionerJSON), &practitioner)
 	if err != nil {
 		return errors.New("failed to unmarshal practitioner: " + err.Error())
@@ -85,12 +141,12 @@ func (c *PractitionerContract) UpdatePractitioner(ctx contractapi.TransactionCon
 	}
 
 	// Update the practitioner record in the ledger
-	return ctx.GetStub().PutState(practitionerID, practitionerJSONBytes)
+	return putResource(ctx, practitionerObjectType, practitionerID, practitionerJSONBytes)
 }
 
 // DeletePractitioner removes a practitioner record from the ledger
 func (c *PractitionerContract) DeletePractitioner(ctx contractapi.TransactionContextInterface, practitionerID string) error {
-	exists, err := ctx.GetStub().GetState(practitionerID)
+	exists, err := getResource(ctx, practitionerObjectType, practitionerID)
 	if err != nil {
 		return errors.New("failed to get practitioner: " + err.Error())
 	}
@@ -99,12 +155,12 @@ func (c *PractitionerContract) DeletePractitioner(ctx contractapi.TransactionCon
 	}
 
 	// Remove the practitioner record
-	return ctx.GetStub().DelState(practitionerID)
+	return deleteResource(ctx, practitionerObjectType, practitionerID)
 }
 
 // CreateCondition adds a new condition record to the ledger
 func (c *PractitionerContract) CreateCondition(ctx contractapi.TransactionContextInterface, conditionID string, conditionJSON string) error {
-	exists, err := ctx.GetStub().GetState(conditionID)
+	exists, err := getResource(ctx, conditionObjectType, conditionID)
 	if err != nil {
 		return errors.New("failed to get condition: " + err.Error())
 	}
@@ -112,7 +168,7 @@ func (c *PractitionerContract) CreateCondition(ctx contractapi.TransactionContex
 		return errors.New("condition already exists: " + conditionID)
 	}
 
-	var condition Condition
+	var condition fhir.Condition
 	err = json.Unmarshal([]byte(conditionJSON), &condition)
 	if err != nil {
 		return errors.New("failed to unmarshal condition: " + err.Error())
@@ -124,12 +180,12 @@ func (c *PractitionerContract) CreateCondition(ctx contractapi.TransactionContex
 	}
 
 	// Save the new condition to the ledger
-	return ctx.GetStub().PutState(conditionID, conditionJSONBytes)
+	return putResource(ctx, conditionObjectType, conditionID, conditionJSONBytes)
 }
 
 // ReadCondition retrieves a condition record from the ledger
-func (c *PractitionerContract) ReadCondition(ctx contractapi.TransactionContextInterface, conditionID string) (*Condition, error) {
-	conditionJSON, err := ctx.GetStub().GetState(conditionID)
+func (c *PractitionerContract) ReadCondition(ctx contractapi.TransactionContextInterface, conditionID string) (*fhir.Condition, error) {
+	conditionJSON, err := getResource(ctx, conditionObjectType, conditionID)
 	if err != nil {
 		return nil, errors.New("failed to read condition: " + err.Error())
 	}
@@ -137,7 +193,7 @@ func (c *PractitionerContract) ReadCondition(ctx contractapi.TransactionContextI
 		return nil, errors.New("condition does not exist: " + conditionID)
 	}
 
-	var condition Condition
+	var condition fhir.Condition
 	err = json.Unmarshal(conditionJSON, &condition)
 	if err != nil {
 		return nil, errors.New("failed to unmarshal condition: " + err.Error())
@@ -146,9 +202,19 @@ func (c *PractitionerContract) ReadCondition(ctx contractapi.TransactionContextI
 	return &condition, nil
 }
 
+// GetConditionHistory returns every version of a condition as a FHIR history Bundle,
+// including deletions, with the transaction and identity that wrote each version
+func (c *PractitionerContract) GetConditionHistory(ctx contractapi.TransactionContextInterface, conditionID string) (string, error) {
+	key, err := ledger.Key(ctx, conditionObjectType, conditionID)
+	if err != nil {
+		return "", err
+	}
+	return history.GetHistoryJSON(ctx, key, "Condition", conditionID)
+}
+
 // UpdateCondition updates an existing condition record in the ledger
 func (c *PractitionerContract) UpdateCondition(ctx contractapi.TransactionContextInterface, conditionID string, conditionJSON string) error {
-	exists, err := ctx.GetStub().GetState(conditionID)
+	exists, err := getResource(ctx, conditionObjectType, conditionID)
 	if err != nil {
 		return errors.New("failed to get condition: " + err.Error())
 	}
@@ -156,11 +222,18 @@ func (c *PractitionerContract) UpdateCondition(ctx contractapi.TransactionContex
 		return errors.New("condition does not exist: " + conditionID)
 	}
 
-	var condition Condition
+	var stored, condition fhir.Condition
+	if err := json.Unmarshal(exists, &stored); err != nil {
+		return errors.New("failed to unmarshal stored condition: " + err.Error())
+	}
 	err = json.Unmarshal([]byte(conditionJSON), &condition)
 	if err != nil {
 		return errors.New("failed to unmarshal condition: " + err.Error())
 	}
+	// The policy checked consent for the stored subject only
+	if !sameSubject(stored.Subject, condition.Subject) {
+		return errors.New("the subject of condition " + conditionID + " cannot be changed")
+	}
 
 	conditionJSONBytes, err := json.Marshal(condition)
 	if err != nil {
@@ -168,12 +241,12 @@ func (c *PractitionerContract) UpdateCondition(ctx contractapi.TransactionContex
 	}
 
 	// Update the condition record in the ledger
-	return ctx.GetStub().PutState(conditionID, conditionJSONBytes)
+	return putResource(ctx, conditionObjectType, conditionID, conditionJSONBytes)
 }
 
 // DeleteCondition removes a condition record from the ledger
 func (c *PractitionerContract) DeleteCondition(ctx contractapi.TransactionContextInterface, conditionID string) error {
-	exists, err := ctx.GetStub().GetState(conditionID)
+	exists, err := getResource(ctx, conditionObjectType, conditionID)
 	if err != nil {
 		return errors.New("failed to get condition: " + err.Error())
 	}
@@ -182,12 +255,12 @@ func (c *PractitionerContract) DeleteCondition(ctx contractapi.TransactionContex
 	}
 
 	// Remove the condition record
-	return ctx.GetStub().DelState(conditionID)
+	return deleteResource(ctx, conditionObjectType, conditionID)
 }
 
 // CreateProcedure adds a new procedure record to the ledger
 func (c *PractitionerContract) CreateProcedure(ctx contractapi.TransactionContextInterface, procedureID string, procedureJSON string) error {
-	exists, err := ctx.GetStub().GetState(procedureID)
+	exists, err := getResource(ctx, procedureObjectType, procedureID)
 	if err != nil {
 		return errors.New("failed to get procedure: " + err.Error())
 	}
@@ -195,7 +268,7 @@ func (c *PractitionerContract) CreateProcedure(ctx contractapi.TransactionContex
 		return errors.New("procedure already exists: " + procedureID)
 	}
 
-	var procedure Procedure
+	var procedure fhir.Procedure
 	err = json.Unmarshal([]byte(procedureJSON), &procedure)
 	if err != nil {
 		return errors.New("failed to unmarshal procedure: " + err.Error())
@@ -207,12 +280,12 @@ func (c *PractitionerContract) CreateProcedure(ctx contractapi.TransactionContex
 	}
 
 	// Save the new procedure to the ledger
-	return ctx.GetStub().PutState(procedureID, procedureJSONBytes)
+	return putResource(ctx, procedureObjectType, procedureID, procedureJSONBytes)
 }
 
 // ReadProcedure retrieves a procedure record from the ledger
-func (c *PractitionerContract) ReadProcedure(ctx contractapi.TransactionContextInterface, procedureID string) (*Procedure, error) {
-	procedureJSON, err := ctx.GetStub().GetState(procedureID)
+func (c *PractitionerContract) ReadProcedure(ctx contractapi.TransactionContextInterface, procedureID string) (*fhir.Procedure, error) {
+	procedureJSON, err := getResource(ctx, procedureObjectType, procedureID)
 	if err != nil {
 		return nil, errors.New("failed to read procedure: " + err.Error())
 	}
@@ -220,7 +293,7 @@ func (c *PractitionerContract) ReadProcedure(ctx contractapi.TransactionContextI
 		return nil, errors.New("procedure does not exist: " + procedureID)
 	}
 
-	var procedure Procedure
+	var procedure fhir.Procedure
 	err = json.Unmarshal(procedureJSON, &procedure)
 	if err != nil {
 		return nil, errors.New("failed to unmarshal procedure: " + err.Error())
@@ -229,9 +302,19 @@ func (c *PractitionerContract) ReadProcedure(ctx contractapi.TransactionContextI
 	return &procedure, nil
 }
 
+// GetProcedureHistory returns every version of a procedure as a FHIR history Bundle,
+// including deletions, with the transaction and identity that wrote each version
+func (c *PractitionerContract) GetProcedureHistory(ctx contractapi.TransactionContextInterface, procedureID string) (string, error) {
+	key, err := ledger.Key(ctx, procedureObjectType, procedureID)
+	if err != nil {
+		return "", err
+	}
+	return history.GetHistoryJSON(ctx, key, "Procedure", procedureID)
+}
+
 // UpdateProcedure updates an existing procedure record in the ledger
 func (c *PractitionerContract) UpdateProcedure(ctx contractapi.TransactionContextInterface, procedureID string, procedureJSON string) error {
-	exists, err := ctx.GetStub().GetState(procedureID)
+	exists, err := getResource(ctx, procedureObjectType, procedureID)
 	if err != nil {
 		return errors.New("failed to get procedure: " + err.Error())
 	}
@@ -239,11 +322,18 @@ func (c *PractitionerContract) UpdateProcedure(ctx contractapi.TransactionContex
 		return errors.New("procedure does not exist: " + procedureID)
 	}
 
-	var procedure Procedure
+	var stored, procedure fhir.Procedure
+	if err := json.Unmarshal(exists, &stored); err != nil {
+		return errors.New("failed to unmarshal stored procedure: " + err.Error())
+	}
 	err = json.Unmarshal([]byte(procedureJSON), &procedure)
 	if err != nil {
 		return errors.New("failed to unmarshal procedure: " + err.Error())
 	}
+	// The policy checked consent for the stored subject only
+	if !sameSubject(stored.Subject, procedure.Subject) {
+		return errors.New("the subject of procedure " + procedureID + " cannot be changed")
+	}
 
 	procedureJSONBytes, err := json.Marshal(procedure)
 	if err != nil {
@@ -251,12 +341,12 @@ func (c *PractitionerContract) UpdateProcedure(ctx contractapi.TransactionContex
 	}
 
 	// Update the procedure record in the ledger
-	return ctx.GetStub().PutState(procedureID, procedureJSONBytes)
+	return putResource(ctx, procedureObjectType, procedureID, procedureJSONBytes)
 }
 
 // DeleteProcedure removes a procedure record from the ledger
 func (c *PractitionerContract) DeleteProcedure(ctx contractapi.TransactionContextInterface, procedureID string) error {
-	exists, err := ctx.GetStub().GetState(procedureID)
+	exists, err := getResource(ctx, procedureObjectType, procedureID)
 	if err != nil {
 		return errors.New("failed to get procedure: " + err.Error())
 	}
@@ -265,13 +355,13 @@ func (c *PractitionerContract) DeleteProcedure(ctx contractapi.TransactionContex
 	}
 
 	// Remove the procedure record
-	return ctx.GetStub().DelState(procedureID)
+	return deleteResource(ctx, procedureObjectType, procedureID)
 }
 
 // CreateAnnotation adds a new annotation record to the ledger
 func (c *PractitionerContract) CreateAnnotation(ctx contractapi.TransactionContextInterface, procedureID string, annotationID string, annotationJSON string) error {
 	// Check if the procedure exists
-	procedureJSON, err := ctx.GetStub().GetState(procedureID)
+	procedureJSON, err := getResource(ctx, procedureObjectType, procedureID)
 	if err != nil {
 		return errors.New("failed to get procedure: " + err.Error())
 	}
@@ -280,14 +370,14 @@ func (c *PractitionerContract) CreateAnnotation(ctx contractapi.TransactionConte
 	}
 
 	// Retrieve the procedure from the ledger
-	var procedure Procedure
+	var procedure fhir.Procedure
 	err = json.Unmarshal(procedureJSON, &procedure)
 	if err != nil {
 		return errors.New("failed to unmarshal procedure: " + err.Error())
 	}
 
 	// Unmarshal the annotation JSON into a struct
-	var annotation Annotation
+	var annotation fhir.Annotation
 	err = json.Unmarshal([]byte(annotationJSON), &annotation)
 	if err != nil {
 		return errors.New("failed to unmarshal annotation: " + err.Error())
@@ -303,7 +393,7 @@ func (c *PractitionerContract) CreateAnnotation(ctx contractapi.TransactionConte
 	}
 
 	// Update the procedure record in the ledger
-	err = ctx.GetStub().PutState(procedureID, updatedProcedureJSON)
+	err = putResource(ctx, procedureObjectType, procedureID, updatedProcedureJSON)
 	if err != nil {
 		return errors.New("failed to update procedure: " + err.Error())
 	}
@@ -312,9 +402,9 @@ func (c *PractitionerContract) CreateAnnotation(ctx contractapi.TransactionConte
 }
 
 // ReadAnnotation retrieves an annotation record from the ledger
-func (c *PractitionerContract) ReadAnnotation(ctx contractapi.TransactionContextInterface, procedureID string, annotationIndex int) (*Annotation, error) {
+func (c *PractitionerContract) ReadAnnotation(ctx contractapi.TransactionContextInterface, procedureID string, annotationIndex int) (*fhir.Annotation, error) {
 	// Retrieve the procedure from the ledger
-	procedureJSON, err := ctx.GetStub().GetState(procedureID)
+	procedureJSON, err := getResource(ctx, procedureObjectType, procedureID)
 	if err != nil {
 		return nil, errors.New("failed to read procedure: " + err.Error())
 	}
@@ -323,7 +413,7 @@ func (c *PractitionerContract) ReadAnnotation(ctx contractapi.TransactionContext
 	}
 
 	// Unmarshal the procedure JSON into a struct
-	var procedure Procedure
+	var procedure fhir.Procedure
 	err = json.Unmarshal(procedureJSON, &procedure)
 	if err != nil {
 		return nil, errors.New("failed to unmarshal procedure: " + err.Error())
@@ -343,7 +433,7 @@ func (c *PractitionerContract) ReadAnnotation(ctx contractapi.TransactionContext
 // UpdateAnnotation updates an existing annotation record in the ledger
 func (c *PractitionerContract) UpdateAnnotation(ctx contractapi.TransactionContextInterface, procedureID string, annotationIndex int, annotationJSON string) error {
 	// Retrieve the procedure from the ledger
-	procedureJSON, err := ctx.GetStub().GetState(procedureID)
+	procedureJSON, err := getResource(ctx, procedureObjectType, procedureID)
 	if err != nil {
 		return errors.New("failed to read procedure: " + err.Error())
 	}
@@ -352,7 +442,7 @@ func (c *PractitionerContract) UpdateAnnotation(ctx contractapi.TransactionConte
 	}
 
 	// Unmarshal the procedure JSON into a struct
-	var procedure Procedure
+	var procedure fhir.Procedure
 	err = json.Unmarshal(procedureJSON, &procedure)
 	if err != nil {
 		return errors.New("failed to unmarshal procedure: " + err.Error())
@@ -364,7 +454,7 @@ func (c *PractitionerContract) UpdateAnnotation(ctx contractapi.TransactionConte
 	}
 
 	// Unmarshal the updated annotation JSON into a struct
-	var updatedAnnotation Annotation
+	var updatedAnnotation fhir.Annotation
 	err = json.Unmarshal([]byte(annotationJSON), &updatedAnnotation)
 	if err != nil {
 		return errors.New("failed to unmarshal updated annotation: " + err.Error())
@@ -380,7 +470,7 @@ func (c *PractitionerContract) UpdateAnnotation(ctx contractapi.TransactionConte
 	}
 
 	// Update the procedure record in the ledger
-	err = ctx.GetStub().PutState(procedureID, updatedProcedureJSON)
+	err = putResource(ctx, procedureObjectType, procedureID, updatedProcedureJSON)
 	if err != nil {
 		return errors.New("failed to update procedure: " + err.Error())
 	}
@@ -391,7 +481,7 @@ func (c *PractitionerContract) UpdateAnnotation(ctx contractapi.TransactionConte
 // DeleteAnnotation removes an annotation record from the ledger
 func (c *PractitionerContract) DeleteAnnotation(ctx contractapi.TransactionContextInterface, procedureID string, annotationIndex int) error {
 	// Retrieve the procedure from the ledger
-	procedureJSON, err := ctx.GetStub().GetState(procedureID)
+	procedureJSON, err := getResource(ctx, procedureObjectType, procedureID)
 	if err != nil {
 		return errors.New("failed to read procedure: " + err.Error())
 	}
@@ -400,7 +490,7 @@ func (c *PractitionerContract) DeleteAnnotation(ctx contractapi.TransactionConte
 	}
 
 	// Unmarshal the procedure JSON into a struct
-	var procedure Procedure
+	var procedure fhir.Procedure
 	err = json.Unmarshal(procedureJSON, &procedure)
 	if err != nil {
 		return errors.New("failed to unmarshal procedure: " + err.Error())
@@ -421,7 +511,7 @@ func (c *PractitionerContract) DeleteAnnotation(ctx contractapi.TransactionConte
 	}
 
 	// Update the procedure record in the ledger
-	err = ctx.GetStub().PutState(procedureID, updatedProcedureJSON)
+	err = putResource(ctx, procedureObjectType, procedureID, updatedProcedureJSON)
 	if err != nil {
 		return errors.New("failed to update procedure: " + err.Error())
 	}
@@ -429,8 +519,97 @@ func (c *PractitionerContract) DeleteAnnotation(ctx contractapi.TransactionConte
 	return nil
 }
 
+// MigrateKeys moves up to limit practitioners, conditions and procedures stored under
+// their bare ID, as written before resources were namespaced, to their composite key.
+// It returns how many it moved; call it until it returns 0.
+func (c *PractitionerContract) MigrateKeys(ctx contractapi.TransactionContextInterface, limit int) (int, error) {
+	return ledger.MigrateKeys(ctx, classifyLegacyResource, limit)
+}
+
+// classifyLegacyResource tells the resources apart by their fields: only conditions
+// serialize their identifier as "id", and practitioners have no subject
+func classifyLegacyResource(key string, value []byte) (string, string, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return "", "", false
+	}
+	if _, ok := fields["id"]; ok {
+		return conditionObjectType, key, true
+	}
+	if _, ok := fields["subject"]; ok {
+		return procedureObjectType, key, true
+	}
+	return practitionerObjectType, key, true
+}
+
+// isValidQualification reports whether a qualification is active at now. A qualification
+// without a status or period is taken as valid.
+func isValidQualification(qualification fhir.Qualification, now time.Time) bool {
+	if qualification.Status != nil && !hasCode(qualification.Status, "active") {
+		return false
+	}
+	if period := qualification.Period; period != nil {
+		if !period.Start.IsZero() && now.Before(period.Start) {
+			return false
+		}
+		if !period.End.IsZero() && now.After(period.End) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasCode(concept *fhir.CodeableConcept, code string) bool {
+	if concept == nil {
+		return false
+	}
+	for _, coding := range concept.Coding {
+		if coding.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// sameSubject reports whether two references point at the same patient, with or without the
+// Patient/ prefix
+func sameSubject(a *fhir.Reference, b *fhir.Reference) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return auth.PatientID(a.Reference) == auth.PatientID(b.Reference)
+}
+
+func getResource(ctx contractapi.TransactionContextInterface, objectType string, id string) ([]byte, error) {
+	key, err := ledger.Key(ctx, objectType, id)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.GetStub().GetState(key)
+}
+
+func putResource(ctx contractapi.TransactionContextInterface, objectType string, id string, value []byte) error {
+	key, err := ledger.Key(ctx, objectType, id)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, value)
+}
+
+func deleteResource(ctx contractapi.TransactionContextInterface, objectType string, id string) error {
+	key, err := ledger.Key(ctx, objectType, id)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
 func main() {
-	chaincode, err := contractapi.NewChaincode(new(PractitionerContract))
+	practitionerContract := new(PractitionerContract)
+	practitionerContract.BeforeTransaction = practitionerEnforcer.BeforeTransaction
+	practitionerContract.AfterTransaction = history.RecordSubmitter
+
+	chaincode, err := contractapi.NewChaincode(practitionerContract)
 	if err != nil {
 		log.Panic(errors.New("error creating practitioner chaincode: " + err.Error()))
 	}
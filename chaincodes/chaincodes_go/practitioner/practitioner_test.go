@@ -1,15 +1,20 @@
 package main
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
 )
 
 // MockStub is a mock implementation of the ChaincodeStubInterface
@@ -276,6 +281,49 @@ func (m *MockIterator) Close() error {
 }
 
 // Tests for CreatePractitioner function
+// MockHistoryIterator is a mock implementation of the HistoryQueryIteratorInterface
+type MockHistoryIterator struct {
+	Modifications []*queryresult.KeyModification // Versions of the key, in the order they are returned
+	CurrentIndex  int                            // Index to keep track of the current position
+}
+
+// HasNext returns true if the iterator has more versions to iterate over
+func (m *MockHistoryIterator) HasNext() bool {
+	return m.CurrentIndex < len(m.Modifications)
+}
+
+// Next returns the next version of the key
+func (m *MockHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	if m.CurrentIndex >= len(m.Modifications) {
+		return nil, nil
+	}
+	modification := m.Modifications[m.CurrentIndex]
+	m.CurrentIndex++
+	return modification, nil
+}
+
+// AddModification adds a version of the key written by txID at the given time
+func (m *MockHistoryIterator) AddModification(txID string, value []byte, at time.Time, isDelete bool) {
+	m.Modifications = append(m.Modifications, &queryresult.KeyModification{
+		TxId:      txID,
+		Value:     value,
+		Timestamp: &timestamp.Timestamp{Seconds: at.Unix()},
+		IsDelete:  isDelete,
+	})
+}
+
+// Close closes the mock iterator (implements shim.HistoryQueryIteratorInterface)
+func (m *MockHistoryIterator) Close() error {
+	return nil
+}
+
+// mockKey expects the composite key objectType~id to be created and returns it
+func mockKey(stub *MockStub, objectType string, id string) string {
+	key := "\x00" + objectType + "\x00" + id + "\x00"
+	stub.On("CreateCompositeKey", objectType, []string{id}).Return(key, nil)
+	return key
+}
+
 func TestCreatePractitioner(t *testing.T) {
 	cc := new(PractitionerContract)
 	mockCtx := new(MockTransactionContext)
@@ -387,8 +435,9 @@ func TestCreatePractitioner(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", practitionerID).Return(nil, nil)
-	mockStub.On("PutState", practitionerID, mock.Anything).Return(nil)
+	practitionerKey := mockKey(mockStub, "Practitioner", practitionerID)
+	mockStub.On("GetState", practitionerKey).Return(nil, nil)
+	mockStub.On("PutState", practitionerKey, mock.Anything).Return(nil)
 
 	err := cc.CreatePractitioner(mockCtx, practitionerID, practitionerJSON)
 
@@ -510,7 +559,8 @@ func TestCreatePractitioner_PractitionerExists(t *testing.T) {
 
 	// Mock GetState method to return existing practitioner
 	existingPractitionerJSON := []byte(practitionerJSON)
-	mockStub.On("GetState", practitionerID).Return(existingPractitionerJSON, nil)
+	practitionerKey := mockKey(mockStub, "Practitioner", practitionerID)
+	mockStub.On("GetState", practitionerKey).Return(existingPractitionerJSON, nil)
 
 	err := cc.CreatePractitioner(mockCtx, practitionerID, practitionerJSON)
 	assert.Error(t, err)
@@ -526,7 +576,8 @@ func TestCreatePractitioner_InvalidJSON(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", practitionerID).Return(nil, nil)
+	practitionerKey := mockKey(mockStub, "Practitioner", practitionerID)
+	mockStub.On("GetState", practitionerKey).Return(nil, nil)
 
 	err := cc.CreatePractitioner(mockCtx, practitionerID, practitionerJSON)
 	assert.Error(t, err)
@@ -645,7 +696,8 @@ func TestReadPractitioner(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", practitionerID).Return(practitionerBytes, nil)
+	practitionerKey := mockKey(mockStub, "Practitioner", practitionerID)
+	mockStub.On("GetState", practitionerKey).Return(practitionerBytes, nil)
 
 	result, err := cc.ReadPractitioner(mockCtx, practitionerID)
 
@@ -662,7 +714,8 @@ func TestReadPractitioner_PractitionerNotFound(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", practitionerID).Return(nil, nil)
+	practitionerKey := mockKey(mockStub, "Practitioner", practitionerID)
+	mockStub.On("GetState", practitionerKey).Return(nil, nil)
 
 	result, err := cc.ReadPractitioner(mockCtx, practitionerID)
 
@@ -782,8 +835,9 @@ func TestUpdatePractitioner(t *testing.T) {
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
 	existingPractitionerJSON := []byte(practitionerJSON)
-	mockStub.On("GetState", practitionerID).Return(existingPractitionerJSON, nil)
-	mockStub.On("PutState", practitionerID, mock.Anything).Return(nil)
+	practitionerKey := mockKey(mockStub, "Practitioner", practitionerID)
+	mockStub.On("GetState", practitionerKey).Return(existingPractitionerJSON, nil)
+	mockStub.On("PutState", practitionerKey, mock.Anything).Return(nil)
 
 	err := cc.UpdatePractitioner(mockCtx, practitionerID, practitionerJSON)
 
@@ -902,7 +956,8 @@ func TestUpdatePractitioner_PractitionerNotFound(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", practitionerID).Return(nil, nil)
+	practitionerKey := mockKey(mockStub, "Practitioner", practitionerID)
+	mockStub.On("GetState", practitionerKey).Return(nil, nil)
 
 	err := cc.UpdatePractitioner(mockCtx, practitionerID, practitionerJSON)
 
@@ -917,8 +972,9 @@ func TestDeletePractitioner(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", practitionerID).Return([]byte{}, nil)
-	mockStub.On("DelState", practitionerID).Return(nil)
+	practitionerKey := mockKey(mockStub, "Practitioner", practitionerID)
+	mockStub.On("GetState", practitionerKey).Return([]byte{}, nil)
+	mockStub.On("DelState", practitionerKey).Return(nil)
 
 	err := cc.DeletePractitioner(mockCtx, practitionerID)
 
@@ -934,7 +990,8 @@ func TestDeletePractitioner_PractitionerNotFound(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", practitionerID).Return(nil, nil)
+	practitionerKey := mockKey(mockStub, "Practitioner", practitionerID)
+	mockStub.On("GetState", practitionerKey).Return(nil, nil)
 
 	err := cc.DeletePractitioner(mockCtx, practitionerID)
 
@@ -1023,7 +1080,8 @@ func TestCreateProcedure_ProcedureExists(t *testing.T) {
 
 	// Mock GetState method to return existing procedure
 	existingProcedureJSON := []byte(procedureJSON)
-	mockStub.On("GetState", procedureID).Return(existingProcedureJSON, nil)
+	procedureKey := mockKey(mockStub, "Procedure", procedureID)
+	mockStub.On("GetState", procedureKey).Return(existingProcedureJSON, nil)
 
 	err := cc.CreateProcedure(mockCtx, procedureID, procedureJSON)
 	assert.Error(t, err)
@@ -1039,7 +1097,8 @@ func TestCreateProcedure_InvalidJSON(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", procedureID).Return(nil, nil)
+	procedureKey := mockKey(mockStub, "Procedure", procedureID)
+	mockStub.On("GetState", procedureKey).Return(nil, nil)
 
 	err := cc.CreateProcedure(mockCtx, procedureID, procedureJSON)
 	assert.Error(t, err)
@@ -1126,7 +1185,8 @@ func TestReadProcedure(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", procedureID).Return(procedureBytes, nil)
+	procedureKey := mockKey(mockStub, "Procedure", procedureID)
+	mockStub.On("GetState", procedureKey).Return(procedureBytes, nil)
 
 	result, err := cc.ReadProcedure(mockCtx, procedureID)
 
@@ -1144,7 +1204,8 @@ func TestReadProcedure_ProcedureNotFound(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", procedureID).Return(nil, nil)
+	procedureKey := mockKey(mockStub, "Procedure", procedureID)
+	mockStub.On("GetState", procedureKey).Return(nil, nil)
 
 	result, err := cc.ReadProcedure(mockCtx, procedureID)
 
@@ -1232,8 +1293,9 @@ func TestUpdateProcedure(t *testing.T) {
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
 	existingProcedureJSON := []byte(procedureJSON)
-	mockStub.On("GetState", procedureID).Return(existingProcedureJSON, nil)
-	mockStub.On("PutState", procedureID, mock.Anything).Return(nil)
+	procedureKey := mockKey(mockStub, "Procedure", procedureID)
+	mockStub.On("GetState", procedureKey).Return(existingProcedureJSON, nil)
+	mockStub.On("PutState", procedureKey, mock.Anything).Return(nil)
 
 	err := cc.UpdateProcedure(mockCtx, procedureID, procedureJSON)
 
@@ -1241,6 +1303,36 @@ func TestUpdateProcedure(t *testing.T) {
 	mockStub.AssertExpectations(t)
 }
 
+func TestUpdateProcedure_RejectsAnotherSubject(t *testing.T) {
+	cc := new(PractitionerContract)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	procedureKey := mockKey(mockStub, "Procedure", "procedure1")
+	mockStub.On("GetState", procedureKey).Return([]byte(`{"subject":{"reference":"Patient/patient123"}}`), nil)
+
+	err := cc.UpdateProcedure(mockCtx, "procedure1", `{"subject":{"reference":"Patient/patient456"}}`)
+
+	assert.EqualError(t, err, "the subject of procedure procedure1 cannot be changed")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestUpdateCondition_RejectsAnotherSubject(t *testing.T) {
+	cc := new(PractitionerContract)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	conditionKey := mockKey(mockStub, "Condition", "condition1")
+	mockStub.On("GetState", conditionKey).Return([]byte(`{"subject":{"reference":"Patient/patient123"}}`), nil)
+
+	err := cc.UpdateCondition(mockCtx, "condition1", `{"subject":{"reference":"patient456"}}`)
+
+	assert.EqualError(t, err, "the subject of condition condition1 cannot be changed")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
 func TestUpdateProcedure_ProcedureNotFound(t *testing.T) {
 	cc := new(PractitionerContract)
 	mockCtx := new(MockTransactionContext)
@@ -1320,7 +1412,8 @@ func TestUpdateProcedure_ProcedureNotFound(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", procedureID).Return(nil, nil)
+	procedureKey := mockKey(mockStub, "Procedure", procedureID)
+	mockStub.On("GetState", procedureKey).Return(nil, nil)
 
 	err := cc.UpdateProcedure(mockCtx, procedureID, procedureJSON)
 
@@ -1335,8 +1428,9 @@ func TestDeleteProcedure(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", procedureID).Return([]byte{}, nil)
-	mockStub.On("DelState", procedureID).Return(nil)
+	procedureKey := mockKey(mockStub, "Procedure", procedureID)
+	mockStub.On("GetState", procedureKey).Return([]byte{}, nil)
+	mockStub.On("DelState", procedureKey).Return(nil)
 
 	err := cc.DeleteProcedure(mockCtx, procedureID)
 
@@ -1352,9 +1446,147 @@ func TestDeleteProcedure_ProcedureNotFound(t *testing.T) {
 
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
-	mockStub.On("GetState", procedureID).Return(nil, nil)
+	procedureKey := mockKey(mockStub, "Procedure", procedureID)
+	mockStub.On("GetState", procedureKey).Return(nil, nil)
 
 	err := cc.DeleteProcedure(mockCtx, procedureID)
 
 	assert.Error(t, err)
 }
+
+func TestPoliciesCoverEveryTransaction(t *testing.T) {
+	assert.Empty(t, auth.MissingPolicies(new(PractitionerContract), practitionerPolicies), "transactions without an access policy")
+}
+
+func TestGetConditionHistory(t *testing.T) {
+	contract := new(PractitionerContract)
+	stub := new(MockStub)
+	ctx := new(MockTransactionContext)
+	ctx.On("GetStub").Return(stub)
+
+	recorded := time.Date(2024, time.April, 15, 10, 0, 0, 0, time.UTC)
+	versions := &MockHistoryIterator{}
+	versions.AddModification("tx-001", []byte(`{"clinicalStatus":{"text":"active"}}`), recorded, false)
+	versions.AddModification("tx-002", []byte(`{"clinicalStatus":{"text":"resolved"}}`), recorded.AddDate(0, 1, 0), false)
+	stub.On("GetHistoryForKey", mockKey(stub, "Condition", "cond-001")).Return(versions, nil)
+	for _, txID := range []string{"tx-001", "tx-002"} {
+		submitterKey := "\x00Submitter\x00" + txID + "\x00"
+		stub.On("CreateCompositeKey", "Submitter", []string{txID}).Return(submitterKey, nil)
+		stub.On("GetState", submitterKey).Return([]byte(`{"userId":"doctor-001","mspId":"OspedaleMarescaMSP","role":"doctor"}`), nil)
+	}
+
+	bundleJSON, err := contract.GetConditionHistory(ctx, "cond-001")
+	assert.NoError(t, err)
+
+	var bundle struct {
+		Total int `json:"total"`
+		Entry []struct {
+			FullURL   string          `json:"fullUrl"`
+			Resource  json.RawMessage `json:"resource"`
+			TxID      string          `json:"txId"`
+			Submitter struct {
+				UserID string `json:"userId"`
+			} `json:"submitter"`
+		} `json:"entry"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(bundleJSON), &bundle))
+	assert.Equal(t, 2, bundle.Total)
+	assert.Equal(t, "Condition/cond-001", bundle.Entry[0].FullURL)
+	assert.Equal(t, "tx-002", bundle.Entry[0].TxID) // Newest first
+	assert.JSONEq(t, `{"clinicalStatus":{"text":"resolved"}}`, string(bundle.Entry[0].Resource))
+	assert.Equal(t, "doctor-001", bundle.Entry[1].Submitter.UserID)
+}
+
+func TestMigrateKeys(t *testing.T) {
+	cc := new(PractitionerContract)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	practitionerJSON := []byte(`{"identifier":{"value":"prac-001"},"active":true}`)
+	conditionJSON := []byte(`{"id":{"value":"cond-001"},"subject":{"reference":"Patient/patient-001"}}`)
+	procedureJSON := []byte(`{"identifier":{"value":"proc-001"},"subject":{"reference":"Patient/patient-001"}}`)
+	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{
+		Records: []KVPair{
+			{Key: "cond-001", Value: conditionJSON},
+			{Key: "prac-001", Value: practitionerJSON},
+			{Key: "proc-001", Value: procedureJSON},
+		},
+	}, nil)
+	for _, moved := range []struct {
+		objectType string
+		id         string
+		value      []byte
+	}{
+		{"Condition", "cond-001", conditionJSON},
+		{"Practitioner", "prac-001", practitionerJSON},
+		{"Procedure", "proc-001", procedureJSON},
+	} {
+		key := mockKey(mockStub, moved.objectType, moved.id)
+		mockStub.On("GetState", key).Return(nil, nil)
+		mockStub.On("PutState", key, moved.value).Return(nil)
+		mockStub.On("DelState", moved.id).Return(nil)
+	}
+
+	migrated, err := cc.MigrateKeys(mockCtx, 100)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, migrated)
+	mockStub.AssertExpectations(t)
+}
+
+func TestChaincodeMetadataIsValid(t *testing.T) {
+	// contractapi rejects at startup any transaction whose types it cannot describe
+	_, err := contractapi.NewChaincode(new(PractitionerContract))
+	assert.NoError(t, err)
+}
+
+func TestEvaluateQualification(t *testing.T) {
+	now := time.Date(2024, time.May, 1, 9, 0, 0, 0, time.UTC)
+	medicine := &fhir.CodeableConcept{Coding: []fhir.Coding{{System: "http://terminology.hl7.org/CodeSystem/v2-0360", Code: "MD"}}}
+	nursing := &fhir.CodeableConcept{Coding: []fhir.Coding{{System: "http://terminology.hl7.org/CodeSystem/v2-0360", Code: "RN"}}}
+	suspended := &fhir.CodeableConcept{Coding: []fhir.Coding{{Code: "suspended"}}}
+
+	for _, test := range []struct {
+		name          string
+		active        bool
+		qualification fhir.Qualification
+		qualified     bool
+	}{
+		{"valid", true, fhir.Qualification{Code: medicine, Period: &fhir.Period{Start: now.AddDate(-10, 0, 0)}}, true},
+		{"other qualification", true, fhir.Qualification{Code: nursing}, false},
+		{"expired", true, fhir.Qualification{Code: medicine, Period: &fhir.Period{End: now.AddDate(0, 0, -1)}}, false},
+		{"suspended", true, fhir.Qualification{Code: medicine, Status: suspended}, false},
+		{"inactive practitioner", false, fhir.Qualification{Code: medicine}, false},
+	} {
+		mockStub := new(MockStub)
+		mockCtx := new(MockTransactionContext)
+		mockCtx.On("GetStub").Return(mockStub)
+
+		practitioner := fhir.Practitioner{
+			ID:            &fhir.Identifier{Value: "doctor-001"},
+			Active:        test.active,
+			Qualification: []fhir.Qualification{test.qualification},
+		}
+		practitionerJSON, _ := json.Marshal(practitioner)
+		mockStub.On("GetState", mockKey(mockStub, "Practitioner", "doctor-001")).Return(practitionerJSON, nil)
+		mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: now.Unix()}, nil)
+
+		qualified, err := new(PractitionerContract).EvaluateQualification(mockCtx, "doctor-001", "MD")
+
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.qualified, qualified, test.name)
+	}
+}
+
+func TestEvaluateQualification_UnknownPractitioner(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockStub.On("GetState", mockKey(mockStub, "Practitioner", "doctor-404")).Return(nil, nil)
+
+	qualified, err := new(PractitionerContract).EvaluateQualification(mockCtx, "doctor-404", "MD")
+
+	assert.NoError(t, err)
+	assert.False(t, qualified)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+)
+
+// Dispenses are stored under the composite key MedicationDispense~prescriptionID~txID
+// and are never updated or deleted
+const dispenseObjectType = "MedicationDispense"
+
+// Fill types, from the http://terminology.hl7.org/CodeSystem/v3-ActCode code system
+const (
+	FillFirst          = "FF"  // The whole first fill
+	FillFirstPartial   = "FFP" // Part of the first fill
+	FillFirstComplete  = "FFC" // The rest of a partially dispensed first fill
+	FillRefill         = "RF"  // A whole repeat fill
+	FillRefillPartial  = "RFP" // Part of a repeat fill
+	FillRefillComplete = "RFC" // The rest of a partially dispensed repeat fill
+)
+
+// actCodeSystem is the HL7 v3 ActCode code system, which codes fill types, detected issues
+// and their mitigations
+const actCodeSystem = "http://terminology.hl7.org/CodeSystem/v3-ActCode"
+
+// dispenseProgress tells how far the dispensing of a prescription has come
+type dispenseProgress struct {
+	fills     int     // Fills dispensed in full
+	dispensed float64 // Quantity dispensed so far of the fill in progress
+}
+
+/*
+================================
+	DISPENSING OPERATIONS
+================================
+*/
+
+// DispensePrescription records that the caller's pharmacy handed over quantity of an active
+// prescription. A fill may be dispensed in parts but never beyond the prescribed quantity, and a
+// prescription allows DispenseRequest.NumberOfRepeatsAllowed fills after the first. A prescription
+// without a quantity is dispensed a whole fill at a time. The prescription is completed with its
+// last fill. The first dispense consumes the redemption code, passed in the transient field
+// redemptionCode, and later fills must be dispensed by the same pharmacy.
+func (t *PrescriptionChaincode) DispensePrescription(ctx contractapi.TransactionContextInterface, prescriptionID string, quantity float64) (*fhir.MedicationDispense, error) {
+	if quantity <= 0 {
+		return nil, errors.New("the quantity to dispense must be positive")
+	}
+	return t.dispense(ctx, prescriptionID, quantity)
+}
+
+// GetDispenses returns the dispenses of a prescription, oldest first
+func (t *PrescriptionChaincode) GetDispenses(ctx contractapi.TransactionContextInterface, prescriptionID string) ([]*fhir.MedicationDispense, error) {
+	if err := t.checkPharmacyRead(ctx, prescriptionID); err != nil {
+		return nil, err
+	}
+	return t.listDispenses(ctx, prescriptionID)
+}
+
+// listDispenses returns the dispenses of a prescription, oldest first, whoever the caller
+func (t *PrescriptionChaincode) listDispenses(ctx contractapi.TransactionContextInterface, prescriptionID string) ([]*fhir.MedicationDispense, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(dispenseObjectType, []string{prescriptionID})
+	if err != nil {
+		return nil, errors.New("failed to get dispenses: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var dispenses []*fhir.MedicationDispense
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, errors.New("failed to iterate dispenses: " + err.Error())
+		}
+
+		var dispense fhir.MedicationDispense
+		if err := json.Unmarshal(queryResponse.Value, &dispense); err != nil {
+			return nil, errors.New("failed to unmarshal dispense: " + err.Error())
+		}
+		dispenses = append(dispenses, &dispense)
+	}
+
+	// Keys sort by transaction ID, not by time
+	sort.SliceStable(dispenses, func(i, j int) bool {
+		return dispenses[i].WhenHandedOver.Before(dispenses[j].WhenHandedOver)
+	})
+	return dispenses, nil
+}
+
+// dispense records a dispense of quantity by the caller's pharmacy and completes the
+// prescription with its last fill. A quantity of 0 dispenses the rest of the fill in progress.
+func (t *PrescriptionChaincode) dispense(ctx contractapi.TransactionContextInterface, prescriptionID string, quantity float64) (*fhir.MedicationDispense, error) {
+	prescription, err := t.getPrescription(ctx, prescriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if statusOf(prescription) != StatusActive {
+		return nil, errors.New("prescription is not active or no status code available")
+	}
+
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pharmacy := auth.OrganizationReference(caller.MSPID)
+	if designated := designatedPharmacy(prescription); designated != "" && designated != pharmacy {
+		return nil, errors.New("prescription " + prescriptionID + " is to be dispensed by " + designated)
+	}
+
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+	if err := checkValidity(prescription, prescriptionID, timestamp.AsTime()); err != nil {
+		return nil, err
+	}
+	if err := redeem(ctx, prescriptionID, pharmacy, timestamp.AsTime()); err != nil {
+		return nil, err
+	}
+
+	dispenses, err := t.listDispenses(ctx, prescriptionID)
+	if err != nil {
+		return nil, err
+	}
+	progress := progressOf(dispenses)
+	fills := allowedFills(prescription)
+	if progress.fills >= fills {
+		return nil, errors.New("all " + strconv.Itoa(fills) + " fills of prescription " + prescriptionID + " have been dispensed")
+	}
+
+	prescribed := prescribedQuantity(prescription)
+	completesFill := true
+	if prescribed != nil {
+		left := prescribed.Value - progress.dispensed
+		if quantity == 0 {
+			quantity = left
+		}
+		if quantity > left {
+			return nil, errors.New("cannot dispense " + formatQuantity(quantity) + " of prescription " + prescriptionID +
+				": only " + formatQuantity(left) + " left of fill " + strconv.Itoa(progress.fills+1) + " of " + strconv.Itoa(fills))
+		}
+		completesFill = quantity == left
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	dispense := fhir.MedicationDispense{
+		ID:                        &fhir.Identifier{Value: txID},
+		Status:                    "completed",
+		MedicationCodeableConcept: prescription.MedicationCodeableConcept,
+		Subject:                   prescription.Subject,
+		Performer:                 &fhir.Reference{Reference: pharmacy},
+		AuthorizingPrescription:   []fhir.Reference{{Reference: "MedicationRequest/" + prescriptionID}},
+		Type:                      fillType(progress, completesFill),
+		WhenHandedOver:            timestamp.AsTime(),
+	}
+	if prescribed != nil {
+		dispensed := *prescribed
+		dispensed.Value = quantity
+		dispense.Quantity = &dispensed
+	} else if quantity > 0 {
+		dispense.Quantity = &fhir.Quantity{Value: quantity}
+	}
+
+	dispenseKey, err := ctx.GetStub().CreateCompositeKey(dispenseObjectType, []string{prescriptionID, txID})
+	if err != nil {
+		return nil, errors.New("failed to create dispense key: " + err.Error())
+	}
+	dispenseJSON, err := json.Marshal(dispense)
+	if err != nil {
+		return nil, errors.New("failed to marshal dispense: " + err.Error())
+	}
+	if err := ctx.GetStub().PutState(dispenseKey, dispenseJSON); err != nil {
+		return nil, errors.New("failed to put state: " + err.Error())
+	}
+
+	if completesFill && progress.fills+1 == fills {
+		if err := t.transition(ctx, prescriptionID, prescription, StatusCompleted, ""); err != nil {
+			return nil, err
+		}
+	}
+	return &dispense, nil
+}
+
+// progressOf replays the dispenses of a prescription, oldest first
+func progressOf(dispenses []*fhir.MedicationDispense) dispenseProgress {
+	var progress dispenseProgress
+	for _, dispense := range dispenses {
+		if dispense.Type == nil || len(dispense.Type.Coding) == 0 {
+			continue
+		}
+		switch dispense.Type.Coding[0].Code {
+		case FillFirst, FillFirstComplete, FillRefill, FillRefillComplete:
+			progress.fills++
+			progress.dispensed = 0
+		case FillFirstPartial, FillRefillPartial:
+			if dispense.Quantity != nil {
+				progress.dispensed += dispense.Quantity.Value
+			}
+		}
+	}
+	return progress
+}
+
+// fillType classifies a dispense made at progress
+func fillType(progress dispenseProgress, completesFill bool) *fhir.CodeableConcept {
+	var code string
+	first := progress.fills == 0
+	switch {
+	case !completesFill && first:
+		code = FillFirstPartial
+	case !completesFill:
+		code = FillRefillPartial
+	case progress.dispensed > 0 && first:
+		code = FillFirstComplete
+	case progress.dispensed > 0:
+		code = FillRefillComplete
+	case first:
+		code = FillFirst
+	default:
+		code = FillRefill
+	}
+	return &fhir.CodeableConcept{Coding: []fhir.Coding{{System: actCodeSystem, Code: code}}}
+}
+
+// designatedPharmacy returns the pharmacy the prescriber directed the prescription to, or an
+// empty string when any pharmacy may dispense it
+func designatedPharmacy(prescription *fhir.MedicationRequest) string {
+	if prescription.DispenseRequest == nil || prescription.DispenseRequest.Performer == nil {
+		return ""
+	}
+	return prescription.DispenseRequest.Performer.Reference
+}
+
+// checkPharmacyRead checks that a pharmacist caller's pharmacy may read a prescription: it was
+// directed to the pharmacy, the pharmacy dispensed it, or the pharmacist passes its redemption
+// code in the transient field redemptionCode, as when the patient hands it over at the counter.
+// Pharmacists need no consent, so they must not read the prescriptions of other pharmacies.
+// Other callers were already checked by the access policy.
+func (t *PrescriptionChaincode) checkPharmacyRead(ctx contractapi.TransactionContextInterface, prescriptionID string) error {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	if !caller.HasRole(auth.RolePharmacist) {
+		return nil
+	}
+
+	prescription, err := t.getPrescription(ctx, prescriptionID)
+	if err != nil {
+		return err
+	}
+	pharmacy := auth.OrganizationReference(caller.MSPID)
+	if designatedPharmacy(prescription) == pharmacy {
+		return nil
+	}
+
+	dispenses, err := t.listDispenses(ctx, prescriptionID)
+	if err != nil {
+		return err
+	}
+	for _, dispense := range dispenses {
+		if dispense.Performer != nil && dispense.Performer.Reference == pharmacy {
+			return nil
+		}
+	}
+
+	presented, err := presentsRedemptionCode(ctx, prescriptionID)
+	if err != nil {
+		return err
+	}
+	if !presented {
+		return errors.New("prescription " + prescriptionID + " was not brought to this pharmacy")
+	}
+	return nil
+}
+
+// checkValidity checks that a prescription may be dispensed at now, according to its
+// DispenseRequest.ValidityPeriod
+func checkValidity(prescription *fhir.MedicationRequest, prescriptionID string, now time.Time) error {
+	if prescription.DispenseRequest == nil || prescription.DispenseRequest.ValidityPeriod == nil {
+		return nil
+	}
+	validity := prescription.DispenseRequest.ValidityPeriod
+	if !validity.Start.IsZero() && now.Before(validity.Start) {
+		return errors.New("prescription " + prescriptionID + " is not valid before " + validity.Start.Format(time.RFC3339))
+	}
+	if !validity.End.IsZero() && now.After(validity.End) {
+		return errors.New("prescription " + prescriptionID + " expired on " + validity.End.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// allowedFills returns how many times a prescription may be filled: once, plus its repeats
+func allowedFills(prescription *fhir.MedicationRequest) int {
+	if prescription.DispenseRequest == nil {
+		return 1
+	}
+	return 1 + prescription.DispenseRequest.NumberOfRepeatsAllowed
+}
+
+// prescribedQuantity returns the quantity of one fill, or nil when the prescription sets none
+func prescribedQuantity(prescription *fhir.MedicationRequest) *fhir.Quantity {
+	if prescription.DispenseRequest == nil || prescription.DispenseRequest.Quantity == nil || prescription.DispenseRequest.Quantity.Value <= 0 {
+		return nil
+	}
+	return prescription.DispenseRequest.Quantity
+}
+
+func formatQuantity(quantity float64) string {
+	return strconv.FormatFloat(quantity, 'f', -1, 64)
+}
@@ -0,0 +1,50 @@
+package main
+
+import "strings"
+
+// atcSystem identifies medications coded with the WHO Anatomical Therapeutic Chemical classification
+const atcSystem = "http://www.whocc.no/atc"
+
+// drugInteraction is a clinically significant interaction between two groups of medications,
+// each identified by an ATC code or by the code of an ATC group, which covers every medication
+// in the group
+type drugInteraction struct {
+	first    string // ATC code of the first group
+	second   string // ATC code of the second group
+	severity string // high, moderate or low, as in DetectedIssue.severity
+	detail   string // What the interaction causes
+}
+
+// drugInteractions is the interaction table the safety check consults
+var drugInteractions = []drugInteraction{
+	{"B01AA", "M01A", "high", "vitamin K antagonists with NSAIDs increase the risk of bleeding"},
+	{"B01AA", "J01FA", "high", "macrolides potentiate vitamin K antagonists and increase the risk of bleeding"},
+	{"B01AA", "N02BA", "high", "vitamin K antagonists with salicylates increase the risk of bleeding"},
+	{"C10AA01", "J01FA", "high", "macrolides raise simvastatin levels and the risk of rhabdomyolysis"},
+	{"N06AB", "N06AF", "high", "SSRIs with MAO inhibitors risk serotonin syndrome"},
+	{"N06AB", "N02CC", "moderate", "SSRIs with triptans risk serotonin syndrome"},
+	{"N02A", "N05BA", "high", "opioids with benzodiazepines risk respiratory depression"},
+	{"L04AX03", "J01EE", "high", "trimethoprim and sulfonamides increase methotrexate toxicity"},
+	{"C09A", "C03DA", "high", "ACE inhibitors with potassium-sparing diuretics risk hyperkalaemia"},
+	{"C09A", "M01A", "moderate", "NSAIDs reduce the effect of ACE inhibitors and impair renal function"},
+	{"C01AA", "C03CA", "moderate", "hypokalaemia caused by loop diuretics increases digoxin toxicity"},
+	{"G03A", "J04AB", "moderate", "rifamycins reduce the efficacy of hormonal contraceptives"},
+}
+
+// interactionBetween returns the interaction between two medications, or nil when the table
+// lists none
+func interactionBetween(atcCode string, otherATCCode string) *drugInteraction {
+	for i, interaction := range drugInteractions {
+		if (inATCGroup(atcCode, interaction.first) && inATCGroup(otherATCCode, interaction.second)) ||
+			(inATCGroup(atcCode, interaction.second) && inATCGroup(otherATCCode, interaction.first)) {
+			return &drugInteractions[i]
+		}
+	}
+	return nil
+}
+
+// inATCGroup reports whether the medication with ATC code atcCode belongs to the group with
+// ATC code group. Every medication belongs to its own group.
+func inATCGroup(atcCode string, group string) bool {
+	return group != "" && strings.HasPrefix(strings.ToUpper(atcCode), strings.ToUpper(group))
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+)
+
+// Prescription statuses, from the http://hl7.org/fhir/CodeSystem/medicationrequest-status code system
+const (
+	StatusActive         = "active"           // The prescription may be dispensed
+	StatusOnHold         = "on-hold"          // Dispensing is suspended until the prescription is resumed
+	StatusCancelled      = "cancelled"        // Withdrawn before anything was dispensed
+	StatusCompleted      = "completed"        // Everything prescribed was dispensed
+	StatusEnteredInError = "entered-in-error" // Should never have existed
+	StatusStopped        = "stopped"          // Withdrawn after part of it was dispensed
+	StatusDraft          = "draft"            // Not yet ready to be dispensed
+	StatusUnknown        = "unknown"          // The authoring system does not know the status
+)
+
+const statusSystem = "http://hl7.org/fhir/CodeSystem/medicationrequest-status"
+
+// statusTransitions lists the statuses each status may move to. Statuses absent from the
+// map, or mapped to nothing, are final.
+var statusTransitions = map[string][]string{
+	StatusDraft:     {StatusActive, StatusCancelled, StatusEnteredInError},
+	StatusActive:    {StatusOnHold, StatusCompleted, StatusCancelled, StatusStopped, StatusEnteredInError},
+	StatusOnHold:    {StatusActive, StatusCancelled, StatusStopped, StatusEnteredInError},
+	StatusCompleted: {StatusEnteredInError},
+	StatusCancelled: {StatusEnteredInError},
+	StatusStopped:   {StatusEnteredInError},
+	StatusUnknown:   {StatusEnteredInError},
+}
+
+// initialStatuses are the statuses a prescription may be created with
+var initialStatuses = []string{StatusDraft, StatusActive, StatusOnHold}
+
+// Status transitions are stored under the composite key StatusTransition~prescriptionID~txID
+// and are never updated or deleted
+const statusTransitionObjectType = "StatusTransition"
+
+// PrescriptionStatusEvent is the chaincode event emitted when a prescription changes status
+const PrescriptionStatusEvent = "PrescriptionStatusChanged"
+
+// StatusTransition records a change of status of a prescription
+type StatusTransition struct {
+	ID             string    `json:"id"`               // ID of the transaction that changed the status
+	PrescriptionID string    `json:"prescriptionId"`   // Prescription whose status changed
+	PatientID      string    `json:"patientId"`        // Patient the prescription is for
+	From           string    `json:"from"`             // Status before the transition
+	To             string    `json:"to"`               // Status after the transition
+	Reason         string    `json:"reason,omitempty"` // Why the status changed, as given by the caller
+	UserID         string    `json:"userId"`           // userId attribute of the caller
+	MSPID          string    `json:"mspId"`            // Organization of the caller
+	Role           string    `json:"role"`             // Role of the caller
+	Timestamp      time.Time `json:"timestamp"`        // Transaction timestamp of the transition
+}
+
+/*
+================================
+	LIFECYCLE OPERATIONS
+================================
+*/
+
+// ActivatePrescription makes a draft prescription ready to be dispensed
+func (t *PrescriptionChaincode) ActivatePrescription(ctx contractapi.TransactionContextInterface, prescriptionID string) error {
+	prescription, err := t.getPrescription(ctx, prescriptionID)
+	if err != nil {
+		return err
+	}
+	if err := checkPrescriber(ctx, prescriptionID, prescription); err != nil {
+		return err
+	}
+	if statusOf(prescription) != StatusDraft {
+		return errors.New("only draft prescriptions can be activated")
+	}
+	return t.transition(ctx, prescriptionID, prescription, StatusActive, "")
+}
+
+// CancelPrescription withdraws a prescription. A prescription partly dispensed can no
+// longer be cancelled, so it is stopped instead.
+func (t *PrescriptionChaincode) CancelPrescription(ctx contractapi.TransactionContextInterface, prescriptionID string, reason string) error {
+	if err := requireReason(StatusCancelled, reason); err != nil {
+		return err
+	}
+
+	prescription, err := t.getPrescription(ctx, prescriptionID)
+	if err != nil {
+		return err
+	}
+	if err := checkPrescriber(ctx, prescriptionID, prescription); err != nil {
+		return err
+	}
+
+	dispenses, err := t.listDispenses(ctx, prescriptionID)
+	if err != nil {
+		return err
+	}
+	if len(dispenses) > 0 {
+		return t.transition(ctx, prescriptionID, prescription, StatusStopped, reason)
+	}
+	return t.transition(ctx, prescriptionID, prescription, StatusCancelled, reason)
+}
+
+// SuspendPrescription puts a prescription on hold; it cannot be dispensed until resumed
+func (t *PrescriptionChaincode) SuspendPrescription(ctx contractapi.TransactionContextInterface, prescriptionID string, reason string) error {
+	return t.changeStatus(ctx, prescriptionID, StatusOnHold, reason)
+}
+
+// ResumePrescription reactivates a prescription put on hold, clearing the suspension reason
+func (t *PrescriptionChaincode) ResumePrescription(ctx contractapi.TransactionContextInterface, prescriptionID string) error {
+	prescription, err := t.getPrescription(ctx, prescriptionID)
+	if err != nil {
+		return err
+	}
+	if err := checkPrescriber(ctx, prescriptionID, prescription); err != nil {
+		return err
+	}
+	if statusOf(prescription) != StatusOnHold {
+		return errors.New("only prescriptions on hold can be resumed")
+	}
+	return t.transition(ctx, prescriptionID, prescription, StatusActive, "")
+}
+
+// MarkEnteredInError flags a prescription that should never have been written. It is
+// final and allowed from any status.
+func (t *PrescriptionChaincode) MarkEnteredInError(ctx contractapi.TransactionContextInterface, prescriptionID string, reason string) error {
+	return t.changeStatus(ctx, prescriptionID, StatusEnteredInError, reason)
+}
+
+// GetStatusHistory returns the status transitions of a prescription, oldest first
+func (t *PrescriptionChaincode) GetStatusHistory(ctx contractapi.TransactionContextInterface, prescriptionID string) ([]*StatusTransition, error) {
+	if err := t.checkPharmacyRead(ctx, prescriptionID); err != nil {
+		return nil, err
+	}
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(statusTransitionObjectType, []string{prescriptionID})
+	if err != nil {
+		return nil, errors.New("failed to get status history: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var transitions []*StatusTransition
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, errors.New("failed to iterate status history: " + err.Error())
+		}
+
+		var transition StatusTransition
+		if err := json.Unmarshal(queryResponse.Value, &transition); err != nil {
+			return nil, errors.New("failed to unmarshal status transition: " + err.Error())
+		}
+		transitions = append(transitions, &transition)
+	}
+
+	// Keys sort by transaction ID, not by time
+	sort.SliceStable(transitions, func(i, j int) bool {
+		return transitions[i].Timestamp.Before(transitions[j].Timestamp)
+	})
+	return transitions, nil
+}
+
+// changeStatus moves a prescription to status on behalf of its prescriber, recording why
+func (t *PrescriptionChaincode) changeStatus(ctx contractapi.TransactionContextInterface, prescriptionID string, status string, reason string) error {
+	if err := requireReason(status, reason); err != nil {
+		return err
+	}
+
+	prescription, err := t.getPrescription(ctx, prescriptionID)
+	if err != nil {
+		return err
+	}
+	if err := checkPrescriber(ctx, prescriptionID, prescription); err != nil {
+		return err
+	}
+	return t.transition(ctx, prescriptionID, prescription, status, reason)
+}
+
+func requireReason(status string, reason string) error {
+	if strings.TrimSpace(reason) == "" {
+		return errors.New("a reason is required to set a prescription " + status)
+	}
+	return nil
+}
+
+// checkPrescriber checks that the caller is the requester of a prescription, enrolled by the
+// same organization. Only the prescriber changes its status; pharmacies complete it by dispensing.
+func checkPrescriber(ctx contractapi.TransactionContextInterface, prescriptionID string, prescription *fhir.MedicationRequest) error {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	if prescription.Requester == nil || prescription.Requester.Reference != "Practitioner/"+caller.UserID ||
+		prescriberOrganization(prescription) != auth.OrganizationReference(caller.MSPID) {
+		return errors.New("only the prescriber of prescription " + prescriptionID + " may change its status")
+	}
+	return nil
+}
+
+// transition checks that prescription may move to status, stores it with its new status
+// and status reason, and records the transition
+func (t *PrescriptionChaincode) transition(ctx contractapi.TransactionContextInterface, prescriptionID string, prescription *fhir.MedicationRequest, status string, reason string) error {
+	from := statusOf(prescription)
+	if !canTransition(from, status) {
+		return errors.New("cannot move prescription " + prescriptionID + " from " + describeStatus(from) + " to " + status)
+	}
+
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+
+	setStatus(prescription, status)
+	if reason != "" {
+		prescription.StatusReason = &fhir.CodeableConcept{Text: reason}
+	} else {
+		prescription.StatusReason = nil
+	}
+
+	prescriptionJSON, err := json.Marshal(prescription)
+	if err != nil {
+		return errors.New("failed to marshal updated prescription")
+	}
+	if err := t.putPrescription(ctx, prescriptionID, prescriptionJSON); err != nil {
+		return err
+	}
+
+	record := StatusTransition{
+		ID:             ctx.GetStub().GetTxID(),
+		PrescriptionID: prescriptionID,
+		From:           from,
+		To:             status,
+		Reason:         reason,
+		UserID:         caller.UserID,
+		MSPID:          caller.MSPID,
+		Role:           caller.Role,
+		Timestamp:      timestamp.AsTime(),
+	}
+	if prescription.Subject != nil {
+		record.PatientID = auth.PatientID(prescription.Subject.Reference)
+	}
+
+	recordKey, err := ctx.GetStub().CreateCompositeKey(statusTransitionObjectType, []string{prescriptionID, record.ID})
+	if err != nil {
+		return errors.New("failed to create status transition key: " + err.Error())
+	}
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return errors.New("failed to marshal status transition: " + err.Error())
+	}
+	if err := ctx.GetStub().PutState(recordKey, recordJSON); err != nil {
+		return errors.New("failed to put state: " + err.Error())
+	}
+	if err := ctx.GetStub().SetEvent(PrescriptionStatusEvent, recordJSON); err != nil {
+		return errors.New("failed to set event: " + err.Error())
+	}
+	return nil
+}
+
+func (t *PrescriptionChaincode) getPrescription(ctx contractapi.TransactionContextInterface, prescriptionID string) (*fhir.MedicationRequest, error) {
+	prescriptionJSON, err := t.readPrescription(ctx, prescriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var prescription fhir.MedicationRequest
+	if err := json.Unmarshal([]byte(prescriptionJSON), &prescription); err != nil {
+		return nil, errors.New("failed to unmarshal prescription")
+	}
+	return &prescription, nil
+}
+
+// statusOf returns the status code of a prescription, or an empty string when it has none
+func statusOf(prescription *fhir.MedicationRequest) string {
+	if prescription.Status == nil || len(prescription.Status.Coding) == 0 {
+		return ""
+	}
+	return prescription.Status.Coding[0].Code
+}
+
+func setStatus(prescription *fhir.MedicationRequest, status string) {
+	prescription.Status = &fhir.Code{Coding: []fhir.Coding{{System: statusSystem, Code: status, Display: status}}}
+}
+
+// canTransition reports whether a prescription may move from one status to another.
+// Any prescription may be marked entered in error, even one without a valid status.
+func canTransition(from string, to string) bool {
+	if to == StatusEnteredInError {
+		return from != StatusEnteredInError
+	}
+	for _, status := range statusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// validateInitialStatus checks the status a prescription is created with
+func validateInitialStatus(prescription *fhir.MedicationRequest) error {
+	status := statusOf(prescription)
+	for _, allowed := range initialStatuses {
+		if status == allowed {
+			return nil
+		}
+	}
+	return errors.New("a new prescription must be " + strings.Join(initialStatuses, ", ") + ", not " + describeStatus(status))
+}
+
+func describeStatus(status string) string {
+	if status == "" {
+		return "no status"
+	}
+	return status
+}
@@ -0,0 +1,37 @@
+package main
+
+import "github.com/xDaryamo/MedChain/auth"
+
+var prescriberRoles = []string{auth.RoleDoctor}
+
+// prescriptionPolicies declares who may call each PrescriptionChaincode transaction.
+// Clinics prescribe, pharmacies dispense; pharmacists act on the prescription itself and need no consent,
+// so they only read the prescriptions brought to their pharmacy (see checkPharmacyRead).
+var prescriptionPolicies = auth.Policies{
+	"CreatePrescription":             {MSPs: auth.ClinicMSPs, Roles: prescriberRoles, Subject: auth.PayloadSubject(0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionWrite},
+	"CreatePrescriptionWithOverride": {MSPs: auth.ClinicMSPs, Roles: prescriberRoles, Subject: auth.PayloadSubject(0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionWrite},
+	"CheckPrescriptionSafety":        {MSPs: auth.ClinicMSPs, Roles: prescriberRoles, Subject: auth.PayloadSubject(0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionWrite},
+	"GetDetectedIssues":              {Roles: []string{auth.RoleDoctor, auth.RolePharmacist, auth.RolePatient}, Subject: auth.StoredSubject("MedicationRequest", 0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionRead},
+	"VerifyPrescription":             {MSPs: auth.PharmacyMSPs, Roles: []string{auth.RolePharmacist}, Subject: auth.StoredSubject("MedicationRequest", 0)},
+	"DispensePrescription":           {MSPs: auth.PharmacyMSPs, Roles: []string{auth.RolePharmacist}, Subject: auth.StoredSubject("MedicationRequest", 0)},
+	"GetDispenses":                   {Roles: []string{auth.RoleDoctor, auth.RolePharmacist, auth.RolePatient}, Subject: auth.StoredSubject("MedicationRequest", 0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionRead},
+	"ReadPrescription":               {Roles: []string{auth.RoleDoctor, auth.RolePharmacist, auth.RolePatient}, Subject: auth.StoredSubject("MedicationRequest", 0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionRead},
+	"GetPrescriptionHistory":         {Roles: []string{auth.RoleDoctor, auth.RolePharmacist, auth.RolePatient}, Subject: auth.LastKnownSubject("MedicationRequest", 0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionRead},
+	"AuditedReadPrescription":        {Roles: []string{auth.RoleDoctor, auth.RolePharmacist, auth.RolePatient}, Subject: auth.StoredSubject("MedicationRequest", 0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionRead},
+	"GetAccessLog":                   {Roles: []string{auth.RolePatient, auth.RoleCompliance}, Subject: auth.Arg(0)},
+	"PrescriptionExists":             {Roles: []string{auth.RoleDoctor, auth.RolePharmacist}},
+	"QueryPrescriptionsByPatient":    {Roles: []string{auth.RoleDoctor, auth.RolePharmacist, auth.RolePatient}, Subject: auth.Arg(0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionRead},
+	"QueryPrescriptionsByAuthoredOn": {Roles: []string{auth.RoleDoctor, auth.RolePharmacist, auth.RolePatient}, Subject: auth.Arg(0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionRead},
+	"QueryPrescriptionsByRequester":  {MSPs: auth.ClinicMSPs, Roles: prescriberRoles},
+	"QueryPrescriptionsByPerformer":  {MSPs: auth.PharmacyMSPs, Roles: []string{auth.RolePharmacist}},
+
+	"ActivatePrescription": {MSPs: auth.ClinicMSPs, Roles: prescriberRoles, Subject: auth.StoredSubject("MedicationRequest", 0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionWrite},
+	"CancelPrescription":   {MSPs: auth.ClinicMSPs, Roles: prescriberRoles, Subject: auth.StoredSubject("MedicationRequest", 0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionWrite},
+	"SuspendPrescription":  {MSPs: auth.ClinicMSPs, Roles: prescriberRoles, Subject: auth.StoredSubject("MedicationRequest", 0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionWrite},
+	"ResumePrescription":   {MSPs: auth.ClinicMSPs, Roles: prescriberRoles, Subject: auth.StoredSubject("MedicationRequest", 0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionWrite},
+	"MarkEnteredInError":   {MSPs: auth.ClinicMSPs, Roles: prescriberRoles, Subject: auth.StoredSubject("MedicationRequest", 0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionWrite},
+	"GetStatusHistory":     {Roles: []string{auth.RoleDoctor, auth.RolePharmacist, auth.RolePatient}, Subject: auth.StoredSubject("MedicationRequest", 0), ConsentRoles: prescriberRoles, Resource: "MedicationRequest", Action: auth.ActionRead},
+	"MigrateKeys":          {Roles: []string{auth.RoleAdmin}},
+}
+
+var prescriptionEnforcer = &auth.Enforcer{Policies: prescriptionPolicies, Consent: auth.PatientConsent}
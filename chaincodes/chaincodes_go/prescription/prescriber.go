@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+)
+
+// Location of the practitioner chaincode, which owns the practitioner registry. The pharmacies
+// are not joined to its channel, so only clinic peers can endorse the transactions querying it.
+const (
+	practitionerChaincode = "practitioner"
+	practitionerChannel   = "patient-records-channel"
+)
+
+// prescriberOrganizationURL identifies the extension of a MedicationRequest holding the
+// organization the prescriber belongs to, since a userId is only unique within its organization
+const prescriberOrganizationURL = "urn:medchain:fhir:prescriber-organization"
+
+// prescriberQualification is the qualification a prescriber must hold: Doctor of Medicine,
+// from the http://terminology.hl7.org/CodeSystem/v2-0360 code system
+const prescriberQualification = "MD"
+
+// setPrescriber checks, by querying the EvaluateQualification function of the practitioner
+// chaincode, that the caller holds a valid medical qualification, and records the caller and
+// their organization as the requester of the prescription
+func setPrescriber(ctx contractapi.TransactionContextInterface, prescription *fhir.MedicationRequest) error {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	if caller.UserID == "" {
+		return errors.New("the caller's certificate has no userId attribute")
+	}
+
+	args := [][]byte{
+		[]byte("EvaluateQualification"),
+		[]byte(caller.UserID),
+		[]byte(prescriberQualification),
+	}
+	response := ctx.GetStub().InvokeChaincode(practitionerChaincode, args, practitionerChannel)
+	if response.Status != 200 {
+		return errors.New("failed to check qualification: " + response.Message)
+	}
+
+	qualified, err := strconv.ParseBool(string(response.Payload))
+	if err != nil {
+		return errors.New("failed to parse qualification response: " + err.Error())
+	}
+	if !qualified {
+		return errors.New("practitioner " + caller.UserID + " holds no valid medical qualification")
+	}
+
+	prescription.Requester = &fhir.Reference{Reference: "Practitioner/" + caller.UserID}
+	extensions := []fhir.Extension{{URL: prescriberOrganizationURL, ValueReference: &fhir.Reference{Reference: auth.OrganizationReference(caller.MSPID)}}}
+	for _, extension := range prescription.Extension {
+		if extension.URL != prescriberOrganizationURL {
+			extensions = append(extensions, extension)
+		}
+	}
+	prescription.Extension = extensions
+	return nil
+}
+
+// prescriberOrganization returns the reference to the organization of the prescriber of a
+// prescription, empty when none was recorded
+func prescriberOrganization(prescription *fhir.MedicationRequest) string {
+	for _, extension := range prescription.Extension {
+		if extension.URL == prescriberOrganizationURL && extension.ValueReference != nil {
+			return extension.ValueReference.Reference
+		}
+	}
+	return ""
+}
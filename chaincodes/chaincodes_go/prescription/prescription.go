@@ -4,72 +4,114 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+	"github.com/xDaryamo/MedChain/history"
+	"github.com/xDaryamo/MedChain/ledger"
 )
 
+// Prescriptions are stored under the composite key MedicationRequest~prescriptionID
+const prescriptionObjectType = "MedicationRequest"
+
 type PrescriptionChaincode struct {
 	contractapi.Contract
 }
 
+// CreatePrescription stores a new prescription written by the caller, with the redemption code
+// passed in the transient field redemptionCode. It is rejected when the safety check finds issues
+// with it; see CheckPrescriptionSafety and CreatePrescriptionWithOverride.
 func (t *PrescriptionChaincode) CreatePrescription(ctx contractapi.TransactionContextInterface, medicationRequestJSON string) error {
-	var medicationRequest MedicationRequest
+	_, err := t.createPrescription(ctx, medicationRequestJSON, "")
+	return err
+}
+
+// createPrescription stores a new prescription, overriding the issues found by the safety check
+// for overrideReason. Without a reason, any issue rejects the prescription.
+func (t *PrescriptionChaincode) createPrescription(ctx contractapi.TransactionContextInterface, medicationRequestJSON string, overrideReason string) ([]*fhir.DetectedIssue, error) {
+	var medicationRequest fhir.MedicationRequest
 	err := json.Unmarshal([]byte(medicationRequestJSON), &medicationRequest)
 	if err != nil {
-		return errors.New("failed to decode JSON")
+		return nil, errors.New("failed to decode JSON")
 	}
 
 	if medicationRequest.ID == nil || medicationRequest.ID.Value == "" {
-		return errors.New("medication request ID is required")
-	}
-
-	exists, err := t.PrescriptionExists(ctx, medicationRequest.ID.Value)
-	if err != nil {
-		return err
+		return nil, errors.New("medication request ID is required")
 	}
-	if exists {
-		return errors.New("the prescription already exists")
+	if err := validateInitialStatus(&medicationRequest); err != nil {
+		return nil, err
 	}
 
-	medicationRequestAsBytes, err := json.Marshal(medicationRequest)
-	if err != nil {
-		return errors.New("failed to marshal medication request")
+	// The requester is whoever signs the transaction, whatever the payload says
+	if err := setPrescriber(ctx, &medicationRequest); err != nil {
+		return nil, err
 	}
 
-	return ctx.GetStub().PutState(medicationRequest.ID.Value, medicationRequestAsBytes)
-}
+	// Queries by authoredOn compare the stored strings, which needs them in one time zone
+	medicationRequest.AuthoredOn = medicationRequest.AuthoredOn.UTC().Truncate(time.Second)
 
-func (t *PrescriptionChaincode) VerifyPrescription(ctx contractapi.TransactionContextInterface, prescriptionID string, pharmacyID string) error {
-	prescriptionAsBytes, err := ctx.GetStub().GetState(prescriptionID)
+	exists, err := t.PrescriptionExists(ctx, medicationRequest.ID.Value)
 	if err != nil {
-		return errors.New("failed to read from world state")
+		return nil, err
 	}
-	if prescriptionAsBytes == nil {
-		return errors.New("the prescription does not exist")
+	if exists {
+		return nil, errors.New("the prescription already exists")
 	}
 
-	var prescription MedicationRequest
-	err = json.Unmarshal(prescriptionAsBytes, &prescription)
+	issues, err := detectIssues(ctx, &medicationRequest)
 	if err != nil {
-		return errors.New("failed to unmarshal prescription")
+		return nil, err
+	}
+	if len(issues) > 0 {
+		if overrideReason == "" {
+			return nil, errors.New("the safety check found issues: " + describeIssues(issues))
+		}
+		if err := overrideIssues(ctx, &medicationRequest, issues, overrideReason); err != nil {
+			return nil, err
+		}
 	}
 
-	if prescription.Status == nil || len(prescription.Status.Coding) == 0 || prescription.Status.Coding[0].Code != "active" {
-		return errors.New("prescription is not active or no status code available")
+	if err := issueRedemptionCode(ctx, medicationRequest.ID.Value); err != nil {
+		return nil, err
 	}
 
-	prescription.Status.Coding[0].Code = "completed"
-	prescription.DispenseRequest.Performer = &Reference{Reference: pharmacyID}
-	updatedPrescriptionAsBytes, err := json.Marshal(prescription)
+	medicationRequestAsBytes, err := json.Marshal(medicationRequest)
 	if err != nil {
-		return errors.New("failed to marshal updated prescription")
+		return nil, errors.New("failed to marshal medication request")
 	}
 
-	return ctx.GetStub().PutState(prescriptionID, updatedPrescriptionAsBytes)
+	if err := t.putPrescription(ctx, medicationRequest.ID.Value, medicationRequestAsBytes); err != nil {
+		return nil, err
+	}
+	return issues, nil
 }
 
+// VerifyPrescription dispenses, on behalf of the caller's pharmacy, the rest of the fill in
+// progress of an active prescription. Use DispensePrescription to dispense part of a fill.
+// The first dispense consumes the redemption code, passed in the transient field redemptionCode.
+func (t *PrescriptionChaincode) VerifyPrescription(ctx contractapi.TransactionContextInterface, prescriptionID string) error {
+	_, err := t.dispense(ctx, prescriptionID, 0)
+	return err
+}
+
+// ReadPrescription returns a prescription. Pharmacists may only read the prescriptions
+// brought to their pharmacy; see checkPharmacyRead.
 func (t *PrescriptionChaincode) ReadPrescription(ctx contractapi.TransactionContextInterface, prescriptionID string) (string, error) {
-	prescriptionAsBytes, err := ctx.GetStub().GetState(prescriptionID)
+	if err := t.checkPharmacyRead(ctx, prescriptionID); err != nil {
+		return "", err
+	}
+	return t.readPrescription(ctx, prescriptionID)
+}
+
+// readPrescription returns a prescription, whoever the caller
+func (t *PrescriptionChaincode) readPrescription(ctx contractapi.TransactionContextInterface, prescriptionID string) (string, error) {
+	prescriptionKey, err := ledger.Key(ctx, prescriptionObjectType, prescriptionID)
+	if err != nil {
+		return "", err
+	}
+	prescriptionAsBytes, err := ctx.GetStub().GetState(prescriptionKey)
 	if err != nil {
 		return "", errors.New("failed to read from world state")
 	}
@@ -80,16 +122,68 @@ func (t *PrescriptionChaincode) ReadPrescription(ctx contractapi.TransactionCont
 	return string(prescriptionAsBytes), nil
 }
 
+// AuditedReadPrescription reads a prescription like ReadPrescription and records the read
+// in the patient's access log. It must be submitted for the log entry to be kept.
+func (t *PrescriptionChaincode) AuditedReadPrescription(ctx contractapi.TransactionContextInterface, prescriptionID string) (string, error) {
+	if _, err := prescriptionEnforcer.Audit(ctx, "ReadPrescription", []string{prescriptionID}, "MedicationRequest", prescriptionID); err != nil {
+		return "", err
+	}
+	return t.ReadPrescription(ctx, prescriptionID)
+}
+
+// GetAccessLog returns the audited reads of the prescriptions of patientID
+func (t *PrescriptionChaincode) GetAccessLog(ctx contractapi.TransactionContextInterface, patientID string) ([]*auth.AccessLogEntry, error) {
+	return auth.GetAccessLog(ctx, patientID)
+}
+
+// GetPrescriptionHistory returns every version of a prescription as a FHIR history Bundle,
+// including deletions, with the transaction and identity that wrote each version
+func (t *PrescriptionChaincode) GetPrescriptionHistory(ctx contractapi.TransactionContextInterface, prescriptionID string) (string, error) {
+	if err := t.checkPharmacyRead(ctx, prescriptionID); err != nil {
+		return "", err
+	}
+	prescriptionKey, err := ledger.Key(ctx, prescriptionObjectType, prescriptionID)
+	if err != nil {
+		return "", err
+	}
+	return history.GetHistoryJSON(ctx, prescriptionKey, "MedicationRequest", prescriptionID)
+}
+
 func (t *PrescriptionChaincode) PrescriptionExists(ctx contractapi.TransactionContextInterface, prescriptionID string) (bool, error) {
-	prescriptionAsBytes, err := ctx.GetStub().GetState(prescriptionID)
+	prescriptionKey, err := ledger.Key(ctx, prescriptionObjectType, prescriptionID)
+	if err != nil {
+		return false, err
+	}
+	prescriptionAsBytes, err := ctx.GetStub().GetState(prescriptionKey)
 	if err != nil {
 		return false, errors.New("failed to read from world state")
 	}
 	return prescriptionAsBytes != nil, nil
 }
 
+// MigrateKeys moves up to limit prescriptions stored under their bare ID, as written before
+// prescriptions were namespaced, to their MedicationRequest~prescriptionID key. It returns
+// how many it moved; call it until it returns 0.
+func (t *PrescriptionChaincode) MigrateKeys(ctx contractapi.TransactionContextInterface, limit int) (int, error) {
+	return ledger.MigrateKeys(ctx, func(key string, value []byte) (string, string, bool) {
+		return prescriptionObjectType, key, true
+	}, limit)
+}
+
+func (t *PrescriptionChaincode) putPrescription(ctx contractapi.TransactionContextInterface, prescriptionID string, prescriptionJSON []byte) error {
+	prescriptionKey, err := ledger.Key(ctx, prescriptionObjectType, prescriptionID)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(prescriptionKey, prescriptionJSON)
+}
+
 func main() {
-	chaincode, err := contractapi.NewChaincode(new(PrescriptionChaincode))
+	prescriptionChaincode := new(PrescriptionChaincode)
+	prescriptionChaincode.BeforeTransaction = prescriptionEnforcer.BeforeTransaction
+	prescriptionChaincode.AfterTransaction = history.RecordSubmitter
+
+	chaincode, err := contractapi.NewChaincode(prescriptionChaincode)
 	if err != nil {
 		log.Panic("Error creating prescription chaincode: ", err)
 	}
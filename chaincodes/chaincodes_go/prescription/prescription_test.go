@@ -1,18 +1,23 @@
 package main
 
 import (
+	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
 )
 
 // MockStub is a mock implementation of the ChaincodeStubInterface
@@ -236,6 +241,190 @@ func (m *MockTransactionContext) GetClientIdentity() cid.ClientIdentity {
 	return args.Get(0).(cid.ClientIdentity)
 }
 
+// MockClientIdentity is a mock implementation of the cid.ClientIdentity interface
+type MockClientIdentity struct {
+	mock.Mock
+}
+
+func (mci *MockClientIdentity) GetID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetMSPID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	args := mci.Called(attrName)
+	return args.String(0), args.Bool(1), args.Error(2)
+}
+
+func (mci *MockClientIdentity) HasAttribute(attrName string) (bool, error) {
+	args := mci.Called(attrName)
+	return args.Bool(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	args := mci.Called(attrName, attrValue)
+	return args.Error(0)
+}
+
+func (mci *MockClientIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	args := mci.Called()
+	return args.Get(0).(*x509.Certificate), args.Error(1)
+}
+
+// mockPatientCaller makes patientID the caller of the transaction, with an enrolled certificate
+func mockPatientCaller(mockCtx *MockTransactionContext, mockStub *MockStub, patientID string) *MockClientIdentity {
+	clientIdentity := new(MockClientIdentity)
+	clientIdentity.On("GetID").Return("x509::CN="+patientID, nil)
+	clientIdentity.On("GetMSPID").Return("PatientMSP", nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(patientID, true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return("patient", true, nil)
+	mockCtx.On("GetClientIdentity").Return(clientIdentity)
+	mockStub.On("InvokeChaincode", "patient", [][]byte{[]byte("VerifyIdentity")}, "patient-records-channel").Return(peer.Response{Status: 200})
+	return clientIdentity
+}
+
+// mockAccessLog expects an access log entry for patientID, written by transaction txID
+func mockAccessLog(mockStub *MockStub, patientID string, txID string, function string) {
+	logKey := "\x00AccessLog\x00" + patientID + "\x00" + txID + "\x00"
+	mockStub.On("GetTxID").Return(txID)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	mockStub.On("CreateCompositeKey", "AccessLog", []string{patientID, txID}).Return(logKey, nil)
+	mockStub.On("PutState", logKey, mock.MatchedBy(func(value []byte) bool {
+		var entry auth.AccessLogEntry
+		return json.Unmarshal(value, &entry) == nil && entry.Function == function &&
+			entry.UserID == patientID && entry.Grant.Basis == auth.BasisPatient
+	})).Return(nil)
+}
+
+// mockCaller sets up the certificate attributes of the caller
+func mockCaller(mockCtx *MockTransactionContext, userID string, mspID string, role string) {
+	clientIdentity := new(MockClientIdentity)
+	clientIdentity.On("GetID").Return("x509::CN="+userID, nil)
+	clientIdentity.On("GetMSPID").Return(mspID, nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(userID, true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return(role, true, nil)
+	mockCtx.On("GetClientIdentity").Return(clientIdentity)
+}
+
+// mockStatusTransition expects transaction txID to record and announce the move of
+// prescriptionID from one status to another
+func mockStatusTransition(mockStub *MockStub, prescriptionID string, txID string, from string, to string) {
+	recordKey := "\x00StatusTransition\x00" + prescriptionID + "\x00" + txID + "\x00"
+	isTransition := mock.MatchedBy(func(value []byte) bool {
+		var transition StatusTransition
+		return json.Unmarshal(value, &transition) == nil && transition.From == from && transition.To == to
+	})
+	mockStub.On("GetTxID").Return(txID)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	mockStub.On("CreateCompositeKey", "StatusTransition", []string{prescriptionID, txID}).Return(recordKey, nil)
+	mockStub.On("PutState", recordKey, isTransition).Return(nil)
+	mockStub.On("SetEvent", PrescriptionStatusEvent, isTransition).Return(nil)
+}
+
+// mockDispenses sets up the dispenses already recorded for prescriptionID
+func mockDispenses(mockStub *MockStub, prescriptionID string, dispenses ...fhir.MedicationDispense) {
+	iterator := &MockIterator{}
+	for _, dispense := range dispenses {
+		dispenseJSON, _ := json.Marshal(dispense)
+		iterator.AddRecord("\x00MedicationDispense\x00"+prescriptionID+"\x00"+dispense.ID.Value+"\x00", dispenseJSON)
+	}
+	mockStub.On("GetStateByPartialCompositeKey", "MedicationDispense", []string{prescriptionID}).Return(iterator, nil)
+}
+
+// recordedDispense returns a dispense of the given fill type and quantity made in transaction txID
+func recordedDispense(txID string, fillType string, quantity float64, handedOver time.Time) fhir.MedicationDispense {
+	return fhir.MedicationDispense{
+		ID:             &fhir.Identifier{Value: txID},
+		Status:         "completed",
+		Type:           &fhir.CodeableConcept{Coding: []fhir.Coding{{System: actCodeSystem, Code: fillType}}},
+		Quantity:       &fhir.Quantity{Value: quantity, Unit: "teaspoonful"},
+		WhenHandedOver: handedOver,
+	}
+}
+
+// mockDispense expects transaction txID to record a dispense of the given fill type and quantity
+func mockDispense(mockStub *MockStub, prescriptionID string, txID string, fillType string, quantity float64) {
+	dispenseKey := "\x00MedicationDispense\x00" + prescriptionID + "\x00" + txID + "\x00"
+	mockStub.On("GetTxID").Return(txID)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	mockStub.On("CreateCompositeKey", "MedicationDispense", []string{prescriptionID, txID}).Return(dispenseKey, nil)
+	mockStub.On("PutState", dispenseKey, mock.MatchedBy(func(value []byte) bool {
+		var dispense fhir.MedicationDispense
+		return json.Unmarshal(value, &dispense) == nil && dispense.Type.Coding[0].Code == fillType &&
+			dispense.Quantity != nil && dispense.Quantity.Value == quantity &&
+			dispense.AuthorizingPrescription[0].Reference == "MedicationRequest/"+prescriptionID
+	})).Return(nil)
+}
+
+// withRepeats returns prescriptionJSON allowing the given number of repeat fills
+func withRepeats(prescriptionJSON string, repeats int) string {
+	var prescription fhir.MedicationRequest
+	json.Unmarshal([]byte(prescriptionJSON), &prescription)
+	prescription.DispenseRequest.NumberOfRepeatsAllowed = repeats
+	updatedJSON, _ := json.Marshal(prescription)
+	return string(updatedJSON)
+}
+
+// mockPrescriber sets up doctorID as the caller, with the practitioner chaincode answering
+// whether they hold a valid medical qualification
+func mockPrescriber(mockCtx *MockTransactionContext, mockStub *MockStub, doctorID string, qualified bool) {
+	mockCaller(mockCtx, doctorID, "MedicinaGeneraleNapoliMSP", "doctor")
+	args := [][]byte{[]byte("EvaluateQualification"), []byte(doctorID), []byte("MD")}
+	mockStub.On("InvokeChaincode", "practitioner", args, "patient-records-channel").
+		Return(peer.Response{Status: 200, Payload: []byte(strconv.FormatBool(qualified))})
+}
+
+// testRedemptionCode is the redemption code the tests pass in transient data
+const testRedemptionCode = "K7QX2M9PLR4TB8VN"
+
+// mockTransientCode sets up the redemption code passed in the transient data, or none when code is empty
+func mockTransientCode(mockStub *MockStub, code string) {
+	transient := map[string][]byte{}
+	if code != "" {
+		transient["redemptionCode"] = []byte(code)
+	}
+	mockStub.On("GetTransient").Return(transient, nil)
+}
+
+// mockIssuedRedemptionCode expects the hash of code to be stored as the redemption code of prescriptionID
+func mockIssuedRedemptionCode(mockStub *MockStub, prescriptionID string, code string) {
+	mockTransientCode(mockStub, code)
+	redemptionKey := mockKey(mockStub, "Redemption", prescriptionID)
+	mockStub.On("PutState", redemptionKey, mock.MatchedBy(func(value []byte) bool {
+		var record redemption
+		return json.Unmarshal(value, &record) == nil && record.CodeHash == hashRedemptionCode(code) && record.Pharmacy == ""
+	})).Return(nil)
+}
+
+// mockRedemption sets up the stored redemption record of prescriptionID, nil for none, and
+// returns its key
+func mockRedemption(mockStub *MockStub, prescriptionID string, record *redemption) string {
+	redemptionKey := mockKey(mockStub, "Redemption", prescriptionID)
+	if record == nil {
+		mockStub.On("GetState", redemptionKey).Return(nil, nil)
+		return redemptionKey
+	}
+	recordJSON, _ := json.Marshal(record)
+	mockStub.On("GetState", redemptionKey).Return(recordJSON, nil)
+	return redemptionKey
+}
+
+// mockCodeRedemption expects the first dispense of prescriptionID, by pharmacy, to consume its
+// redemption code
+func mockCodeRedemption(mockStub *MockStub, prescriptionID string, pharmacy string) {
+	redemptionKey := mockRedemption(mockStub, prescriptionID, &redemption{CodeHash: hashRedemptionCode(testRedemptionCode)})
+	mockTransientCode(mockStub, testRedemptionCode)
+	mockStub.On("PutState", redemptionKey, mock.MatchedBy(func(value []byte) bool {
+		var record redemption
+		return json.Unmarshal(value, &record) == nil && record.CodeHash == "" && record.Pharmacy == pharmacy
+	})).Return(nil)
+}
+
 // KVPair represents a key-value pair for testing purposes
 type KVPair struct {
 	Key   string
@@ -283,13 +472,13 @@ func (m *MockIterator) Close() error {
 func generateMedicationRequestJSON(id string, status string) string {
 	// Create a simple MedicationRequest struct
 	now := time.Now() // Creare il valore time.Time
-	medicationRequest := MedicationRequest{
-		ID: &Identifier{
+	medicationRequest := fhir.MedicationRequest{
+		ID: &fhir.Identifier{
 			System: "http://hospital.smarthealth.it/medicationrequests",
 			Value:  id,
 		},
-		Status: &Code{
-			Coding: []Coding{
+		Status: &fhir.Code{
+			Coding: []fhir.Coding{
 				{
 					System:  "http://hl7.org/fhir/medicationrequest-status",
 					Code:    status,
@@ -297,8 +486,8 @@ func generateMedicationRequestJSON(id string, status string) string {
 				},
 			},
 		},
-		Intent: &Code{
-			Coding: []Coding{
+		Intent: &fhir.Code{
+			Coding: []fhir.Coding{
 				{
 					System:  "http://hl7.org/fhir/medication-request-intent",
 					Code:    "order",
@@ -306,8 +495,8 @@ func generateMedicationRequestJSON(id string, status string) string {
 				},
 			},
 		},
-		MedicationCodeableConcept: &CodeableConcept{
-			Coding: []Coding{
+		MedicationCodeableConcept: &fhir.CodeableConcept{
+			Coding: []fhir.Coding{
 				{
 					System:  "http://www.nlm.nih.gov/research/umls/rxnorm",
 					Code:    "582620",
@@ -316,29 +505,35 @@ func generateMedicationRequestJSON(id string, status string) string {
 			},
 			Text: "Amoxicillin 250mg/5ml Suspension",
 		},
-		Subject: &Reference{
+		Subject: &fhir.Reference{
 			Reference: "Patient/example",
 			Display:   "John Doe",
 		},
-		AuthoredOn: &now, // Usare l'indirizzo del valore time.Time
-		Requester: &Reference{
+		AuthoredOn: now,
+		Requester: &fhir.Reference{
 			Reference: "Practitioner/example",
 			Display:   "Dr. Jane Smith",
 		},
-		DosageInstruction: []Dosage{
+		Extension: []fhir.Extension{
+			{
+				URL:            prescriberOrganizationURL,
+				ValueReference: &fhir.Reference{Reference: "Organization/MedicinaGeneraleNapoli"},
+			},
+		},
+		DosageInstruction: []fhir.Dosage{
 			{
 				Text: "Take one teaspoonful by mouth three times daily",
 			},
 		},
-		DispenseRequest: &DispenseRequest{
-			Performer: &Reference{
-				Reference: "Organization/pharmacy",
+		DispenseRequest: &fhir.DispenseRequest{
+			Performer: &fhir.Reference{
+				Reference: "Organization/FarmaciaPetrone",
 			},
-			Quantity: &Quantity{
+			Quantity: &fhir.Quantity{
 				Value: 15,
 				Unit:  "teaspoonful",
 			},
-			ExpectedSupplyDuration: &Duration{
+			ExpectedSupplyDuration: &fhir.Duration{
 				Value: 10,
 				Unit:  "days",
 			},
@@ -354,6 +549,13 @@ func generateMedicationRequestJSON(id string, status string) string {
 	return string(medicationRequestJSON)
 }
 
+// mockKey expects the composite key objectType~id to be created and returns it
+func mockKey(stub *MockStub, objectType string, id string) string {
+	key := "\x00" + objectType + "\x00" + id + "\x00"
+	stub.On("CreateCompositeKey", objectType, []string{id}).Return(key, nil)
+	return key
+}
+
 func TestCreatePrescription_Success(t *testing.T) {
 	mockStub := new(MockStub)
 	mockCtx := new(MockTransactionContext)
@@ -362,8 +564,16 @@ func TestCreatePrescription_Success(t *testing.T) {
 	medicationRequestID := "medReq123"
 	medicationRequestJSON := generateMedicationRequestJSON(medicationRequestID, "active")
 
-	mockStub.On("GetState", medicationRequestID).Return(nil, nil)           // Medication request does not exist
-	mockStub.On("PutState", medicationRequestID, mock.Anything).Return(nil) // Expect the put to succeed
+	mockPrescriber(mockCtx, mockStub, "doctor-001", true)
+	mockIssuedRedemptionCode(mockStub, medicationRequestID, testRedemptionCode)
+
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", medicationRequestID)
+	mockStub.On("GetState", prescriptionKey).Return(nil, nil) // Medication request does not exist
+	mockStub.On("PutState", prescriptionKey, mock.MatchedBy(func(value []byte) bool {
+		var prescription fhir.MedicationRequest
+		return json.Unmarshal(value, &prescription) == nil && prescription.Requester.Reference == "Practitioner/doctor-001" &&
+			prescriberOrganization(&prescription) == "Organization/MedicinaGeneraleNapoli"
+	})).Return(nil) // Expect the put to succeed, with the caller and their clinic as requester
 
 	chaincode := PrescriptionChaincode{}
 	err := chaincode.CreatePrescription(mockCtx, medicationRequestJSON)
@@ -380,7 +590,10 @@ func TestCreatePrescription_AlreadyExists(t *testing.T) {
 	medicationRequestID := "medReq123"
 	medicationRequestJSON := generateMedicationRequestJSON(medicationRequestID, "active")
 
-	mockStub.On("GetState", medicationRequestID).Return([]byte("existing medication request"), nil) // Medication request already exists
+	mockPrescriber(mockCtx, mockStub, "doctor-001", true)
+
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", medicationRequestID)
+	mockStub.On("GetState", prescriptionKey).Return([]byte("existing medication request"), nil) // Medication request already exists
 
 	chaincode := PrescriptionChaincode{}
 	err := chaincode.CreatePrescription(mockCtx, medicationRequestJSON)
@@ -426,14 +639,19 @@ func TestVerifyPrescription_Success(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	prescriptionID := "prescription123"
-	pharmacyID := "pharmacyXYZ"
 	activePrescriptionJSON := generateMedicationRequestJSON(prescriptionID, "active")
 
-	mockStub.On("GetState", prescriptionID).Return([]byte(activePrescriptionJSON), nil)
-	mockStub.On("PutState", prescriptionID, mock.Anything).Return(nil)
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(activePrescriptionJSON), nil)
+	mockStub.On("PutState", prescriptionKey, mock.Anything).Return(nil)
+	mockCaller(mockCtx, "pharmacist-001", "FarmaciaPetroneMSP", "pharmacist")
+	mockDispenses(mockStub, prescriptionID)
+	mockCodeRedemption(mockStub, prescriptionID, "Organization/FarmaciaPetrone")
+	mockDispense(mockStub, prescriptionID, "tx1", FillFirst, 15)
+	mockStatusTransition(mockStub, prescriptionID, "tx1", "active", "completed")
 
 	chaincode := PrescriptionChaincode{}
-	err := chaincode.VerifyPrescription(mockCtx, prescriptionID, pharmacyID)
+	err := chaincode.VerifyPrescription(mockCtx, prescriptionID)
 
 	assert.Nil(t, err)
 	mockStub.AssertExpectations(t)
@@ -445,12 +663,12 @@ func TestVerifyPrescription_NotFound(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	prescriptionID := "nonexistent"
-	pharmacyID := "pharmacyXYZ"
 
-	mockStub.On("GetState", prescriptionID).Return(nil, nil)
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return(nil, nil)
 
 	chaincode := PrescriptionChaincode{}
-	err := chaincode.VerifyPrescription(mockCtx, prescriptionID, pharmacyID)
+	err := chaincode.VerifyPrescription(mockCtx, prescriptionID)
 
 	assert.NotNil(t, err)
 	assert.Equal(t, "the prescription does not exist", err.Error())
@@ -463,13 +681,13 @@ func TestVerifyPrescription_NotActive(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	prescriptionID := "prescription123"
-	pharmacyID := "pharmacyXYZ"
 	inactivePrescriptionJSON := generateMedicationRequestJSON(prescriptionID, "completed")
 
-	mockStub.On("GetState", prescriptionID).Return([]byte(inactivePrescriptionJSON), nil)
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(inactivePrescriptionJSON), nil)
 
 	chaincode := PrescriptionChaincode{}
-	err := chaincode.VerifyPrescription(mockCtx, prescriptionID, pharmacyID)
+	err := chaincode.VerifyPrescription(mockCtx, prescriptionID)
 
 	assert.NotNil(t, err)
 	assert.Equal(t, "prescription is not active or no status code available", err.Error())
@@ -482,12 +700,12 @@ func TestVerifyPrescription_StubFailure(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	prescriptionID := "prescription123"
-	pharmacyID := "pharmacyXYZ"
 
-	mockStub.On("GetState", prescriptionID).Return(nil, errors.New("ledger error"))
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return(nil, errors.New("ledger error"))
 
 	chaincode := PrescriptionChaincode{}
-	err := chaincode.VerifyPrescription(mockCtx, prescriptionID, pharmacyID)
+	err := chaincode.VerifyPrescription(mockCtx, prescriptionID)
 
 	assert.NotNil(t, err)
 	assert.Equal(t, "failed to read from world state", err.Error())
@@ -498,11 +716,13 @@ func TestReadPrescription_Success(t *testing.T) {
 	mockStub := new(MockStub)
 	mockCtx := new(MockTransactionContext)
 	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "doctor123", "MedicinaGeneraleNapoliMSP", "doctor")
 
 	medicationRequestID := "medReq123"
 	medicationRequestJSON := generateMedicationRequestJSON(medicationRequestID, "active")
 
-	mockStub.On("GetState", medicationRequestID).Return([]byte(medicationRequestJSON), nil)
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", medicationRequestID)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(medicationRequestJSON), nil)
 
 	chaincode := PrescriptionChaincode{}
 	result, err := chaincode.ReadPrescription(mockCtx, medicationRequestID)
@@ -516,10 +736,12 @@ func TestReadPrescription_NotFound(t *testing.T) {
 	mockStub := new(MockStub)
 	mockCtx := new(MockTransactionContext)
 	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "doctor123", "MedicinaGeneraleNapoliMSP", "doctor")
 
 	medicationRequestID := "nonexistent"
 
-	mockStub.On("GetState", medicationRequestID).Return(nil, nil)
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", medicationRequestID)
+	mockStub.On("GetState", prescriptionKey).Return(nil, nil)
 
 	chaincode := PrescriptionChaincode{}
 	result, err := chaincode.ReadPrescription(mockCtx, medicationRequestID)
@@ -534,10 +756,12 @@ func TestReadPrescription_LedgerError(t *testing.T) {
 	mockStub := new(MockStub)
 	mockCtx := new(MockTransactionContext)
 	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "doctor123", "MedicinaGeneraleNapoliMSP", "doctor")
 
 	medicationRequestID := "medReq123"
 
-	mockStub.On("GetState", medicationRequestID).Return(nil, errors.New("ledger error"))
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", medicationRequestID)
+	mockStub.On("GetState", prescriptionKey).Return(nil, errors.New("ledger error"))
 
 	chaincode := PrescriptionChaincode{}
 	result, err := chaincode.ReadPrescription(mockCtx, medicationRequestID)
@@ -556,7 +780,8 @@ func TestPrescriptionExists_Exists(t *testing.T) {
 	prescriptionID := "prescription123"
 
 	// Simulate finding the prescription in the blockchain
-	mockStub.On("GetState", prescriptionID).Return([]byte("prescription data"), nil)
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return([]byte("prescription data"), nil)
 
 	chaincode := PrescriptionChaincode{}
 	exists, err := chaincode.PrescriptionExists(mockCtx, prescriptionID)
@@ -574,7 +799,8 @@ func TestPrescriptionExists_DoesNotExist(t *testing.T) {
 	prescriptionID := "nonexistent"
 
 	// Simulate the prescription not being found in the blockchain
-	mockStub.On("GetState", prescriptionID).Return(nil, nil)
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return(nil, nil)
 
 	chaincode := PrescriptionChaincode{}
 	exists, err := chaincode.PrescriptionExists(mockCtx, prescriptionID)
@@ -592,7 +818,8 @@ func TestPrescriptionExists_LedgerError(t *testing.T) {
 	prescriptionID := "prescription123"
 
 	// Simulate an error accessing the ledger
-	mockStub.On("GetState", prescriptionID).Return(nil, errors.New("ledger access error"))
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return(nil, errors.New("ledger access error"))
 
 	chaincode := PrescriptionChaincode{}
 	exists, err := chaincode.PrescriptionExists(mockCtx, prescriptionID)
@@ -602,3 +829,940 @@ func TestPrescriptionExists_LedgerError(t *testing.T) {
 	assert.Equal(t, "failed to read from world state", err.Error())
 	mockStub.AssertExpectations(t)
 }
+
+func TestPoliciesCoverEveryTransaction(t *testing.T) {
+	assert.Empty(t, auth.MissingPolicies(new(PrescriptionChaincode), prescriptionPolicies), "transactions without an access policy")
+}
+
+func TestAuditedReadPrescription_RecordsTheRead(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockPatientCaller(mockCtx, mockStub, "example")
+
+	medicationRequestID := "medReq123"
+	medicationRequestJSON := generateMedicationRequestJSON(medicationRequestID, "active")
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", medicationRequestID)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(medicationRequestJSON), nil)
+	mockAccessLog(mockStub, "example", "tx1", "ReadPrescription")
+
+	chaincode := PrescriptionChaincode{}
+	result, err := chaincode.AuditedReadPrescription(mockCtx, medicationRequestID)
+
+	assert.Nil(t, err)
+	assert.Equal(t, medicationRequestJSON, result)
+	mockStub.AssertExpectations(t)
+}
+
+func TestMigrateKeys(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	prescriptionJSON := []byte(generateMedicationRequestJSON("prescription123", "active"))
+	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{
+		Records: []KVPair{{Key: "prescription123", Value: prescriptionJSON}},
+	}, nil)
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", "prescription123")
+	mockStub.On("GetState", prescriptionKey).Return(nil, nil)
+	mockStub.On("PutState", prescriptionKey, prescriptionJSON).Return(nil)
+	mockStub.On("DelState", "prescription123").Return(nil)
+
+	chaincode := PrescriptionChaincode{}
+	migrated, err := chaincode.MigrateKeys(mockCtx, 100)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+	mockStub.AssertExpectations(t)
+}
+
+func TestCreatePrescription_RejectsFinalStatus(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.CreatePrescription(mockCtx, generateMedicationRequestJSON("medReq123", "completed"))
+
+	assert.EqualError(t, err, "a new prescription must be draft, active, on-hold, not completed")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestCancelPrescription_StoresTheReason(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "example", "MedicinaGeneraleNapoliMSP", "doctor")
+
+	prescriptionID := "prescription123"
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(generateMedicationRequestJSON(prescriptionID, "active")), nil)
+	mockDispenses(mockStub, prescriptionID)
+	mockStub.On("PutState", prescriptionKey, mock.MatchedBy(func(value []byte) bool {
+		var prescription fhir.MedicationRequest
+		return json.Unmarshal(value, &prescription) == nil && statusOf(&prescription) == StatusCancelled &&
+			prescription.StatusReason != nil && prescription.StatusReason.Text == "duplicate therapy"
+	})).Return(nil)
+	mockStatusTransition(mockStub, prescriptionID, "tx1", "active", "cancelled")
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.CancelPrescription(mockCtx, prescriptionID, "duplicate therapy")
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestCancelPrescription_RequiresAReason(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.CancelPrescription(mockCtx, "prescription123", " ")
+
+	assert.EqualError(t, err, "a reason is required to set a prescription cancelled")
+}
+
+func TestStatusTransitions_RejectIllegalMoves(t *testing.T) {
+	for _, test := range []struct {
+		from   string
+		action func(chaincode *PrescriptionChaincode, ctx *MockTransactionContext) error
+		err    string
+	}{
+		{"completed", func(c *PrescriptionChaincode, ctx *MockTransactionContext) error {
+			return c.CancelPrescription(ctx, "prescription123", "patient request")
+		}, "cannot move prescription prescription123 from completed to cancelled"},
+		{"cancelled", func(c *PrescriptionChaincode, ctx *MockTransactionContext) error {
+			return c.SuspendPrescription(ctx, "prescription123", "awaiting lab results")
+		}, "cannot move prescription prescription123 from cancelled to on-hold"},
+		{"active", func(c *PrescriptionChaincode, ctx *MockTransactionContext) error {
+			return c.ResumePrescription(ctx, "prescription123")
+		}, "only prescriptions on hold can be resumed"},
+		{"entered-in-error", func(c *PrescriptionChaincode, ctx *MockTransactionContext) error {
+			return c.MarkEnteredInError(ctx, "prescription123", "wrong patient")
+		}, "cannot move prescription prescription123 from entered-in-error to entered-in-error"},
+		{"active", func(c *PrescriptionChaincode, ctx *MockTransactionContext) error {
+			return c.ActivatePrescription(ctx, "prescription123")
+		}, "only draft prescriptions can be activated"},
+	} {
+		mockStub := new(MockStub)
+		mockCtx := new(MockTransactionContext)
+		mockCtx.On("GetStub").Return(mockStub)
+		mockCaller(mockCtx, "example", "MedicinaGeneraleNapoliMSP", "doctor")
+		prescriptionKey := mockKey(mockStub, "MedicationRequest", "prescription123")
+		mockStub.On("GetState", prescriptionKey).Return([]byte(generateMedicationRequestJSON("prescription123", test.from)), nil)
+		mockDispenses(mockStub, "prescription123")
+
+		err := test.action(&PrescriptionChaincode{}, mockCtx)
+
+		assert.EqualError(t, err, test.err)
+		mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+	}
+}
+
+func TestSuspendAndResumePrescription(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "example", "MedicinaGeneraleNapoliMSP", "doctor")
+
+	prescriptionID := "prescription123"
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(generateMedicationRequestJSON(prescriptionID, "on-hold")), nil)
+	mockStub.On("PutState", prescriptionKey, mock.MatchedBy(func(value []byte) bool {
+		var prescription fhir.MedicationRequest
+		return json.Unmarshal(value, &prescription) == nil && statusOf(&prescription) == StatusActive && prescription.StatusReason == nil
+	})).Return(nil)
+	mockStatusTransition(mockStub, prescriptionID, "tx1", "on-hold", "active")
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.ResumePrescription(mockCtx, prescriptionID)
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestCancelPrescription_StopsADispensedPrescription(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "example", "MedicinaGeneraleNapoliMSP", "doctor")
+
+	prescriptionID := "prescription123"
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(generateMedicationRequestJSON(prescriptionID, "active")), nil)
+	mockDispenses(mockStub, prescriptionID, recordedDispense("tx0", FillFirstPartial, 5, time.Now()))
+	mockStub.On("PutState", prescriptionKey, mock.MatchedBy(func(value []byte) bool {
+		var prescription fhir.MedicationRequest
+		return json.Unmarshal(value, &prescription) == nil && statusOf(&prescription) == StatusStopped
+	})).Return(nil)
+	mockStatusTransition(mockStub, prescriptionID, "tx1", "active", "stopped")
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.CancelPrescription(mockCtx, prescriptionID, "adverse reaction")
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestActivatePrescription_ActivatesADraft(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "example", "MedicinaGeneraleNapoliMSP", "doctor")
+
+	prescriptionID := "prescription123"
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(generateMedicationRequestJSON(prescriptionID, "draft")), nil)
+	mockStub.On("PutState", prescriptionKey, mock.MatchedBy(func(value []byte) bool {
+		var prescription fhir.MedicationRequest
+		return json.Unmarshal(value, &prescription) == nil && statusOf(&prescription) == StatusActive
+	})).Return(nil)
+	mockStatusTransition(mockStub, prescriptionID, "tx1", "draft", "active")
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.ActivatePrescription(mockCtx, prescriptionID)
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestSuspendPrescription_OnlyThePrescriberChangesTheStatus(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "doctor-002", "NeurologiaNapoliMSP", "doctor")
+
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", "prescription123")
+	mockStub.On("GetState", prescriptionKey).Return([]byte(generateMedicationRequestJSON("prescription123", "active")), nil)
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.SuspendPrescription(mockCtx, "prescription123", "awaiting lab results")
+
+	assert.EqualError(t, err, "only the prescriber of prescription prescription123 may change its status")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestSuspendPrescription_RejectsThePrescriberIDOfAnotherOrganization(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	// Same userId as the prescriber, enrolled by another clinic
+	mockCaller(mockCtx, "example", "NeurologiaNapoliMSP", "doctor")
+
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", "prescription123")
+	mockStub.On("GetState", prescriptionKey).Return([]byte(generateMedicationRequestJSON("prescription123", "active")), nil)
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.SuspendPrescription(mockCtx, "prescription123", "awaiting lab results")
+
+	assert.EqualError(t, err, "only the prescriber of prescription prescription123 may change its status")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestChaincodeMetadataIsValid(t *testing.T) {
+	// contractapi rejects at startup any transaction whose types it cannot describe
+	_, err := contractapi.NewChaincode(new(PrescriptionChaincode))
+	assert.NoError(t, err)
+}
+
+func TestDispensePrescription_PartialFillKeepsThePrescriptionActive(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-001", "FarmaciaPetroneMSP", "pharmacist")
+
+	prescriptionID := "prescription123"
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(generateMedicationRequestJSON(prescriptionID, "active")), nil)
+	mockDispenses(mockStub, prescriptionID)
+	mockCodeRedemption(mockStub, prescriptionID, "Organization/FarmaciaPetrone")
+	mockDispense(mockStub, prescriptionID, "tx1", FillFirstPartial, 5)
+
+	chaincode := PrescriptionChaincode{}
+	dispense, err := chaincode.DispensePrescription(mockCtx, prescriptionID, 5)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Organization/FarmaciaPetrone", dispense.Performer.Reference)
+	assert.Equal(t, "teaspoonful", dispense.Quantity.Unit)
+	mockStub.AssertExpectations(t)
+	mockStub.AssertNotCalled(t, "PutState", prescriptionKey, mock.Anything)
+}
+
+func TestDispensePrescription_RejectsMoreThanIsLeftOfTheFill(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-001", "FarmaciaPetroneMSP", "pharmacist")
+
+	prescriptionID := "prescription123"
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	prescriptionJSON := withRepeats(generateMedicationRequestJSON(prescriptionID, "active"), 1)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(prescriptionJSON), nil)
+	mockDispenses(mockStub, prescriptionID, recordedDispense("tx0", FillFirstPartial, 10, time.Now()))
+	mockRedemption(mockStub, prescriptionID, &redemption{Pharmacy: "Organization/FarmaciaPetrone"})
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+
+	chaincode := PrescriptionChaincode{}
+	_, err := chaincode.DispensePrescription(mockCtx, prescriptionID, 10)
+
+	assert.EqualError(t, err, "cannot dispense 10 of prescription prescription123: only 5 left of fill 1 of 2")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestVerifyPrescription_RepeatFillKeepsThePrescriptionActive(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-001", "FarmaciaPetroneMSP", "pharmacist")
+
+	prescriptionID := "prescription123"
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	prescriptionJSON := withRepeats(generateMedicationRequestJSON(prescriptionID, "active"), 2)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(prescriptionJSON), nil)
+	mockDispenses(mockStub, prescriptionID, recordedDispense("tx0", FillFirst, 15, time.Now()))
+	mockRedemption(mockStub, prescriptionID, &redemption{Pharmacy: "Organization/FarmaciaPetrone"})
+	mockDispense(mockStub, prescriptionID, "tx1", FillRefill, 15)
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.VerifyPrescription(mockCtx, prescriptionID)
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+	mockStub.AssertNotCalled(t, "PutState", prescriptionKey, mock.Anything)
+}
+
+func TestDispensePrescription_LastRepeatCompletesThePrescription(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-001", "FarmaciaPetroneMSP", "pharmacist")
+
+	prescriptionID := "prescription123"
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	prescriptionJSON := withRepeats(generateMedicationRequestJSON(prescriptionID, "active"), 1)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(prescriptionJSON), nil)
+	mockStub.On("PutState", prescriptionKey, mock.Anything).Return(nil)
+	lastWeek := time.Now().AddDate(0, 0, -7)
+	mockDispenses(mockStub, prescriptionID,
+		recordedDispense("tx2", FillRefillPartial, 10, lastWeek.Add(time.Hour)),
+		recordedDispense("tx1", FillFirst, 15, lastWeek))
+	mockRedemption(mockStub, prescriptionID, &redemption{Pharmacy: "Organization/FarmaciaPetrone"})
+	mockDispense(mockStub, prescriptionID, "tx3", FillRefillComplete, 5)
+	mockStatusTransition(mockStub, prescriptionID, "tx3", "active", "completed")
+
+	chaincode := PrescriptionChaincode{}
+	_, err := chaincode.DispensePrescription(mockCtx, prescriptionID, 5)
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+// withValidity returns prescriptionJSON valid from start to end
+func withValidity(prescriptionJSON string, start time.Time, end time.Time) string {
+	var prescription fhir.MedicationRequest
+	json.Unmarshal([]byte(prescriptionJSON), &prescription)
+	prescription.DispenseRequest.ValidityPeriod = &fhir.Period{Start: start, End: end}
+	updatedJSON, _ := json.Marshal(prescription)
+	return string(updatedJSON)
+}
+
+func TestVerifyPrescription_RejectsPrescriptionsOutsideTheirValidity(t *testing.T) {
+	now := time.Date(2024, time.May, 1, 9, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		start time.Time
+		end   time.Time
+		err   string
+	}{
+		{now.AddDate(0, -2, 0), now.AddDate(0, -1, 0), "prescription prescription123 expired on 2024-04-01T09:00:00Z"},
+		{now.AddDate(0, 0, 1), time.Time{}, "prescription prescription123 is not valid before 2024-05-02T09:00:00Z"},
+	} {
+		mockStub := new(MockStub)
+		mockCtx := new(MockTransactionContext)
+		mockCtx.On("GetStub").Return(mockStub)
+		mockCaller(mockCtx, "pharmacist-001", "FarmaciaPetroneMSP", "pharmacist")
+
+		prescriptionKey := mockKey(mockStub, "MedicationRequest", "prescription123")
+		prescriptionJSON := withValidity(generateMedicationRequestJSON("prescription123", "active"), test.start, test.end)
+		mockStub.On("GetState", prescriptionKey).Return([]byte(prescriptionJSON), nil)
+		mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: now.Unix()}, nil)
+
+		chaincode := PrescriptionChaincode{}
+		err := chaincode.VerifyPrescription(mockCtx, "prescription123")
+
+		assert.EqualError(t, err, test.err)
+		mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+	}
+}
+
+func TestVerifyPrescription_WithinValidity(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-001", "FarmaciaPetroneMSP", "pharmacist")
+
+	prescriptionID := "prescription123"
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	prescriptionJSON := withValidity(generateMedicationRequestJSON(prescriptionID, "active"), time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 30))
+	mockStub.On("GetState", prescriptionKey).Return([]byte(prescriptionJSON), nil)
+	mockStub.On("PutState", prescriptionKey, mock.Anything).Return(nil)
+	mockDispenses(mockStub, prescriptionID)
+	mockCodeRedemption(mockStub, prescriptionID, "Organization/FarmaciaPetrone")
+	mockDispense(mockStub, prescriptionID, "tx1", FillFirst, 15)
+	mockStatusTransition(mockStub, prescriptionID, "tx1", "active", "completed")
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.VerifyPrescription(mockCtx, prescriptionID)
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+func TestVerifyPrescription_HonorsTheDesignatedPharmacy(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-002", "FarmaciaCarboneMSP", "pharmacist")
+
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", "prescription123")
+	mockStub.On("GetState", prescriptionKey).Return([]byte(generateMedicationRequestJSON("prescription123", "active")), nil)
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.VerifyPrescription(mockCtx, "prescription123")
+
+	assert.EqualError(t, err, "prescription prescription123 is to be dispensed by Organization/FarmaciaPetrone")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestDispensePrescription_AnyPharmacyWithoutDesignation(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-002", "FarmaciaCarboneMSP", "pharmacist")
+
+	prescriptionID := "prescription123"
+	var prescription fhir.MedicationRequest
+	json.Unmarshal([]byte(generateMedicationRequestJSON(prescriptionID, "active")), &prescription)
+	prescription.DispenseRequest.Performer = nil
+	prescriptionJSON, _ := json.Marshal(prescription)
+
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return(prescriptionJSON, nil)
+	mockDispenses(mockStub, prescriptionID)
+	mockCodeRedemption(mockStub, prescriptionID, "Organization/FarmaciaCarbone")
+	mockDispense(mockStub, prescriptionID, "tx1", FillFirstPartial, 5)
+
+	chaincode := PrescriptionChaincode{}
+	dispense, err := chaincode.DispensePrescription(mockCtx, prescriptionID, 5)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Organization/FarmaciaCarbone", dispense.Performer.Reference)
+	mockStub.AssertExpectations(t)
+}
+
+func TestCreatePrescription_RejectsUnqualifiedPrescriber(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockPrescriber(mockCtx, mockStub, "doctor-002", false)
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.CreatePrescription(mockCtx, generateMedicationRequestJSON("medReq123", "active"))
+
+	assert.EqualError(t, err, "practitioner doctor-002 holds no valid medical qualification")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestCreatePrescription_QualificationCheckFailure(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "doctor-001", "NeurologiaNapoliMSP", "doctor")
+	mockStub.On("InvokeChaincode", "practitioner", mock.Anything, "patient-records-channel").
+		Return(peer.Response{Status: 500, Message: "chaincode practitioner not found"})
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.CreatePrescription(mockCtx, generateMedicationRequestJSON("medReq123", "active"))
+
+	assert.EqualError(t, err, "failed to check qualification: chaincode practitioner not found")
+}
+
+// generateIbuprofenPrescriptionJSON returns an active prescription of ibuprofen, coded with ATC
+func generateIbuprofenPrescriptionJSON(id string) string {
+	var prescription fhir.MedicationRequest
+	json.Unmarshal([]byte(generateMedicationRequestJSON(id, "active")), &prescription)
+	prescription.MedicationCodeableConcept = &fhir.CodeableConcept{
+		Coding: []fhir.Coding{{System: "http://www.whocc.no/atc", Code: "M01AE01", Display: "ibuprofen"}},
+		Text:   "Ibuprofen 400mg",
+	}
+	prescriptionJSON, _ := json.Marshal(prescription)
+	return string(prescriptionJSON)
+}
+
+// mockMedicalRecords sets up the records chaincode answering with the medical records of patientID
+func mockMedicalRecords(mockStub *MockStub, patientID string, records medicalRecords) {
+	recordsJSON, _ := json.Marshal(records)
+	args := [][]byte{[]byte("GetMedicalRecords"), []byte(patientID)}
+	mockStub.On("InvokeChaincode", "records", args, "patient-records-channel").Return(peer.Response{Status: 200, Payload: recordsJSON})
+}
+
+// nsaidAllergicOnWarfarin are the records of a patient allergic to NSAIDs and taking warfarin
+var nsaidAllergicOnWarfarin = medicalRecords{
+	Allergies: []fhir.AllergyIntolerance{{
+		ID:             &fhir.Identifier{Value: "allergy-1"},
+		ClinicalStatus: &fhir.CodeableConcept{Coding: []fhir.Coding{{Code: "active"}}},
+		Criticality:    "high",
+		Code:           &fhir.CodeableConcept{Coding: []fhir.Coding{{System: "http://www.whocc.no/atc", Code: "M01A"}}, Text: "NSAIDs"},
+	}},
+	Prescriptions: []fhir.MedicationStatement{{
+		ID:                        "statement-1",
+		Status:                    "active",
+		MedicationCodeableConcept: &fhir.CodeableConcept{Coding: []fhir.Coding{{System: "http://www.whocc.no/atc", Code: "B01AA03"}}, Text: "Warfarin 5mg"},
+	}},
+}
+
+func TestCheckPrescriptionSafety_FlagsAllergiesAndInteractions(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	mockMedicalRecords(mockStub, "example", nsaidAllergicOnWarfarin)
+
+	chaincode := PrescriptionChaincode{}
+	issues, err := chaincode.CheckPrescriptionSafety(mockCtx, generateIbuprofenPrescriptionJSON("medReq123"))
+
+	assert.NoError(t, err)
+	assert.Len(t, issues, 2)
+	assert.Equal(t, IssueAllergy, issues[0].Code.Coding[0].Code)
+	assert.Equal(t, "high", issues[0].Severity)
+	assert.Equal(t, "the patient is allergic to NSAIDs, which includes Ibuprofen 400mg", issues[0].Detail)
+	assert.Equal(t, "AllergyIntolerance/allergy-1", issues[0].Implicated[0].Reference)
+	assert.Equal(t, IssueDrugInteraction, issues[1].Code.Coding[0].Code)
+	assert.Equal(t, "Ibuprofen 400mg with Warfarin 5mg: vitamin K antagonists with NSAIDs increase the risk of bleeding", issues[1].Detail)
+	assert.Equal(t, "MedicationStatement/statement-1", issues[1].Implicated[0].Reference)
+}
+
+func TestCheckPrescriptionSafety_IgnoresPastAllergiesAndMedications(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+
+	records := medicalRecords{
+		Allergies:     []fhir.AllergyIntolerance{nsaidAllergicOnWarfarin.Allergies[0]},
+		Prescriptions: []fhir.MedicationStatement{nsaidAllergicOnWarfarin.Prescriptions[0]},
+	}
+	records.Allergies[0].VerificationStatus = &fhir.CodeableConcept{Coding: []fhir.Coding{{Code: "refuted"}}}
+	records.Prescriptions[0].Status = "completed"
+	mockMedicalRecords(mockStub, "example", records)
+
+	chaincode := PrescriptionChaincode{}
+	issues, err := chaincode.CheckPrescriptionSafety(mockCtx, generateIbuprofenPrescriptionJSON("medReq123"))
+
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestCheckPrescriptionSafety_ReportsUnreadableRecords(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	args := [][]byte{[]byte("GetMedicalRecords"), []byte("example")}
+	mockStub.On("InvokeChaincode", "records", args, "patient-records-channel").Return(peer.Response{Status: 500, Message: "access denied: no consent of patient example"})
+
+	chaincode := PrescriptionChaincode{}
+	issues, err := chaincode.CheckPrescriptionSafety(mockCtx, generateIbuprofenPrescriptionJSON("medReq123"))
+
+	assert.NoError(t, err)
+	if assert.Len(t, issues, 1) {
+		assert.Equal(t, IssueCheckUnavailable, issues[0].Code.Coding[0].Code)
+		assert.Equal(t, "safety check unavailable for Ibuprofen 400mg: failed to read medical records: access denied: no consent of patient example", issues[0].Detail)
+	}
+}
+
+func TestCreatePrescription_RejectsUnsafePrescription(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockPrescriber(mockCtx, mockStub, "doctor-001", true)
+	mockStub.On("GetState", mockKey(mockStub, "MedicationRequest", "medReq123")).Return(nil, nil)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	mockMedicalRecords(mockStub, "example", medicalRecords{Prescriptions: nsaidAllergicOnWarfarin.Prescriptions})
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.CreatePrescription(mockCtx, generateIbuprofenPrescriptionJSON("medReq123"))
+
+	assert.EqualError(t, err, "the safety check found issues: high Drug Interaction Alert, "+
+		"Ibuprofen 400mg with Warfarin 5mg: vitamin K antagonists with NSAIDs increase the risk of bleeding")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestCreatePrescriptionWithOverride_RecordsTheOverride(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockPrescriber(mockCtx, mockStub, "doctor-001", true)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	mockMedicalRecords(mockStub, "example", medicalRecords{Prescriptions: nsaidAllergicOnWarfarin.Prescriptions})
+	mockIssuedRedemptionCode(mockStub, "medReq123", testRedemptionCode)
+
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", "medReq123")
+	mockStub.On("GetState", prescriptionKey).Return(nil, nil)
+	mockStub.On("PutState", prescriptionKey, mock.MatchedBy(func(value []byte) bool {
+		var prescription fhir.MedicationRequest
+		return json.Unmarshal(value, &prescription) == nil && len(prescription.DetectedIssue) == 1 &&
+			prescription.DetectedIssue[0].Reference == "DetectedIssue/medReq123-issue-1"
+	})).Return(nil)
+	issueKey := "\x00DetectedIssue\x00medReq123\x001\x00"
+	mockStub.On("CreateCompositeKey", "DetectedIssue", []string{"medReq123", "1"}).Return(issueKey, nil)
+	mockStub.On("PutState", issueKey, mock.Anything).Return(nil)
+
+	chaincode := PrescriptionChaincode{}
+	issues, err := chaincode.CreatePrescriptionWithOverride(mockCtx, generateIbuprofenPrescriptionJSON("medReq123"), "INR monitored weekly")
+
+	assert.NoError(t, err)
+	assert.Len(t, issues, 1)
+	assert.Equal(t, "final", issues[0].Status)
+	assert.Equal(t, "MedicationRequest/medReq123", issues[0].Implicated[0].Reference)
+	assert.Equal(t, "Practitioner/doctor-001", issues[0].Mitigation[0].Author.Reference)
+	assert.Equal(t, "INR monitored weekly", issues[0].Mitigation[0].Note[0].Text)
+	mockStub.AssertExpectations(t)
+}
+
+func TestCreatePrescriptionWithOverride_RequiresAReason(t *testing.T) {
+	chaincode := PrescriptionChaincode{}
+	_, err := chaincode.CreatePrescriptionWithOverride(new(MockTransactionContext), generateIbuprofenPrescriptionJSON("medReq123"), "")
+
+	assert.EqualError(t, err, "a reason is required to override safety issues")
+}
+
+func TestInteractionBetween(t *testing.T) {
+	assert.NotNil(t, interactionBetween("M01AE01", "B01AA03"))
+	assert.NotNil(t, interactionBetween("B01AA03", "M01AE01"))
+	assert.Nil(t, interactionBetween("M01AE01", "J01CA04"))
+	assert.Nil(t, interactionBetween("M01AE01", ""))
+}
+
+func TestQueryPrescriptionsByPatient(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "doctor123", "MedicinaGeneraleNapoliMSP", "doctor")
+
+	iterator := &MockIterator{}
+	iterator.AddRecord("\x00MedicationRequest\x00medReq123\x00", []byte(generateMedicationRequestJSON("medReq123", "active")))
+	query := `{"selector":{"intent":{"$exists":true},"status.coding":{"$elemMatch":{"code":"active"}},"subject.reference":"Patient/example"},` +
+		`"sort":[{"subject.reference":"desc"},{"authoredOn":"desc"}],"use_index":["indexBySubject","indexBySubject"]}`
+	mockStub.On("GetQueryResultWithPagination", query, int32(10), "").
+		Return(iterator, &peer.QueryResponseMetadata{FetchedRecordsCount: 1, Bookmark: "next"}, nil)
+
+	chaincode := PrescriptionChaincode{}
+	page, err := chaincode.QueryPrescriptionsByPatient(mockCtx, "Patient/example", "active", 10, "")
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Prescriptions, 1)
+	assert.Equal(t, "medReq123", page.Prescriptions[0].ID.Value)
+	assert.Equal(t, "next", page.Bookmark)
+	assert.Equal(t, int32(1), page.Count)
+}
+
+func TestQueryPrescriptionsByPatient_KeepsArgumentsOutOfTheQuery(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "doctor123", "MedicinaGeneraleNapoliMSP", "doctor")
+
+	query := `{"selector":{"intent":{"$exists":true},"subject.reference":"Patient/x\"},\"$or\":[{}]}"},` +
+		`"sort":[{"subject.reference":"desc"},{"authoredOn":"desc"}],"use_index":["indexBySubject","indexBySubject"]}`
+	mockStub.On("GetQueryResultWithPagination", query, int32(10), "").
+		Return(&MockIterator{}, &peer.QueryResponseMetadata{}, nil)
+
+	chaincode := PrescriptionChaincode{}
+	page, err := chaincode.QueryPrescriptionsByPatient(mockCtx, `x"},"$or":[{}]}`, "", 10, "")
+
+	assert.NoError(t, err)
+	assert.Empty(t, page.Prescriptions)
+	mockStub.AssertExpectations(t)
+}
+
+func TestQueryPrescriptionsByAuthoredOn(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "doctor123", "MedicinaGeneraleNapoliMSP", "doctor")
+
+	rome := time.FixedZone("CET", 3600)
+	start := time.Date(2024, 3, 1, 9, 0, 0, 500, rome)
+	end := time.Date(2024, 3, 31, 18, 0, 0, 500, rome)
+	query := `{"selector":{"authoredOn":{"$gte":"2024-03-01T08:00:01Z","$lte":"2024-03-31T17:00:00Z"},"intent":{"$exists":true},"subject.reference":"Patient/example"},` +
+		`"sort":[{"subject.reference":"desc"},{"authoredOn":"desc"}],"use_index":["indexBySubject","indexBySubject"]}`
+	mockStub.On("GetQueryResultWithPagination", query, int32(5), "bookmark").
+		Return(&MockIterator{}, &peer.QueryResponseMetadata{}, nil)
+
+	chaincode := PrescriptionChaincode{}
+	_, err := chaincode.QueryPrescriptionsByAuthoredOn(mockCtx, "example", start, end, 5, "bookmark")
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+
+	_, err = chaincode.QueryPrescriptionsByAuthoredOn(mockCtx, "example", end, start, 5, "")
+	assert.EqualError(t, err, "the end of the range is before its start")
+}
+
+func TestQueryPrescriptionsByRequester(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "doctor-001", "MedicinaGeneraleNapoliMSP", "doctor")
+
+	query := `{"selector":{"intent":{"$exists":true},"requester.reference":"Practitioner/doctor-001"},` +
+		`"sort":[{"requester.reference":"desc"},{"authoredOn":"desc"}],"use_index":["indexByRequester","indexByRequester"]}`
+	mockStub.On("GetQueryResultWithPagination", query, int32(20), "").
+		Return(&MockIterator{}, &peer.QueryResponseMetadata{}, nil)
+
+	chaincode := PrescriptionChaincode{}
+	_, err := chaincode.QueryPrescriptionsByRequester(mockCtx, "doctor-001", "", 20, "")
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+
+	_, err = chaincode.QueryPrescriptionsByRequester(mockCtx, "doctor-002", "", 20, "")
+	assert.EqualError(t, err, "practitioners may only list the prescriptions they wrote")
+}
+
+func TestQueryPrescriptionsByPerformer(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-001", "FarmaciaPetroneMSP", "pharmacist")
+
+	query := `{"selector":{"dispenseRequest.performer.reference":"Organization/FarmaciaPetrone","intent":{"$exists":true},"status.coding":{"$elemMatch":{"code":"active"}}},` +
+		`"sort":[{"dispenseRequest.performer.reference":"desc"},{"authoredOn":"desc"}],"use_index":["indexByPerformer","indexByPerformer"]}`
+	mockStub.On("GetQueryResultWithPagination", query, int32(20), "").
+		Return(&MockIterator{}, &peer.QueryResponseMetadata{}, nil)
+
+	chaincode := PrescriptionChaincode{}
+	_, err := chaincode.QueryPrescriptionsByPerformer(mockCtx, "FarmaciaPetrone", "active", 20, "")
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+
+	_, err = chaincode.QueryPrescriptionsByPerformer(mockCtx, "FarmaciaCentrale", "active", 20, "")
+	assert.EqualError(t, err, "pharmacies may only list the prescriptions directed to them")
+
+	_, err = chaincode.QueryPrescriptionsByPerformer(mockCtx, "FarmaciaPetrone", "active", 0, "")
+	assert.EqualError(t, err, "the page size must be positive")
+}
+
+func TestQueryPrescriptionsByPatient_PharmacistsOnlyGetThePrescriptionsDirectedToThem(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-001", "FarmaciaPetroneMSP", "pharmacist")
+
+	query := `{"selector":{"dispenseRequest.performer.reference":"Organization/FarmaciaPetrone","intent":{"$exists":true},"subject.reference":"Patient/example"},` +
+		`"sort":[{"subject.reference":"desc"},{"authoredOn":"desc"}],"use_index":["indexBySubject","indexBySubject"]}`
+	mockStub.On("GetQueryResultWithPagination", query, int32(10), "").
+		Return(&MockIterator{}, &peer.QueryResponseMetadata{}, nil)
+
+	chaincode := PrescriptionChaincode{}
+	_, err := chaincode.QueryPrescriptionsByPatient(mockCtx, "example", "", 10, "")
+
+	assert.NoError(t, err)
+	mockStub.AssertExpectations(t)
+}
+
+// undirectedPrescriptionJSON returns an active prescription that any pharmacy may dispense
+func undirectedPrescriptionJSON(prescriptionID string) []byte {
+	var prescription fhir.MedicationRequest
+	json.Unmarshal([]byte(generateMedicationRequestJSON(prescriptionID, "active")), &prescription)
+	prescription.DispenseRequest.Performer = nil
+	prescriptionJSON, _ := json.Marshal(prescription)
+	return prescriptionJSON
+}
+
+func TestReadPrescription_PharmacyReadsThePrescriptionsDirectedToIt(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-001", "FarmaciaPetroneMSP", "pharmacist")
+
+	prescriptionJSON := generateMedicationRequestJSON("prescription123", "active")
+	mockStub.On("GetState", mockKey(mockStub, "MedicationRequest", "prescription123")).Return([]byte(prescriptionJSON), nil)
+
+	chaincode := PrescriptionChaincode{}
+	result, err := chaincode.ReadPrescription(mockCtx, "prescription123")
+
+	assert.NoError(t, err)
+	assert.Equal(t, prescriptionJSON, result)
+}
+
+func TestReadPrescription_RejectsPharmaciesThePrescriptionWasNotBroughtTo(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-002", "FarmaciaCarboneMSP", "pharmacist")
+
+	prescriptionID := "prescription123"
+	mockStub.On("GetState", mockKey(mockStub, "MedicationRequest", prescriptionID)).Return(undirectedPrescriptionJSON(prescriptionID), nil)
+	mockDispenses(mockStub, prescriptionID)
+	mockRedemption(mockStub, prescriptionID, &redemption{CodeHash: hashRedemptionCode(testRedemptionCode)})
+	mockTransientCode(mockStub, "")
+
+	chaincode := PrescriptionChaincode{}
+	_, err := chaincode.ReadPrescription(mockCtx, prescriptionID)
+
+	assert.EqualError(t, err, "prescription prescription123 was not brought to this pharmacy")
+}
+
+func TestReadPrescription_PharmacyPassingTheRedemptionCode(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-002", "FarmaciaCarboneMSP", "pharmacist")
+
+	prescriptionID := "prescription123"
+	prescriptionJSON := undirectedPrescriptionJSON(prescriptionID)
+	mockStub.On("GetState", mockKey(mockStub, "MedicationRequest", prescriptionID)).Return(prescriptionJSON, nil)
+	mockDispenses(mockStub, prescriptionID)
+	mockRedemption(mockStub, prescriptionID, &redemption{CodeHash: hashRedemptionCode(testRedemptionCode)})
+	mockTransientCode(mockStub, testRedemptionCode)
+
+	chaincode := PrescriptionChaincode{}
+	result, err := chaincode.ReadPrescription(mockCtx, prescriptionID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, string(prescriptionJSON), result)
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestGetDispenses_PharmacyThatDispensedThePrescription(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-002", "FarmaciaCarboneMSP", "pharmacist")
+
+	prescriptionID := "prescription123"
+	mockStub.On("GetState", mockKey(mockStub, "MedicationRequest", prescriptionID)).Return(undirectedPrescriptionJSON(prescriptionID), nil)
+	dispensed := recordedDispense("tx1", FillFirst, 10, time.Now())
+	dispensed.Performer = &fhir.Reference{Reference: "Organization/FarmaciaCarbone"}
+	dispenseJSON, _ := json.Marshal(dispensed)
+	// The pharmacy check and the listing each iterate the dispenses
+	for i := 0; i < 2; i++ {
+		iterator := &MockIterator{}
+		iterator.AddRecord("\x00MedicationDispense\x00"+prescriptionID+"\x00tx1\x00", dispenseJSON)
+		mockStub.On("GetStateByPartialCompositeKey", "MedicationDispense", []string{prescriptionID}).Return(iterator, nil).Once()
+	}
+
+	chaincode := PrescriptionChaincode{}
+	dispenses, err := chaincode.GetDispenses(mockCtx, prescriptionID)
+
+	assert.NoError(t, err)
+	assert.Len(t, dispenses, 1)
+}
+
+// createWithRedemptionCode creates an active prescription, passing code as its redemption code
+func createWithRedemptionCode(code string) (*MockStub, error) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockPrescriber(mockCtx, mockStub, "doctor-001", true)
+	mockStub.On("GetState", mockKey(mockStub, "MedicationRequest", "medReq123")).Return(nil, nil)
+	mockTransientCode(mockStub, code)
+
+	chaincode := PrescriptionChaincode{}
+	return mockStub, chaincode.CreatePrescription(mockCtx, generateMedicationRequestJSON("medReq123", "active"))
+}
+
+func TestCreatePrescription_RequiresARedemptionCode(t *testing.T) {
+	mockStub, err := createWithRedemptionCode("")
+
+	assert.EqualError(t, err, "a redemption code is required in the transient field redemptionCode")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestCreatePrescription_RejectsAShortRedemptionCode(t *testing.T) {
+	mockStub, err := createWithRedemptionCode("123456")
+
+	assert.EqualError(t, err, "the redemption code must be at least 16 characters long")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+// verifyWithRedemptionCode verifies an active prescription whose redemption code is
+// testRedemptionCode, passing code
+func verifyWithRedemptionCode(code string) (*MockStub, error) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-001", "FarmaciaPetroneMSP", "pharmacist")
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+
+	prescriptionID := "prescription123"
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(generateMedicationRequestJSON(prescriptionID, "active")), nil)
+	mockRedemption(mockStub, prescriptionID, &redemption{CodeHash: hashRedemptionCode(testRedemptionCode)})
+	mockTransientCode(mockStub, code)
+
+	chaincode := PrescriptionChaincode{}
+	return mockStub, chaincode.VerifyPrescription(mockCtx, prescriptionID)
+}
+
+func TestVerifyPrescription_RequiresTheRedemptionCode(t *testing.T) {
+	mockStub, err := verifyWithRedemptionCode("")
+
+	assert.EqualError(t, err, "the redemption code of prescription prescription123 is required")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestVerifyPrescription_RejectsAWrongRedemptionCode(t *testing.T) {
+	mockStub, err := verifyWithRedemptionCode("AAAAAAAAAAAAAAAA")
+
+	assert.EqualError(t, err, "invalid redemption code for prescription prescription123")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestDispensePrescription_RedeemedPrescriptionStaysWithItsPharmacy(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-002", "FarmaciaCarboneMSP", "pharmacist")
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+
+	prescriptionID := "prescription123"
+	var prescription fhir.MedicationRequest
+	json.Unmarshal([]byte(generateMedicationRequestJSON(prescriptionID, "active")), &prescription)
+	prescription.DispenseRequest.Performer = nil
+	prescriptionJSON, _ := json.Marshal(prescription)
+
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return(prescriptionJSON, nil)
+	mockRedemption(mockStub, prescriptionID, &redemption{Pharmacy: "Organization/FarmaciaPetrone"})
+
+	chaincode := PrescriptionChaincode{}
+	_, err := chaincode.DispensePrescription(mockCtx, prescriptionID, 5)
+
+	assert.EqualError(t, err, "prescription prescription123 was redeemed by Organization/FarmaciaPetrone")
+	mockStub.AssertNotCalled(t, "PutState", mock.Anything, mock.Anything)
+}
+
+func TestVerifyPrescription_WithoutRedemptionCodeOnRecord(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockCaller(mockCtx, "pharmacist-001", "FarmaciaPetroneMSP", "pharmacist")
+
+	// Prescriptions written before redemption codes were introduced have none to consume
+	prescriptionID := "prescription123"
+	prescriptionKey := mockKey(mockStub, "MedicationRequest", prescriptionID)
+	mockStub.On("GetState", prescriptionKey).Return([]byte(generateMedicationRequestJSON(prescriptionID, "active")), nil)
+	mockStub.On("PutState", prescriptionKey, mock.Anything).Return(nil)
+	mockRedemption(mockStub, prescriptionID, nil)
+	mockDispenses(mockStub, prescriptionID)
+	mockDispense(mockStub, prescriptionID, "tx1", FillFirst, 15)
+	mockStatusTransition(mockStub, prescriptionID, "tx1", "active", "completed")
+
+	chaincode := PrescriptionChaincode{}
+	err := chaincode.VerifyPrescription(mockCtx, prescriptionID)
+
+	assert.NoError(t, err)
+	mockStub.AssertNotCalled(t, "GetTransient")
+}
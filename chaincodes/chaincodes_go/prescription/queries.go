@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+	"github.com/xDaryamo/MedChain/query"
+)
+
+// PrescriptionPage is one page of the prescriptions matching a query, newest first
+type PrescriptionPage struct {
+	Prescriptions []*fhir.MedicationRequest `json:"prescriptions"` // The prescriptions on this page
+	Bookmark      string                    `json:"bookmark"`      // Pass to the same query to get the next page
+	Count         int32                     `json:"count"`         // How many prescriptions are on this page
+}
+
+/*
+================================
+	QUERY OPERATIONS
+================================
+*/
+
+// QueryPrescriptionsByPatient returns a page of the prescriptions of a patient, optionally only
+// those with the given status. Pharmacists only get those directed to their pharmacy.
+func (t *PrescriptionChaincode) QueryPrescriptionsByPatient(ctx contractapi.TransactionContextInterface, patientID string, status string, pageSize int32, bookmark string) (*PrescriptionPage, error) {
+	selector, err := forCaller(ctx, query.Where("subject.reference", query.Eq("Patient/"+auth.PatientID(patientID))))
+	if err != nil {
+		return nil, err
+	}
+	return queryPrescriptions(ctx, withStatus(selector, status), "subject.reference", "indexBySubject", pageSize, bookmark)
+}
+
+// QueryPrescriptionsByAuthoredOn returns a page of the prescriptions of a patient written
+// between start and end, inclusive. Pharmacists only get those directed to their pharmacy.
+func (t *PrescriptionChaincode) QueryPrescriptionsByAuthoredOn(ctx contractapi.TransactionContextInterface, patientID string, start time.Time, end time.Time, pageSize int32, bookmark string) (*PrescriptionPage, error) {
+	if end.Before(start) {
+		return nil, errors.New("the end of the range is before its start")
+	}
+	// authoredOn is stored in UTC to the second, so the stored strings sort like the times
+	// they denote and whole-second bounds select exactly the prescriptions in the range
+	selector := query.Where("subject.reference", query.Eq("Patient/"+auth.PatientID(patientID))).
+		And("authoredOn",
+			query.Gte(ceilSecond(start).Format(time.RFC3339)),
+			query.Lte(end.UTC().Truncate(time.Second).Format(time.RFC3339)))
+	selector, err := forCaller(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+	return queryPrescriptions(ctx, selector, "subject.reference", "indexBySubject", pageSize, bookmark)
+}
+
+// QueryPrescriptionsByRequester returns a page of the prescriptions written by the calling
+// practitioner, optionally only those with the given status
+func (t *PrescriptionChaincode) QueryPrescriptionsByRequester(ctx contractapi.TransactionContextInterface, practitionerID string, status string, pageSize int32, bookmark string) (*PrescriptionPage, error) {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if caller.UserID != practitionerID {
+		return nil, errors.New("practitioners may only list the prescriptions they wrote")
+	}
+	selector := query.Where("requester.reference", query.Eq("Practitioner/"+practitionerID))
+	return queryPrescriptions(ctx, withStatus(selector, status), "requester.reference", "indexByRequester", pageSize, bookmark)
+}
+
+// QueryPrescriptionsByPerformer returns a page of the prescriptions directed to the caller's
+// pharmacy, optionally only those with the given status
+func (t *PrescriptionChaincode) QueryPrescriptionsByPerformer(ctx contractapi.TransactionContextInterface, pharmacyID string, status string, pageSize int32, bookmark string) (*PrescriptionPage, error) {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pharmacy := "Organization/" + pharmacyID
+	if pharmacy != auth.OrganizationReference(caller.MSPID) {
+		return nil, errors.New("pharmacies may only list the prescriptions directed to them")
+	}
+	selector := query.Where("dispenseRequest.performer.reference", query.Eq(pharmacy))
+	return queryPrescriptions(ctx, withStatus(selector, status), "dispenseRequest.performer.reference", "indexByPerformer", pageSize, bookmark)
+}
+
+// queryPrescriptions runs a CouchDB query for prescriptions and returns the page at bookmark,
+// sorted by the field index covers and then newest first
+func queryPrescriptions(ctx contractapi.TransactionContextInterface, selector *query.Selector, indexedField string, index string, pageSize int32, bookmark string) (*PrescriptionPage, error) {
+	if pageSize <= 0 {
+		return nil, errors.New("the page size must be positive")
+	}
+
+	// Dispenses, status transitions and detected issues share the namespace; only
+	// prescriptions have an intent
+	queryJSON, err := query.New(selector.And("intent", query.Exists(true))).
+		Sort(indexedField, query.Descending).
+		Sort("authoredOn", query.Descending).
+		UseIndex(index, index).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(queryJSON, pageSize, bookmark)
+	if err != nil {
+		return nil, errors.New("failed to query prescriptions: " + err.Error())
+	}
+	defer iterator.Close()
+
+	page := &PrescriptionPage{Prescriptions: []*fhir.MedicationRequest{}}
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, errors.New("failed to iterate prescriptions: " + err.Error())
+		}
+		var prescription fhir.MedicationRequest
+		if err := json.Unmarshal(result.Value, &prescription); err != nil {
+			return nil, errors.New("failed to unmarshal prescription: " + err.Error())
+		}
+		page.Prescriptions = append(page.Prescriptions, &prescription)
+	}
+	if metadata != nil {
+		page.Bookmark = metadata.Bookmark
+		page.Count = metadata.FetchedRecordsCount
+	}
+	return page, nil
+}
+
+// forCaller narrows selector, for a pharmacist caller, to the prescriptions directed to their
+// pharmacy. Pharmacists need no consent, so they must not list every prescription of a patient.
+func forCaller(ctx contractapi.TransactionContextInterface, selector *query.Selector) (*query.Selector, error) {
+	caller, err := auth.GetIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if caller.HasRole(auth.RolePharmacist) {
+		selector.And("dispenseRequest.performer.reference", query.Eq(auth.OrganizationReference(caller.MSPID)))
+	}
+	return selector, nil
+}
+
+// withStatus narrows selector to prescriptions with the given status, unless it is empty
+func withStatus(selector *query.Selector, status string) *query.Selector {
+	if status != "" {
+		selector.And("status.coding", query.ElemMatch(query.Where("code", query.Eq(status))))
+	}
+	return selector
+}
+
+// ceilSecond returns t in UTC, rounded up to the second
+func ceilSecond(t time.Time) time.Time {
+	truncated := t.UTC().Truncate(time.Second)
+	if truncated.Before(t) {
+		return truncated.Add(time.Second)
+	}
+	return truncated
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/ledger"
+)
+
+// Redemption codes are stored, hashed, under the composite key Redemption~prescriptionID
+const redemptionObjectType = "Redemption"
+
+// redemptionCodeField is the transient field carrying a redemption code. Transient data is
+// not recorded in the block, so the code stays between the prescriber, the patient and the
+// pharmacy they hand it to.
+const redemptionCodeField = "redemptionCode"
+
+// minRedemptionCodeLength keeps codes long enough that their hash cannot be reversed by
+// trying every code
+const minRedemptionCodeLength = 16
+
+// redemption tracks the redemption code of a prescription
+type redemption struct {
+	CodeHash   string    `json:"codeHash,omitempty"`   // Hex SHA-256 of the code, cleared once redeemed
+	Pharmacy   string    `json:"pharmacy,omitempty"`   // The pharmacy that redeemed the code
+	RedeemedAt time.Time `json:"redeemedAt,omitempty"` // When the code was redeemed
+}
+
+// issueRedemptionCode stores the hash of the redemption code the prescriber passed for a new
+// prescription. The prescriber's client generates the code: every endorser must compute the same
+// result, and whatever the chaincode returns is recorded in the block.
+func issueRedemptionCode(ctx contractapi.TransactionContextInterface, prescriptionID string) error {
+	code, err := transientRedemptionCode(ctx)
+	if err != nil {
+		return err
+	}
+	if code == "" {
+		return errors.New("a redemption code is required in the transient field " + redemptionCodeField)
+	}
+	if err := validateRedemptionCode(code); err != nil {
+		return err
+	}
+	return putRedemption(ctx, prescriptionID, &redemption{CodeHash: hashRedemptionCode(code)})
+}
+
+// redeem checks that pharmacy may dispense a prescription. The first dispense consumes the
+// redemption code, which the pharmacy must pass, and binds the prescription to that pharmacy
+// for its later fills. Prescriptions written before redemption codes were introduced have none.
+func redeem(ctx contractapi.TransactionContextInterface, prescriptionID string, pharmacy string, now time.Time) error {
+	redemptionKey, err := ledger.Key(ctx, redemptionObjectType, prescriptionID)
+	if err != nil {
+		return err
+	}
+	redemptionJSON, err := ctx.GetStub().GetState(redemptionKey)
+	if err != nil {
+		return errors.New("failed to get redemption code: " + err.Error())
+	}
+	if redemptionJSON == nil {
+		return nil
+	}
+	var record redemption
+	if err := json.Unmarshal(redemptionJSON, &record); err != nil {
+		return errors.New("failed to unmarshal redemption code: " + err.Error())
+	}
+
+	if record.Pharmacy != "" {
+		if record.Pharmacy != pharmacy {
+			return errors.New("prescription " + prescriptionID + " was redeemed by " + record.Pharmacy)
+		}
+		return nil
+	}
+
+	code, err := transientRedemptionCode(ctx)
+	if err != nil {
+		return err
+	}
+	if code == "" {
+		return errors.New("the redemption code of prescription " + prescriptionID + " is required")
+	}
+	if subtle.ConstantTimeCompare([]byte(hashRedemptionCode(code)), []byte(record.CodeHash)) != 1 {
+		return errors.New("invalid redemption code for prescription " + prescriptionID)
+	}
+
+	return putRedemption(ctx, prescriptionID, &redemption{Pharmacy: pharmacy, RedeemedAt: now})
+}
+
+// presentsRedemptionCode reports whether the caller passed the unredeemed redemption code of a
+// prescription, without consuming it
+func presentsRedemptionCode(ctx contractapi.TransactionContextInterface, prescriptionID string) (bool, error) {
+	redemptionKey, err := ledger.Key(ctx, redemptionObjectType, prescriptionID)
+	if err != nil {
+		return false, err
+	}
+	redemptionJSON, err := ctx.GetStub().GetState(redemptionKey)
+	if err != nil {
+		return false, errors.New("failed to get redemption code: " + err.Error())
+	}
+	if redemptionJSON == nil {
+		return false, nil
+	}
+	var record redemption
+	if err := json.Unmarshal(redemptionJSON, &record); err != nil {
+		return false, errors.New("failed to unmarshal redemption code: " + err.Error())
+	}
+	if record.CodeHash == "" {
+		return false, nil
+	}
+
+	code, err := transientRedemptionCode(ctx)
+	if err != nil {
+		return false, err
+	}
+	return code != "" && subtle.ConstantTimeCompare([]byte(hashRedemptionCode(code)), []byte(record.CodeHash)) == 1, nil
+}
+
+func transientRedemptionCode(ctx contractapi.TransactionContextInterface) (string, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", errors.New("failed to get transient data: " + err.Error())
+	}
+	return string(transient[redemptionCodeField]), nil
+}
+
+// validateRedemptionCode accepts codes of at least minRedemptionCodeLength letters and digits,
+// which fit in a QR code and can be read out at the counter
+func validateRedemptionCode(code string) error {
+	if len(code) < minRedemptionCodeLength {
+		return errors.New("the redemption code must be at least " + strconv.Itoa(minRedemptionCodeLength) + " characters long")
+	}
+	for _, c := range code {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return errors.New("the redemption code may only contain letters and digits")
+		}
+	}
+	return nil
+}
+
+func hashRedemptionCode(code string) string {
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
+
+func putRedemption(ctx contractapi.TransactionContextInterface, prescriptionID string, record *redemption) error {
+	redemptionKey, err := ledger.Key(ctx, redemptionObjectType, prescriptionID)
+	if err != nil {
+		return err
+	}
+	redemptionJSON, err := json.Marshal(record)
+	if err != nil {
+		return errors.New("failed to marshal redemption code: " + err.Error())
+	}
+	if err := ctx.GetStub().PutState(redemptionKey, redemptionJSON); err != nil {
+		return errors.New("failed to put state: " + err.Error())
+	}
+	return nil
+}
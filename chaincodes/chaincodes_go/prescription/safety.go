@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+)
+
+// Location of the records chaincode, which owns the patients' medical record folders. Like the
+// practitioner registry, it can only be queried from clinic peers.
+const (
+	recordsChaincode = "records"
+	recordsChannel   = "patient-records-channel"
+)
+
+// Detected issues are stored under the composite key DetectedIssue~prescriptionID~issueNumber
+// when a prescriber overrides them
+const detectedIssueObjectType = "DetectedIssue"
+
+// Kinds of detected issue, from the http://terminology.hl7.org/CodeSystem/v3-ActCode code system
+const (
+	IssueDrugInteraction  = "DRG"     // The prescribed medication interacts with one the patient takes
+	IssueAllergy          = "ALGY"    // The patient is allergic to the prescribed medication
+	IssueCheckUnavailable = "VALIDAT" // The safety check could not read the patient's medical records
+)
+
+// overrideAction is the mitigation recorded when a prescriber overrides an issue: the prescriber
+// judged the therapy appropriate despite it
+var overrideAction = fhir.CodeableConcept{Coding: []fhir.Coding{{System: actCodeSystem, Code: "1", Display: "Therapy Appropriate"}}}
+
+// medicalRecords is the part of a MedicalRecords folder of the records chaincode the safety
+// check reads
+type medicalRecords struct {
+	Allergies     []fhir.AllergyIntolerance
+	Prescriptions []fhir.MedicationStatement
+}
+
+/*
+================================
+	SAFETY CHECK OPERATIONS
+================================
+*/
+
+// CheckPrescriptionSafety returns the issues CreatePrescription would raise for a prescription:
+// allergies of the patient to the medication, and interactions with the medications they take,
+// according to their medical records, or that the check was unavailable when the records could
+// not be read. Medications are matched by ATC code; a prescription without one is not checked.
+func (t *PrescriptionChaincode) CheckPrescriptionSafety(ctx contractapi.TransactionContextInterface, medicationRequestJSON string) ([]*fhir.DetectedIssue, error) {
+	var medicationRequest fhir.MedicationRequest
+	if err := json.Unmarshal([]byte(medicationRequestJSON), &medicationRequest); err != nil {
+		return nil, errors.New("failed to decode JSON")
+	}
+	return detectIssues(ctx, &medicationRequest)
+}
+
+// CreatePrescriptionWithOverride creates a prescription like CreatePrescription, overriding the
+// issues found by the safety check. Each overridden issue is stored with the prescriber and
+// reason, referenced from the prescription's detectedIssue, and returned.
+func (t *PrescriptionChaincode) CreatePrescriptionWithOverride(ctx contractapi.TransactionContextInterface, medicationRequestJSON string, reason string) ([]*fhir.DetectedIssue, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, errors.New("a reason is required to override safety issues")
+	}
+	return t.createPrescription(ctx, medicationRequestJSON, reason)
+}
+
+// GetDetectedIssues returns the safety issues overridden when a prescription was written
+func (t *PrescriptionChaincode) GetDetectedIssues(ctx contractapi.TransactionContextInterface, prescriptionID string) ([]*fhir.DetectedIssue, error) {
+	if err := t.checkPharmacyRead(ctx, prescriptionID); err != nil {
+		return nil, err
+	}
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(detectedIssueObjectType, []string{prescriptionID})
+	if err != nil {
+		return nil, errors.New("failed to get detected issues: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var issues []*fhir.DetectedIssue
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, errors.New("failed to iterate detected issues: " + err.Error())
+		}
+
+		var issue fhir.DetectedIssue
+		if err := json.Unmarshal(queryResponse.Value, &issue); err != nil {
+			return nil, errors.New("failed to unmarshal detected issue: " + err.Error())
+		}
+		issues = append(issues, &issue)
+	}
+
+	// Keys sort by issue number as text, so 10 would come before 2
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issueNumber(issues[i]) < issueNumber(issues[j])
+	})
+	return issues, nil
+}
+
+// detectIssues runs the safety check of a prescription against the patient's medical records
+func detectIssues(ctx contractapi.TransactionContextInterface, prescription *fhir.MedicationRequest) ([]*fhir.DetectedIssue, error) {
+	atcCode := atcCodeOf(prescription.MedicationCodeableConcept)
+	if atcCode == "" || prescription.Subject == nil {
+		return nil, nil
+	}
+	patientID := auth.PatientID(prescription.Subject.Reference)
+
+	records, unavailable, err := readMedicalRecords(ctx, patientID)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, errors.New("failed to get transaction timestamp: " + err.Error())
+	}
+	medication := describe(prescription.MedicationCodeableConcept, atcCode)
+
+	// A prescriber who may not read the records, or a records chaincode out of reach, leaves
+	// the prescription unchecked: that is an issue to override, not a reason to fail
+	if records == nil {
+		return []*fhir.DetectedIssue{newIssue(IssueCheckUnavailable, "moderate", patientID, timestamp.AsTime(),
+			"safety check unavailable for "+medication+": "+unavailable)}, nil
+	}
+
+	var issues []*fhir.DetectedIssue
+	for _, allergy := range records.Allergies {
+		if !isCurrentAllergy(allergy) || !isAllergen(allergy, atcCode) {
+			continue
+		}
+		severity := "moderate"
+		if allergy.Criticality == "high" {
+			severity = "high"
+		}
+		issue := newIssue(IssueAllergy, severity, patientID, timestamp.AsTime(),
+			"the patient is allergic to "+describe(allergy.Code, "")+", which includes "+medication)
+		if allergy.ID != nil {
+			issue.Implicated = append(issue.Implicated, fhir.Reference{Reference: "AllergyIntolerance/" + allergy.ID.Value})
+		}
+		issues = append(issues, issue)
+	}
+
+	for _, statement := range records.Prescriptions {
+		if statement.Status != "active" && statement.Status != "intended" {
+			continue
+		}
+		otherATCCode := atcCodeOf(statement.MedicationCodeableConcept)
+		interaction := interactionBetween(atcCode, otherATCCode)
+		if otherATCCode == "" || interaction == nil {
+			continue
+		}
+		issue := newIssue(IssueDrugInteraction, interaction.severity, patientID, timestamp.AsTime(),
+			medication+" with "+describe(statement.MedicationCodeableConcept, otherATCCode)+": "+interaction.detail)
+		if statement.ID != "" {
+			issue.Implicated = append(issue.Implicated, fhir.Reference{Reference: "MedicationStatement/" + statement.ID})
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+// readMedicalRecords reads the medical record folder of patientID by querying the
+// GetMedicalRecords function of the records chaincode. A patient without a folder has
+// no recorded allergies or medications. When the records chaincode refuses the query, e.g.
+// because the caller may not read the patient's records, it returns no records and why.
+func readMedicalRecords(ctx contractapi.TransactionContextInterface, patientID string) (*medicalRecords, string, error) {
+	args := [][]byte{[]byte("GetMedicalRecords"), []byte(patientID)}
+	response := ctx.GetStub().InvokeChaincode(recordsChaincode, args, recordsChannel)
+	if response.Status != 200 {
+		return nil, "failed to read medical records: " + response.Message, nil
+	}
+
+	var records medicalRecords
+	if len(response.Payload) == 0 {
+		return &records, "", nil
+	}
+	if err := json.Unmarshal(response.Payload, &records); err != nil {
+		return nil, "", errors.New("failed to unmarshal medical records: " + err.Error())
+	}
+	return &records, "", nil
+}
+
+// overrideIssues records that prescriber overrode issues for reason, stores them and links them
+// to the prescription
+func overrideIssues(ctx contractapi.TransactionContextInterface, prescription *fhir.MedicationRequest, issues []*fhir.DetectedIssue, reason string) error {
+	prescriptionID := prescription.ID.Value
+	for i, issue := range issues {
+		issueID := prescriptionID + "-issue-" + strconv.Itoa(i+1)
+		issue.ID = &fhir.Identifier{Value: issueID}
+		issue.Status = "final"
+		issue.Implicated = append([]fhir.Reference{{Reference: "MedicationRequest/" + prescriptionID}}, issue.Implicated...)
+		issue.Mitigation = []fhir.DetectedIssueMitigation{{
+			Action: &overrideAction,
+			Date:   issue.Identified,
+			Author: prescription.Requester,
+			Note:   []fhir.Annotation{{AuthorReference: prescription.Requester, Time: issue.Identified, Text: reason}},
+		}}
+
+		issueKey, err := ctx.GetStub().CreateCompositeKey(detectedIssueObjectType, []string{prescriptionID, strconv.Itoa(i + 1)})
+		if err != nil {
+			return errors.New("failed to create detected issue key: " + err.Error())
+		}
+		issueJSON, err := json.Marshal(issue)
+		if err != nil {
+			return errors.New("failed to marshal detected issue: " + err.Error())
+		}
+		if err := ctx.GetStub().PutState(issueKey, issueJSON); err != nil {
+			return errors.New("failed to put state: " + err.Error())
+		}
+		prescription.DetectedIssue = append(prescription.DetectedIssue, fhir.Reference{Reference: "DetectedIssue/" + issueID})
+	}
+	return nil
+}
+
+// describeIssues joins the details of issues into an error message
+func describeIssues(issues []*fhir.DetectedIssue) string {
+	details := make([]string, len(issues))
+	for i, issue := range issues {
+		details[i] = issue.Severity + " " + issue.Code.Coding[0].Display + ", " + issue.Detail
+	}
+	return strings.Join(details, "; ")
+}
+
+func newIssue(kind string, severity string, patientID string, identified time.Time, detail string) *fhir.DetectedIssue {
+	display := "Drug Interaction Alert"
+	switch kind {
+	case IssueAllergy:
+		display = "Allergy Alert"
+	case IssueCheckUnavailable:
+		display = "Validation Issue"
+	}
+	return &fhir.DetectedIssue{
+		Status:     "preliminary",
+		Code:       &fhir.CodeableConcept{Coding: []fhir.Coding{{System: actCodeSystem, Code: kind, Display: display}}},
+		Severity:   severity,
+		Patient:    &fhir.Reference{Reference: "Patient/" + patientID},
+		Identified: identified,
+		Detail:     detail,
+	}
+}
+
+// isCurrentAllergy reports whether an allergy is active and not refuted
+func isCurrentAllergy(allergy fhir.AllergyIntolerance) bool {
+	if allergy.ClinicalStatus != nil && len(allergy.ClinicalStatus.Coding) > 0 && allergy.ClinicalStatus.Coding[0].Code != "active" {
+		return false
+	}
+	if allergy.VerificationStatus != nil && len(allergy.VerificationStatus.Coding) > 0 {
+		status := allergy.VerificationStatus.Coding[0].Code
+		return status != "refuted" && status != "entered-in-error"
+	}
+	return true
+}
+
+// isAllergen reports whether the medication with ATC code atcCode belongs to the substances
+// an allergy is to, as coded by the allergy or by the substances of its reactions
+func isAllergen(allergy fhir.AllergyIntolerance, atcCode string) bool {
+	if inATCGroup(atcCode, atcCodeOf(allergy.Code)) {
+		return true
+	}
+	for _, reaction := range allergy.Reaction {
+		if inATCGroup(atcCode, atcCodeOf(reaction.Substance)) {
+			return true
+		}
+	}
+	return false
+}
+
+// atcCodeOf returns the ATC code of a medication or substance, or an empty string when it has none
+func atcCodeOf(concept *fhir.CodeableConcept) string {
+	if concept == nil {
+		return ""
+	}
+	for _, coding := range concept.Coding {
+		if coding.System == atcSystem {
+			return coding.Code
+		}
+	}
+	return ""
+}
+
+// describe names a medication or substance for a person to read
+func describe(concept *fhir.CodeableConcept, atcCode string) string {
+	if concept != nil && concept.Text != "" {
+		return concept.Text
+	}
+	if concept != nil {
+		for _, coding := range concept.Coding {
+			if coding.Display != "" {
+				return coding.Display
+			}
+		}
+	}
+	if atcCode != "" {
+		return atcCode
+	}
+	return atcCodeOf(concept)
+}
+
+func issueNumber(issue *fhir.DetectedIssue) int {
+	if issue.ID == nil {
+		return 0
+	}
+	number, _ := strconv.Atoi(issue.ID.Value[strings.LastIndex(issue.ID.Value, "-")+1:])
+	return number
+}
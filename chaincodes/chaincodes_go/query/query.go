@@ -0,0 +1,272 @@
+// Package query builds the CouchDB Mango queries the MedChain chaincodes run against the
+// world state. Selectors, sort clauses, field projections and use_index hints are assembled
+// from typed values and encoded with encoding/json, so arguments that reach a query from a
+// transaction can only ever be compared against, never change the shape of the query.
+// Field names are checked as well, so that a field can never be taken for an operator.
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Value is a type a field can be compared against
+type Value interface {
+	~string | ~bool | ~int | ~int32 | ~int64 | ~float64
+}
+
+// Condition is a test on the value of a field, e.g., Eq("final") or Gte("2024-01-01")
+type Condition struct {
+	operator string      // Mango operator, e.g., $eq
+	operand  interface{} // What the operator compares the field against
+	err      error       // Why the condition is invalid, if it is
+}
+
+// Eq matches fields equal to value
+func Eq[V Value](value V) Condition {
+	return Condition{operator: "$eq", operand: value}
+}
+
+// Ne matches fields not equal to value
+func Ne[V Value](value V) Condition {
+	return Condition{operator: "$ne", operand: value}
+}
+
+// Gt matches fields greater than value
+func Gt[V Value](value V) Condition {
+	return Condition{operator: "$gt", operand: value}
+}
+
+// Gte matches fields greater than or equal to value
+func Gte[V Value](value V) Condition {
+	return Condition{operator: "$gte", operand: value}
+}
+
+// Lt matches fields less than value
+func Lt[V Value](value V) Condition {
+	return Condition{operator: "$lt", operand: value}
+}
+
+// Lte matches fields less than or equal to value
+func Lte[V Value](value V) Condition {
+	return Condition{operator: "$lte", operand: value}
+}
+
+// In matches fields equal to one of values
+func In[V Value](values ...V) Condition {
+	if len(values) == 0 {
+		return Condition{operator: "$in", err: errors.New("$in needs at least one value")}
+	}
+	return Condition{operator: "$in", operand: values}
+}
+
+// Exists matches documents that have the field, or that lack it when exists is false
+func Exists(exists bool) Condition {
+	return Condition{operator: "$exists", operand: exists}
+}
+
+// ElemMatch matches array fields with an element that selector matches
+func ElemMatch(selector *Selector) Condition {
+	return Condition{operator: "$elemMatch", operand: selector}
+}
+
+// Selector selects the documents whose fields meet all of its conditions
+type Selector struct {
+	fields map[string][]Condition // The conditions on each field
+	err    error                  // The first invalid field or condition, if any
+}
+
+// Where returns a selector with the conditions on field
+func Where(field string, conditions ...Condition) *Selector {
+	return (&Selector{fields: map[string][]Condition{}}).And(field, conditions...)
+}
+
+// And adds conditions on field to the selector. Conditions on the same field must use
+// different operators.
+func (s *Selector) And(field string, conditions ...Condition) *Selector {
+	if s.err != nil {
+		return s
+	}
+	if err := validateField(field); err != nil {
+		s.err = err
+		return s
+	}
+	if len(conditions) == 0 {
+		s.err = errors.New("field " + field + " has no conditions")
+		return s
+	}
+	for _, condition := range conditions {
+		if condition.err != nil {
+			s.err = errors.New("invalid condition on " + field + ": " + condition.err.Error())
+			return s
+		}
+		for _, existing := range s.fields[field] {
+			if existing.operator == condition.operator {
+				s.err = errors.New("field " + field + " already has a " + condition.operator + " condition")
+				return s
+			}
+		}
+		s.fields[field] = append(s.fields[field], condition)
+	}
+	return s
+}
+
+// mango returns the selector as the value encoding/json turns into Mango
+func (s *Selector) mango() (map[string]interface{}, error) {
+	if s == nil {
+		return nil, errors.New("the selector is missing")
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	selector := make(map[string]interface{}, len(s.fields))
+	for field, conditions := range s.fields {
+		// A lone equality is written as the plain value, as CouchDB documents it
+		if len(conditions) == 1 && conditions[0].operator == "$eq" {
+			selector[field] = conditions[0].operand
+			continue
+		}
+		operators := make(map[string]interface{}, len(conditions))
+		for _, condition := range conditions {
+			operand := condition.operand
+			if nested, isSelector := operand.(*Selector); isSelector {
+				nestedSelector, err := nested.mango()
+				if err != nil {
+					return nil, errors.New("invalid $elemMatch on " + field + ": " + err.Error())
+				}
+				operand = nestedSelector
+			}
+			operators[condition.operator] = operand
+		}
+		selector[field] = operators
+	}
+	return selector, nil
+}
+
+// Direction is the order a sort clause sorts a field in
+type Direction string
+
+const (
+	Ascending  Direction = "asc"  // Smallest first
+	Descending Direction = "desc" // Largest first
+)
+
+// Query is a CouchDB Mango query
+type Query struct {
+	selector  *Selector           // The documents to return
+	fields    []string            // The fields to return; all of them when empty
+	sort      []map[string]string // The sort clauses, one field each
+	direction Direction           // The direction of every sort clause
+	useIndex  []string            // Design document and, optionally, name of the index to use
+	err       error               // The first invalid field, if any
+}
+
+// New returns a query for the documents selector matches
+func New(selector *Selector) *Query {
+	return &Query{selector: selector}
+}
+
+// Sort sorts the results by field after the fields already added. CouchDB sorts every field
+// in the same direction.
+func (q *Query) Sort(field string, direction Direction) *Query {
+	if q.err != nil {
+		return q
+	}
+	if err := validateField(field); err != nil {
+		q.err = err
+		return q
+	}
+	if direction != Ascending && direction != Descending {
+		q.err = errors.New("unknown sort direction " + string(direction))
+		return q
+	}
+	if q.direction != "" && q.direction != direction {
+		q.err = errors.New("CouchDB sorts every field in the same direction")
+		return q
+	}
+	q.direction = direction
+	q.sort = append(q.sort, map[string]string{field: string(direction)})
+	return q
+}
+
+// Fields restricts the documents returned to fields
+func (q *Query) Fields(fields ...string) *Query {
+	if q.err != nil {
+		return q
+	}
+	for _, field := range fields {
+		if err := validateField(field); err != nil {
+			q.err = err
+			return q
+		}
+	}
+	q.fields = append(q.fields, fields...)
+	return q
+}
+
+// UseIndex tells CouchDB to answer the query with the index name of designDoc. name may be
+// empty when the design document holds a single index.
+func (q *Query) UseIndex(designDoc string, name string) *Query {
+	if q.err != nil {
+		return q
+	}
+	if designDoc == "" {
+		q.err = errors.New("the design document of the index is required")
+		return q
+	}
+	q.useIndex = []string{designDoc}
+	if name != "" {
+		q.useIndex = append(q.useIndex, name)
+	}
+	return q
+}
+
+// Build returns the query as the JSON GetQueryResult and GetQueryResultWithPagination take
+func (q *Query) Build() (string, error) {
+	if q.err != nil {
+		return "", q.err
+	}
+	selector, err := q.selector.mango()
+	if err != nil {
+		return "", err
+	}
+
+	mango := struct {
+		Selector map[string]interface{} `json:"selector"`
+		Fields   []string               `json:"fields,omitempty"`
+		Sort     []map[string]string    `json:"sort,omitempty"`
+		UseIndex interface{}            `json:"use_index,omitempty"`
+	}{Selector: selector, Fields: q.fields, Sort: q.sort}
+	if len(q.useIndex) == 1 {
+		mango.UseIndex = q.useIndex[0]
+	} else if len(q.useIndex) > 1 {
+		mango.UseIndex = q.useIndex
+	}
+
+	queryJSON, err := json.Marshal(mango)
+	if err != nil {
+		return "", errors.New("failed to marshal query: " + err.Error())
+	}
+	return string(queryJSON), nil
+}
+
+// validateField checks that field is a dotted path whose parts CouchDB cannot take for operators
+func validateField(field string) error {
+	if field == "" {
+		return errors.New("the field name is required")
+	}
+	for _, part := range strings.Split(field, ".") {
+		if part == "" {
+			return errors.New("field " + field + " has an empty part")
+		}
+		if strings.HasPrefix(part, "$") {
+			return errors.New("field " + field + " looks like an operator")
+		}
+		if strings.ContainsAny(part, "\\\"") || strings.IndexFunc(part, func(r rune) bool { return r < ' ' }) >= 0 {
+			return errors.New("field " + field + " has a character fields may not contain")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeSelector decodes the selector of a built query
+func decodeSelector(t *testing.T, queryJSON string) map[string]interface{} {
+	var decoded struct {
+		Selector map[string]interface{} `json:"selector"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(queryJSON), &decoded))
+	return decoded.Selector
+}
+
+func TestBuild_Equality(t *testing.T) {
+	queryJSON, err := New(Where("subject.reference", Eq("Patient/patient1")).And("acknowledged", Eq(false))).Build()
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"selector":{"acknowledged":false,"subject.reference":"Patient/patient1"}}`, queryJSON)
+}
+
+func TestBuild_OperatorsOnOneField(t *testing.T) {
+	queryJSON, err := New(Where("effectivePeriod.start", Gte("2024-01-01T00:00:00Z"), Lte("2024-12-31T23:59:59Z"))).Build()
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"selector":{"effectivePeriod.start":{"$gte":"2024-01-01T00:00:00Z","$lte":"2024-12-31T23:59:59Z"}}}`, queryJSON)
+}
+
+func TestBuild_NestedElemMatch(t *testing.T) {
+	selector := Where("code.coding", ElemMatch(Where("system", Eq("http://loinc.org")).And("code", In("4548-4", "17856-6")))).
+		And("intent", Exists(true))
+
+	queryJSON, err := New(selector).Build()
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"selector":{"code.coding":{"$elemMatch":{"code":{"$in":["4548-4","17856-6"]},"system":"http://loinc.org"}},"intent":{"$exists":true}}}`, queryJSON)
+}
+
+func TestBuild_SortFieldsAndIndex(t *testing.T) {
+	query := New(Where("subject.reference", Eq("Patient/patient1"))).
+		Sort("subject.reference", Ascending).
+		Sort("effectivePeriod.start", Ascending).
+		Fields("id", "valueQuantity").
+		UseIndex("indexByPatientAndEffectiveTime", "indexByPatientAndEffectiveTime")
+
+	queryJSON, err := query.Build()
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"selector":{"subject.reference":"Patient/patient1"},"fields":["id","valueQuantity"],`+
+		`"sort":[{"subject.reference":"asc"},{"effectivePeriod.start":"asc"}],`+
+		`"use_index":["indexByPatientAndEffectiveTime","indexByPatientAndEffectiveTime"]}`, queryJSON)
+}
+
+func TestBuild_IndexWithoutName(t *testing.T) {
+	queryJSON, err := New(Where("patient", Eq("Patient/patient1"))).UseIndex("indexCriticalAlertsByPatient", "").Build()
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"selector":{"patient":"Patient/patient1"},"use_index":"indexCriticalAlertsByPatient"}`, queryJSON)
+}
+
+func TestBuild_QuotesStayInsideTheValue(t *testing.T) {
+	patientID := `patient1","category.text":{"$gt":null},"x":"`
+
+	queryJSON, err := New(Where("subject.reference", Eq(patientID)).And("category.text", Eq("Laboratory"))).Build()
+
+	assert.NoError(t, err)
+	selector := decodeSelector(t, queryJSON)
+	assert.Len(t, selector, 2)
+	assert.Equal(t, patientID, selector["subject.reference"])
+	assert.Equal(t, "Laboratory", selector["category.text"])
+}
+
+func TestBuild_OperatorInjectionIsAValue(t *testing.T) {
+	patientID := `"},"$or":[{"subject.reference":{"$regex":".*"}}],"x":{"y":"`
+
+	queryJSON, err := New(Where("subject.reference", Eq(patientID))).Build()
+
+	assert.NoError(t, err)
+	selector := decodeSelector(t, queryJSON)
+	assert.Len(t, selector, 1)
+	assert.NotContains(t, selector, "$or")
+	assert.Equal(t, patientID, selector["subject.reference"])
+}
+
+func TestBuild_BackslashesAndControlCharactersStayInsideTheValue(t *testing.T) {
+	patientID := "patient1\\\"}\x00\n </script>"
+
+	queryJSON, err := New(Where("subject.reference", Eq(patientID))).Build()
+
+	assert.NoError(t, err)
+	selector := decodeSelector(t, queryJSON)
+	assert.Equal(t, patientID, selector["subject.reference"])
+}
+
+func TestBuild_ValuesInsideInStayValues(t *testing.T) {
+	statuses := []string{`final"]},"$or":[{}],"x":{"$in":["`, "amended"}
+
+	queryJSON, err := New(Where("status", In(statuses...))).Build()
+
+	assert.NoError(t, err)
+	selector := decodeSelector(t, queryJSON)
+	assert.Len(t, selector, 1)
+	assert.Equal(t, map[string]interface{}{"$in": []interface{}{statuses[0], statuses[1]}}, selector["status"])
+}
+
+func TestBuild_RejectsOperatorFields(t *testing.T) {
+	_, err := New(Where("$or", Eq("x"))).Build()
+
+	assert.EqualError(t, err, "field $or looks like an operator")
+}
+
+func TestBuild_RejectsOperatorsInsideDottedFields(t *testing.T) {
+	_, err := New(Where("subject.$regex", Eq(".*"))).Build()
+
+	assert.EqualError(t, err, "field subject.$regex looks like an operator")
+}
+
+func TestBuild_RejectsOperatorFieldsInsideElemMatch(t *testing.T) {
+	_, err := New(Where("code.coding", ElemMatch(Where("$where", Eq("true"))))).Build()
+
+	assert.EqualError(t, err, "invalid $elemMatch on code.coding: field $where looks like an operator")
+}
+
+func TestBuild_RejectsQuotesInFields(t *testing.T) {
+	_, err := New(Where(`subject":{"$gt":null},"x`, Eq("y"))).Build()
+
+	assert.EqualError(t, err, `field subject":{"$gt":null},"x has a character fields may not contain`)
+}
+
+func TestBuild_RejectsEmptyFieldParts(t *testing.T) {
+	_, err := New(Where("subject..reference", Eq("Patient/patient1"))).Build()
+
+	assert.EqualError(t, err, "field subject..reference has an empty part")
+}
+
+func TestBuild_RejectsOperatorSortFields(t *testing.T) {
+	_, err := New(Where("status", Eq("final"))).Sort("$or", Ascending).Build()
+
+	assert.EqualError(t, err, "field $or looks like an operator")
+}
+
+func TestBuild_RejectsUnknownSortDirections(t *testing.T) {
+	_, err := New(Where("status", Eq("final"))).Sort("status", Direction(`asc"},{"x":"`)).Build()
+
+	assert.EqualError(t, err, `unknown sort direction asc"},{"x":"`)
+}
+
+func TestBuild_RejectsMixedSortDirections(t *testing.T) {
+	_, err := New(Where("status", Eq("final"))).Sort("status", Ascending).Sort("issued", Descending).Build()
+
+	assert.EqualError(t, err, "CouchDB sorts every field in the same direction")
+}
+
+func TestBuild_RejectsOperatorProjections(t *testing.T) {
+	_, err := New(Where("status", Eq("final"))).Fields("id", "$or").Build()
+
+	assert.EqualError(t, err, "field $or looks like an operator")
+}
+
+func TestBuild_RejectsRepeatedOperators(t *testing.T) {
+	_, err := New(Where("status", Eq("final")).And("status", Eq("amended"))).Build()
+
+	assert.EqualError(t, err, "field status already has a $eq condition")
+}
+
+func TestBuild_RejectsEmptyIn(t *testing.T) {
+	_, err := New(Where("status", In[string]())).Build()
+
+	assert.EqualError(t, err, "invalid condition on status: $in needs at least one value")
+}
+
+func TestBuild_RejectsMissingSelector(t *testing.T) {
+	_, err := New(nil).Build()
+
+	assert.EqualError(t, err, "the selector is missing")
+}
+
+func TestBuild_RejectsEmptyIndex(t *testing.T) {
+	_, err := New(Where("status", Eq("final"))).UseIndex("", "indexByStatus").Build()
+
+	assert.EqualError(t, err, "the design document of the index is required")
+}
@@ -8,6 +8,10 @@ require (
 	github.com/hyperledger/fabric-contract-api-go v1.2.2
 	github.com/hyperledger/fabric-protos-go v0.3.3
 	github.com/stretchr/testify v1.9.0
+	github.com/xDaryamo/MedChain/auth v0.0.0
+	github.com/xDaryamo/MedChain/fhir v0.0.0
+	github.com/xDaryamo/MedChain/history v0.0.0
+	github.com/xDaryamo/MedChain/ledger v0.0.0
 )
 
 require (
@@ -37,3 +41,10 @@ require (
 	google.golang.org/protobuf v1.33.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace (
+	github.com/xDaryamo/MedChain/auth => ../auth
+	github.com/xDaryamo/MedChain/fhir => ../fhir
+	github.com/xDaryamo/MedChain/history => ../history
+	github.com/xDaryamo/MedChain/ledger => ../ledger
+)
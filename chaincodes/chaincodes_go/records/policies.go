@@ -0,0 +1,25 @@
+package main
+
+import "github.com/xDaryamo/MedChain/auth"
+
+var (
+	clinicalRoles = []string{auth.RoleDoctor, auth.RoleNurse}
+	careProviders = auth.Join(auth.HospitalMSPs, auth.ClinicMSPs)
+)
+
+// recordsPolicies declares who may call each MedicalRecordsChaincode transaction.
+// Medical records are keyed by patient ID, so the subject is always the first argument.
+var recordsPolicies = auth.Policies{
+	"CreateMedicalRecords":     {MSPs: careProviders, Roles: []string{auth.RoleDoctor}, Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "MedicalRecords", Action: auth.ActionWrite},
+	"GetMedicalRecords":        {Roles: auth.Join(clinicalRoles, []string{auth.RolePatient}), Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "MedicalRecords", Action: auth.ActionRead},
+	"GetMedicalRecordsHistory": {Roles: auth.Join(clinicalRoles, []string{auth.RolePatient}), Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "MedicalRecords", Action: auth.ActionRead},
+	"UpdateMedicalRecords":     {MSPs: careProviders, Roles: []string{auth.RoleDoctor}, Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "MedicalRecords", Action: auth.ActionWrite},
+	"DeleteMedicalRecords":     {MSPs: careProviders, Roles: []string{auth.RoleDoctor}, Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "MedicalRecords", Action: auth.ActionWrite},
+	"SearchMedicalRecords":     {MSPs: careProviders, Roles: []string{auth.RoleDoctor}, Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "MedicalRecords", Action: auth.ActionRead},
+
+	"AuditedGetMedicalRecords": {Roles: auth.Join(clinicalRoles, []string{auth.RolePatient}), Subject: auth.Arg(0), ConsentRoles: clinicalRoles, Resource: "MedicalRecords", Action: auth.ActionRead},
+	"GetAccessLog":             {Roles: []string{auth.RolePatient, auth.RoleCompliance}, Subject: auth.Arg(0)},
+	"MigrateKeys":              {Roles: []string{auth.RoleAdmin}},
+}
+
+var recordsEnforcer = &auth.Enforcer{Policies: recordsPolicies, Consent: auth.AnyConsent(auth.PatientConsent, auth.EmergencyConsent)}
@@ -7,8 +7,15 @@ import (
 	"strings"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xDaryamo/MedChain/auth"
+	"github.com/xDaryamo/MedChain/fhir"
+	"github.com/xDaryamo/MedChain/history"
+	"github.com/xDaryamo/MedChain/ledger"
 )
 
+// Medical record folders are stored under the composite key MedicalRecords~patientID
+const medicalRecordsObjectType = "MedicalRecords"
+
 // MedicalRecordsChaincode represents the Chaincode for managing medical records on the blockchain
 type MedicalRecordsChaincode struct {
 	contractapi.Contract
@@ -17,11 +24,11 @@ type MedicalRecordsChaincode struct {
 // MedicalRecords represents the data structure for a medical record folder
 type MedicalRecords struct {
 	PatienID      string
-	Allergies     []AllergyIntolerance
-	Conditions    []Condition
-	Prescriptions []MedicationStatement
-	CarePlan      CarePlanActivity
-	Request       MedicationRequest
+	Allergies     []fhir.AllergyIntolerance
+	Conditions    []fhir.Condition
+	Prescriptions []fhir.MedicationStatement
+	CarePlan      fhir.CarePlanActivity
+	Request       fhir.MedicationRequest
 }
 
 // CreateMedicalRecords creates a new medical record folder for a patient
@@ -46,13 +53,17 @@ func (mc *MedicalRecordsChaincode) CreateMedicalRecords(ctx contractapi.Transact
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(patientID, medicalRecordJSONBytes)
+	return mc.putMedicalRecords(ctx, patientID, medicalRecordJSONBytes)
 }
 
 // GetMedicalRecords retrieves a patient's medical record folder from the blockchain
 func (mc *MedicalRecordsChaincode) GetMedicalRecords(ctx contractapi.TransactionContextInterface, patientID string) (*MedicalRecords, error) {
 	// Retrieve the medical record folder from the blockchain
-	medicalRecordJSON, err := ctx.GetStub().GetState(patientID)
+	medicalRecordsKey, err := ledger.Key(ctx, medicalRecordsObjectType, patientID)
+	if err != nil {
+		return nil, err
+	}
+	medicalRecordJSON, err := ctx.GetStub().GetState(medicalRecordsKey)
 	if err != nil {
 		return nil, err
 	}
@@ -70,6 +81,30 @@ func (mc *MedicalRecordsChaincode) GetMedicalRecords(ctx contractapi.Transaction
 	return &medicalRecord, nil
 }
 
+// AuditedGetMedicalRecords reads a medical record folder like GetMedicalRecords and records
+// the read in the patient's access log. It must be submitted for the log entry to be kept.
+func (mc *MedicalRecordsChaincode) AuditedGetMedicalRecords(ctx contractapi.TransactionContextInterface, patientID string) (*MedicalRecords, error) {
+	if _, err := recordsEnforcer.Audit(ctx, "GetMedicalRecords", []string{patientID}, "MedicalRecords", patientID); err != nil {
+		return nil, err
+	}
+	return mc.GetMedicalRecords(ctx, patientID)
+}
+
+// GetAccessLog returns the audited reads of the medical records of patientID
+func (mc *MedicalRecordsChaincode) GetAccessLog(ctx contractapi.TransactionContextInterface, patientID string) ([]*auth.AccessLogEntry, error) {
+	return auth.GetAccessLog(ctx, patientID)
+}
+
+// GetMedicalRecordsHistory returns every version of a medical record folder as a FHIR history Bundle,
+// including deletions, with the transaction and identity that wrote each version
+func (mc *MedicalRecordsChaincode) GetMedicalRecordsHistory(ctx contractapi.TransactionContextInterface, patientID string) (string, error) {
+	medicalRecordsKey, err := ledger.Key(ctx, medicalRecordsObjectType, patientID)
+	if err != nil {
+		return "", err
+	}
+	return history.GetHistoryJSON(ctx, medicalRecordsKey, "MedicalRecords", patientID)
+}
+
 // UpdateMedicalRecords updates an existing medical record folder for a patient
 func (mc *MedicalRecordsChaincode) UpdateMedicalRecords(ctx contractapi.TransactionContextInterface, patientID string, updatedMedicalRecordJSON string) error {
 	// Retrieve the existing medical record folder
@@ -99,7 +134,7 @@ func (mc *MedicalRecordsChaincode) UpdateMedicalRecords(ctx contractapi.Transact
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(patientID, updatedMedicalRecordJSONBytes)
+	return mc.putMedicalRecords(ctx, patientID, updatedMedicalRecordJSONBytes)
 }
 
 // DeleteMedicalRecords removes an existing medical record folder for a patient
@@ -114,46 +149,57 @@ func (mc *MedicalRecordsChaincode) DeleteMedicalRecords(ctx contractapi.Transact
 	}
 
 	// Remove the medical record folder from the blockchain
-	return ctx.GetStub().DelState(patientID)
+	medicalRecordsKey, err := ledger.Key(ctx, medicalRecordsObjectType, patientID)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(medicalRecordsKey)
 }
 
-func (mc *MedicalRecordsChaincode) SearchMedicalRecords(ctx contractapi.TransactionContextInterface, query string) ([]*MedicalRecords, error) {
+// SearchMedicalRecords returns the medical record folder of a patient if one of its
+// conditions has an ID containing the query
+func (mc *MedicalRecordsChaincode) SearchMedicalRecords(ctx contractapi.TransactionContextInterface, patientID string, query string) ([]*MedicalRecords, error) {
 	var results []*MedicalRecords
 
-	// Retrieve all medical records stored on the blockchain
-	iterator, err := ctx.GetStub().GetStateByRange("", "")
-	if err != nil {
+	medicalRecord, err := mc.GetMedicalRecords(ctx, patientID)
+	if err != nil || medicalRecord == nil {
 		return nil, err
 	}
-	defer iterator.Close()
-
-	// Iterate through all records and filter those that match the query
-	for iterator.HasNext() {
-		result, err := iterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		var medicalRecord MedicalRecords
-		err = json.Unmarshal(result.Value, &medicalRecord)
-		if err != nil {
-			return nil, err
-		}
 
-		// Check if any condition matches the query
-		for _, condition := range medicalRecord.Conditions {
-			if strings.Contains(condition.ID.Value, query) {
-
-				results = append(results, &medicalRecord)
-				break
-			}
+	// Check if any condition matches the query
+	for _, condition := range medicalRecord.Conditions {
+		if condition.ID != nil && strings.Contains(condition.ID.Value, query) {
+			results = append(results, medicalRecord)
+			break
 		}
 	}
 
 	return results, nil
 }
 
+// MigrateKeys moves up to limit medical record folders stored under the bare patient ID,
+// as written before records were namespaced, to their MedicalRecords~patientID key.
+// It returns how many it moved; call it until it returns 0.
+func (mc *MedicalRecordsChaincode) MigrateKeys(ctx contractapi.TransactionContextInterface, limit int) (int, error) {
+	return ledger.MigrateKeys(ctx, func(key string, value []byte) (string, string, bool) {
+		return medicalRecordsObjectType, key, true
+	}, limit)
+}
+
+func (mc *MedicalRecordsChaincode) putMedicalRecords(ctx contractapi.TransactionContextInterface, patientID string, medicalRecordsJSON []byte) error {
+	medicalRecordsKey, err := ledger.Key(ctx, medicalRecordsObjectType, patientID)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(medicalRecordsKey, medicalRecordsJSON)
+}
+
 func main() {
-	chaincode, err := contractapi.NewChaincode(new(MedicalRecordsChaincode))
+	recordsChaincode := new(MedicalRecordsChaincode)
+	recordsChaincode.BeforeTransaction = recordsEnforcer.BeforeTransaction
+	recordsChaincode.AfterTransaction = history.RecordSubmitter
+
+	chaincode, err := contractapi.NewChaincode(recordsChaincode)
 	if err != nil {
 		log.Panic(errors.New("Error creating medical records chaincode: " + err.Error()))
 	}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/xDaryamo/MedChain/auth"
 )
 
 // MockStub is a mock implementation of the ChaincodeStubInterface
@@ -234,6 +239,66 @@ func (m *MockTransactionContext) GetClientIdentity() cid.ClientIdentity {
 	return args.Get(0).(cid.ClientIdentity)
 }
 
+// MockClientIdentity is a mock implementation of the cid.ClientIdentity interface
+type MockClientIdentity struct {
+	mock.Mock
+}
+
+func (mci *MockClientIdentity) GetID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetMSPID() (string, error) {
+	args := mci.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	args := mci.Called(attrName)
+	return args.String(0), args.Bool(1), args.Error(2)
+}
+
+func (mci *MockClientIdentity) HasAttribute(attrName string) (bool, error) {
+	args := mci.Called(attrName)
+	return args.Bool(0), args.Error(1)
+}
+
+func (mci *MockClientIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	args := mci.Called(attrName, attrValue)
+	return args.Error(0)
+}
+
+func (mci *MockClientIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	args := mci.Called()
+	return args.Get(0).(*x509.Certificate), args.Error(1)
+}
+
+// mockPatientCaller makes patientID the caller of the transaction, with an enrolled certificate
+func mockPatientCaller(mockCtx *MockTransactionContext, mockStub *MockStub, patientID string) *MockClientIdentity {
+	clientIdentity := new(MockClientIdentity)
+	clientIdentity.On("GetID").Return("x509::CN="+patientID, nil)
+	clientIdentity.On("GetMSPID").Return("PatientMSP", nil)
+	clientIdentity.On("GetAttributeValue", "userId").Return(patientID, true, nil)
+	clientIdentity.On("GetAttributeValue", "role").Return("patient", true, nil)
+	mockCtx.On("GetClientIdentity").Return(clientIdentity)
+	mockStub.On("InvokeChaincode", "patient", [][]byte{[]byte("VerifyIdentity")}, "patient-records-channel").Return(peer.Response{Status: 200})
+	return clientIdentity
+}
+
+// mockAccessLog expects an access log entry for patientID, written by transaction txID
+func mockAccessLog(mockStub *MockStub, patientID string, txID string, function string) {
+	logKey := "\x00AccessLog\x00" + patientID + "\x00" + txID + "\x00"
+	mockStub.On("GetTxID").Return(txID)
+	mockStub.On("GetTxTimestamp").Return(&timestamp.Timestamp{Seconds: time.Now().Unix()}, nil)
+	mockStub.On("CreateCompositeKey", "AccessLog", []string{patientID, txID}).Return(logKey, nil)
+	mockStub.On("PutState", logKey, mock.MatchedBy(func(value []byte) bool {
+		var entry auth.AccessLogEntry
+		return json.Unmarshal(value, &entry) == nil && entry.Function == function &&
+			entry.UserID == patientID && entry.Grant.Basis == auth.BasisPatient
+	})).Return(nil)
+}
+
 // KVPair represents a key-value pair for testing purposes
 type KVPair struct {
 	Key   string
@@ -277,6 +342,13 @@ func (m *MockIterator) Close() error {
 }
 
 // TestCreateMedicalRecords tests the CreateMedicalRecords function
+// mockKey expects the composite key objectType~id to be created and returns it
+func mockKey(stub *MockStub, objectType string, id string) string {
+	key := "\x00" + objectType + "\x00" + id + "\x00"
+	stub.On("CreateCompositeKey", objectType, []string{id}).Return(key, nil)
+	return key
+}
+
 func TestCreateMedicalRecords(t *testing.T) {
 	// Create a new instance of the chaincode
 	cc := new(MedicalRecordsChaincode)
@@ -287,13 +359,11 @@ func TestCreateMedicalRecords(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Mock GetMedicalRecords to return nil, nil
-	mockStub.On("GetState", "patient1").Return(nil, nil)
-
-	// Mock GetState to return nil when called during the test
-	mockStub.On("GetState", mock.Anything).Return(nil, nil)
+	medicalRecordsKey := mockKey(mockStub, "MedicalRecords", "patient1")
+	mockStub.On("GetState", medicalRecordsKey).Return(nil, nil)
 
 	// Mock PutState method to return nil (indicating success)
-	mockStub.On("PutState", mock.Anything, mock.Anything).Return(nil)
+	mockStub.On("PutState", medicalRecordsKey, mock.Anything).Return(nil)
 
 	// Test case 1: Create a new medical record folder successfully
 	err := cc.CreateMedicalRecords(mockCtx, "patient1", `{ "PatientID": "patient1", "Allergies": [], "Conditions": [], "Prescriptions": [], "CarePlan": {}, "Request": {} }`)
@@ -312,7 +382,8 @@ func TestCreateMedicalRecordsIfExists(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Mock GetState to return a non-nil value when called with the key "patient1"
-	mockStub.On("GetState", "patient1").Return([]byte("existing_record"), nil)
+	medicalRecordsKey := mockKey(mockStub, "MedicalRecords", "patient1")
+	mockStub.On("GetState", medicalRecordsKey).Return([]byte("existing_record"), nil)
 
 	// Test case: Try to create a medical record folder for an existing patient
 	err := cc.CreateMedicalRecords(mockCtx, "patient1", `{ "PatientID": "patient1", "Allergies": [], "Conditions": [], "Prescriptions": [], "CarePlan": {}, "Request": {} }`)
@@ -346,10 +417,11 @@ func TestUpdateMedicalRecords(t *testing.T) {
 	}`
 
 	// Mock GetMedicalRecords to return an existing record
-	mockStub.On("GetState", "patient1").Return([]byte(existingRecordJSON), nil)
+	medicalRecordsKey := mockKey(mockStub, "MedicalRecords", "patient1")
+	mockStub.On("GetState", medicalRecordsKey).Return([]byte(existingRecordJSON), nil)
 
 	// Mock PutState method to return nil (indicating success)
-	mockStub.On("PutState", "patient1", mock.Anything).Return(nil)
+	mockStub.On("PutState", medicalRecordsKey, mock.Anything).Return(nil)
 
 	// Test case: Update an existing medical record folder successfully
 	err := cc.UpdateMedicalRecords(mockCtx, "patient1", `{
@@ -382,10 +454,11 @@ func TestDeleteMedicalRecords(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Mock GetMedicalRecords to return an existing record
-	mockStub.On("GetState", "patient1").Return([]byte(existingRecordJSON), nil)
+	medicalRecordsKey := mockKey(mockStub, "MedicalRecords", "patient1")
+	mockStub.On("GetState", medicalRecordsKey).Return([]byte(existingRecordJSON), nil)
 
 	// Mock DelState method to return nil (indicating success)
-	mockStub.On("DelState", "patient1").Return(nil)
+	mockStub.On("DelState", medicalRecordsKey).Return(nil)
 
 	// Test case: Delete an existing medical record folder successfully
 	err := cc.DeleteMedicalRecords(mockCtx, "patient1")
@@ -428,15 +501,12 @@ func TestSearchMedicalRecords(t *testing.T) {
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
 
-	// Mock iterator
-	mockIterator := new(MockIterator)
-	mockStub.On("GetStateByRange", "", "").Return(mockIterator, nil)
-
-	// Add a record to the mock iterator
-	mockIterator.AddRecord("patient1", []byte(existingRecordJSON))
+	// Mock GetMedicalRecords to return the patient's folder
+	medicalRecordsKey := mockKey(mockStub, "MedicalRecords", "patient1")
+	mockStub.On("GetState", medicalRecordsKey).Return([]byte(existingRecordJSON), nil)
 
 	// Test case: Search for medical records with a specific ID
-	results, err := cc.SearchMedicalRecords(mockCtx, "condition123")
+	results, err := cc.SearchMedicalRecords(mockCtx, "patient1", "condition123")
 	assert.NoError(t, err)
 	assert.NotNil(t, results)
 	assert.Len(t, results, 1)
@@ -451,12 +521,12 @@ func TestSearchNonExistentMedicalRecords(t *testing.T) {
 	mockStub := new(MockStub)
 	mockCtx.On("GetStub").Return(mockStub)
 
-	// Create a mock iterator with no records
-	mockIterator := new(MockIterator)
-	mockStub.On("GetStateByRange", "", "").Return(mockIterator, nil)
+	// Mock GetMedicalRecords to find no folder for the patient
+	medicalRecordsKey := mockKey(mockStub, "MedicalRecords", "patient1")
+	mockStub.On("GetState", medicalRecordsKey).Return(nil, nil)
 
 	// Test case: Search for non-existent medical records
-	results, err := cc.SearchMedicalRecords(mockCtx, "nonexistent123")
+	results, err := cc.SearchMedicalRecords(mockCtx, "patient1", "nonexistent123")
 	assert.NoError(t, err)
 
 	// If results are nil, assign an empty slice to avoid nil pointer dereference
@@ -478,7 +548,8 @@ func TestUpdateNonExistentMedicalRecords(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Mock GetState to return nil, indicating the record doesn't exist
-	mockStub.On("GetState", "nonexistent_patient").Return(nil, nil)
+	medicalRecordsKey := mockKey(mockStub, "MedicalRecords", "nonexistent_patient")
+	mockStub.On("GetState", medicalRecordsKey).Return(nil, nil)
 
 	// Test case: Try to update non-existent medical records
 	err := cc.UpdateMedicalRecords(mockCtx, "nonexistent_patient", `{
@@ -502,7 +573,8 @@ func TestDeleteNonExistentMedicalRecords(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Mock GetState to return nil, indicating the record doesn't exist
-	mockStub.On("GetState", "nonexistent_patient").Return(nil, nil)
+	medicalRecordsKey := mockKey(mockStub, "MedicalRecords", "nonexistent_patient")
+	mockStub.On("GetState", medicalRecordsKey).Return(nil, nil)
 
 	// Test case: Try to delete non-existent medical records
 	err := cc.DeleteMedicalRecords(mockCtx, "nonexistent_patient")
@@ -533,9 +605,60 @@ func TestErrorPaths(t *testing.T) {
 	mockCtx.On("GetStub").Return(mockStub)
 
 	// Mock GetState to return an error
-	mockStub.On("GetState", mock.Anything).Return(nil, fmt.Errorf("error retrieving state"))
+	medicalRecordsKey := mockKey(mockStub, "MedicalRecords", "patient1")
+	mockStub.On("GetState", medicalRecordsKey).Return(nil, fmt.Errorf("error retrieving state"))
 
 	// Test case: Create medical records when there's an error retrieving state
 	err := cc.CreateMedicalRecords(mockCtx, "patient1", `{ "PatientID": "patient1", "Allergies": [], "Conditions": [], "Prescriptions": [], "CarePlan": {}, "Request": {} }`)
 	assert.Error(t, err) // Expect an error due to error retrieving state
 }
+
+func TestPoliciesCoverEveryTransaction(t *testing.T) {
+	assert.Empty(t, auth.MissingPolicies(new(MedicalRecordsChaincode), recordsPolicies), "transactions without an access policy")
+}
+
+func TestAuditedGetMedicalRecords_RecordsTheRead(t *testing.T) {
+	cc := new(MedicalRecordsChaincode)
+	mockCtx := new(MockTransactionContext)
+	mockStub := new(MockStub)
+	mockCtx.On("GetStub").Return(mockStub)
+	mockPatientCaller(mockCtx, mockStub, "patient1")
+
+	mockAccessLog(mockStub, "patient1", "tx1", "GetMedicalRecords")
+	medicalRecordsKey := mockKey(mockStub, "MedicalRecords", "patient1")
+	mockStub.On("GetState", medicalRecordsKey).Return([]byte(`{"patientID": "patient1"}`), nil)
+
+	records, err := cc.AuditedGetMedicalRecords(mockCtx, "patient1")
+
+	assert.NoError(t, err)
+	assert.NotNil(t, records)
+	mockStub.AssertExpectations(t)
+}
+
+func TestMigrateKeys(t *testing.T) {
+	mockStub := new(MockStub)
+	mockCtx := new(MockTransactionContext)
+	mockCtx.On("GetStub").Return(mockStub)
+	cc := new(MedicalRecordsChaincode)
+
+	recordsJSON := []byte(`{"PatienID": "patient1"}`)
+	mockStub.On("GetStateByRange", "", "").Return(&MockIterator{
+		Records: []KVPair{{Key: "patient1", Value: recordsJSON}},
+	}, nil)
+	medicalRecordsKey := mockKey(mockStub, "MedicalRecords", "patient1")
+	mockStub.On("GetState", medicalRecordsKey).Return(nil, nil)
+	mockStub.On("PutState", medicalRecordsKey, recordsJSON).Return(nil)
+	mockStub.On("DelState", "patient1").Return(nil)
+
+	migrated, err := cc.MigrateKeys(mockCtx, 100)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+	mockStub.AssertExpectations(t)
+}
+
+func TestChaincodeMetadataIsValid(t *testing.T) {
+	// contractapi rejects at startup any transaction whose types it cannot describe
+	_, err := contractapi.NewChaincode(new(MedicalRecordsChaincode))
+	assert.NoError(t, err)
+}